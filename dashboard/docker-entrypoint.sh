@@ -18,29 +18,40 @@ echo "{\"clusters\": $JSON_CLUSTERS}" > /usr/share/nginx/html/config.json
 # Sync data from S3 if configured
 if [ -n "$S3_BUCKET" ] && [ "$DISABLE_S3_SYNC" != "true" ]; then
     echo "📥 Starting background S3 sync..."
+
+    # Copies a report, unpacking objects the exporter stored with COMPRESS_UPLOADS=gzip
+    fetch_report() {
+        aws s3 cp "$1" "$2.tmp" 2>/dev/null || return 0
+        if gzip -t "$2.tmp" 2>/dev/null; then
+            gunzip -c "$2.tmp" > "$2" && rm -f "$2.tmp"
+        else
+            mv "$2.tmp" "$2"
+        fi
+    }
     
     sync_data() {
         while true; do
             # echo "   Syncing reports..."
             for cluster in $CLUSTER_LIST; do
                 # List of report files to sync
-                # We expect them at S3_PREFIX/CLUSTER/filename.json
+                # We expect them at S3_PREFIX/CLUSTER/filename.json, or under the
+                # S3_PREFIX_<TYPE> override the exporter was given for a report type
                 # And we want them at /data/CLUSTER-filename.json
                 
                 # Vulnerability Reports (legacy name fallback supported by API, but we stick to new name)
                 # Note: Exporter now uploads 'vulnerability-reports.json', not '-latest.json'
-                aws s3 cp "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/vulnerability-reports.json" "/usr/share/nginx/html/data/${cluster}-vulnerability-reports.json" 2>/dev/null || true
+                fetch_report "s3://${S3_BUCKET}/${S3_PREFIX_VULNERABILITYREPORTS:-${S3_PREFIX:-vuln}}/$cluster/vulnerability-reports.json" "/usr/share/nginx/html/data/${cluster}-vulnerability-reports.json"
                 # Fallback for old exporter
-                aws s3 cp "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/vulnerability-reports-latest.json" "/usr/share/nginx/html/data/${cluster}-reports.json" 2>/dev/null || true
+                fetch_report "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/vulnerability-reports-latest.json" "/usr/share/nginx/html/data/${cluster}-reports.json"
                 
                 # New Reports
-                aws s3 cp "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/config-audit-reports.json" "/usr/share/nginx/html/data/${cluster}-config-audit-reports.json" 2>/dev/null || true
-                aws s3 cp "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/exposed-secret-reports.json" "/usr/share/nginx/html/data/${cluster}-exposed-secret-reports.json" 2>/dev/null || true
-                aws s3 cp "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/cluster-rbac-assessment-reports.json" "/usr/share/nginx/html/data/${cluster}-cluster-rbac-assessment-reports.json" 2>/dev/null || true
-                aws s3 cp "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/cluster-compliance-reports.json" "/usr/share/nginx/html/data/${cluster}-cluster-compliance-reports.json" 2>/dev/null || true
-                aws s3 cp "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/cluster-vulnerability-reports.json" "/usr/share/nginx/html/data/${cluster}-cluster-vulnerability-reports.json" 2>/dev/null || true
-                aws s3 cp "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/rbac-assessment-reports.json" "/usr/share/nginx/html/data/${cluster}-rbac-assessment-reports.json" 2>/dev/null || true
-                aws s3 cp "s3://${S3_BUCKET}/${S3_PREFIX:-vuln}/$cluster/cluster-config-audit-reports.json" "/usr/share/nginx/html/data/${cluster}-cluster-config-audit-reports.json" 2>/dev/null || true
+                fetch_report "s3://${S3_BUCKET}/${S3_PREFIX_CONFIGAUDITREPORTS:-${S3_PREFIX:-vuln}}/$cluster/config-audit-reports.json" "/usr/share/nginx/html/data/${cluster}-config-audit-reports.json"
+                fetch_report "s3://${S3_BUCKET}/${S3_PREFIX_EXPOSEDSECRETREPORTS:-${S3_PREFIX:-vuln}}/$cluster/exposed-secret-reports.json" "/usr/share/nginx/html/data/${cluster}-exposed-secret-reports.json"
+                fetch_report "s3://${S3_BUCKET}/${S3_PREFIX_CLUSTERRBACASSESSMENTREPORTS:-${S3_PREFIX:-vuln}}/$cluster/cluster-rbac-assessment-reports.json" "/usr/share/nginx/html/data/${cluster}-cluster-rbac-assessment-reports.json"
+                fetch_report "s3://${S3_BUCKET}/${S3_PREFIX_CLUSTERCOMPLIANCEREPORTS:-${S3_PREFIX:-vuln}}/$cluster/cluster-compliance-reports.json" "/usr/share/nginx/html/data/${cluster}-cluster-compliance-reports.json"
+                fetch_report "s3://${S3_BUCKET}/${S3_PREFIX_CLUSTERVULNERABILITYREPORTS:-${S3_PREFIX:-vuln}}/$cluster/cluster-vulnerability-reports.json" "/usr/share/nginx/html/data/${cluster}-cluster-vulnerability-reports.json"
+                fetch_report "s3://${S3_BUCKET}/${S3_PREFIX_RBACASSESSMENTREPORTS:-${S3_PREFIX:-vuln}}/$cluster/rbac-assessment-reports.json" "/usr/share/nginx/html/data/${cluster}-rbac-assessment-reports.json"
+                fetch_report "s3://${S3_BUCKET}/${S3_PREFIX_CLUSTERCONFIGAUDITREPORTS:-${S3_PREFIX:-vuln}}/$cluster/cluster-config-audit-reports.json" "/usr/share/nginx/html/data/${cluster}-cluster-config-audit-reports.json"
             done
             sleep 30
         done
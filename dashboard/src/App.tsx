@@ -91,6 +91,8 @@ function App() {
         switch (activeView) {
             case 'vulnerability': return clusterData.vulnerabilityReports;
             case 'config-audit': return clusterData.configAuditReports;
+            case 'infra-assessment': return clusterData.infraAssessmentReports;
+            case 'cluster-infra-assessment': return clusterData.clusterInfraAssessmentReports;
             case 'rbac-assessment': return clusterData.rbacAssessmentReports;
             case 'exposed-secret': return clusterData.exposedSecretReports;
             case 'cluster-compliance': return clusterData.clusterComplianceReports;
@@ -107,6 +109,8 @@ function App() {
                 switch (activeView) {
                     case 'vulnerability': return c.vulnerabilityReports;
                     case 'config-audit': return c.configAuditReports;
+                    case 'infra-assessment': return c.infraAssessmentReports;
+                    case 'cluster-infra-assessment': return c.clusterInfraAssessmentReports;
                     case 'rbac-assessment': return c.rbacAssessmentReports;
                     case 'exposed-secret': return c.exposedSecretReports;
                     case 'cluster-compliance': return c.clusterComplianceReports;
@@ -213,6 +217,8 @@ function App() {
         const base = {
             vulnerabilityReports: 0,
             configAuditReports: 0,
+            infraAssessmentReports: 0,
+            clusterInfraAssessmentReports: 0,
             rbacAssessmentReports: 0,
             exposedSecretReports: 0,
             clusterComplianceReports: 0,
@@ -225,6 +231,8 @@ function App() {
         targetClusters.forEach(c => {
             base.vulnerabilityReports += c.vulnerabilityReports?.length || 0;
             base.configAuditReports += c.configAuditReports?.length || 0;
+            base.infraAssessmentReports += c.infraAssessmentReports?.length || 0;
+            base.clusterInfraAssessmentReports += c.clusterInfraAssessmentReports?.length || 0;
             base.rbacAssessmentReports += c.rbacAssessmentReports?.length || 0;
             base.exposedSecretReports += c.exposedSecretReports?.length || 0;
             base.clusterComplianceReports += c.clusterComplianceReports?.length || 0;
@@ -29,6 +29,7 @@ export function GenericReportTable({ reports, type, isLoading, onRowClick }: Gen
         // Extract the main list of items based on report type
         if (!report.report) return [];
         if (type === 'config-audit' || type === 'cluster-config-audit') return report.report.checks || [];
+        if (type === 'infra-assessment' || type === 'cluster-infra-assessment') return report.report.checks || [];
         if (type === 'exposed-secret') return report.report.secrets || [];
         if (type === 'rbac-assessment' || type === 'cluster-rbac-assessment') return report.report.checks || [];
         if (type === 'cluster-compliance') return report.report.compliances || []; // Hypothetical
@@ -212,7 +213,7 @@ function ExpandedReportItems({ items, type }: { items: any[], type: string }) {
                 <table className="table" style={{ fontSize: 'var(--font-size-sm)' }}>
                     <thead>
                         {/* Dynamic Headers based on type */}
-                        {type.includes('config-audit') || type.includes('rbac') ? (
+                        {type.includes('config-audit') || type.includes('infra-assessment') || type.includes('rbac') ? (
                             <tr>
                                 <th>ID</th>
                                 <th>Check</th>
@@ -237,7 +238,7 @@ function ExpandedReportItems({ items, type }: { items: any[], type: string }) {
                     </thead>
                     <tbody>
                         {items.map((item, idx) => {
-                            if (type.includes('config-audit') || type.includes('rbac')) {
+                            if (type.includes('config-audit') || type.includes('infra-assessment') || type.includes('rbac')) {
                                 return (
                                     <tr key={idx}>
                                         <td style={{ fontFamily: 'monospace' }}>{item.checkID || item.id}</td>
@@ -17,6 +17,8 @@ export function Sidebar({ activeView, onViewChange, counts }: SidebarProps) {
     const menuItems = [
         { id: 'vulnerability', label: 'Vulnerabilities', icon: ShieldAlert, countKey: 'vulnerabilityReports' },
         { id: 'config-audit', label: 'Config Audit', icon: FileText, countKey: 'configAuditReports' },
+        { id: 'infra-assessment', label: 'Infra Assessment', icon: FileText, countKey: 'infraAssessmentReports' },
+        { id: 'cluster-infra-assessment', label: 'Cluster Infra Assessment', icon: Server, countKey: 'clusterInfraAssessmentReports' },
         { id: 'rbac-assessment', label: 'RBAC Assessment', icon: Lock, countKey: 'rbacAssessmentReports' },
         { id: 'exposed-secret', label: 'Exposed Secrets', icon: Lock, countKey: 'exposedSecretReports' },
         { id: 'cluster-compliance', label: 'Cluster Compliance', icon: CheckSquare, countKey: 'clusterComplianceReports' },
@@ -31,6 +31,8 @@ const API_CONFIG = {
 const REPORT_FILES = {
     vulnerabilityReports: 'vulnerability-reports.json',
     configAuditReports: 'config-audit-reports.json',
+    infraAssessmentReports: 'infra-assessment-reports.json',
+    clusterInfraAssessmentReports: 'cluster-infra-assessment-reports.json',
     clusterRbacAssessmentReports: 'cluster-rbac-assessment-reports.json',
     exposedSecretReports: 'exposed-secret-reports.json',
     clusterComplianceReports: 'cluster-compliance-reports.json',
@@ -101,6 +103,8 @@ export async function fetchClusterData(cluster: string): Promise<ClusterData> {
             // Initialize with empty arrays
             vulnerabilityReports: [],
             configAuditReports: [],
+            infraAssessmentReports: [],
+            clusterInfraAssessmentReports: [],
             clusterRbacAssessmentReports: [],
             exposedSecretReports: [],
             clusterComplianceReports: [],
@@ -329,6 +333,8 @@ function createEmptyClusterData(cluster: string): ClusterData {
         reports: [],
         vulnerabilityReports: [],
         configAuditReports: [],
+        infraAssessmentReports: [],
+        clusterInfraAssessmentReports: [],
         clusterRbacAssessmentReports: [],
         exposedSecretReports: [],
         clusterComplianceReports: [],
@@ -59,6 +59,8 @@ export interface ClusterData {
     // Map report types to their data
     vulnerabilityReports: VulnerabilityReport[];
     configAuditReports: any[]; // Using any for now to get piping working, will refine
+    infraAssessmentReports: any[];
+    clusterInfraAssessmentReports: any[];
     clusterRbacAssessmentReports: any[];
     exposedSecretReports: any[];
     clusterComplianceReports: any[];
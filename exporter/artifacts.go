@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Artifact is a single object published for the cluster. Large report files are streamed
+// from their temp file, small generated documents (index.json) are passed as a buffer;
+// both go through publishArtifact so every sink treats them the same way.
+type Artifact struct {
+	Name string   // key within the cluster, e.g. "vulnerability-reports.json"
+	File *os.File // streamed content, read from offset 0
+	Data []byte   // in-memory content, used when File is nil
+
+	// SHA-256 of the content, set for report files so unchanged ones are not uploaded again
+	Digest string
+}
+
+// Suffix of the checksum sidecar published next to each report file
+const checksumSuffix = ".sha256"
+
+// checksumSidecar returns the <name>.sha256 artifact of a report file in sha256sum format
+func checksumSidecar(a Artifact) Artifact {
+	return Artifact{
+		Name:   a.Name + checksumSuffix,
+		Data:   []byte(fmt.Sprintf("%s  %s\n", a.Digest, a.Name)),
+		Digest: a.Digest,
+	}
+}
+
+// open returns the content of the artifact from its start
+func (a Artifact) open() (io.Reader, error) {
+	if a.File == nil {
+		return bytes.NewReader(a.Data), nil
+	}
+	if _, err := a.File.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek temp file: %w", err)
+	}
+	return a.File, nil
+}
+
+func (a Artifact) size() int64 {
+	if a.File == nil {
+		return int64(len(a.Data))
+	}
+	info, err := a.File.Stat()
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// publishArtifact writes an artifact to every sink. A failing sink does not stop the
+// others; the returned error joins the failures of all sinks.
+//
+// Callers publish index.json after the report files of the cycle so consumers never see
+// an index describing reports that have not been written yet.
+func publishArtifact(ctx context.Context, sinks []Sink, cfg Config, a Artifact) error {
+	var errs []error
+	size := a.size()
+	for _, sink := range sinks {
+		if !cfg.ForceUpload && uploadedDigests.unchanged(sink, a) {
+			log.Printf("♻️ %s unchanged, skipping upload to %s", a.Name, sink.Name())
+			uploadSkips.record(a.Name, sink.Name(), skipUnchanged)
+			continue
+		}
+		// UPLOAD_BANDWIDTH_LIMIT applies to remote sinks; local writes are not throttled
+		_, remote := retryComponents[sink.Name()]
+		throttled := remote && uploadBandwidth != nil
+		start := time.Now()
+		// Every attempt reopens the artifact, so retries upload it in full
+		err := withRetry(ctx, cfg, sink, func() error {
+			r, err := a.open()
+			if err != nil {
+				return err
+			}
+			if throttled {
+				start = time.Now()
+				r = uploadBandwidth.reader(ctx, r)
+			}
+			return sink.Put(ctx, a.Name, r, size)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+			continue
+		}
+		if elapsed := time.Since(start); throttled && elapsed >= time.Second {
+			log.Printf("📶 Uploaded %s to %s at %s (limit %s)", a.Name, sink.Name(),
+				formatBandwidth(float64(size)/elapsed.Seconds()), formatBandwidth(float64(uploadBandwidth.limit)))
+		}
+		uploadedDigests.remember(sink, a)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to publish %s (%d bytes): %w", a.Name, size, err)
+	}
+	cycleArtifacts.record(a.Name)
+	return nil
+}
+
+// Reason codes of uploads that were skipped on purpose
+const (
+	skipUnchanged      = "unchanged"        // same digest as the last upload to the sink
+	skipDisabledByMode = "disabled-by-mode" // UPLOAD_MODE=bundle publishes it inside bundle.tar.gz
+)
+
+// uploadSkips collects the skipped uploads of the cycle: per report file the sinks with
+// their reason code, and the count of every skipped artifact per reason for index.json
+var uploadSkips = &skipRecorder{
+	byArtifact: make(map[string]map[string]string),
+	counts:     &retryCounter{counts: make(map[string]int)},
+}
+
+type skipRecorder struct {
+	mu         sync.Mutex
+	byArtifact map[string]map[string]string
+	counts     *retryCounter
+}
+
+func (r *skipRecorder) record(artifact, sink, reason string) {
+	r.counts.add(reason)
+	if !isReportFile(artifact) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byArtifact[artifact] == nil {
+		r.byArtifact[artifact] = make(map[string]string)
+	}
+	r.byArtifact[artifact][sink] = reason
+}
+
+// artifact returns and clears the skipped sinks of a report file, nil without any
+func (r *skipRecorder) artifact(name string) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	skipped := r.byArtifact[name]
+	delete(r.byArtifact, name)
+	return skipped
+}
+
+// uploadedDigests remembers the digest of the last successful upload of each artifact per
+// sink for the lifetime of the process. Batching sinks assemble every cycle from its Puts
+// and always get the artifact.
+var uploadedDigests = &digestRecorder{digests: make(map[string]string)}
+
+type digestRecorder struct {
+	mu      sync.Mutex
+	digests map[string]string
+}
+
+func (r *digestRecorder) unchanged(sink Sink, a Artifact) bool {
+	if a.Digest == "" {
+		return false
+	}
+	if _, ok := sink.(cycleSink); ok {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.digests[sink.Name()+"/"+a.Name] == a.Digest
+}
+
+func (r *digestRecorder) remember(sink Sink, a Artifact) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a.Digest == "" {
+		delete(r.digests, sink.Name()+"/"+a.Name)
+		return
+	}
+	r.digests[sink.Name()+"/"+a.Name] = a.Digest
+}
+
+// cycleArtifacts lists the artifacts published during the current cycle for index.json.
+// Per-item artifacts in subdirectories (overflow/) are counted in the stats instead.
+var cycleArtifacts = &artifactRecorder{names: make(map[string]bool)}
+
+type artifactRecorder struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func (r *artifactRecorder) record(name string) {
+	if strings.Contains(name, "/") {
+		return
+	}
+	r.mu.Lock()
+	r.names[name] = true
+	r.mu.Unlock()
+}
+
+// withIndexes returns the artifacts of the cycle including the index files published
+// after the index is built, and starts a new list
+func (r *artifactRecorder) withIndexes(cfg Config) []string {
+	r.record(ownIndexName(cfg))
+	r.record("index.json")
+	r.record(ownDeltaName(cfg))
+	return r.reset()
+}
+
+// reset returns the sorted names recorded since the last reset
+func (r *artifactRecorder) reset() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := sortedKeys(r.names)
+	r.names = make(map[string]bool)
+	return names
+}
+
+// errReadUnsupported is returned by write-only sinks, such as the push API and OCI bundles
+var errReadUnsupported = errors.New("sink does not support reads")
+
+// readArtifact reads back a cluster artifact published by this or a sibling exporter from
+// the first sink that can read, preferring FS_OUTPUT_DIR over the object stores. A missing
+// artifact returns nil data and no error; errReadUnsupported is returned when no sink can
+// read, so callers never mistake a write-only output for a missing artifact.
+func readArtifact(ctx context.Context, sinks []Sink, cfg Config, name string) ([]byte, error) {
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	for _, sink := range sinks {
+		var data []byte
+		err := withRetry(ctx, cfg, sink, func() error {
+			var err error
+			data, err = sink.Get(ctx, name)
+			return err
+		})
+		if errors.Is(err, errReadUnsupported) {
+			continue
+		}
+		return data, err
+	}
+	return nil, fmt.Errorf("no sink can read %s back: %w", name, errReadUnsupported)
+}
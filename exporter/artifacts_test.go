@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakySink stands in for S3: its first failures Puts fail with err, later ones succeed
+type flakySink struct {
+	failures int
+	err      error
+	puts     int
+	objects  map[string][]byte
+}
+
+func (s *flakySink) Name() string { return "s3" }
+
+func (s *flakySink) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	s.puts++
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if s.puts <= s.failures {
+		return s.err
+	}
+	s.objects[key] = data
+	return nil
+}
+
+func (s *flakySink) Get(ctx context.Context, key string) ([]byte, error) { return s.objects[key], nil }
+func (s *flakySink) Delete(ctx context.Context, key string) error        { return nil }
+
+// Report files streamed from their temp file and small generated documents such as
+// index.json must fail, retry and be recorded the same way
+func TestPublishArtifactSmallAndStreamed(t *testing.T) {
+	content := []byte(`{"items": []}`)
+	artifacts := map[string]func(t *testing.T) Artifact{
+		"report file": func(t *testing.T) Artifact {
+			f, err := os.CreateTemp(t.TempDir(), "report-*.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { f.Close() })
+			f.Write(content)
+			return Artifact{Name: "vulnerability-reports.json", File: f}
+		},
+		"index buffer": func(t *testing.T) Artifact {
+			return Artifact{Name: "index.json", Data: content}
+		},
+	}
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		puts      int
+		retries   int
+		published bool
+	}{
+		{name: "transient failures are retried", failures: 2, err: &httpStatusError{code: 503}, puts: 3, retries: 2, published: true},
+		{name: "persistent failure gives up", failures: 10, err: &httpStatusError{code: 503}, puts: 3, retries: 2},
+		{name: "forbidden fails fast", failures: 10, err: &httpStatusError{code: 403}, puts: 1},
+	}
+	for _, kind := range sortedKeys(artifacts) {
+		for _, tt := range tests {
+			t.Run(kind+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				s3 := &flakySink{failures: tt.failures, err: tt.err, objects: make(map[string][]byte)}
+				fs := &fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}
+				cfg := Config{RetryPolicies: map[string]RetryPolicy{
+					"s3": {MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Retryable: isRetryableAWSError},
+				}}
+				retryCounts.reset()
+				cycleArtifacts.reset()
+
+				a := artifacts[kind](t)
+				err := publishArtifact(context.Background(), []Sink{fs, s3}, cfg, a)
+				if (err == nil) != tt.published {
+					t.Fatalf("err = %v, want published %v", err, tt.published)
+				}
+				if s3.puts != tt.puts {
+					t.Errorf("S3 puts = %d, want %d", s3.puts, tt.puts)
+				}
+				if retries := retryCounts.reset()["s3"]; retries != tt.retries {
+					t.Errorf("S3 retries = %d, want %d", retries, tt.retries)
+				}
+				if tt.published && string(s3.objects[a.Name]) != string(content) {
+					t.Errorf("S3 object = %q, want the full content on the last attempt", s3.objects[a.Name])
+				}
+				// The other sinks get the artifact either way
+				if data, err := os.ReadFile(filepath.Join(dir, "prod-"+a.Name)); err != nil || string(data) != string(content) {
+					t.Errorf("FS copy = %q, %v", data, err)
+				}
+				if recorded := slices.Contains(cycleArtifacts.reset(), a.Name); recorded != tt.published {
+					t.Errorf("listed in the artifacts of the cycle = %v, want %v", recorded, tt.published)
+				}
+			})
+		}
+	}
+}
+
+// Write-only sinks are skipped when reading artifacts back; without any readable sink the
+// read fails instead of reporting a missing artifact
+func TestReadArtifactSkipsWriteOnlySinks(t *testing.T) {
+	stored := &flakySink{objects: map[string][]byte{"index-cluster.json": []byte(`{"cluster": "prod"}`)}}
+	tests := []struct {
+		name  string
+		sinks []Sink
+		want  string
+		err   error
+	}{
+		{name: "no sinks"},
+		{name: "readable sink after write-only ones", sinks: []Sink{&pushSink{}, &ociSink{}, stored}, want: `{"cluster": "prod"}`},
+		{name: "write-only sinks", sinks: []Sink{&pushSink{}, &ociSink{}}, err: errReadUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := readArtifact(context.Background(), tt.sinks, Config{}, "index-cluster.json")
+			if string(data) != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("readArtifact() = %q, %v; want %q, %v", data, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+// A push-only deployment never rewrites deletions-audit.jsonl from its pending records alone
+func TestFlushDeletionAuditWriteOnlySinks(t *testing.T) {
+	var posts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+	}))
+	defer server.Close()
+	sinks := []Sink{&pushSink{client: server.Client(), url: server.URL, cluster: "prod"}}
+
+	pendingDeletions.add(DeletionRecord{Feature: "snapshot-retention", Reason: "expired", Sink: "push", Key: "snapshots/old.json"})
+	err := flushDeletionAudit(context.Background(), sinks, Config{})
+	if !errors.Is(err, errReadUnsupported) {
+		t.Errorf("flushDeletionAudit() = %v, want %v", err, errReadUnsupported)
+	}
+	if n := posts.Load(); n != 0 {
+		t.Errorf("%d artifacts pushed, want none", n)
+	}
+	pendingDeletions.mu.Lock()
+	defer pendingDeletions.mu.Unlock()
+	if len(pendingDeletions.records) != 0 {
+		t.Errorf("%d records still pending", len(pendingDeletions.records))
+	}
+}
+
+// Split SCOPE deployments sharing a sink each append to their own audit trail, so neither
+// rewrites the file without the records of the other
+func TestFlushDeletionAuditPerScope(t *testing.T) {
+	resetPendingDeletions()
+	t.Cleanup(resetPendingDeletions)
+	dir := t.TempDir()
+	sinks := []Sink{&fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}}
+	for cycle := 1; cycle <= 2; cycle++ {
+		for _, scope := range []string{scopeCluster, scopeNamespaced} {
+			pendingDeletions.add(DeletionRecord{CycleID: fmt.Sprint(cycle), Feature: "snapshot-retention", Reason: "expired", Sink: "fs", Key: scope})
+			if err := flushDeletionAudit(context.Background(), sinks, Config{Scope: scope}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, scope := range []string{scopeCluster, scopeNamespaced} {
+		data, err := os.ReadFile(filepath.Join(dir, "prod-deletions-audit-"+scope+".jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("%s trail has %d records, want 2:\n%s", scope, len(lines), data)
+		}
+		for i, line := range lines {
+			var r DeletionRecord
+			if err := json.Unmarshal([]byte(line), &r); err != nil || r.Key != scope || r.CycleID != fmt.Sprint(i+1) {
+				t.Errorf("%s record %d = %s", scope, i+1, line)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prod-"+deletionsAuditName)); !os.IsNotExist(err) {
+		t.Errorf("%s written by a split deployment", deletionsAuditName)
+	}
+}
+
+// Every feature that decides not to upload a report file records its reason code, per sink
+// in the stats of the report type and per reason for index.json
+func TestUploadSkipReasons(t *testing.T) {
+	resource := vulnerabilityResource
+	tests := []struct {
+		name    string
+		collect func(t *testing.T, sinks []Sink, cfg Config) ResourceStats
+		skipped map[string]string
+		counts  map[string]int
+	}{
+		{
+			name: "uploaded",
+			collect: func(t *testing.T, sinks []Sink, cfg Config) ResourceStats {
+				u := uploadReport(context.Background(), sinks, cfg, resource, "c1", testArtifact(t, "uploaded"))
+				return ResourceStats{SkippedUploads: u.skipped}
+			},
+			counts: map[string]int{},
+		},
+		{
+			name: "unchanged",
+			collect: func(t *testing.T, sinks []Sink, cfg Config) ResourceStats {
+				uploadReport(context.Background(), sinks, cfg, resource, "c1", testArtifact(t, "unchanged"))
+				uploadSkips.counts.reset()
+				u := uploadReport(context.Background(), sinks, cfg, resource, "c2", testArtifact(t, "unchanged"))
+				return ResourceStats{SkippedUploads: u.skipped}
+			},
+			skipped: map[string]string{"fs": skipUnchanged, "s3": skipUnchanged},
+			// The checksum sidecars are unchanged too
+			counts: map[string]int{skipUnchanged: 4},
+		},
+		{
+			name: "unchanged with FORCE_UPLOAD",
+			collect: func(t *testing.T, sinks []Sink, cfg Config) ResourceStats {
+				cfg.ForceUpload = true
+				uploadReport(context.Background(), sinks, cfg, resource, "c1", testArtifact(t, "forced"))
+				uploadSkips.counts.reset()
+				u := uploadReport(context.Background(), sinks, cfg, resource, "c2", testArtifact(t, "forced"))
+				return ResourceStats{SkippedUploads: u.skipped}
+			},
+			counts: map[string]int{},
+		},
+		{
+			name: "UPLOAD_MODE=bundle",
+			collect: func(t *testing.T, sinks []Sink, cfg Config) ResourceStats {
+				bundle, err := newReportBundle()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer bundle.cleanup()
+				calls := 0
+				client := pagedClient(t, []listPage{{items: []string{"a"}}}, &calls)
+				stats, err := collectResourcePaged(context.Background(), client, sinks, cfg, resource, "c1", nil, bundle, newUploadQueue(1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return stats
+			},
+			skipped: map[string]string{"fs": skipDisabledByMode, "s3": skipDisabledByMode},
+			counts:  map[string]int{skipDisabledByMode: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedStaging, savedDigests := stagingDir, uploadedDigests
+			defer func() { stagingDir, uploadedDigests = savedStaging, savedDigests }()
+			stagingDir = t.TempDir()
+			uploadedDigests = &digestRecorder{digests: make(map[string]string)}
+			dir := t.TempDir()
+			sinks := []Sink{
+				&fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions},
+				&flakySink{objects: make(map[string][]byte)},
+			}
+			cfg := Config{ClusterName: "prod", PageSize: 10, FSOutputDir: dir, FSLayout: fsLayoutFlat}
+			uploadSkips.counts.reset()
+
+			stats := tt.collect(t, sinks, cfg)
+			if !maps.Equal(stats.SkippedUploads, tt.skipped) {
+				t.Errorf("skippedUploads = %v, want %v", stats.SkippedUploads, tt.skipped)
+			}
+			if counts := uploadSkips.counts.reset(); !maps.Equal(counts, tt.counts) {
+				t.Errorf("skipped uploads per reason = %v, want %v", counts, tt.counts)
+			}
+		})
+	}
+}
+
+// testArtifact returns a report file whose digest is derived from its content
+func testArtifact(t *testing.T, content string) Artifact {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "report-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	f.WriteString(content)
+	sum := sha256.Sum256([]byte(content))
+	return Artifact{Name: vulnerabilityResource.FileName + ".json", File: f, Digest: hex.EncodeToString(sum[:])}
+}
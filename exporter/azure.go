@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// newAzureClient builds a Blob Storage client from AZURE_STORAGE_CONNECTION_STRING when set,
+// and otherwise from the default Azure credential chain (managed or workload identity on AKS)
+func newAzureClient(cfg Config) (*azblob.Client, error) {
+	// Retries are driven by RetryPolicy, so the SDK makes a single attempt per call
+	opts := &azblob.ClientOptions{ClientOptions: azcore.ClientOptions{
+		Retry: policy.RetryOptions{MaxRetries: -1},
+	}}
+	if cfg.AzureConnectionString != "" {
+		return azblob.NewClientFromConnectionString(cfg.AzureConnectionString, opts)
+	}
+	if cfg.AzureStorageAccount == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING is required with AZURE_CONTAINER")
+	}
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	return azblob.NewClient(fmt.Sprintf("https://%s.blob.core.windows.net/", cfg.AzureStorageAccount), cred, opts)
+}
+
+// azureSink writes to AZURE_CONTAINER under <AZURE_PREFIX>/<cluster>/
+type azureSink struct {
+	client    *azblob.Client
+	container string
+	prefix    string
+}
+
+func (s *azureSink) Name() string { return "azure" }
+
+// Put uploads in blocks, so report files are streamed from their temp file without being
+// loaded into memory
+func (s *azureSink) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	blobName := s.prefix + key
+	contentType := contentTypeFor(key)
+	_, err := s.client.UploadStream(ctx, s.container, blobName, r, &azblob.UploadStreamOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: &contentType},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s/%s: %w", s.container, blobName, err)
+	}
+	return nil
+}
+
+func (s *azureSink) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.client.DownloadStream(ctx, s.container, s.prefix+key, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *azureSink) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteBlob(ctx, s.container, s.prefix+key, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil
+	}
+	return err
+}
+
+// isRetryableAzureError classifies Blob Storage errors by status code like other HTTP errors
+func isRetryableAzureError(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == 429 || respErr.StatusCode >= 500
+	}
+	return isRetryableHTTPError(err)
+}
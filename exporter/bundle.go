@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// UPLOAD_MODE values
+const (
+	uploadFiles  = "files"  // every report file is its own artifact
+	uploadBundle = "bundle" // report files are published together as bundle.tar.gz
+)
+
+const bundleName = "bundle.tar.gz"
+
+// reportBundle streams the report files of a cycle into bundle.tar.gz, so consumers never
+// read a half-updated set. The archive is written to a temp file as reports are collected;
+// memory use does not depend on report sizes.
+type reportBundle struct {
+	mu    sync.Mutex // Report types are collected concurrently
+	file  *os.File
+	gz    *gzip.Writer
+	tw    *tar.Writer
+	files int
+	err   error // first write error; the archive is unusable after it
+}
+
+func newReportBundle() (*reportBundle, error) {
+	file, err := os.CreateTemp(stagingDir, "bundle-*.tar.gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	gz := gzip.NewWriter(file)
+	return &reportBundle{file: file, gz: gz, tw: tar.NewWriter(gz)}, nil
+}
+
+// add appends an artifact to the archive under its name
+func (b *reportBundle) add(a Artifact) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	r, err := a.open()
+	if err != nil {
+		b.err = err
+		return err
+	}
+	header := &tar.Header{
+		Name:    a.Name,
+		Mode:    0644,
+		Size:    a.size(),
+		ModTime: time.Now(),
+	}
+	if err := b.tw.WriteHeader(header); err != nil {
+		b.err = fmt.Errorf("failed to add %s to bundle: %w", a.Name, err)
+		return b.err
+	}
+	if _, err := io.Copy(b.tw, r); err != nil {
+		b.err = fmt.Errorf("failed to add %s to bundle: %w", a.Name, err)
+		return b.err
+	}
+	b.files++
+	return nil
+}
+
+// artifact finishes the archive and returns it as bundle.tar.gz
+func (b *reportBundle) artifact() (Artifact, error) {
+	if b.err != nil {
+		return Artifact{}, b.err
+	}
+	if err := b.tw.Close(); err != nil {
+		return Artifact{}, fmt.Errorf("failed to close bundle: %w", err)
+	}
+	if err := b.gz.Close(); err != nil {
+		return Artifact{}, fmt.Errorf("failed to close bundle: %w", err)
+	}
+	return Artifact{Name: bundleName, File: b.file}, nil
+}
+
+// publishBundle adds the index of the cycle to the bundle and publishes it
+func publishBundle(ctx context.Context, sinks []Sink, cfg Config, b *reportBundle, index ClusterIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+	if err := b.add(Artifact{Name: "index.json", Data: data}); err != nil {
+		return err
+	}
+	a, err := b.artifact()
+	if err != nil {
+		return err
+	}
+	if err := publishArtifact(ctx, sinks, cfg, a); err != nil {
+		return err
+	}
+	log.Printf("📦 Published %d files as %s (%d bytes)", b.files, bundleName, a.size())
+	return nil
+}
+
+// cleanup removes the temp file
+func (b *reportBundle) cleanup() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
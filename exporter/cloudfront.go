@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// Invalidations with more changed objects than this invalidate the cluster prefix with a
+// single wildcard path instead; CloudFront bills every listed path
+const maxInvalidationPaths = 50
+
+// cloudFrontInvalidation collects the S3 keys uploaded since the last invalidation and
+// invalidates them on CLOUDFRONT_DISTRIBUTION_ID once a cycle has published every report
+// type. Unchanged report files are not uploaded, so they are never invalidated.
+type cloudFrontInvalidation struct {
+	client         *cloudfront.Client
+	distributionID string
+	prefix         string // "<S3_PREFIX>/<cluster>/"
+	cluster        string
+
+	mu   sync.Mutex
+	keys map[string]string // artifact key -> bucket key
+}
+
+// newCloudFrontInvalidation returns nil without CLOUDFRONT_DISTRIBUTION_ID
+func newCloudFrontInvalidation(awsCfg aws.Config, cfg Config) *cloudFrontInvalidation {
+	if cfg.CloudFrontDistributionID == "" {
+		return nil
+	}
+	return &cloudFrontInvalidation{
+		client:         cloudfront.NewFromConfig(awsCfg),
+		distributionID: cfg.CloudFrontDistributionID,
+		prefix:         objectPrefix(cfg.S3Prefix, cfg.ClusterName),
+		cluster:        cfg.ClusterName,
+		keys:           make(map[string]string),
+	}
+}
+
+// changed records an uploaded key with its bucket key. Snapshot copies are written once
+// under a new timestamp and have never been cached.
+func (c *cloudFrontInvalidation) changed(key, objectKey string) {
+	if c == nil || strings.HasPrefix(key, snapshotDir+"/") {
+		return
+	}
+	c.mu.Lock()
+	c.keys[key] = objectKey
+	c.mu.Unlock()
+}
+
+// invalidate creates an invalidation for the keys uploaded since the last one and the
+// pending keys the cycle publishes afterwards (the index files), returning its ID. Nothing
+// is invalidated when no report file changed. Keys of a failed invalidation are kept for
+// the next cycle.
+func (c *cloudFrontInvalidation) invalidate(ctx context.Context, cfg Config, cycleID string, pending []string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reports := 0
+	for key := range c.keys {
+		if isReportFile(key) {
+			reports++
+		}
+	}
+	if reports == 0 {
+		log.Printf("♻️ No report file changed, skipping CloudFront invalidation")
+		return "", nil
+	}
+	for _, key := range pending {
+		c.keys[key] = c.prefix + key
+	}
+
+	// Report types under S3_PREFIX_<TYPE> overrides need a wildcard of their own
+	var paths []string
+	if len(c.keys) > maxInvalidationPaths {
+		wildcards := make(map[string]bool)
+		for key, objectKey := range c.keys {
+			wildcards["/"+strings.TrimSuffix(objectKey, key)+"*"] = true
+		}
+		paths = sortedKeys(wildcards)
+	} else {
+		for _, key := range sortedKeys(c.keys) {
+			paths = append(paths, "/"+c.keys[key])
+		}
+	}
+	input := &cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(c.distributionID),
+		InvalidationBatch: &cftypes.InvalidationBatch{
+			// A retried request with the same reference returns the invalidation created before
+			CallerReference: aws.String(c.cluster + "-" + cycleID),
+			Paths:           &cftypes.Paths{Quantity: aws.Int32(int32(len(paths))), Items: paths},
+		},
+	}
+	var out *cloudfront.CreateInvalidationOutput
+	err := cfg.retryPolicy("cloudfront").Do(ctx, "cloudfront", func() error {
+		var err error
+		out, err = c.client.CreateInvalidation(ctx, input)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to invalidate %d paths on CloudFront distribution %s: %w", len(paths), c.distributionID, err)
+	}
+	id := aws.ToString(out.Invalidation.Id)
+	log.Printf("🌐 Created CloudFront invalidation %s for %d changed objects (%d report files)", id, len(c.keys), reports)
+	c.keys = make(map[string]string)
+	return id, nil
+}
+
+// invalidateCDN invalidates the objects changed on S3 during the cycle, returning the
+// invalidation ID or "" when there is none. Failures are only logged.
+func invalidateCDN(ctx context.Context, sinks []Sink, cfg Config, cycleID string, pending []string) string {
+	for _, sink := range sinks {
+		s, ok := sink.(*s3Sink)
+		if !ok || s.cdn == nil {
+			continue
+		}
+		id, err := s.cdn.invalidate(ctx, cfg, cycleID, pending)
+		if err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// listPage is one response of a paged test list: its items and continue token, or an error
+type listPage struct {
+	items []string
+	next  string
+	err   error
+}
+
+func vulnerableReport(name string) *unstructured.Unstructured {
+	u := testReport("default", name, "1")
+	u.Object["report"] = map[string]interface{}{
+		"summary":         map[string]interface{}{"highCount": int64(1)},
+		"vulnerabilities": []interface{}{map[string]interface{}{"vulnerabilityID": "CVE-" + name, "severity": "HIGH"}},
+	}
+	return u
+}
+
+// pagedClient answers the LIST requests of a report type with pages in order and counts
+// them. The fake client does not pass continue tokens on, so each page must be asked for.
+func pagedClient(t *testing.T, pages []listPage, calls *int) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	gvr := reportGVR(vulnerabilityResource)
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "VulnerabilityReportList"})
+	client.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*calls++
+		if len(pages) == 0 {
+			t.Fatalf("unexpected LIST request %d", *calls)
+		}
+		page := pages[0]
+		pages = pages[1:]
+		if page.err != nil {
+			return true, nil, page.err
+		}
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{
+			"apiVersion": gvr.GroupVersion().String(),
+			"kind":       "VulnerabilityReportList",
+		}}
+		list.SetContinue(page.next)
+		for _, name := range page.items {
+			list.Items = append(list.Items, *vulnerableReport(name))
+		}
+		return true, list, nil
+	})
+	return client
+}
+
+func TestCollectResourcePagedRestartsExpiredList(t *testing.T) {
+	expired := apierrors.NewResourceExpired("continue token expired")
+	tests := []struct {
+		name     string
+		restarts int
+		pages    []listPage
+		items    []string // Items of the report file, nil when the type fails
+		restart  int
+	}{
+		{
+			name:  "pages are followed to the last",
+			pages: []listPage{{items: []string{"a", "b"}, next: "p2"}, {items: []string{"c"}}},
+			items: []string{"a", "b", "c"},
+		},
+		{
+			name:     "expired token restarts the list",
+			restarts: 2,
+			pages: []listPage{
+				{items: []string{"a", "b"}, next: "p2"},
+				{err: expired},
+				// b was deleted before the list started over
+				{items: []string{"a", "c"}, next: "q2"},
+				{items: []string{"d"}},
+			},
+			items:   []string{"a", "c", "d"},
+			restart: 1,
+		},
+		{
+			name:     "restarts are limited by LIST_RESTARTS",
+			restarts: 1,
+			pages: []listPage{
+				{items: []string{"a"}, next: "p2"},
+				{err: expired},
+				{items: []string{"a"}, next: "q2"},
+				{err: expired},
+			},
+		},
+		{
+			name:     "expiry without a continue token is not restarted",
+			restarts: 2,
+			pages:    []listPage{{err: expired}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			client := pagedClient(t, tt.pages, &calls)
+			dir := t.TempDir()
+			cfg := Config{ClusterName: "prod", PageSize: 2, ListRestarts: tt.restarts, FSOutputDir: dir, FSLayout: fsLayoutFlat}
+			sinks := []Sink{&fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}}
+
+			broker := newFindingsBroker(10)
+			findingsFeed = broker
+			defer func() { findingsFeed = nil }()
+			broker.begin(vulnerabilityResource.Kind)
+
+			uploads := newUploadQueue(1)
+			stats, err := collectResourcePaged(context.Background(), client, sinks, cfg, vulnerabilityResource, "20260301-100000", nil, nil, uploads)
+			uploaded := uploads.wait()
+
+			if calls != len(tt.pages) {
+				t.Errorf("made %d LIST requests, want %d", calls, len(tt.pages))
+			}
+			if tt.items == nil {
+				if !apierrors.IsResourceExpired(err) {
+					t.Fatalf("err = %v, want the 410 of the list", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u := uploaded[vulnerabilityResource.Name]; u.err != nil {
+				t.Fatal(u.err)
+			}
+			if stats.Items != len(tt.items) || stats.ListRestarts != tt.restart {
+				t.Errorf("stats items=%d restarts=%d, want %d and %d", stats.Items, stats.ListRestarts, len(tt.items), tt.restart)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "prod-vulnerability-reports.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var report struct {
+				Items []metav1.PartialObjectMetadata `json:"items"`
+			}
+			if err := json.Unmarshal(data, &report); err != nil {
+				t.Fatalf("report file is not valid JSON: %v\n%s", err, data)
+			}
+			var names []string
+			for _, item := range report.Items {
+				names = append(names, item.Name)
+			}
+			if !slices.Equal(names, tt.items) {
+				t.Errorf("report items = %v, want %v", names, tt.items)
+			}
+
+			// Findings streamed to gRPC subscribers start over with the list
+			var ids []string
+			for _, f := range broker.pending[vulnerabilityResource.Kind] {
+				ids = append(ids, f.ID)
+			}
+			slices.Sort(ids)
+			var want []string
+			for _, name := range tt.items {
+				want = append(want, "CVE-"+name)
+			}
+			if !slices.Equal(ids, want) {
+				t.Errorf("findings = %v, want %v", ids, want)
+			}
+		})
+	}
+}
+
+func TestCollectResourcePagedCalibratesDefaultPageSizes(t *testing.T) {
+	sbom := vulnerabilityResource
+	sbom.SBOM = true
+	tests := []struct {
+		name     string
+		resource ReportResource
+		cfg      Config
+		want     int
+	}{
+		{name: "default PAGE_SIZE", resource: vulnerabilityResource, want: maxAutoPageSize},
+		{name: "explicit PAGE_SIZE", resource: vulnerabilityResource, cfg: Config{FixedPageSize: true}, want: 2},
+		{name: "explicit PAGE_SIZE leaves SBOM types calibrated", resource: sbom, cfg: Config{FixedPageSize: true}, want: maxAutoPageSize},
+		{name: "explicit SBOM_PAGE_SIZE", resource: sbom, cfg: Config{FixedSBOMPageSize: true}, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calibratedPageSizes.sizes = make(map[string]int64)
+			calls := 0
+			client := pagedClient(t, []listPage{{items: []string{"a"}}}, &calls)
+			cfg := tt.cfg
+			cfg.ClusterName, cfg.PageSize, cfg.SBOMPageSize = "prod", 2, 3
+			cfg.AutoPageSize, cfg.PageMemoryBudget = true, 1
+
+			uploads := newUploadQueue(1)
+			stats, err := collectResourcePaged(context.Background(), client, nil, cfg, tt.resource, "20260301-100000", nil, nil, uploads)
+			uploads.wait()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.PageSize != tt.want {
+				t.Errorf("page size = %d, want %d", stats.PageSize, tt.want)
+			}
+		})
+	}
+}
+
+// repeatPage returns more responses than a test list under a deadline ever gets to
+func repeatPage(page listPage) []listPage {
+	pages := make([]listPage, 1000)
+	for i := range pages {
+		pages[i] = page
+	}
+	return pages
+}
+
+func TestCollectResourcePagedDeadline(t *testing.T) {
+	throttled := apierrors.NewTooManyRequests("slow down", 0)
+	tests := []struct {
+		name     string
+		pages    []listPage
+		deadline time.Duration
+		wantErr  bool
+	}{
+		{name: "retries within the deadline", pages: []listPage{{err: throttled}, {items: []string{"a"}, next: "p2"}, {items: []string{"b"}}}, deadline: 5 * time.Second},
+		{name: "retries stop at the deadline", pages: repeatPage(listPage{err: throttled}), deadline: 200 * time.Millisecond, wantErr: true},
+		{name: "deadline covers every page", pages: repeatPage(listPage{items: []string{"a"}, next: "more"}), deadline: 200 * time.Millisecond, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			client := pagedClient(t, tt.pages, &calls)
+			policy := RetryPolicy{MaxAttempts: 1000, BaseDelay: 20 * time.Millisecond, MaxDelay: 20 * time.Millisecond,
+				Deadline: tt.deadline, Retryable: isRetryableK8sError}
+			cfg := Config{ClusterName: "prod", PageSize: 1, PageDelay: 5 * time.Millisecond,
+				RetryPolicies: map[string]RetryPolicy{"kubernetes": policy}}
+
+			started := time.Now()
+			uploads := newUploadQueue(1)
+			_, err := collectResourcePaged(context.Background(), client, nil, cfg, vulnerabilityResource, "20260301-100000", nil, nil, uploads)
+			uploads.wait()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(started); elapsed > tt.deadline+time.Second {
+				t.Errorf("collection took %v with a deadline of %v", elapsed, tt.deadline)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "RETRY_KUBERNETES_DEADLINE") {
+				t.Errorf("err = %v, want the deadline named", err)
+			}
+		})
+	}
+}
+
+// API errors are told apart by their status, never by their message
+func TestCollectResourcePagedAPIErrors(t *testing.T) {
+	gr := reportGVR(vulnerabilityResource).GroupResource()
+	notFound := apierrors.NewNotFound(gr, "")
+	tests := []struct {
+		name        string
+		pages       []listPage
+		skipMissing bool
+		unavailable bool
+		reportFile  bool
+		forbidden   bool
+		err         string
+	}{
+		{name: "missing CRD", pages: []listPage{{err: notFound}}, unavailable: true, reportFile: true},
+		{name: "missing CRD skipped", pages: []listPage{{err: notFound}}, skipMissing: true, unavailable: true},
+		{
+			name:        "missing CRD on a localized API server",
+			pages:       []listPage{{err: apierrors.NewGenericServerResponse(404, "list", gr, "", "die angeforderte Ressource wurde nicht gefunden", 0, false)}},
+			unavailable: true,
+			reportFile:  true,
+		},
+		{
+			name:  "404 in the middle of a list",
+			pages: []listPage{{items: []string{"a"}, next: "p2"}, {err: notFound}},
+			err:   "failed to list vulnerabilityreports",
+		},
+		{
+			name:      "forbidden",
+			pages:     []listPage{{err: apierrors.NewForbidden(gr, "", errors.New("RBAC: access denied"))}},
+			forbidden: true,
+			err:       "missing RBAC for vulnerabilityreports, need list on vulnerabilityreports.aquasecurity.github.io",
+		},
+		{
+			name:  "not-found message without a status",
+			pages: []listPage{{err: errors.New("the server could not find the requested resource")}},
+			err:   "failed to list vulnerabilityreports",
+		},
+		{
+			name:  "server error",
+			pages: []listPage{{err: apierrors.NewInternalError(errors.New("etcd unavailable"))}},
+			err:   "failed to list vulnerabilityreports",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			client := pagedClient(t, tt.pages, &calls)
+			dir := t.TempDir()
+			// Every case writes the same empty report file, which would otherwise be skipped as unchanged
+			cfg := Config{ClusterName: "prod", PageSize: 1, SkipMissingResources: tt.skipMissing, ForceUpload: true, FSOutputDir: dir, FSLayout: fsLayoutFlat}
+			sinks := []Sink{&fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}}
+
+			uploads := newUploadQueue(1)
+			stats, err := collectResourcePaged(context.Background(), client, sinks, cfg, vulnerabilityResource, "20260301-100000", nil, nil, uploads)
+			uploads.wait()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				if apierrors.IsForbidden(err) != tt.forbidden || exitCode(err) != exitK8s {
+					t.Errorf("err = %v: forbidden %v, exit code %d; want forbidden %v, exit code %d",
+						err, apierrors.IsForbidden(err), exitCode(err), tt.forbidden, exitK8s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Unavailable != tt.unavailable || stats.Items != 0 {
+				t.Errorf("stats unavailable=%v items=%d, want unavailable %v and no items", stats.Unavailable, stats.Items, tt.unavailable)
+			}
+			// The empty report file replaces the previous one unless SKIP_MISSING_RESOURCES is set
+			_, statErr := os.Stat(filepath.Join(dir, "prod-vulnerability-reports.json"))
+			if (statErr == nil) != tt.reportFile {
+				t.Errorf("report file written = %v, want %v", statErr == nil, tt.reportFile)
+			}
+		})
+	}
+}
+
+// index.json tells report types without a CRD from report types the exporter may not list
+func TestIndexForbiddenAndUnavailableResources(t *testing.T) {
+	saved := reportResources
+	defer func() { reportResources = saved }()
+	resources := knownReportResources[:3]
+	reportResources = resources
+
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for _, r := range resources {
+		listKinds[reportGVR(r)] = r.Kind + "List"
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	client.PrependReactor("list", resources[0].Name, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(reportGVR(resources[0]).GroupResource(), "")
+	})
+	client.PrependReactor("list", resources[1].Name, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(reportGVR(resources[1]).GroupResource(), "", errors.New("RBAC: access denied"))
+	})
+
+	cfg := configFromEnv()
+	cfg.ClusterName = "prod"
+	cfg.FSOutputDir = t.TempDir()
+	cfg.FSLayout = fsLayoutFlat
+	cfg.S3Bucket = ""
+	sinks, err := newSinks(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := collectAndUploadAll(context.Background(), client, sinks, cfg); exitCode(err) != exitPartial {
+		t.Fatalf("cycle err = %v, want a partial collection", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cfg.FSOutputDir, "prod-index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index ClusterIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{resources[0].Name}; !slices.Equal(index.UnavailableResources, want) {
+		t.Errorf("unavailableResources = %v, want %v", index.UnavailableResources, want)
+	}
+	if want := []string{resources[1].Name}; !slices.Equal(index.ForbiddenResources, want) {
+		t.Errorf("forbiddenResources = %v, want %v", index.ForbiddenResources, want)
+	}
+}
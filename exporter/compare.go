@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// compareTypes maps the --types values to the report files they are built from
+var compareTypes = map[string]string{
+	"compliance":      "cluster-compliance-reports",
+	"vulnerabilities": "vulnerability-reports",
+}
+
+// Maximum number of rows per section printed in the stdout table (the JSON output is complete)
+const compareTableRows = 25
+
+// CompareResult is the structured output of `exporter compare`
+type CompareResult struct {
+	Clusters        []string                 `json:"clusters"`
+	GeneratedAt     string                   `json:"generatedAt"`
+	Compliance      *ComplianceComparison    `json:"compliance,omitempty"`
+	Vulnerabilities *VulnerabilityComparison `json:"vulnerabilities,omitempty"`
+}
+
+// SchemaInfo records the apiVersion each cluster's artifact was written with
+type SchemaInfo struct {
+	Versions map[string]string `json:"versions"`
+	Mismatch bool              `json:"mismatch"`
+}
+
+type ComplianceComparison struct {
+	Schema  SchemaInfo       `json:"schema"`
+	Reports []ComplianceDiff `json:"reports,omitempty"`
+}
+
+// ComplianceDiff lists the controls of one compliance report failing in exactly one cluster
+type ComplianceDiff struct {
+	Report        string                         `json:"report"`
+	FailingOnlyIn map[string][]ComplianceControl `json:"failingOnlyIn"`
+}
+
+type ComplianceControl struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Severity  string `json:"severity"`
+	TotalFail int    `json:"totalFail"`
+	// Status of the control in the other cluster: "pass" or "absent"
+	OtherStatus string `json:"otherStatus"`
+}
+
+type VulnerabilityComparison struct {
+	Schema          SchemaInfo                 `json:"schema"`
+	OnlyIn          map[string][]CVEOccurrence `json:"onlyIn,omitempty"`
+	NamespaceDeltas []NamespaceDelta           `json:"namespaceDeltas,omitempty"`
+}
+
+type CVEOccurrence struct {
+	ID       string   `json:"id"`
+	Severity string   `json:"severity"`
+	Images   []string `json:"images"`
+}
+
+// NamespaceDelta compares finding counts for a namespace present in both clusters.
+// Delta is the second cluster's count minus the first cluster's count.
+type NamespaceDelta struct {
+	Namespace string                    `json:"namespace"`
+	Counts    map[string]SeverityCounts `json:"counts"`
+	Delta     SeverityCounts            `json:"delta"`
+}
+
+type SeverityCounts struct {
+	Critical int `json:"critical"`
+	High     int `json:"high"`
+	Medium   int `json:"medium"`
+	Low      int `json:"low"`
+	Unknown  int `json:"unknown"`
+}
+
+func (c *SeverityCounts) add(severity string) {
+	switch strings.ToUpper(severity) {
+	case "CRITICAL":
+		c.Critical++
+	case "HIGH":
+		c.High++
+	case "MEDIUM":
+		c.Medium++
+	case "LOW":
+		c.Low++
+	default:
+		c.Unknown++
+	}
+}
+
+func (c SeverityCounts) sub(o SeverityCounts) SeverityCounts {
+	return SeverityCounts{
+		Critical: c.Critical - o.Critical,
+		High:     c.High - o.High,
+		Medium:   c.Medium - o.Medium,
+		Low:      c.Low - o.Low,
+		Unknown:  c.Unknown - o.Unknown,
+	}
+}
+
+func (c SeverityCounts) total() int {
+	return c.Critical + c.High + c.Medium + c.Low + c.Unknown
+}
+
+func severityRank(severity string) int {
+	switch strings.ToUpper(severity) {
+	case "CRITICAL":
+		return 0
+	case "HIGH":
+		return 1
+	case "MEDIUM":
+		return 2
+	case "LOW":
+		return 3
+	default:
+		return 4
+	}
+}
+
+// runCompare implements `exporter compare`
+func runCompare(args []string) error {
+	var s3Cfg Config
+	loadS3Settings(&s3Cfg)
+
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	bucket := fs.String("bucket", getEnv("S3_BUCKET", ""), "S3 bucket holding the exported reports")
+	prefix := fs.String("prefix", getEnv("S3_PREFIX", "vuln"), "S3 prefix the exporters write under")
+	fs.StringVar(&s3Cfg.AWSRegion, "region", s3Cfg.AWSRegion, "AWS region of the bucket")
+	fs.StringVar(&s3Cfg.S3Endpoint, "endpoint", s3Cfg.S3Endpoint, "S3-compatible endpoint, e.g. minio.example.com:9000")
+	fs.BoolVar(&s3Cfg.S3ForcePathStyle, "path-style", s3Cfg.S3ForcePathStyle, "use path-style addressing")
+	clusters := fs.String("clusters", "", "two comma-separated cluster names, e.g. prod,staging")
+	types := fs.String("types", "compliance,vulnerabilities", "comma-separated comparison types: compliance, vulnerabilities")
+	out := fs.String("out", "compare.json", "path of the JSON result (empty to skip)")
+	if err := fs.Parse(args); err != nil {
+		return configError(err)
+	}
+
+	if *bucket == "" {
+		return configError(fmt.Errorf("--bucket is required"))
+	}
+	names := splitList(*clusters)
+	if len(names) != 2 {
+		return configError(fmt.Errorf("--clusters must name exactly two clusters, got %q", *clusters))
+	}
+	typeList := splitList(*types)
+	for _, t := range typeList {
+		if _, ok := compareTypes[t]; !ok {
+			return configError(fmt.Errorf("unknown comparison type %q (valid: compliance, vulnerabilities)", t))
+		}
+	}
+
+	ctx := context.Background()
+	s3Client, err := newS3Client(ctx, s3Cfg)
+	if err != nil {
+		return storageError(fmt.Errorf("failed to create S3 client: %w", err))
+	}
+	retryFile, err := loadRetryPolicyFile(getEnv("RETRY_POLICY_FILE", ""))
+	if err != nil {
+		return configError(err)
+	}
+	_, policies := loadRetryPolicies(retryFile)
+	retry := policies["s3"]
+
+	open := func(cluster, fileName string) (io.ReadCloser, error) {
+		// Keys are mapped like the exporter of the cluster published them, including the
+		// report types moved under S3_PREFIX_<TYPE>
+		s3Cfg.S3Prefix, s3Cfg.ClusterName = *prefix, cluster
+		published := &s3Sink{prefix: objectPrefix(*prefix, cluster), reportPrefixes: s3ReportPrefixes(s3Cfg)}
+		key := published.objectKey(fileName + ".json")
+		var obj *s3.GetObjectOutput
+		err := retry.Do(ctx, "s3", func() error {
+			var err error
+			obj, err = s3Client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(*bucket),
+				Key:    aws.String(key),
+			})
+			return err
+		})
+		if err != nil {
+			return nil, storageError(fmt.Errorf("failed to download s3://%s/%s: %w", *bucket, key, err))
+		}
+		return decodeContent(obj.Body, aws.ToString(obj.ContentEncoding))
+	}
+
+	result := CompareResult{
+		Clusters:    names,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for _, t := range typeList {
+		log.Printf("📥 Comparing %s for %s...", t, strings.Join(names, " vs "))
+		switch t {
+		case "compliance":
+			result.Compliance, err = compareCompliance(names, open)
+		case "vulnerabilities":
+			result.Vulnerabilities, err = compareVulnerabilities(names, open)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if *out != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal comparison: %w", err)
+		}
+		if err := os.WriteFile(*out, data, 0644); err != nil {
+			return storageError(fmt.Errorf("failed to write %s: %w", *out, err))
+		}
+		log.Printf("💾 Saved comparison to %s", *out)
+	}
+
+	printComparison(os.Stdout, result)
+	return nil
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+type openArtifactFunc func(cluster, fileName string) (io.ReadCloser, error)
+
+// streamArtifact feeds every item of a cluster's artifact to fn and returns its schema
+// version, the apiVersion the exporter wrote at the top of the file. The apiVersion of the
+// items is the one of each report object and can differ within a file.
+func streamArtifact(open openArtifactFunc, cluster, fileName string, fn func(item map[string]interface{})) (string, error) {
+	body, err := open(cluster, fileName)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	stream := newJSONStream(body)
+	for {
+		item, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", validationError(fmt.Errorf("failed to parse %s for %s: %w", fileName, cluster, err))
+		}
+		fn(item)
+	}
+	return stream.APIVersion, nil
+}
+
+func checkSchema(versions map[string]string, names []string) SchemaInfo {
+	info := SchemaInfo{Versions: versions}
+	if versions[names[0]] != versions[names[1]] {
+		info.Mismatch = true
+		log.Printf("⚠️ Schema mismatch: %s=%q, %s=%q; skipping comparison",
+			names[0], versions[names[0]], names[1], versions[names[1]])
+	}
+	return info
+}
+
+func compareCompliance(names []string, open openArtifactFunc) (*ComplianceComparison, error) {
+	// cluster -> report -> control ID -> control
+	controls := make(map[string]map[string]map[string]ComplianceControl)
+	versions := make(map[string]string)
+
+	for _, cluster := range names {
+		reports := make(map[string]map[string]ComplianceControl)
+		version, err := streamArtifact(open, cluster, compareTypes["compliance"], func(item map[string]interface{}) {
+			name, _, _ := unstructured.NestedString(item, "metadata", "name")
+			checks := nestedSlice(item, "status", "summaryReport", "controlCheck")
+			byID := make(map[string]ComplianceControl)
+			for _, c := range checks {
+				check, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				ctrl := ComplianceControl{TotalFail: toInt(check["totalFail"])}
+				ctrl.ID, _ = check["id"].(string)
+				ctrl.Name, _ = check["name"].(string)
+				ctrl.Severity, _ = check["severity"].(string)
+				byID[ctrl.ID] = ctrl
+			}
+			reports[name] = byID
+		})
+		if err != nil {
+			return nil, err
+		}
+		versions[cluster] = version
+		controls[cluster] = reports
+	}
+
+	result := &ComplianceComparison{Schema: checkSchema(versions, names)}
+	if result.Schema.Mismatch {
+		return result, nil
+	}
+
+	reportNames := make(map[string]bool)
+	for _, reports := range controls {
+		for name := range reports {
+			reportNames[name] = true
+		}
+	}
+
+	for _, report := range sortedKeys(reportNames) {
+		diff := ComplianceDiff{Report: report, FailingOnlyIn: make(map[string][]ComplianceControl)}
+		for i, cluster := range names {
+			other := controls[names[1-i]][report]
+			for _, ctrl := range controls[cluster][report] {
+				if ctrl.TotalFail == 0 {
+					continue
+				}
+				otherCtrl, present := other[ctrl.ID]
+				switch {
+				case !present:
+					ctrl.OtherStatus = "absent"
+				case otherCtrl.TotalFail == 0:
+					ctrl.OtherStatus = "pass"
+				default:
+					continue
+				}
+				diff.FailingOnlyIn[cluster] = append(diff.FailingOnlyIn[cluster], ctrl)
+			}
+			sort.Slice(diff.FailingOnlyIn[cluster], func(a, b int) bool {
+				return diff.FailingOnlyIn[cluster][a].ID < diff.FailingOnlyIn[cluster][b].ID
+			})
+		}
+		if len(diff.FailingOnlyIn) > 0 {
+			result.Reports = append(result.Reports, diff)
+		}
+	}
+	return result, nil
+}
+
+type clusterVulns struct {
+	cves       map[string]*CVEOccurrence
+	images     map[string]map[string]bool
+	namespaces map[string]*SeverityCounts
+}
+
+func compareVulnerabilities(names []string, open openArtifactFunc) (*VulnerabilityComparison, error) {
+	data := make(map[string]*clusterVulns)
+	versions := make(map[string]string)
+
+	for _, cluster := range names {
+		cv := &clusterVulns{
+			cves:       make(map[string]*CVEOccurrence),
+			images:     make(map[string]map[string]bool),
+			namespaces: make(map[string]*SeverityCounts),
+		}
+		version, err := streamArtifact(open, cluster, compareTypes["vulnerabilities"], func(item map[string]interface{}) {
+			namespace, _, _ := unstructured.NestedString(item, "metadata", "namespace")
+			image := imageRef(item)
+			vulns := nestedSlice(item, "report", "vulnerabilities")
+
+			counts := cv.namespaces[namespace]
+			if counts == nil {
+				counts = &SeverityCounts{}
+				cv.namespaces[namespace] = counts
+			}
+			for _, v := range vulns {
+				vuln, ok := v.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				id, _ := vuln["vulnerabilityID"].(string)
+				severity, _ := vuln["severity"].(string)
+				counts.add(severity)
+				if id == "" {
+					continue
+				}
+				if cv.cves[id] == nil {
+					cv.cves[id] = &CVEOccurrence{ID: id, Severity: severity}
+					cv.images[id] = make(map[string]bool)
+				}
+				if image != "" {
+					cv.images[id][image] = true
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		versions[cluster] = version
+		data[cluster] = cv
+	}
+
+	result := &VulnerabilityComparison{Schema: checkSchema(versions, names)}
+	if result.Schema.Mismatch {
+		return result, nil
+	}
+
+	result.OnlyIn = make(map[string][]CVEOccurrence)
+	for i, cluster := range names {
+		other := data[names[1-i]]
+		var only []CVEOccurrence
+		for id, occ := range data[cluster].cves {
+			if _, ok := other.cves[id]; ok {
+				continue
+			}
+			occ.Images = sortedKeys(data[cluster].images[id])
+			only = append(only, *occ)
+		}
+		sort.Slice(only, func(a, b int) bool {
+			if ra, rb := severityRank(only[a].Severity), severityRank(only[b].Severity); ra != rb {
+				return ra < rb
+			}
+			return only[a].ID < only[b].ID
+		})
+		result.OnlyIn[cluster] = only
+	}
+
+	// Namespaces are matched by name; ones present in a single cluster are not compared
+	var shared []string
+	for ns := range data[names[0]].namespaces {
+		if _, ok := data[names[1]].namespaces[ns]; ok {
+			shared = append(shared, ns)
+		}
+	}
+	sort.Strings(shared)
+	for _, ns := range shared {
+		a, b := *data[names[0]].namespaces[ns], *data[names[1]].namespaces[ns]
+		result.NamespaceDeltas = append(result.NamespaceDeltas, NamespaceDelta{
+			Namespace: ns,
+			Counts:    map[string]SeverityCounts{names[0]: a, names[1]: b},
+			Delta:     b.sub(a),
+		})
+	}
+	return result, nil
+}
+
+// imageRef builds registry/repository:tag (or @digest) from a VulnerabilityReport item
+func imageRef(item map[string]interface{}) string {
+	registry, _, _ := unstructured.NestedString(item, "report", "registry", "server")
+	repository, _, _ := unstructured.NestedString(item, "report", "artifact", "repository")
+	tag, _, _ := unstructured.NestedString(item, "report", "artifact", "tag")
+	digest, _, _ := unstructured.NestedString(item, "report", "artifact", "digest")
+	if repository == "" {
+		return ""
+	}
+	ref := repository
+	if registry != "" {
+		ref = registry + "/" + repository
+	}
+	switch {
+	case tag != "":
+		ref += ":" + tag
+	case digest != "":
+		ref += "@" + digest
+	}
+	return ref
+}
+
+// nestedSlice returns the slice at the given path without deep-copying it
+func nestedSlice(obj map[string]interface{}, fields ...string) []interface{} {
+	v, found, err := unstructured.NestedFieldNoCopy(obj, fields...)
+	if !found || err != nil {
+		return nil
+	}
+	s, _ := v.([]interface{})
+	return s
+}
+
+// toInt converts a decoded JSON number (json.Number, float64 or int64) to an int
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		if err != nil {
+			f, _ := n.Float64()
+			return int(f)
+		}
+		return int(i)
+	case float64:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printComparison(w io.Writer, result CompareResult) {
+	a, b := result.Clusters[0], result.Clusters[1]
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	if c := result.Compliance; c != nil {
+		fmt.Fprintf(tw, "\nCOMPLIANCE (%s vs %s)\n", a, b)
+		if c.Schema.Mismatch {
+			fmt.Fprintf(tw, "schema mismatch: %s=%s %s=%s\n", a, c.Schema.Versions[a], b, c.Schema.Versions[b])
+		} else {
+			fmt.Fprintln(tw, "REPORT\tCONTROL\tSEVERITY\tFAILING IN\tOTHER")
+			for _, r := range c.Reports {
+				for _, cluster := range result.Clusters {
+					for _, ctrl := range r.FailingOnlyIn[cluster] {
+						fmt.Fprintf(tw, "%s\t%s %s\t%s\t%s (%d)\t%s\n",
+							r.Report, ctrl.ID, ctrl.Name, ctrl.Severity, cluster, ctrl.TotalFail, ctrl.OtherStatus)
+					}
+				}
+			}
+		}
+	}
+
+	if v := result.Vulnerabilities; v != nil {
+		fmt.Fprintf(tw, "\nVULNERABILITIES (%s vs %s)\n", a, b)
+		if v.Schema.Mismatch {
+			fmt.Fprintf(tw, "schema mismatch: %s=%s %s=%s\n", a, v.Schema.Versions[a], b, v.Schema.Versions[b])
+			return
+		}
+		for _, cluster := range result.Clusters {
+			only := v.OnlyIn[cluster]
+			fmt.Fprintf(tw, "\nONLY IN %s: %d\n", cluster, len(only))
+			fmt.Fprintln(tw, "ID\tSEVERITY\tIMAGES")
+			for i, occ := range only {
+				if i == compareTableRows {
+					fmt.Fprintf(tw, "... %d more\t\t\n", len(only)-i)
+					break
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", occ.ID, occ.Severity, strings.Join(occ.Images, ", "))
+			}
+		}
+
+		fmt.Fprintf(tw, "\nNAMESPACE\t%s\t%s\tDELTA\n", a, b)
+		for _, d := range v.NamespaceDeltas {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%+d\n", d.Namespace, d.Counts[a].total(), d.Counts[b].total(), d.Delta.total())
+		}
+	}
+}
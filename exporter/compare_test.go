@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStreamArtifactSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		artifact string
+		want     string
+	}{
+		{
+			name:     "file apiVersion wins over the items",
+			artifact: `{"apiVersion": "aquasecurity.github.io/v1alpha1", "items": [{"apiVersion": "v1"}]}`,
+			want:     "aquasecurity.github.io/v1alpha1",
+		},
+		{
+			name:     "apiVersion after the items",
+			artifact: `{"items": [{"apiVersion": "v1"}], "apiVersion": "aquasecurity.github.io/v1alpha1"}`,
+			want:     "aquasecurity.github.io/v1alpha1",
+		},
+		{
+			name:     "no file apiVersion",
+			artifact: `{"items": [{"apiVersion": "aquasecurity.github.io/v1alpha1"}]}`,
+			want:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open := func(cluster, fileName string) (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(tt.artifact)), nil
+			}
+			got, err := streamArtifact(open, "prod", "vulnerability-reports", func(map[string]interface{}) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("schema version = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// COMPRESS_UPLOADS encodings
+const compressGzip = "gzip"
+
+// gzipStream compresses r on the fly. The returned digest holds the compressed size and
+// SHA-256 once the stream has been read to the end.
+func gzipStream(r io.Reader) (io.ReadCloser, *streamDigest) {
+	pr, pw := io.Pipe()
+	digest := newStreamDigest(pw)
+	go func() {
+		pw.CloseWithError(gzipTo(digest, r))
+	}()
+	return pr, digest
+}
+
+// gzipFile compresses r into a temp file for stores that need the length of an upload up
+// front. The file is positioned at its start; the caller closes and removes it.
+func gzipFile(r io.Reader) (*os.File, *streamDigest, error) {
+	f, err := os.CreateTemp(stagingPath(), "upload-*.json.gz")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	digest := newStreamDigest(f)
+	if err := gzipTo(digest, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, nil, fmt.Errorf("failed to compress to %s: %w", f.Name(), err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, nil, fmt.Errorf("failed to seek temp file: %w", err)
+	}
+	return f, digest, nil
+}
+
+func gzipTo(w io.Writer, r io.Reader) error {
+	zw := gzip.NewWriter(w)
+	if _, err := io.Copy(zw, r); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// streamDigest counts and hashes the bytes written through it
+type streamDigest struct {
+	w    io.Writer
+	n    int64
+	hash hash.Hash
+}
+
+func newStreamDigest(w io.Writer) *streamDigest {
+	return &streamDigest{w: w, hash: sha256.New()}
+}
+
+func (d *streamDigest) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.hash.Write(p[:n])
+	d.n += int64(n)
+	return n, err
+}
+
+func (d *streamDigest) sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// decodeContent undoes the Content-Encoding of a downloaded object
+func decodeContent(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	if encoding != compressGzip {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, body}, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Series of trivy-operator's metrics endpoint holding per-image vulnerability counts
+const operatorVulnerabilityMetric = "trivy_image_vulnerabilities"
+
+var operatorMetricsClient = &http.Client{Timeout: 10 * time.Second}
+
+// OperatorCrossCheck compares the exporter's vulnerability totals per severity with the
+// totals the operator exposes as metrics. It is published in index.json and diagnostics.json.
+type OperatorCrossCheck struct {
+	URL       string         `json:"url"`
+	Tolerance float64        `json:"tolerance"`
+	Pass      bool           `json:"pass"`
+	Exporter  map[string]int `json:"exporter"`
+	Operator  map[string]int `json:"operator"`
+	// Severities whose totals differ by more than the tolerance
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// scrapeOperatorVulnerabilities sums trivy_image_vulnerabilities per upper-case severity
+func scrapeOperatorVulnerabilities(ctx context.Context, cfg Config) (map[string]int, error) {
+	var totals map[string]int
+	err := cfg.retryPolicy("operator-metrics").Do(ctx, "operator-metrics", func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.OperatorMetricsURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := operatorMetricsClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &httpStatusError{code: resp.StatusCode, url: cfg.OperatorMetricsURL}
+		}
+		totals, err = parseOperatorVulnerabilities(resp.Body)
+		return err
+	})
+	return totals, err
+}
+
+// parseOperatorVulnerabilities reads the Prometheus text format, summing the vulnerability
+// series by their severity label
+func parseOperatorVulnerabilities(r io.Reader) (map[string]int, error) {
+	totals := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, operatorVulnerabilityMetric+"{") {
+			continue
+		}
+		end := strings.LastIndex(line, "}")
+		if end < 0 {
+			continue
+		}
+		severity := metricLabel(line[len(operatorVulnerabilityMetric)+1:end], "severity")
+		fields := strings.Fields(line[end+1:])
+		if severity == "" || len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		totals[strings.ToUpper(severity)] += int(v)
+	}
+	return totals, scanner.Err()
+}
+
+// metricLabel returns the value of a label from the label set of a sample line
+func metricLabel(labels, name string) string {
+	for _, pair := range strings.Split(labels, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && key == name {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// crossCheckOperator compares the totals of both sides. A severity passes when the two
+// counts differ by at most tolerance relative to the larger one.
+func crossCheckOperator(url string, tolerance float64, exporter, operator map[string]int) OperatorCrossCheck {
+	check := OperatorCrossCheck{URL: url, Tolerance: tolerance, Pass: true, Exporter: exporter, Operator: operator}
+	severities := make(map[string]bool)
+	for s := range exporter {
+		severities[s] = true
+	}
+	for s := range operator {
+		severities[s] = true
+	}
+	for _, s := range sortedKeys(severities) {
+		a, b := float64(exporter[s]), float64(operator[s])
+		if math.Abs(a-b) > tolerance*math.Max(a, b) {
+			check.Pass = false
+			check.Mismatches = append(check.Mismatches, s)
+		}
+	}
+	return check
+}
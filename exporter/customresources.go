@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	"sigs.k8s.io/yaml"
+)
+
+// CustomResource declares a CRD of another scanner in CUSTOM_RESOURCES_FILE, e.g.
+//
+//   - group: kube-bench.example.com
+//     version: v1
+//     resource: benchreports
+//     fileName: kube-bench-reports
+//     namespaced: false
+type CustomResource struct {
+	Group      string `json:"group"`
+	Version    string `json:"version"`
+	Resource   string `json:"resource"`
+	Kind       string `json:"kind,omitempty"`
+	FileName   string `json:"fileName"`
+	Namespaced bool   `json:"namespaced"`
+	Priority   int    `json:"priority,omitempty"`
+}
+
+// CustomResourceInfo lists a custom report type in index.json, so consumers can tell it
+// from the trivy-operator report types
+type CustomResourceInfo struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	FileName string `json:"fileName"`
+}
+
+var customFileNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`)
+
+// Files the exporter writes next to the report files
+var reservedFileNames = []string{"index", "index-delta", "index-cluster", "index-namespaced",
+	"index-delta-cluster", "index-delta-namespaced",
+	"freshness", "diagnostics", "namespaces", "runtime-config", "findings", "bundle"}
+
+// loadCustomResources reads CUSTOM_RESOURCES_FILE, a YAML or JSON list of CustomResource.
+// Custom report types are named <resource>.<group> like kubectl does, so they never clash
+// with the trivy-operator report types.
+func loadCustomResources(path string) ([]ReportResource, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CUSTOM_RESOURCES_FILE: %w", err)
+	}
+	var declared []CustomResource
+	if err := yaml.UnmarshalStrict(data, &declared); err != nil {
+		return nil, fmt.Errorf("invalid CUSTOM_RESOURCES_FILE %s: %w", path, err)
+	}
+
+	fileNames := make(map[string]string)
+	for _, r := range knownReportResources {
+		fileNames[r.FileName] = r.Name
+	}
+	for _, name := range reservedFileNames {
+		fileNames[name] = "the exporter"
+	}
+	var resources []ReportResource
+	for i, c := range declared {
+		if c.Version == "" || c.Resource == "" || c.FileName == "" {
+			return nil, fmt.Errorf("invalid CUSTOM_RESOURCES_FILE entry %d: version, resource and fileName are required", i+1)
+		}
+		if !customFileNamePattern.MatchString(c.FileName) {
+			return nil, fmt.Errorf("invalid CUSTOM_RESOURCES_FILE fileName %q (lowercase letters, digits, dots and dashes)", c.FileName)
+		}
+		name := c.Resource
+		if c.Group != "" {
+			name += "." + c.Group
+		}
+		if owner, ok := fileNames[c.FileName]; ok {
+			return nil, fmt.Errorf("invalid CUSTOM_RESOURCES_FILE fileName %q of %s: already used by %s", c.FileName, name, owner)
+		}
+		sameName := func(r ReportResource) bool { return r.Name == name }
+		if slices.ContainsFunc(knownReportResources, sameName) || slices.ContainsFunc(resources, sameName) {
+			return nil, fmt.Errorf("invalid CUSTOM_RESOURCES_FILE: %s is declared twice or is a built-in report type", name)
+		}
+		fileNames[c.FileName] = name
+		priority := c.Priority
+		if priority == 0 {
+			priority = priorityLow
+		}
+		resources = append(resources, ReportResource{
+			Name:          name,
+			Kind:          c.Kind,
+			FileName:      c.FileName,
+			Priority:      priority,
+			ClusterScoped: !c.Namespaced,
+			Group:         c.Group,
+			Version:       c.Version,
+			Resource:      c.Resource,
+		})
+	}
+	return resources, nil
+}
+
+// baseReportResources are the report types collected without discovery: the known
+// trivy-operator report types and those of CUSTOM_RESOURCES_FILE
+func baseReportResources(cfg Config) []ReportResource {
+	return slices.Concat(knownReportResources, cfg.CustomResources)
+}
+
+// customResourceIndex lists the collected custom report types for index.json
+func customResourceIndex(cfg Config) map[string]CustomResourceInfo {
+	var custom map[string]CustomResourceInfo
+	for _, r := range reportResources {
+		if !r.custom() || !inScope(r, cfg.Scope) {
+			continue
+		}
+		if custom == nil {
+			custom = make(map[string]CustomResourceInfo)
+		}
+		custom[r.Name] = CustomResourceInfo{Group: r.Group, Version: r.Version, Resource: r.Resource, FileName: r.FileName}
+	}
+	return custom
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Rows sent to PostgreSQL per round trip
+const dbBatchSize = 500
+
+// dbMigrations are applied in order at startup; append new versions, never edit old ones
+var dbMigrations = []string{
+	// 1: finding tables
+	`CREATE TABLE vulnerability_findings (
+		cluster           text NOT NULL,
+		namespace         text NOT NULL,
+		workload          text NOT NULL,
+		container         text NOT NULL,
+		image             text NOT NULL,
+		vulnerability_id  text NOT NULL,
+		resource          text NOT NULL,
+		installed_version text NOT NULL,
+		severity          text NOT NULL,
+		fixed_version     text NOT NULL,
+		collected_at      timestamptz NOT NULL,
+		cycle_id          text NOT NULL,
+		stale             boolean NOT NULL DEFAULT false,
+		PRIMARY KEY (cluster, namespace, workload, container, vulnerability_id, resource)
+	);
+	CREATE INDEX vulnerability_findings_severity ON vulnerability_findings (severity);
+	CREATE TABLE config_audit_findings (
+		cluster      text NOT NULL,
+		namespace    text NOT NULL,
+		workload     text NOT NULL,
+		check_id     text NOT NULL,
+		severity     text NOT NULL,
+		title        text NOT NULL,
+		category     text NOT NULL,
+		collected_at timestamptz NOT NULL,
+		cycle_id     text NOT NULL,
+		stale        boolean NOT NULL DEFAULT false,
+		PRIMARY KEY (cluster, namespace, workload, check_id)
+	);
+	CREATE TABLE exposed_secret_findings (
+		cluster      text NOT NULL,
+		namespace    text NOT NULL,
+		workload     text NOT NULL,
+		container    text NOT NULL,
+		image        text NOT NULL,
+		rule_id      text NOT NULL,
+		target       text NOT NULL,
+		severity     text NOT NULL,
+		title        text NOT NULL,
+		collected_at timestamptz NOT NULL,
+		cycle_id     text NOT NULL,
+		stale        boolean NOT NULL DEFAULT false,
+		PRIMARY KEY (cluster, namespace, workload, container, rule_id, target)
+	)`,
+}
+
+// findingTable maps the findings of a report kind onto a table. The values returned by
+// row follow columns; the first keyColumns columns are the primary key.
+type findingTable struct {
+	name       string
+	columns    []string
+	keyColumns int
+	row        func(item reportItemRef, entry map[string]interface{}) []interface{}
+}
+
+// reportItemRef identifies the workload a report item belongs to
+type reportItemRef struct {
+	cluster, namespace, workload, container, image string
+}
+
+var findingTables = map[string]findingTable{
+	"VulnerabilityReport": {
+		name:       "vulnerability_findings",
+		columns:    []string{"cluster", "namespace", "workload", "container", "vulnerability_id", "resource", "image", "installed_version", "severity", "fixed_version"},
+		keyColumns: 6,
+		row: func(item reportItemRef, e map[string]interface{}) []interface{} {
+			return []interface{}{item.cluster, item.namespace, item.workload, item.container,
+				entryString(e, "vulnerabilityID"), entryString(e, "resource"), item.image,
+				entryString(e, "installedVersion"), strings.ToUpper(entryString(e, "severity")), entryString(e, "fixedVersion")}
+		},
+	},
+	"ConfigAuditReport": {
+		name:       "config_audit_findings",
+		columns:    []string{"cluster", "namespace", "workload", "check_id", "severity", "title", "category"},
+		keyColumns: 4,
+		row: func(item reportItemRef, e map[string]interface{}) []interface{} {
+			return []interface{}{item.cluster, item.namespace, item.workload,
+				entryString(e, "checkID"), strings.ToUpper(entryString(e, "severity")), entryString(e, "title"), entryString(e, "category")}
+		},
+	},
+	"ExposedSecretReport": {
+		name:       "exposed_secret_findings",
+		columns:    []string{"cluster", "namespace", "workload", "container", "rule_id", "target", "image", "severity", "title"},
+		keyColumns: 6,
+		row: func(item reportItemRef, e map[string]interface{}) []interface{} {
+			return []interface{}{item.cluster, item.namespace, item.workload, item.container,
+				entryString(e, "ruleID"), entryString(e, "target"), item.image,
+				strings.ToUpper(entryString(e, "severity")), entryString(e, "title")}
+		},
+	},
+}
+
+func entryString(entry map[string]interface{}, field string) string {
+	s, _ := entry[field].(string)
+	return s
+}
+
+// upsertSQL inserts a row of the table, or refreshes it when its key exists
+func (t findingTable) upsertSQL() string {
+	columns := append(append([]string(nil), t.columns...), "collected_at", "cycle_id", "stale")
+	placeholders := make([]string, len(columns))
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	var updates []string
+	for _, c := range columns[t.keyColumns:] {
+		updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", c, c))
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		t.name, strings.Join(columns, ", "), strings.Join(placeholders, ", "),
+		strings.Join(t.columns[:t.keyColumns], ", "), strings.Join(updates, ", "))
+}
+
+// findingsDB loads findings into PostgreSQL (DATABASE_URL) while reports are collected
+type findingsDB struct {
+	pool *pgxpool.Pool
+	cfg  Config
+}
+
+// findingsStore is the database of the collection loop, nil without DATABASE_URL
+var findingsStore *findingsDB
+
+// openFindingsDB connects and brings the schema up to date
+func openFindingsDB(ctx context.Context, cfg Config) (*findingsDB, error) {
+	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	db := &findingsDB{pool: pool, cfg: cfg}
+	if err := db.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrate applies the pending dbMigrations in one transaction. The advisory lock keeps
+// exporters of several clusters sharing the database from migrating concurrently.
+func (db *findingsDB) migrate(ctx context.Context) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start migration: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('trivy-exporter-migrations'))`); err != nil {
+		return fmt.Errorf("failed to lock schema: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	var current int
+	if err := tx.QueryRow(ctx, `SELECT coalesce(max(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	for version := current + 1; version <= len(dbMigrations); version++ {
+		if _, err := tx.Exec(ctx, dbMigrations[version-1]); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", version, err)
+		}
+		log.Printf("🗄️ Applied database migration %d", version)
+	}
+	return tx.Commit(ctx)
+}
+
+func (db *findingsDB) Close() {
+	db.pool.Close()
+}
+
+// loader returns the loader of a report type collected at collectedAt, or nil when its
+// findings have no table
+func (db *findingsDB) loader(resource ReportResource, cycleID string, collectedAt time.Time) *findingsLoader {
+	if db == nil {
+		return nil
+	}
+	table, ok := findingTables[resource.Kind]
+	if !ok {
+		return nil
+	}
+	return &findingsLoader{db: db, table: table, upsert: table.upsertSQL(), kind: resource.Kind, cycleID: cycleID, collectedAt: collectedAt}
+}
+
+// findingsLoader upserts the findings of one report type in batches. Once the whole type
+// was collected, finish removes the rows the cycle did not refresh, or marks them stale
+// with DESTRUCTIVE_OPS=deny. After a failed batch nothing is removed.
+type findingsLoader struct {
+	db          *findingsDB
+	table       findingTable
+	upsert      string
+	kind        string
+	cycleID     string
+	collectedAt time.Time
+
+	batch     pgx.Batch
+	rows      int
+	err       error
+	restarted bool // Rows of the abandoned pass are retired too, see restart
+}
+
+// add queues the findings of a report item
+func (l *findingsLoader) add(ctx context.Context, obj map[string]interface{}) {
+	if l.err != nil {
+		return
+	}
+	u := unstructured.Unstructured{Object: obj}
+	labels := u.GetLabels()
+	item := reportItemRef{
+		cluster:   l.db.cfg.ClusterName,
+		namespace: u.GetNamespace(),
+		workload:  workloadRef(labels),
+		container: labels["trivy-operator.container.name"],
+		image:     imageRef(obj),
+	}
+
+	spec := summarySpecs[l.kind]
+	for _, e := range nestedSlice(obj, spec.entries...) {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if spec.failedOnly {
+			if success, _ := entry["success"].(bool); success {
+				continue
+			}
+		}
+		args := append(l.table.row(item, entry), l.collectedAt, l.cycleID, false)
+		l.batch.Queue(l.upsert, args...)
+		if l.batch.Len() >= dbBatchSize {
+			l.flush(ctx)
+		}
+	}
+}
+
+func (l *findingsLoader) flush(ctx context.Context) {
+	if l.batch.Len() == 0 || l.err != nil {
+		return
+	}
+	n := l.batch.Len()
+	err := l.db.pool.SendBatch(ctx, &l.batch).Close()
+	l.batch = pgx.Batch{}
+	if err != nil {
+		l.err = fmt.Errorf("failed to upsert into %s: %w", l.table.name, err)
+		log.Printf("⚠️ %v", l.err)
+		return
+	}
+	l.rows += n
+}
+
+// restart drops the queued rows when the list of the report type starts over. Rows the
+// abandoned pass already upserted carry the cycle ID, so the second pass stamps its rows
+// with the restart time and finish also retires the older rows of this cycle.
+func (l *findingsLoader) restart(at time.Time) {
+	if l == nil {
+		return
+	}
+	l.batch = pgx.Batch{}
+	l.rows = 0
+	// Truncated to the precision of timestamptz, so refreshed rows never look older
+	l.collectedAt = at.Truncate(time.Microsecond)
+	l.restarted = true
+}
+
+// finish flushes the remaining rows and retires those of earlier cycles
+func (l *findingsLoader) finish(ctx context.Context) error {
+	l.flush(ctx)
+	if l.err != nil {
+		return l.err
+	}
+
+	retired := "cycle_id <> $2"
+	args := []interface{}{l.db.cfg.ClusterName, l.cycleID}
+	if l.restarted {
+		retired = "(cycle_id <> $2 OR collected_at < $3)"
+		args = append(args, l.collectedAt)
+	}
+	query := fmt.Sprintf(`DELETE FROM %s WHERE cluster = $1 AND %s`, l.table.name, retired)
+	if l.db.cfg.DestructiveOps == destructiveDeny {
+		query = fmt.Sprintf(`UPDATE %s SET stale = true WHERE cluster = $1 AND %s AND NOT stale`, l.table.name, retired)
+	}
+	tag, err := l.db.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to retire old rows of %s: %w", l.table.name, err)
+	}
+	log.Printf("🗄️ Loaded %d rows into %s, retired %d", l.rows, l.table.name, tag.RowsAffected())
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DESTRUCTIVE_OPS modes
+const (
+	destructiveAllow = "allow"
+	destructiveDeny  = "deny" // skip every deletion, e.g. for versioned buckets with lifecycle rules
+)
+
+// Append-only audit trail of the deletions requested by the exporter
+const deletionsAuditName = "deletions-audit.jsonl"
+
+// deletionsAuditNameOf is the audit trail the deployment appends to. Split SCOPE
+// deployments of a cluster keep one each, deletions-audit-<scope>.jsonl, as the file is
+// read back and rewritten and would otherwise lose the records of the other scope.
+func deletionsAuditNameOf(cfg Config) string {
+	if cfg.Scope != scopeCluster && cfg.Scope != scopeNamespaced {
+		return deletionsAuditName
+	}
+	return fmt.Sprintf("deletions-audit-%s.jsonl", cfg.Scope)
+}
+
+// errDeleteUnsupported is returned by sinks that cannot delete, such as the push API
+var errDeleteUnsupported = errors.New("sink does not support deletes")
+
+// DeletionRequest describes an artifact a feature wants removed
+type DeletionRequest struct {
+	Key     string // key within the cluster, as passed to Sink.Put
+	Cluster string // set when the key belongs to another cluster's exporter
+	Size    int64  // -1 when unknown
+	Feature string // feature requesting the deletion, e.g. "snapshot-retention"
+	Reason  string // reason code, e.g. "expired" or "orphaned"
+	CycleID string
+	DryRun  bool
+}
+
+// describe names the key in the logs, with its cluster when it is another one's
+func (r DeletionRequest) describe() string {
+	if r.Cluster != "" {
+		return r.Cluster + ":" + r.Key
+	}
+	return r.Key
+}
+
+// DeletionRecord is one line of deletions-audit.jsonl
+type DeletionRecord struct {
+	Time    string `json:"time"`
+	CycleID string `json:"cycleId"`
+	Feature string `json:"feature"`
+	Reason  string `json:"reason"`
+	Sink    string `json:"sink"`
+	Key     string `json:"key"`
+	Cluster string `json:"cluster,omitempty"`
+	Size    int64  `json:"size"`
+	DryRun  bool   `json:"dryRun"`
+	// Why the deletion was not carried out: denied (DESTRUCTIVE_OPS=deny), not-owner
+	// (FS_OUTPUT_DIR owned by another exporter) or unsupported
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// deletionCounts tracks deletions per reason code for the current cycle
+var deletionCounts = &retryCounter{counts: make(map[string]int)}
+
+// pendingDeletions buffers audit records until they are appended to deletions-audit.jsonl
+var pendingDeletions = &deletionAudit{}
+
+type deletionAudit struct {
+	mu      sync.Mutex
+	records []DeletionRecord
+}
+
+func (d *deletionAudit) add(r DeletionRecord) {
+	d.mu.Lock()
+	d.records = append(d.records, r)
+	d.mu.Unlock()
+}
+
+// deleteArtifact is the only way features may remove published artifacts. Every sink's
+// outcome is audited; DESTRUCTIVE_OPS=deny overrides any feature flag and skips them all.
+func deleteArtifact(ctx context.Context, sinks []Sink, cfg Config, req DeletionRequest) error {
+	_, _, err := deleteArtifacts(ctx, sinks, cfg, []DeletionRequest{req})
+	return err
+}
+
+// deleteArtifacts is deleteArtifact for many keys at once; sinks implementing batchDeleter
+// remove them in batches. It returns the number of keys removed across all sinks and
+// their total size.
+func deleteArtifacts(ctx context.Context, sinks []Sink, cfg Config, reqs []DeletionRequest) (int, int64, error) {
+	errs := make([][]error, len(reqs))
+	deleted := 0
+	var freed int64
+	for _, sink := range sinks {
+		records := make([]DeletionRecord, len(reqs))
+		var keys []string
+		// Ownership of FS_OUTPUT_DIR is checked again right before each batch of deletions
+		notOwner := sink.Name() == "fs" && len(reqs) > 0 && cfg.DestructiveOps != destructiveDeny && !canDeleteFromOutputDir(cfg, reqs[0].Feature)
+		for i, req := range reqs {
+			records[i] = DeletionRecord{
+				Time:    time.Now().UTC().Format(time.RFC3339),
+				CycleID: req.CycleID,
+				Feature: req.Feature,
+				Reason:  req.Reason,
+				Sink:    sink.Name(),
+				Key:     req.Key,
+				Cluster: req.Cluster,
+				Size:    req.Size,
+				DryRun:  req.DryRun,
+			}
+
+			switch {
+			case cfg.DestructiveOps == destructiveDeny:
+				records[i].Skipped = "denied"
+				log.Printf("🛑 DESTRUCTIVE_OPS=deny: skipping %s of %s from %s (%s)", req.Feature, req.describe(), sink.Name(), req.Reason)
+			case notOwner:
+				records[i].Skipped = "not-owner"
+			case req.DryRun:
+				log.Printf("🧪 Dry run: %s would delete %s from %s (%s)", req.Feature, req.describe(), sink.Name(), req.Reason)
+			default:
+				keys = append(keys, req.Key)
+			}
+		}
+
+		failed := deleteKeys(ctx, cfg, sink, keys)
+		for i, req := range reqs {
+			if records[i].Skipped != "" || req.DryRun {
+				pendingDeletions.add(records[i])
+				continue
+			}
+			err := failed[req.Key]
+			switch {
+			case errors.Is(err, errDeleteUnsupported):
+				records[i].Skipped = "unsupported"
+			case err != nil:
+				records[i].Error = err.Error()
+				errs[i] = append(errs[i], fmt.Errorf("%s: %w", sink.Name(), err))
+			default:
+				deleted++
+				freed += req.Size
+				deletionCounts.add(req.Reason)
+				log.Printf("🗑️ %s deleted %s from %s (%s)", req.Feature, req.describe(), sink.Name(), req.Reason)
+			}
+			pendingDeletions.add(records[i])
+		}
+	}
+
+	var failures []error
+	for i, req := range reqs {
+		if err := errors.Join(errs[i]...); err != nil {
+			failures = append(failures, fmt.Errorf("failed to delete %s: %w", req.describe(), err))
+		}
+	}
+	return deleted, freed, errors.Join(failures...)
+}
+
+// Most keys a batchDeleter is asked to remove per request, the limit of S3 DeleteObjects
+const maxDeleteBatch = 1000
+
+// batchDeleter is implemented by sinks that remove many keys per request, such as S3
+type batchDeleter interface {
+	// deleteBatch removes keys and returns the errors of the keys that were not removed;
+	// a returned error fails the whole batch
+	deleteBatch(ctx context.Context, keys []string) (map[string]error, error)
+}
+
+// deleteKeys removes keys from a sink and returns the error of each key not removed
+func deleteKeys(ctx context.Context, cfg Config, sink Sink, keys []string) map[string]error {
+	failed := make(map[string]error)
+	batcher, ok := sink.(batchDeleter)
+	if !ok || len(keys) == 1 {
+		for _, key := range keys {
+			err := withRetry(ctx, cfg, sink, func() error {
+				return sink.Delete(ctx, key)
+			})
+			if err != nil {
+				failed[key] = err
+			}
+		}
+		return failed
+	}
+
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), maxDeleteBatch)]
+		keys = keys[len(batch):]
+		var keyErrs map[string]error
+		err := withRetry(ctx, cfg, sink, func() error {
+			var err error
+			keyErrs, err = batcher.deleteBatch(ctx, batch)
+			return err
+		})
+		if err != nil {
+			for _, key := range batch {
+				failed[key] = err
+			}
+			continue
+		}
+		for key, err := range keyErrs {
+			failed[key] = err
+		}
+	}
+	return failed
+}
+
+// flushDeletionAudit appends the pending records to the audit trail. The existing
+// file is read back first; if it cannot be read, or the write fails, the records stay
+// pending for the next cycle rather than replacing the trail with a partial one. Without any
+// sink that can read the file back, it is never rewritten.
+func flushDeletionAudit(ctx context.Context, sinks []Sink, cfg Config) error {
+	pendingDeletions.mu.Lock()
+	defer pendingDeletions.mu.Unlock()
+	if len(pendingDeletions.records) == 0 {
+		return nil
+	}
+
+	name := deletionsAuditNameOf(cfg)
+	existing, err := readArtifact(ctx, sinks, cfg, name)
+	if errors.Is(err, errReadUnsupported) {
+		// Rewriting the trail without its existing lines would truncate it. The records could
+		// never be flushed either, so they are dropped instead of piling up.
+		n := len(pendingDeletions.records)
+		pendingDeletions.records = nil
+		return fmt.Errorf("not rewriting %s, dropping %d records: %w", name, n, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s, keeping %d records for the next cycle: %w", name, len(pendingDeletions.records), err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(existing)
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	encoder := json.NewEncoder(&buf)
+	for _, r := range pendingDeletions.records {
+		if err := encoder.Encode(r); err != nil {
+			return fmt.Errorf("failed to encode deletion record: %w", err)
+		}
+	}
+
+	if err := publishArtifact(ctx, sinks, cfg, Artifact{Name: name, Data: buf.Bytes()}); err != nil {
+		return fmt.Errorf("failed to append to %s, keeping %d records for the next cycle: %w", name, len(pendingDeletions.records), err)
+	}
+	pendingDeletions.records = nil
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// CountChange is the item count of a report type in two consecutive cycles
+type CountChange struct {
+	Previous int `json:"previous"`
+	Current  int `json:"current"`
+	Delta    int `json:"delta"`
+}
+
+// IndexDelta is published as index-delta.json so pollers can fetch the changes of the last
+// cycle instead of diffing full indexes. With SCOPE=cluster or SCOPE=namespaced each
+// deployment diffs its own index-<scope>.json and publishes index-delta-<scope>.json.
+type IndexDelta struct {
+	Cluster         string `json:"cluster"`
+	GeneratedAt     string `json:"generatedAt"`
+	CycleID         string `json:"cycleId"`
+	PreviousCycleID string `json:"previousCycleId,omitempty"`
+	// No previous index was found; every list below describes the full current state
+	FirstCycle bool `json:"firstCycle"`
+
+	CountChanges map[string]CountChange `json:"countChanges"`
+	// Report types collected in the previous cycle but not in this one, and the reverse
+	Stale     []string `json:"stale"`
+	Recovered []string `json:"recovered"`
+
+	ArtifactsAdded      []string `json:"artifactsAdded"`
+	ArtifactsRemoved    []string `json:"artifactsRemoved"`
+	CapabilitiesAdded   []string `json:"capabilitiesAdded"`
+	CapabilitiesRemoved []string `json:"capabilitiesRemoved"`
+}
+
+// previousIndex is the index published by the last cycle of this process
+var previousIndex *ClusterIndex
+
+// computeIndexDelta diffs two indexes. previous is nil on the first cycle.
+func computeIndexDelta(previous *ClusterIndex, current ClusterIndex) IndexDelta {
+	delta := IndexDelta{
+		Cluster:      current.Cluster,
+		GeneratedAt:  time.Now().UTC().Format(time.RFC3339),
+		CycleID:      current.CycleID,
+		FirstCycle:   previous == nil,
+		CountChanges: make(map[string]CountChange),
+	}
+	if previous == nil {
+		previous = &ClusterIndex{}
+	}
+	delta.PreviousCycleID = previous.CycleID
+
+	for name, count := range current.CollectionStats {
+		prev, existed := previous.CollectionStats[name]
+		if !existed && !delta.FirstCycle {
+			delta.Recovered = append(delta.Recovered, name)
+		}
+		if !existed || prev != count {
+			delta.CountChanges[name] = CountChange{Previous: prev, Current: count, Delta: count - prev}
+		}
+	}
+	for name, prev := range previous.CollectionStats {
+		if _, ok := current.CollectionStats[name]; !ok {
+			delta.Stale = append(delta.Stale, name)
+			delta.CountChanges[name] = CountChange{Previous: prev, Delta: -prev}
+		}
+	}
+
+	delta.ArtifactsAdded, delta.ArtifactsRemoved = diffStrings(previous.Artifacts, current.Artifacts)
+	delta.CapabilitiesAdded, delta.CapabilitiesRemoved = diffStrings(previous.Capabilities, current.Capabilities)
+
+	sort.Strings(delta.Recovered)
+	sort.Strings(delta.Stale)
+	if delta.Recovered == nil {
+		delta.Recovered = []string{}
+	}
+	if delta.Stale == nil {
+		delta.Stale = []string{}
+	}
+	return delta
+}
+
+// diffStrings returns the sorted values only in b (added) and only in a (removed)
+func diffStrings(a, b []string) (added, removed []string) {
+	inA := make(map[string]bool, len(a))
+	for _, s := range a {
+		inA[s] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+		if !inA[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range a {
+		if !inB[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	if added == nil {
+		added = []string{}
+	}
+	if removed == nil {
+		removed = []string{}
+	}
+	return added, removed
+}
+
+// loadPreviousIndex returns the index of the last cycle, read back from the outputs after a
+// restart. It must run before the new index is published.
+func loadPreviousIndex(ctx context.Context, sinks []Sink, cfg Config) *ClusterIndex {
+	if previousIndex != nil {
+		return previousIndex
+	}
+	name := ownIndexName(cfg)
+	data, err := readArtifact(ctx, sinks, cfg, name)
+	if err != nil {
+		log.Printf("⚠️ Failed to read previous %s, publishing a first-cycle delta: %v", name, err)
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+	var previous ClusterIndex
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return nil
+	}
+	return &previous
+}
+
+// ownDeltaName is the delta file diffing the index of ownIndexName
+func ownDeltaName(cfg Config) string {
+	if cfg.Scope != scopeCluster && cfg.Scope != scopeNamespaced {
+		return "index-delta.json"
+	}
+	return fmt.Sprintf("index-delta-%s.json", cfg.Scope)
+}
+
+// publishIndexDelta diffs the index against the previous cycle and publishes the delta file
+// of the scope
+func publishIndexDelta(ctx context.Context, sinks []Sink, cfg Config, previous *ClusterIndex, index ClusterIndex) error {
+	previousIndex = &index
+	if err := publishJSON(ctx, sinks, cfg, ownDeltaName(cfg), computeIndexDelta(previous, index)); err != nil {
+		return fmt.Errorf("failed to publish index delta: %w", err)
+	}
+	return nil
+}
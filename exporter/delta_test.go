@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestComputeIndexDelta(t *testing.T) {
+	previous := &ClusterIndex{
+		Cluster:         "prod",
+		CycleID:         "20260301-100000",
+		CollectionStats: map[string]int{"vulnerabilityreports": 10, "configauditreports": 4, "exposedsecretreports": 2},
+		Artifacts:       []string{"config-audit-reports.json", "exposed-secret-reports.json", "vulnerability-reports.json"},
+		Capabilities:    []string{"fs", "s3"},
+	}
+	tests := []struct {
+		name     string
+		previous *ClusterIndex
+		current  ClusterIndex
+		want     IndexDelta
+	}{
+		{
+			name: "first cycle",
+			current: ClusterIndex{
+				Cluster:         "prod",
+				CycleID:         "20260301-110000",
+				CollectionStats: map[string]int{"vulnerabilityreports": 10},
+				Artifacts:       []string{"vulnerability-reports.json"},
+				Capabilities:    []string{"fs"},
+			},
+			want: IndexDelta{
+				Cluster:             "prod",
+				CycleID:             "20260301-110000",
+				FirstCycle:          true,
+				CountChanges:        map[string]CountChange{"vulnerabilityreports": {Current: 10, Delta: 10}},
+				Stale:               []string{},
+				Recovered:           []string{},
+				ArtifactsAdded:      []string{"vulnerability-reports.json"},
+				ArtifactsRemoved:    []string{},
+				CapabilitiesAdded:   []string{"fs"},
+				CapabilitiesRemoved: []string{},
+			},
+		},
+		{
+			name:     "unchanged cycle",
+			previous: previous,
+			current: ClusterIndex{
+				Cluster:         "prod",
+				CycleID:         "20260301-110000",
+				CollectionStats: previous.CollectionStats,
+				Artifacts:       previous.Artifacts,
+				Capabilities:    previous.Capabilities,
+			},
+			want: IndexDelta{
+				Cluster:             "prod",
+				CycleID:             "20260301-110000",
+				PreviousCycleID:     "20260301-100000",
+				CountChanges:        map[string]CountChange{},
+				Stale:               []string{},
+				Recovered:           []string{},
+				ArtifactsAdded:      []string{},
+				ArtifactsRemoved:    []string{},
+				CapabilitiesAdded:   []string{},
+				CapabilitiesRemoved: []string{},
+			},
+		},
+		{
+			name:     "counts, stale and recovered types, artifacts and capabilities",
+			previous: previous,
+			current: ClusterIndex{
+				Cluster:         "prod",
+				CycleID:         "20260301-110000",
+				CollectionStats: map[string]int{"vulnerabilityreports": 12, "configauditreports": 4, "sbomreports": 3},
+				Artifacts:       []string{"config-audit-reports.json", "sbom-reports.json", "vulnerability-reports.json"},
+				Capabilities:    []string{"fs", "kafka"},
+			},
+			want: IndexDelta{
+				Cluster:         "prod",
+				CycleID:         "20260301-110000",
+				PreviousCycleID: "20260301-100000",
+				CountChanges: map[string]CountChange{
+					"vulnerabilityreports": {Previous: 10, Current: 12, Delta: 2},
+					"exposedsecretreports": {Previous: 2, Delta: -2},
+					"sbomreports":          {Current: 3, Delta: 3},
+				},
+				Stale:               []string{"exposedsecretreports"},
+				Recovered:           []string{"sbomreports"},
+				ArtifactsAdded:      []string{"sbom-reports.json"},
+				ArtifactsRemoved:    []string{"exposed-secret-reports.json"},
+				CapabilitiesAdded:   []string{"kafka"},
+				CapabilitiesRemoved: []string{"s3"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeIndexDelta(tt.previous, tt.current)
+			if got.GeneratedAt == "" {
+				t.Error("generatedAt is empty")
+			}
+			got.GeneratedAt = ""
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("computeIndexDelta() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// After a restart the previous index is read back from the outputs; without one the delta
+// is a first-cycle delta
+func TestPublishIndexDeltaAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{ClusterName: "prod", FSOutputDir: dir, FSLayout: fsLayoutFlat}
+	sinks := []Sink{&fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}}
+	defer func() { previousIndex = nil }()
+
+	cycles := []struct {
+		index    ClusterIndex
+		restart  bool // The process restarted before the cycle
+		first    bool
+		previous string
+	}{
+		{index: ClusterIndex{Cluster: "prod", CycleID: "c1", CollectionStats: map[string]int{"vulnerabilityreports": 1}}, restart: true, first: true},
+		{index: ClusterIndex{Cluster: "prod", CycleID: "c2", CollectionStats: map[string]int{"vulnerabilityreports": 2}}, previous: "c1"},
+		{index: ClusterIndex{Cluster: "prod", CycleID: "c3", CollectionStats: map[string]int{"vulnerabilityreports": 3}}, restart: true, previous: "c2"},
+	}
+	for _, c := range cycles {
+		if c.restart {
+			previousIndex = nil
+		}
+		cycleArtifacts.reset()
+		previous := loadPreviousIndex(context.Background(), sinks, cfg)
+		if err := publishIndex(context.Background(), sinks, cfg, c.index); err != nil {
+			t.Fatal(err)
+		}
+		if err := publishIndexDelta(context.Background(), sinks, cfg, previous, c.index); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "prod-index-delta.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var delta IndexDelta
+		if err := json.Unmarshal(data, &delta); err != nil {
+			t.Fatal(err)
+		}
+		if delta.CycleID != c.index.CycleID || delta.FirstCycle != c.first || delta.PreviousCycleID != c.previous {
+			t.Errorf("delta of %s = cycle %s, first %v, previous %q; want first %v, previous %q",
+				c.index.CycleID, delta.CycleID, delta.FirstCycle, delta.PreviousCycleID, c.first, c.previous)
+		}
+		if !slices.Contains(cycleArtifacts.reset(), "index-delta.json") {
+			t.Errorf("index-delta.json of %s is not listed in the artifacts of the cycle", c.index.CycleID)
+		}
+	}
+}
+
+// Split deployments each diff their own scope's index and publish their own delta file,
+// so neither overwrites the delta of the other
+func TestPublishIndexDeltaPerScope(t *testing.T) {
+	dir := t.TempDir()
+	sinks := []Sink{&fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}}
+	defer func() { previousIndex = nil }()
+
+	cycles := []struct {
+		scope    string
+		index    ClusterIndex
+		first    bool
+		previous string
+	}{
+		{scope: scopeCluster, index: ClusterIndex{Cluster: "prod", CycleID: "c1", CollectionStats: map[string]int{"clustervulnerabilityreports": 1}}, first: true},
+		{scope: scopeNamespaced, index: ClusterIndex{Cluster: "prod", CycleID: "n1", CollectionStats: map[string]int{"vulnerabilityreports": 5}}, first: true},
+		{scope: scopeCluster, index: ClusterIndex{Cluster: "prod", CycleID: "c2", CollectionStats: map[string]int{"clustervulnerabilityreports": 2}}, previous: "c1"},
+		{scope: scopeNamespaced, index: ClusterIndex{Cluster: "prod", CycleID: "n2", CollectionStats: map[string]int{"vulnerabilityreports": 5}}, previous: "n1"},
+	}
+	for _, c := range cycles {
+		// The deployments run in separate processes
+		previousIndex = nil
+		cfg := Config{ClusterName: "prod", FSOutputDir: dir, FSLayout: fsLayoutFlat, Scope: c.scope}
+		previous := loadPreviousIndex(context.Background(), sinks, cfg)
+		if err := publishIndex(context.Background(), sinks, cfg, c.index); err != nil {
+			t.Fatal(err)
+		}
+		if err := publishIndexDelta(context.Background(), sinks, cfg, previous, c.index); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "prod-index-delta-"+c.scope+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var delta IndexDelta
+		if err := json.Unmarshal(data, &delta); err != nil {
+			t.Fatal(err)
+		}
+		if delta.CycleID != c.index.CycleID || delta.FirstCycle != c.first || delta.PreviousCycleID != c.previous {
+			t.Errorf("delta of %s = cycle %s, first %v, previous %q; want first %v, previous %q",
+				c.index.CycleID, delta.CycleID, delta.FirstCycle, delta.PreviousCycleID, c.first, c.previous)
+		}
+		if len(delta.Stale) != 0 {
+			t.Errorf("delta of %s lists the other scope's report types %v as stale", c.index.CycleID, delta.Stale)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prod-index-delta.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("split deployments published the shared index-delta.json: %v", err)
+	}
+}
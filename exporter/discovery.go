@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode"
+
+	"k8s.io/client-go/discovery"
+)
+
+// Group version of the trivy-operator report CRDs
+const reportGroupVersion = "aquasecurity.github.io/v1alpha1"
+
+// reportDiscoveryCycles counts the cycles for REPORT_DISCOVERY_EVERY
+var reportDiscoveryCycles = &cycleCounter{}
+
+// reportTypesDiscovered is set once the report types were discovered
+var reportTypesDiscovered bool
+
+// availableReportResources are the known report types and those discovered in the cluster
+var availableReportResources = knownReportResources
+
+// reportTypeFileName derives the file name of a discovered report type from its kind, e.g.
+// infra-assessment-reports for InfraAssessmentReport, falling back to the resource name
+// when the kind does not spell it
+func reportTypeFileName(name, kind string) string {
+	var b strings.Builder
+	for i, r := range kind {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	fileName := b.String() + "s"
+	if strings.ReplaceAll(fileName, "-", "") != name {
+		return name
+	}
+	return fileName
+}
+
+// reportTypeAllowed applies ENABLE_SBOM_REPORTS, REPORT_TYPES and REPORT_TYPES_EXCLUDE to a
+// report type
+func (c Config) reportTypeAllowed(r ReportResource) bool {
+	if r.SBOM && !c.EnableSBOMReports {
+		return false
+	}
+	if len(c.ReportTypes) > 0 && !slices.Contains(c.ReportTypes, r.Name) {
+		return false
+	}
+	return !slices.Contains(c.ReportTypesExclude, r.Name)
+}
+
+// allowedReportResources returns the resources of known that the configuration allows
+func allowedReportResources(known []ReportResource, cfg Config) []ReportResource {
+	var allowed []ReportResource
+	for _, r := range known {
+		if cfg.reportTypeAllowed(r) {
+			allowed = append(allowed, r)
+		}
+	}
+	return allowed
+}
+
+// discoverReportResources lists the report CRDs installed in the cluster and returns the
+// report types available for collection, including those of CUSTOM_RESOURCES_FILE. Known
+// report types keep their file name and priority and stay available when their CRD is
+// missing, so their report file is marked unavailable; report types added by newer
+// trivy-operator versions get low priority.
+func discoverReportResources(client discovery.DiscoveryInterface, cfg Config) ([]ReportResource, error) {
+	list, err := client.ServerResourcesForGroupVersion(reportGroupVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover %s resources: %w", reportGroupVersion, err)
+	}
+
+	available := baseReportResources(cfg)
+	known := make(map[string]bool, len(knownReportResources))
+	for _, r := range knownReportResources {
+		known[r.Name] = true
+	}
+	for _, api := range list.APIResources {
+		// Subresources such as vulnerabilityreports/status
+		if strings.Contains(api.Name, "/") || !slices.Contains(api.Verbs, "list") || known[api.Name] {
+			continue
+		}
+		available = append(available, ReportResource{
+			Name:          api.Name,
+			Kind:          api.Kind,
+			FileName:      reportTypeFileName(api.Name, api.Kind),
+			Priority:      priorityLow,
+			ClusterScoped: !api.Namespaced,
+		})
+	}
+	return available, nil
+}
+
+// validateReportTypes checks that REPORT_TYPES and REPORT_TYPES_EXCLUDE only name available
+// report types
+func validateReportTypes(cfg Config, available []ReportResource) error {
+	var names []string
+	for _, r := range available {
+		names = append(names, r.Name)
+	}
+	for _, setting := range []struct {
+		name  string
+		types []string
+	}{{"REPORT_TYPES", cfg.ReportTypes}, {"REPORT_TYPES_EXCLUDE", cfg.ReportTypesExclude}} {
+		for _, t := range setting.types {
+			if !slices.Contains(names, t) {
+				return fmt.Errorf("invalid %s entry %q (valid: %s)", setting.name, t, strings.Join(names, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// refreshReportResources replaces reportResources with the allowed report types of the
+// cluster every REPORT_DISCOVERY_EVERY cycles, logging them the first time and whenever they
+// change. A failed discovery keeps the current set.
+func refreshReportResources(client discovery.DiscoveryInterface, cfg Config) {
+	if cfg.ReportDiscoveryEvery <= 0 || !reportDiscoveryCycles.due(cfg.ReportDiscoveryEvery) {
+		return
+	}
+	available, err := discoverReportResources(client, cfg)
+	if err != nil {
+		log.Printf("⚠️ %v; collecting %s", err, strings.Join(getReportTypeNames(), ", "))
+		return
+	}
+	availableReportResources = available
+	before := getReportTypeNames()
+	reportResources = allowedReportResources(available, cfg)
+	first := !reportTypesDiscovered
+	reportTypesDiscovered = true
+	if !first && slices.Equal(before, getReportTypeNames()) {
+		return
+	}
+	var skipped []string
+	for _, r := range available {
+		if !cfg.reportTypeAllowed(r) {
+			skipped = append(skipped, r.Name)
+		}
+	}
+	log.Printf("🔍 Discovered report types: %s", strings.Join(getReportTypeNames(), ", "))
+	if len(skipped) > 0 {
+		log.Printf("⏭️ Skipped report types: %s", strings.Join(skipped, ", "))
+	}
+}
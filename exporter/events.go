@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Event kinds published by the collection pipeline
+const (
+	eventCycleCompleted    = "CycleCompleted"
+	eventResourceCollected = "ResourceCollected"
+	eventFindingAdded      = "FindingAdded"
+	eventFindingResolved   = "FindingResolved"
+	eventSinkUnhealthy     = "SinkUnhealthy"
+)
+
+// Delivery semantics per kind. Every subscriber receives its events in publish order.
+// Asynchronous events are queued and publish returns immediately, blocking only while a
+// subscriber's queue is full. Synchronous events are delivered after all events published
+// before them, and publish returns once every subscriber has handled them, so
+// CycleCompleted subscribers see the whole cycle and finish before the next one starts.
+var synchronousEvents = map[string]bool{
+	eventCycleCompleted: true,
+}
+
+// Event is anything published on the bus
+type Event interface {
+	Kind() string
+}
+
+// CycleCompleted is published at the end of every collection cycle, after the index
+type CycleCompleted struct {
+	Index ClusterIndex
+	Err   error // The cycle's result, nil when every report type was collected
+}
+
+// ResourceCollected is published once a report type was collected and uploaded, or failed
+type ResourceCollected struct {
+	CycleID  string
+	Resource string
+	Stats    ResourceStats
+	Err      error
+}
+
+// FindingAdded and FindingResolved describe findings that appeared or disappeared since the
+// previous cycle. The first cycle of a process only records a baseline.
+type FindingAdded struct {
+	CycleID string
+	Finding Finding
+}
+
+type FindingResolved struct {
+	CycleID string
+	Finding Finding
+}
+
+// SinkUnhealthy is published when an output sink starts failing or fails for a new reason
+type SinkUnhealthy struct {
+	Sink   string
+	Reason string
+	Err    error
+}
+
+func (CycleCompleted) Kind() string    { return eventCycleCompleted }
+func (ResourceCollected) Kind() string { return eventResourceCollected }
+func (FindingAdded) Kind() string      { return eventFindingAdded }
+func (FindingResolved) Kind() string   { return eventFindingResolved }
+func (SinkUnhealthy) Kind() string     { return eventSinkUnhealthy }
+
+// Events a subscriber may have queued before publish blocks
+const subscriberQueueSize = 1024
+
+// events is the process-wide bus; at most four handlers run at once
+var events = newEventBus(4)
+
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers []*subscriber
+	running     chan struct{}
+}
+
+type subscriber struct {
+	name   string
+	kinds  map[string]bool
+	handle func(context.Context, Event) error
+	queue  chan delivery
+}
+
+type delivery struct {
+	ctx   context.Context
+	event Event
+	done  *sync.WaitGroup // Set for synchronous events
+}
+
+func newEventBus(maxRunning int) *eventBus {
+	return &eventBus{running: make(chan struct{}, maxRunning)}
+}
+
+// subscribe registers a handler for the given kinds. Handlers run on the subscriber's own
+// goroutine; an error or panic is logged and does not affect the pipeline or other
+// subscribers. Handlers must not publish synchronous events.
+func (b *eventBus) subscribe(name string, handle func(context.Context, Event) error, kinds ...string) {
+	s := &subscriber{
+		name:   name,
+		kinds:  make(map[string]bool, len(kinds)),
+		handle: handle,
+		queue:  make(chan delivery, subscriberQueueSize),
+	}
+	for _, kind := range kinds {
+		s.kinds[kind] = true
+	}
+	go func() {
+		for d := range s.queue {
+			b.running <- struct{}{}
+			s.run(d)
+			<-b.running
+			if d.done != nil {
+				d.done.Done()
+			}
+		}
+	}()
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mu.Unlock()
+	log.Printf("📮 %s subscribed to %s", name, strings.Join(kinds, ", "))
+}
+
+func (s *subscriber) run(d delivery) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("💥 Event subscriber %s panicked on %s: %v", s.name, d.event.Kind(), r)
+		}
+	}()
+	if err := s.handle(d.ctx, d.event); err != nil {
+		log.Printf("⚠️ Event subscriber %s failed on %s: %v", s.name, d.event.Kind(), err)
+	}
+}
+
+// wants reports whether any subscriber handles a kind, so costly events are only built
+// when someone listens
+func (b *eventBus) wants(kind string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subscribers {
+		if s.kinds[kind] {
+			return true
+		}
+	}
+	return false
+}
+
+// publish delivers an event to its subscribers with the semantics of its kind
+func (b *eventBus) publish(ctx context.Context, e Event) {
+	b.mu.RLock()
+	var targets []*subscriber
+	for _, s := range b.subscribers {
+		if s.kinds[e.Kind()] {
+			targets = append(targets, s)
+		}
+	}
+	b.mu.RUnlock()
+
+	if !synchronousEvents[e.Kind()] {
+		for _, s := range targets {
+			s.queue <- delivery{ctx: ctx, event: e}
+		}
+		return
+	}
+
+	var done sync.WaitGroup
+	done.Add(len(targets))
+	for _, s := range targets {
+		s.queue <- delivery{ctx: ctx, event: e, done: &done}
+	}
+	done.Wait()
+}
+
+// trackedFindings holds the findings of the current and the previous cycle while anyone
+// subscribes to FindingAdded or FindingResolved
+var trackedFindings = &findingTracker{}
+
+type findingTracker struct {
+	mu       sync.Mutex
+	previous map[string]Finding // nil until the first cycle completed
+	current  map[string]Finding
+}
+
+func findingEventsWanted() bool {
+	return events.wants(eventFindingAdded) || events.wants(eventFindingResolved)
+}
+
+func (t *findingTracker) observe(findings []Finding) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current == nil {
+		t.current = make(map[string]Finding)
+	}
+	for _, f := range findings {
+		t.current[findingKey(f)] = f
+	}
+}
+
+// publishChanges publishes the findings added and resolved since the previous cycle.
+// Report types that failed this cycle are kept from the previous cycle so their findings
+// are not reported as resolved.
+func (t *findingTracker) publishChanges(ctx context.Context, cycleID string, failedKinds map[string]bool) {
+	t.mu.Lock()
+	previous, current := t.previous, t.current
+	if current == nil {
+		current = make(map[string]Finding)
+	}
+	for key, f := range previous {
+		if failedKinds[f.Kind] {
+			current[key] = f
+		}
+	}
+	t.previous, t.current = current, nil
+	t.mu.Unlock()
+
+	if previous == nil {
+		return
+	}
+	for key, f := range current {
+		if _, ok := previous[key]; !ok {
+			events.publish(ctx, FindingAdded{CycleID: cycleID, Finding: f})
+		}
+	}
+	for key, f := range previous {
+		if _, ok := current[key]; !ok {
+			events.publish(ctx, FindingResolved{CycleID: cycleID, Finding: f})
+		}
+	}
+}
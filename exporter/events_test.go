@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recorder collects the events a subscriber handled
+type recorder struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+func (r *recorder) handle(ctx context.Context, e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch e := e.(type) {
+	case ResourceCollected:
+		r.seen = append(r.seen, e.Resource)
+	case CycleCompleted:
+		r.seen = append(r.seen, "cycle "+e.Index.CycleID)
+	default:
+		r.seen = append(r.seen, e.Kind())
+	}
+	return nil
+}
+
+func (r *recorder) events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.seen)
+}
+
+// Asynchronous events reach every subscriber in publish order, and a synchronous event
+// returns only after everything published before it was handled
+func TestEventBusOrdering(t *testing.T) {
+	tests := []struct {
+		name        string
+		subscribers int
+		maxRunning  int
+		kinds       []string
+		want        func(resources []string) []string
+	}{
+		{
+			name:        "one subscriber",
+			subscribers: 1,
+			maxRunning:  4,
+			kinds:       []string{eventResourceCollected, eventCycleCompleted},
+			want:        func(resources []string) []string { return append(resources, "cycle c1") },
+		},
+		{
+			name:        "more subscribers than running handlers",
+			subscribers: 8,
+			maxRunning:  2,
+			kinds:       []string{eventResourceCollected, eventCycleCompleted},
+			want:        func(resources []string) []string { return append(resources, "cycle c1") },
+		},
+		{
+			name:        "only subscribed kinds",
+			subscribers: 2,
+			maxRunning:  4,
+			kinds:       []string{eventCycleCompleted},
+			want:        func([]string) []string { return []string{"cycle c1"} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := newEventBus(tt.maxRunning)
+			recorders := make([]*recorder, tt.subscribers)
+			for i := range recorders {
+				recorders[i] = &recorder{}
+				bus.subscribe(fmt.Sprintf("recorder-%d", i), recorders[i].handle, tt.kinds...)
+			}
+
+			ctx := context.Background()
+			var resources []string
+			for i := 0; i < 100; i++ {
+				resources = append(resources, fmt.Sprintf("resource-%d", i))
+				bus.publish(ctx, ResourceCollected{CycleID: "c1", Resource: resources[i]})
+			}
+			bus.publish(ctx, CycleCompleted{Index: ClusterIndex{CycleID: "c1"}})
+
+			want := tt.want(resources)
+			for i, r := range recorders {
+				if got := r.events(); !slices.Equal(got, want) {
+					t.Errorf("subscriber %d handled %d events %v, want %d in publish order", i, len(got), got, len(want))
+				}
+			}
+		})
+	}
+}
+
+func TestEventBusDeliverySemantics(t *testing.T) {
+	tests := []struct {
+		event       Event
+		synchronous bool
+	}{
+		{event: CycleCompleted{}, synchronous: true},
+		{event: ResourceCollected{}},
+		{event: FindingAdded{}},
+		{event: FindingResolved{}},
+		{event: SinkUnhealthy{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.event.Kind(), func(t *testing.T) {
+			bus := newEventBus(4)
+			release := make(chan struct{})
+			var handled atomic.Bool
+			bus.subscribe("blocked", func(ctx context.Context, e Event) error {
+				<-release
+				handled.Store(true)
+				return nil
+			}, tt.event.Kind())
+
+			returned := make(chan struct{})
+			go func() {
+				bus.publish(context.Background(), tt.event)
+				close(returned)
+			}()
+
+			select {
+			case <-returned:
+				if tt.synchronous {
+					t.Fatal("publish returned before the subscriber handled the event")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if !tt.synchronous {
+					t.Fatal("publish waited for the subscriber")
+				}
+			}
+			close(release)
+			<-returned
+			if tt.synchronous && !handled.Load() {
+				t.Error("publish returned before the handler finished")
+			}
+		})
+	}
+}
+
+// A failing or panicking subscriber affects neither the publisher nor other subscribers,
+// and keeps receiving later events
+func TestEventBusIsolatesSubscribers(t *testing.T) {
+	bus := newEventBus(2)
+	var panics, failures atomic.Int32
+	bus.subscribe("panicking", func(ctx context.Context, e Event) error {
+		panics.Add(1)
+		panic("nil map")
+	}, eventResourceCollected, eventCycleCompleted)
+	bus.subscribe("failing", func(ctx context.Context, e Event) error {
+		failures.Add(1)
+		return errors.New("webhook unreachable")
+	}, eventResourceCollected, eventCycleCompleted)
+	healthy := &recorder{}
+	bus.subscribe("healthy", healthy.handle, eventResourceCollected, eventCycleCompleted)
+
+	ctx := context.Background()
+	for _, cycle := range []string{"c1", "c2"} {
+		bus.publish(ctx, ResourceCollected{CycleID: cycle, Resource: "vulnerabilityreports"})
+		bus.publish(ctx, CycleCompleted{Index: ClusterIndex{CycleID: cycle}})
+	}
+
+	if panics.Load() != 4 || failures.Load() != 4 {
+		t.Errorf("panicking subscriber ran %d times, failing one %d times; want 4 each", panics.Load(), failures.Load())
+	}
+	want := []string{"vulnerabilityreports", "cycle c1", "vulnerabilityreports", "cycle c2"}
+	if got := healthy.events(); !slices.Equal(got, want) {
+		t.Errorf("healthy subscriber handled %v, want %v", got, want)
+	}
+}
+
+func TestEventBusBoundsRunningHandlers(t *testing.T) {
+	bus := newEventBus(2)
+	var running, peak atomic.Int32
+	for i := 0; i < 6; i++ {
+		bus.subscribe(fmt.Sprintf("slow-%d", i), func(ctx context.Context, e Event) error {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		}, eventCycleCompleted)
+	}
+	bus.publish(context.Background(), CycleCompleted{})
+	if p := peak.Load(); p < 1 || p > 2 {
+		t.Errorf("%d handlers ran at once, want at most 2", p)
+	}
+}
+
+// FindingAdded and FindingResolved compare consecutive cycles; the first one is a baseline
+// and failed report types keep their previous findings
+func TestFindingTrackerPublishChanges(t *testing.T) {
+	saved := events
+	defer func() { events = saved }()
+	events = newEventBus(4)
+	var mu sync.Mutex
+	var got []string
+	events.subscribe("changes", func(ctx context.Context, e Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		switch e := e.(type) {
+		case FindingAdded:
+			got = append(got, e.CycleID+" added "+e.Finding.ID)
+		case FindingResolved:
+			got = append(got, e.CycleID+" resolved "+e.Finding.ID)
+		}
+		return nil
+	}, eventFindingAdded, eventFindingResolved, eventCycleCompleted)
+
+	vuln := func(id string) Finding { return Finding{Kind: "VulnerabilityReport", Workload: "nginx", ID: id} }
+	audit := func(id string) Finding { return Finding{Kind: "ConfigAuditReport", Workload: "nginx", ID: id} }
+	cycles := []struct {
+		id       string
+		findings []Finding
+		failed   map[string]bool
+		want     []string
+	}{
+		{id: "c1", findings: []Finding{vuln("CVE-1"), audit("KSV001")}},
+		{id: "c2", findings: []Finding{vuln("CVE-2"), audit("KSV001")}, want: []string{"c2 added CVE-2", "c2 resolved CVE-1"}},
+		{id: "c3", findings: []Finding{vuln("CVE-2")}, failed: map[string]bool{"ConfigAuditReport": true}},
+		{id: "c4", findings: []Finding{vuln("CVE-2")}, want: []string{"c4 resolved KSV001"}},
+	}
+	tracker := &findingTracker{}
+	for _, c := range cycles {
+		mu.Lock()
+		got = nil
+		mu.Unlock()
+		tracker.observe(c.findings)
+		tracker.publishChanges(context.Background(), c.id, c.failed)
+		// Finding events are asynchronous; the synchronous CycleCompleted is handled after them
+		events.publish(context.Background(), CycleCompleted{})
+
+		mu.Lock()
+		slices.Sort(got)
+		if !slices.Equal(got, c.want) {
+			t.Errorf("cycle %s published %v, want %v", c.id, got, c.want)
+		}
+		mu.Unlock()
+	}
+}
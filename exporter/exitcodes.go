@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Exit codes of the exporter and its subcommands, so automation can tell failure classes
+// apart without parsing logs
+const (
+	exitInternal   = 1
+	exitConfig     = 2 // invalid flags or environment
+	exitK8s        = 3 // cluster unreachable or report resources not listable
+	exitStorage    = 4 // S3 or filesystem output/input unusable
+	exitPartial    = 5 // cycle finished but some report types failed
+	exitValidation = 6 // input or artifact contents invalid
+)
+
+var exitClasses = map[int]string{
+	exitInternal:   "internal",
+	exitConfig:     "config",
+	exitK8s:        "k8s",
+	exitStorage:    "storage",
+	exitPartial:    "partial",
+	exitValidation: "validation",
+}
+
+// exitError tags an error with its failure class. The outermost tag wins, so lower layers
+// classify and callers only wrap with fmt.Errorf("...: %w").
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func classify(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+func configError(err error) error     { return classify(exitConfig, err) }
+func k8sError(err error) error        { return classify(exitK8s, err) }
+func storageError(err error) error    { return classify(exitStorage, err) }
+func partialError(err error) error    { return classify(exitPartial, err) }
+func validationError(err error) error { return classify(exitValidation, err) }
+
+// exitCode returns the code of the first classified error in the chain
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitInternal
+}
+
+// errorFormatFromArgs removes --error-format=<text|json> from the arguments
+func errorFormatFromArgs(args []string) (string, []string) {
+	format := "text"
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.HasPrefix(args[i], "--error-format="):
+			format = strings.TrimPrefix(args[i], "--error-format=")
+		case args[i] == "--error-format" && i+1 < len(args):
+			format = args[i+1]
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return format, rest
+}
+
+// exitWithError logs err and exits with its code. With --error-format=json a summary
+// {"code", "class", "error"} is also written to stderr as a single JSON line.
+func exitWithError(format, what string, err error) {
+	code := exitCode(err)
+	log.Printf("❌ %s failed: %v", what, err)
+	if format == "json" {
+		summary, _ := json.Marshal(map[string]interface{}{
+			"code":  code,
+			"class": exitClasses[code],
+			"error": err.Error(),
+		})
+		fmt.Fprintln(os.Stderr, string(summary))
+	}
+	os.Exit(code)
+}
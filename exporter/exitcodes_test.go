@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"unclassified", errors.New("boom"), exitInternal},
+		{"classified", storageError(errors.New("disk full")), exitStorage},
+		{"wrapped by callers", fmt.Errorf("cycle: %w", k8sError(errors.New("forbidden"))), exitK8s},
+		{"outermost class wins", partialError(fmt.Errorf("1 of 2 report types failed: %w", k8sError(errors.New("forbidden")))), exitPartial},
+		{"joined", errors.Join(errors.New("boom"), validationError(errors.New("bad JSON"))), exitValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorFormatFromArgs(t *testing.T) {
+	tests := []struct {
+		args   []string
+		format string
+		rest   []string
+	}{
+		{args: []string{"compare", "--bucket", "b"}, format: "text", rest: []string{"compare", "--bucket", "b"}},
+		{args: []string{"--error-format=json", "compare"}, format: "json", rest: []string{"compare"}},
+		{args: []string{"simulate", "--error-format", "json", "--input", "dumps"}, format: "json", rest: []string{"simulate", "--input", "dumps"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			format, rest := errorFormatFromArgs(tt.args)
+			if format != tt.format || strings.Join(rest, " ") != strings.Join(tt.rest, " ") {
+				t.Errorf("errorFormatFromArgs() = %q, %q; want %q, %q", format, rest, tt.format, tt.rest)
+			}
+		})
+	}
+}
+
+// writeDump writes a report dump with a single vulnerability report for `exporter simulate`
+func writeDump(t *testing.T, data string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "vulnerability-reports.json"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+const validDump = `{"items": [{"apiVersion": "aquasecurity.github.io/v1alpha1", "kind": "VulnerabilityReport", "metadata": {"name": "a", "namespace": "default"}}]}`
+
+// Representative failures of the subcommands and the collection cycle, each with its class
+func TestExitCodesOfFailures(t *testing.T) {
+	saved := reportResources
+	defer func() { reportResources = saved }()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T) error
+		want int
+	}{
+		{
+			name: "compare without a bucket",
+			run:  func(t *testing.T) error { return runCompare(nil) },
+			want: exitConfig,
+		},
+		{
+			name: "compare with one cluster",
+			run:  func(t *testing.T) error { return runCompare([]string{"--bucket", "b", "--clusters", "prod"}) },
+			want: exitConfig,
+		},
+		{
+			name: "simulate without dumps",
+			run:  func(t *testing.T) error { return runSimulate([]string{"--input", t.TempDir()}) },
+			want: exitConfig,
+		},
+		{
+			name: "simulate with an invalid CUSTOM_RESOURCES_FILE",
+			run: func(t *testing.T) error {
+				custom := filepath.Join(t.TempDir(), "custom-resources.yaml")
+				os.WriteFile(custom, []byte("- resource: benchreports\n"), 0644)
+				t.Setenv("CUSTOM_RESOURCES_FILE", custom)
+				return runSimulate([]string{"--input", writeDump(t, validDump)})
+			},
+			want: exitConfig,
+		},
+		{
+			name: "simulate a malformed dump",
+			run: func(t *testing.T) error {
+				return runSimulate([]string{"--input", writeDump(t, `{"items": [{"kind": `)})
+			},
+			want: exitValidation,
+		},
+		{
+			name: "simulate into an unusable output directory",
+			run: func(t *testing.T) error {
+				file := filepath.Join(t.TempDir(), "output")
+				os.WriteFile(file, nil, 0644)
+				return runSimulate([]string{"--input", writeDump(t, validDump), "--output", filepath.Join(file, "out")})
+			},
+			want: exitStorage,
+		},
+		{
+			name: "report type not listable",
+			run: func(t *testing.T) error {
+				calls := 0
+				forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "vulnerabilityreports"}, "", errors.New("rbac"))
+				client := pagedClient(t, []listPage{{err: forbidden}}, &calls)
+				dir := t.TempDir()
+				sinks := []Sink{&fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}}
+				cfg := Config{ClusterName: "prod", PageSize: 10, FSOutputDir: dir, FSLayout: fsLayoutFlat}
+				_, err := collectResourcePaged(context.Background(), client, sinks, cfg, vulnerabilityResource, "20260301-100000", nil, nil, newUploadQueue(1))
+				return err
+			},
+			want: exitK8s,
+		},
+		{
+			name: "cycle with a failed report type",
+			run: func(t *testing.T) error {
+				resources := knownReportResources[:2]
+				listKinds := make(map[schema.GroupVersionResource]string)
+				for _, r := range resources {
+					listKinds[reportGVR(r)] = r.Kind + "List"
+				}
+				client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+				client.PrependReactor("list", resources[1].Name, func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewForbidden(reportGVR(resources[1]).GroupResource(), "", errors.New("rbac"))
+				})
+				reportResources = resources
+
+				cfg := configFromEnv()
+				cfg.ClusterName = "prod"
+				cfg.FSOutputDir = t.TempDir()
+				cfg.S3Bucket = ""
+				sinks, err := newSinks(context.Background(), cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return collectAndUploadAll(context.Background(), client, sinks, cfg)
+			},
+			want: exitPartial,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run(t)
+			if err == nil {
+				t.Fatal("succeeded")
+			}
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("exit code = %d (%v), want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// runMainHelper re-runs the test binary as the exporter with the arguments in
+// EXPORTER_TEST_ARGS, so the exit code and stderr of os.Exit can be observed. The
+// arguments may be empty for the collection loop.
+func runMainHelper(t *testing.T, env []string, args ...string) (int, []byte) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMainHelper$")
+	cmd.Env = append(os.Environ(), append(env, "EXPORTER_TEST_MAIN=1", "EXPORTER_TEST_ARGS="+strings.Join(args, "\n"))...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		t.Fatal(err)
+	}
+	return cmd.ProcessState.ExitCode(), stderr.Bytes()
+}
+
+func TestMainHelper(t *testing.T) {
+	if os.Getenv("EXPORTER_TEST_MAIN") == "" {
+		t.Skip("only run by runMainHelper")
+	}
+	os.Args = []string{"exporter"}
+	if args := os.Getenv("EXPORTER_TEST_ARGS"); args != "" {
+		os.Args = append(os.Args, strings.Split(args, "\n")...)
+	}
+	main()
+}
+
+func TestExitWithErrorSummary(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+		args []string
+		code int
+		err  string
+	}{
+		{name: "compare without a bucket", args: []string{"--error-format=json", "compare"}, code: exitConfig, err: "--bucket"},
+		{name: "simulate a malformed dump", args: []string{"simulate", "--error-format", "json", "--input", writeDump(t, `[`)}, code: exitValidation, err: "failed to read"},
+		{name: "invalid configuration", env: []string{"LIST_RESTARTS=-1"}, args: []string{"--error-format=json"}, code: exitConfig, err: "LIST_RESTARTS"},
+		{name: "unreadable kubeconfig", args: []string{"--error-format=json", "--kubeconfig", filepath.Join(t.TempDir(), "missing")}, code: exitK8s, err: "kubeconfig"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			env := append([]string{"FS_OUTPUT_DIR=" + dir, "TERMINATION_LOG_PATH=" + filepath.Join(dir, "termination-log")}, tt.env...)
+			code, stderr := runMainHelper(t, env, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d\n%s", code, tt.code, stderr)
+			}
+
+			// The summary is the last line of stderr, after the logs
+			lines := strings.Split(strings.TrimSpace(string(stderr)), "\n")
+			var summary struct {
+				Code  int    `json:"code"`
+				Class string `json:"class"`
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &summary); err != nil {
+				t.Fatalf("last line of stderr is not a JSON summary: %v\n%s", err, stderr)
+			}
+			if summary.Code != tt.code || summary.Class != exitClasses[tt.code] || !strings.Contains(summary.Error, tt.err) {
+				t.Errorf("summary = %+v, want code %d (%s) and an error about %s", summary, tt.code, exitClasses[tt.code], tt.err)
+			}
+		})
+	}
+}
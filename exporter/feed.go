@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// With COLLECTION_MODE=watch and WATCH_FEED=true every report change the informers observe
+// is appended to feed/<date>.ndjson of the cluster, one FeedEvent per line. Sequence
+// numbers increase by one per event across files and restarts, and events are appended in
+// sequence order, so consumers track their position by the last sequence they applied.
+//
+// Delivery is at least once. An append whose response was lost is retried and its lines
+// appear twice, with the same sequence numbers. After a restart the informers replay every
+// report as an added event with "replay": true, followed by a synced event per kind; the
+// replay restores the state of any events a crash lost before they were flushed.
+const feedDir = "feed"
+
+// FeedEvent types
+const (
+	feedAdded   = "added"
+	feedUpdated = "updated"
+	feedDeleted = "deleted"
+	feedSynced  = "synced" // the informer of Kind delivered every existing report
+)
+
+// Events held in memory while a sink keeps failing; older ones are dropped, leaving a gap in
+// the sequence numbers of that sink's feed
+const maxFeedPending = 100000
+
+// FeedEvent is one line of feed/<date>.ndjson. Sequence comes first so the last sequence of
+// a feed file is read back without decoding the report.
+type FeedEvent struct {
+	Sequence        uint64                 `json:"sequence"`
+	Type            string                 `json:"type"`
+	ObservedAt      string                 `json:"observedAt"`
+	Cluster         string                 `json:"cluster"`
+	Kind            string                 `json:"kind"`
+	Namespace       string                 `json:"namespace,omitempty"`
+	Name            string                 `json:"name,omitempty"`
+	UID             string                 `json:"uid,omitempty"`
+	ResourceVersion string                 `json:"resourceVersion,omitempty"`
+	Replay          bool                   `json:"replay,omitempty"` // part of an informer's initial list
+	Object          map[string]interface{} `json:"object,omitempty"` // the report, without WATCH_PRUNE_FIELDS; not set on deletes
+}
+
+// feedAppender is implemented by the sinks the feed is written to: FS_OUTPUT_DIR appends to
+// the file, S3 emulates appends with multipart uploads
+type feedAppender interface {
+	Sink
+	lister
+	// appendTo adds data at the end of key, creating it
+	appendTo(ctx context.Context, key string, data []byte) error
+	// readTail returns the last n bytes of key, or all of it when shorter; nil when missing
+	readTail(ctx context.Context, key string, n int64) ([]byte, error)
+}
+
+// reportFeed appends the changes observed by the informers, nil unless WATCH_FEED is set
+var reportFeed *watchFeed
+
+type watchFeed struct {
+	cfg       Config
+	appenders []feedAppender
+	now       func() time.Time
+
+	mu       sync.Mutex
+	sequence uint64
+	day      string // date of the newest event; never goes back, so files stay in order
+	pending  []feedLine
+
+	flushMu   sync.Mutex        // serializes flushes; guards flushed and prunedDay
+	flushed   map[string]uint64 // last sequence appended per sink
+	prunedDay string
+}
+
+type feedLine struct {
+	sequence uint64
+	day      string
+	data     []byte
+}
+
+// feedKey returns the key of the feed file of a day
+func feedKey(day string) string {
+	return feedDir + "/" + day + ".ndjson"
+}
+
+// newWatchFeed writes the feed to the sinks that can append and continues the sequence
+// numbers of the feed files they hold
+func newWatchFeed(ctx context.Context, sinks []Sink, cfg Config) (*watchFeed, error) {
+	f := &watchFeed{cfg: cfg, now: time.Now, flushed: make(map[string]uint64)}
+	for _, s := range sinks {
+		if a, ok := s.(feedAppender); ok {
+			f.appenders = append(f.appenders, a)
+		}
+	}
+	if len(f.appenders) == 0 {
+		return nil, fmt.Errorf("WATCH_FEED requires FS_OUTPUT_DIR or S3_BUCKET")
+	}
+	for _, a := range f.appenders {
+		var last uint64
+		var day string
+		err := withRetry(ctx, cfg, a, func() error {
+			var err error
+			last, day, err = lastFeedSequence(ctx, a)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the feed of %s: %w", a.Name(), err)
+		}
+		f.flushed[a.Name()] = last
+		f.sequence = max(f.sequence, last)
+		f.day = max(f.day, day)
+	}
+	log.Printf("📜 Appending report changes to %s/ from sequence %d", feedDir, f.sequence+1)
+	return f, nil
+}
+
+// feedDays returns the feed files of a sink by day
+func feedDays(ctx context.Context, a feedAppender) (map[string]listedObject, error) {
+	objects, err := a.list(ctx, feedDir+"/")
+	if err != nil {
+		return nil, err
+	}
+	days := make(map[string]listedObject)
+	for _, obj := range objects {
+		name, ok := strings.CutPrefix(obj.Key, feedDir+"/")
+		if !ok {
+			continue
+		}
+		day, ok := strings.CutSuffix(name, ".ndjson")
+		if _, err := time.Parse(time.DateOnly, day); ok && err == nil {
+			days[day] = obj
+		}
+	}
+	return days, nil
+}
+
+// lastFeedSequence returns the sequence of the last complete event in a sink's newest feed
+// file and that file's day. A line torn by a crash mid-write is skipped.
+func lastFeedSequence(ctx context.Context, a feedAppender) (uint64, string, error) {
+	days, err := feedDays(ctx, a)
+	if err != nil || len(days) == 0 {
+		return 0, "", err
+	}
+	sorted := sortedKeys(days)
+	day := sorted[len(sorted)-1]
+	for n := int64(64 * 1024); ; n *= 4 {
+		data, err := a.readTail(ctx, feedKey(day), n)
+		if err != nil {
+			return 0, "", err
+		}
+		whole := int64(len(data)) < n
+		// Drop the torn line, then find the start of the last complete one
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			data = data[:i]
+		} else if whole {
+			return 0, day, nil
+		} else {
+			continue
+		}
+		i := bytes.LastIndexByte(data, '\n')
+		if i < 0 && !whole {
+			continue
+		}
+		var e struct {
+			Sequence uint64 `json:"sequence"`
+		}
+		if err := json.Unmarshal(data[i+1:], &e); err != nil {
+			return 0, "", fmt.Errorf("last event of %s: %w", feedKey(day), err)
+		}
+		return e.Sequence, day, nil
+	}
+}
+
+// observe appends an informer event of a report type. Items of namespaces excluded by
+// NAMESPACES_INCLUDE/NAMESPACES_EXCLUDE are left out, as in the report files.
+func (f *watchFeed) observe(resource ReportResource, typ string, u *unstructured.Unstructured, replay bool) {
+	if f == nil || u == nil {
+		return
+	}
+	if !resource.ClusterScoped && f.cfg.namespaceFiltered(u.GetNamespace()) {
+		return
+	}
+	e := FeedEvent{
+		Type:            typ,
+		Kind:            resource.Kind,
+		Namespace:       u.GetNamespace(),
+		Name:            u.GetName(),
+		UID:             string(u.GetUID()),
+		ResourceVersion: u.GetResourceVersion(),
+		Replay:          replay,
+	}
+	if typ != feedDeleted {
+		e.Object = u.Object
+	}
+	f.append(e)
+}
+
+// synced marks the end of the initial list of a report type
+func (f *watchFeed) synced(resource ReportResource) {
+	if f == nil {
+		return
+	}
+	f.append(FeedEvent{Type: feedSynced, Kind: resource.Kind})
+}
+
+// append numbers an event and queues it for the next flush. Numbering and queueing happen
+// under one lock, so the queue is always in sequence order.
+func (f *watchFeed) append(e FeedEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now().UTC()
+	f.day = max(f.day, now.Format(time.DateOnly))
+	e.Sequence = f.sequence + 1
+	e.ObservedAt = now.Format(time.RFC3339Nano)
+	e.Cluster = f.cfg.ClusterName
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("⚠️ Failed to encode the feed event of %s %s/%s: %v", e.Kind, e.Namespace, e.Name, err)
+		return
+	}
+	f.sequence = e.Sequence
+	f.pending = append(f.pending, feedLine{sequence: e.Sequence, day: f.day, data: append(data, '\n')})
+	if dropped := len(f.pending) - maxFeedPending; dropped > 0 {
+		log.Printf("⚠️ Feed backlog full, dropping events %d to %d", f.pending[0].sequence, f.pending[dropped-1].sequence)
+		f.pending = f.pending[dropped:]
+	}
+}
+
+// flush appends the queued events to every sink. Events stay queued for the sinks that
+// failed and are retried by the next flush.
+func (f *watchFeed) flush(ctx context.Context) error {
+	if f == nil {
+		return nil
+	}
+	f.flushMu.Lock()
+	defer f.flushMu.Unlock()
+	f.mu.Lock()
+	lines := f.pending
+	f.mu.Unlock()
+
+	var errs []error
+	for _, a := range f.appenders {
+		if err := f.appendLines(ctx, a, lines); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Name(), err))
+		}
+	}
+
+	// Events every sink has can go; append only ever grows the queue meanwhile
+	f.mu.Lock()
+	done := f.sequence
+	for _, last := range f.flushed {
+		done = min(done, last)
+	}
+	for len(f.pending) > 0 && f.pending[0].sequence <= done {
+		f.pending = f.pending[1:]
+	}
+	f.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// appendLines appends the lines a sink does not have yet, one append per feed file
+func (f *watchFeed) appendLines(ctx context.Context, a feedAppender, lines []feedLine) error {
+	for len(lines) > 0 {
+		if lines[0].sequence <= f.flushed[a.Name()] {
+			lines = lines[1:]
+			continue
+		}
+		day := lines[0].day
+		var buf bytes.Buffer
+		n := 0
+		for n < len(lines) && lines[n].day == day {
+			buf.Write(lines[n].data)
+			n++
+		}
+		err := withRetry(ctx, f.cfg, a, func() error {
+			return a.appendTo(ctx, feedKey(day), buf.Bytes())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to append to %s: %w", feedKey(day), err)
+		}
+		f.flushed[a.Name()] = lines[n-1].sequence
+		lines = lines[n:]
+	}
+	return nil
+}
+
+// prune deletes the feed files older than WATCH_FEED_RETENTION, once a day
+func (f *watchFeed) prune(ctx context.Context) {
+	if f == nil || f.cfg.WatchFeedRetention <= 0 {
+		return
+	}
+	f.flushMu.Lock()
+	defer f.flushMu.Unlock()
+	now := f.now().UTC()
+	today := now.Format(time.DateOnly)
+	if f.prunedDay == today {
+		return
+	}
+	cutoff := now.Add(-f.cfg.WatchFeedRetention)
+	for _, a := range f.appenders {
+		var days map[string]listedObject
+		err := withRetry(ctx, f.cfg, a, func() error {
+			var err error
+			days, err = feedDays(ctx, a)
+			return err
+		})
+		if err != nil {
+			log.Printf("⚠️ Failed to list the feed in %s: %v", a.Name(), err)
+			return
+		}
+		var reqs []DeletionRequest
+		for _, day := range sortedKeys(days) {
+			// A file holds the events of its whole day
+			if start, _ := time.Parse(time.DateOnly, day); start.Add(24 * time.Hour).Before(cutoff) {
+				reqs = append(reqs, DeletionRequest{Key: days[day].Key, Size: days[day].Size, Feature: "watch-feed", Reason: "expired"})
+			}
+		}
+		if len(reqs) == 0 {
+			continue
+		}
+		if _, _, err := deleteArtifacts(ctx, []Sink{a}, f.cfg, reqs); err != nil {
+			log.Printf("⚠️ Failed to prune the feed in %s: %v", a.Name(), err)
+			return
+		}
+	}
+	f.prunedDay = today
+}
+
+// run flushes the feed every WATCH_FEED_FLUSH_INTERVAL until ctx is done
+func (f *watchFeed) run(ctx context.Context) {
+	if f == nil {
+		return
+	}
+	ticker := time.NewTicker(f.cfg.WatchFeedFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.flush(ctx); err != nil {
+				log.Printf("⚠️ Failed to flush the feed: %v", err)
+			}
+			f.prune(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// close flushes the events still queued on shutdown
+func (f *watchFeed) close() {
+	if f == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := f.flush(ctx); err != nil {
+		log.Printf("⚠️ Failed to flush the feed on shutdown: %v", err)
+	}
+}
+
+// appendTo appends to the file of key. A line torn by a crash mid-write is terminated first,
+// so the data starts on a line of its own.
+func (s *fsSink) appendTo(ctx context.Context, key string, data []byte) error {
+	defer s.lock()()
+	p := s.path(key)
+	if err := s.opts.mkdirAll(filepath.Dir(p)); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	_, statErr := os.Stat(p)
+	file, err := os.OpenFile(p, os.O_RDWR|os.O_APPEND|os.O_CREATE, s.opts.fileMode)
+	if err != nil {
+		fsHealth.fail(key, err)
+		return err
+	}
+	defer file.Close()
+	if errors.Is(statErr, os.ErrNotExist) {
+		if err := file.Chmod(s.opts.fileMode); err != nil {
+			return err
+		}
+		if err := s.opts.chown(p); err != nil {
+			return err
+		}
+	}
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+	if _, err := file.Write(data); err != nil {
+		fsHealth.fail(key, err)
+		return err
+	}
+	if s.opts.fsync {
+		if err := file.Sync(); err != nil {
+			fsHealth.fail(key, err)
+			return err
+		}
+	}
+	fsHealth.observe(nil)
+	return file.Close()
+}
+
+func (s *fsSink) readTail(ctx context.Context, key string, n int64) ([]byte, error) {
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(0, info.Size()-n)
+	data := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// appendTo emulates an append, which S3 lacks. Objects below the minimum part size are read
+// back and rewritten with data added. Larger ones are copied server-side as the first part
+// of a multipart upload whose second part is data, so the feed file is never downloaded.
+// Reads and copies are conditional on the ETag seen first, so an object replaced meanwhile
+// fails the append instead of losing lines.
+func (s *s3Sink) appendTo(ctx context.Context, key string, data []byte) error {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		_, err := s.client.PutObject(ctx, s.putObjectInput(key, bytes.NewReader(data), int64(len(data)), ""))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if aws.ToInt64(head.ContentLength) < manager.MinUploadPartSize {
+		obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:  aws.String(s.bucket),
+			Key:     aws.String(s.objectKey(key)),
+			IfMatch: head.ETag,
+		})
+		if err != nil {
+			return err
+		}
+		existing, err := io.ReadAll(obj.Body)
+		obj.Body.Close()
+		if err != nil {
+			return err
+		}
+		body := append(existing, data...)
+		_, err = s.client.PutObject(ctx, s.putObjectInput(key, bytes.NewReader(body), int64(len(body)), ""))
+		return err
+	}
+
+	put := s.putObjectInput(key, nil, -1, "")
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               put.Bucket,
+		Key:                  put.Key,
+		ContentType:          put.ContentType,
+		CacheControl:         put.CacheControl,
+		Tagging:              put.Tagging,
+		ServerSideEncryption: put.ServerSideEncryption,
+		SSEKMSKeyId:          put.SSEKMSKeyId,
+		StorageClass:         put.StorageClass,
+	})
+	if err != nil {
+		return err
+	}
+	completed := false
+	defer func() {
+		if !completed {
+			s.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+				Bucket:   put.Bucket,
+				Key:      put.Key,
+				UploadId: created.UploadId,
+			})
+		}
+	}()
+	copied, err := s.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+		Bucket:            put.Bucket,
+		Key:               put.Key,
+		UploadId:          created.UploadId,
+		PartNumber:        aws.Int32(1),
+		CopySource:        aws.String(url.PathEscape(s.bucket) + "/" + url.PathEscape(s.objectKey(key))),
+		CopySourceIfMatch: head.ETag,
+	})
+	if err != nil {
+		return err
+	}
+	appended, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        put.Bucket,
+		Key:           put.Key,
+		UploadId:      created.UploadId,
+		PartNumber:    aws.Int32(2),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   put.Bucket,
+		Key:      put.Key,
+		UploadId: created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: []types.CompletedPart{
+			{PartNumber: aws.Int32(1), ETag: copied.CopyPartResult.ETag},
+			{PartNumber: aws.Int32(2), ETag: appended.ETag},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	completed = true
+	return nil
+}
+
+func (s *s3Sink) readTail(ctx context.Context, key string, n int64) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+		Range:  aws.String(fmt.Sprintf("bytes=-%d", n)),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	return io.ReadAll(obj.Body)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// memoryFeed is an in-memory feedAppender whose appends fail while failures is positive
+type memoryFeed struct {
+	mu       sync.Mutex
+	files    map[string][]byte
+	failures int
+}
+
+func newMemoryFeed() *memoryFeed { return &memoryFeed{files: make(map[string][]byte)} }
+
+func (m *memoryFeed) Name() string { return "memory" }
+
+func (m *memoryFeed) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	return nil
+}
+
+func (m *memoryFeed) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files[key], nil
+}
+
+func (m *memoryFeed) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, key)
+	return nil
+}
+
+func (m *memoryFeed) list(ctx context.Context, prefix string) ([]listedObject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var objects []listedObject
+	for key, data := range m.files {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, listedObject{Key: key, Size: int64(len(data))})
+		}
+	}
+	return objects, nil
+}
+
+func (m *memoryFeed) appendTo(ctx context.Context, key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("unavailable")
+	}
+	m.files[key] = append(m.files[key], data...)
+	return nil
+}
+
+func (m *memoryFeed) readTail(ctx context.Context, key string, n int64) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data := m.files[key]
+	return data[max(0, int64(len(data))-n):], nil
+}
+
+// readFeed decodes the events of a feed file
+func readFeed(t *testing.T, data []byte) []FeedEvent {
+	t.Helper()
+	var events []FeedEvent
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var e FeedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("decoding %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func sequencesOf(events []FeedEvent) []uint64 {
+	var seqs []uint64
+	for _, e := range events {
+		seqs = append(seqs, e.Sequence)
+	}
+	return seqs
+}
+
+// newTestFeed returns a feed on sinks whose clock is read from now
+func newTestFeed(t *testing.T, sinks []Sink, now *time.Time) *watchFeed {
+	t.Helper()
+	cfg := Config{ClusterName: "prod", WatchFeedRetention: 7 * 24 * time.Hour, DestructiveOps: destructiveAllow}
+	f, err := newWatchFeed(context.Background(), sinks, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.now = func() time.Time { return *now }
+	return f
+}
+
+func testReport(namespace, name, rv string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "aquasecurity.github.io/v1alpha1",
+		"kind":       "VulnerabilityReport",
+		"metadata": map[string]interface{}{
+			"namespace":       namespace,
+			"name":            name,
+			"uid":             namespace + "-" + name,
+			"resourceVersion": rv,
+		},
+		"report": map[string]interface{}{"summary": map[string]interface{}{"criticalCount": int64(1)}},
+	}}
+	return u
+}
+
+var vulnerabilityResource = ReportResource{Name: "vulnerabilityreports", Kind: "VulnerabilityReport", FileName: "vulnerability-reports"}
+
+func TestWatchFeedContinuesSequenceAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	sink := &fsSink{dir: dir, cluster: "prod", layout: fsLayoutNested, opts: fileOptions{fileMode: 0644, dirMode: 0755, uid: -1, gid: -1}}
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	f := newTestFeed(t, []Sink{sink}, &now)
+	for i := 1; i <= 3; i++ {
+		f.observe(vulnerabilityResource, feedAdded, testReport("default", fmt.Sprintf("r%d", i), "1"), false)
+	}
+	if err := f.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restarted exporter continues after the last event, also when the previous one
+	// crashed in the middle of a line
+	path := filepath.Join(dir, "prod", "feed", "2026-03-01.ndjson")
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"sequence":4,"type":"add`)
+	file.Close()
+
+	restarted := newTestFeed(t, []Sink{sink}, &now)
+	restarted.observe(vulnerabilityResource, feedDeleted, testReport("default", "r1", "2"), false)
+	if err := restarted.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[3], `{"sequence":4,"type":"add`) {
+		t.Fatalf("feed file:\n%s", data)
+	}
+	var last FeedEvent
+	if err := json.Unmarshal([]byte(lines[4]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if last.Sequence != 4 || last.Type != feedDeleted || last.Object != nil || last.Cluster != "prod" {
+		t.Errorf("event after restart = %+v, want sequence 4, a delete without object", last)
+	}
+}
+
+func TestWatchFeedRotatesDailyInOrder(t *testing.T) {
+	mem := newMemoryFeed()
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	f := newTestFeed(t, []Sink{mem}, &now)
+
+	f.observe(vulnerabilityResource, feedAdded, testReport("default", "a", "1"), false)
+	now = now.Add(2 * time.Minute)
+	f.observe(vulnerabilityResource, feedUpdated, testReport("default", "a", "2"), false)
+	// A clock stepped back does not move later events into an earlier file
+	now = now.Add(-time.Hour)
+	f.observe(vulnerabilityResource, feedUpdated, testReport("default", "a", "3"), false)
+	if err := f.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		want []uint64
+	}{
+		{key: "feed/2026-03-01.ndjson", want: []uint64{1}},
+		{key: "feed/2026-03-02.ndjson", want: []uint64{2, 3}},
+	}
+	for _, tt := range tests {
+		got := sequencesOf(readFeed(t, mem.files[tt.key]))
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s holds sequences %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestWatchFeedRetriesFailedSink(t *testing.T) {
+	healthy, failing := newMemoryFeed(), newMemoryFeed()
+	failing.failures = 1
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	f := newTestFeed(t, []Sink{healthy, &namedFeed{failing, "flaky"}}, &now)
+
+	f.observe(vulnerabilityResource, feedAdded, testReport("default", "a", "1"), false)
+	if err := f.flush(context.Background()); err == nil {
+		t.Fatal("flush succeeded with a failing sink")
+	}
+	f.observe(vulnerabilityResource, feedAdded, testReport("default", "b", "1"), false)
+	if err := f.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "feed/2026-03-01.ndjson"
+	for name, m := range map[string]*memoryFeed{"healthy": healthy, "flaky": failing} {
+		if got := sequencesOf(readFeed(t, m.files[key])); fmt.Sprint(got) != "[1 2]" {
+			t.Errorf("%s sink holds sequences %v, want [1 2] exactly once", name, got)
+		}
+	}
+	if len(f.pending) != 0 {
+		t.Errorf("%d events still queued after every sink caught up", len(f.pending))
+	}
+}
+
+// namedFeed renames a memoryFeed so two of them are tracked separately
+type namedFeed struct {
+	*memoryFeed
+	name string
+}
+
+func (n *namedFeed) Name() string { return n.name }
+
+func TestLastFeedSequence(t *testing.T) {
+	big := fmt.Sprintf(`{"sequence":8,"type":"added","object":{"pad":%q}}`, strings.Repeat("x", 200*1024))
+	tests := []struct {
+		name    string
+		content string
+		want    uint64
+	}{
+		{name: "empty file", content: "", want: 0},
+		{name: "single event", content: `{"sequence":1}` + "\n", want: 1},
+		{name: "several events", content: `{"sequence":1}` + "\n" + `{"sequence":2}` + "\n", want: 2},
+		{name: "torn last line", content: `{"sequence":5}` + "\n" + `{"sequence":6,"ty`, want: 5},
+		{name: "event larger than the tail window", content: `{"sequence":7}` + "\n" + big + "\n", want: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := newMemoryFeed()
+			mem.files["feed/2026-02-28.ndjson"] = []byte(`{"sequence":99}` + "\n")
+			mem.files["feed/2026-03-01.ndjson"] = []byte(tt.content)
+			mem.files["feed/notes.txt"] = []byte("ignored")
+			got, day, err := lastFeedSequence(context.Background(), mem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || day != "2026-03-01" {
+				t.Errorf("lastFeedSequence() = %d, %s, want %d, 2026-03-01", got, day, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchFeedPrune(t *testing.T) {
+	mem := newMemoryFeed()
+	for _, day := range []string{"2026-02-20", "2026-02-21", "2026-02-22", "2026-02-28"} {
+		mem.files[feedKey(day)] = []byte(`{"sequence":1}` + "\n")
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	f := newTestFeed(t, []Sink{mem}, &now)
+	f.prune(context.Background())
+
+	for _, tt := range []struct {
+		day  string
+		kept bool
+	}{
+		{day: "2026-02-20", kept: false},
+		{day: "2026-02-21", kept: false},
+		{day: "2026-02-22", kept: true}, // ends less than 7 days ago
+		{day: "2026-02-28", kept: true},
+	} {
+		if _, ok := mem.files[feedKey(tt.day)]; ok != tt.kept {
+			t.Errorf("feed file of %s kept = %v, want %v", tt.day, ok, tt.kept)
+		}
+	}
+}
+
+func TestWatchFeedFromInformers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	gvr := reportGVR(vulnerabilityResource)
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{gvr: "VulnerabilityReportList"},
+		testReport("default", "a", "1"), testReport("kube-system", "b", "1"))
+
+	mem := newMemoryFeed()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	cfg := Config{WatchMaxFailures: 5, NamespacesExclude: []string{"kube-system"}}
+	feed := newTestFeed(t, []Sink{mem}, &now)
+	feed.cfg.NamespacesExclude = cfg.NamespacesExclude
+	reportFeed = feed
+	defer func() { reportFeed = nil }()
+
+	w := newReportWatcher(client, cfg)
+	w.watch([]ReportResource{vulnerabilityResource})
+	defer w.watch(nil)
+
+	waitForFeed := func(n int) []FeedEvent {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if err := feed.flush(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			events := readFeed(t, mem.files["feed/2026-03-01.ndjson"])
+			if len(events) >= n || time.Now().After(deadline) {
+				return events
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForFeed(2)
+
+	ctx := context.Background()
+	updated := testReport("default", "a", "2")
+	if _, err := client.Resource(gvr).Namespace("default").Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Resource(gvr).Namespace("default").Delete(ctx, "a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		Sequence uint64
+		Type     string
+		Name     string
+		UID      types.UID
+		Replay   bool
+	}
+	want := []event{
+		{1, feedAdded, "a", "default-a", true},
+		{2, feedSynced, "", "", false},
+		{3, feedUpdated, "a", "default-a", false},
+		{4, feedDeleted, "a", "default-a", false},
+	}
+	events := waitForFeed(len(want))
+	var got []event
+	for _, e := range events {
+		got = append(got, event{e.Sequence, e.Type, e.Name, types.UID(e.UID), e.Replay})
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("feed events = %v, want %v", got, want)
+	}
+}
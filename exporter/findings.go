@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Finding is one row of the normalized findings stream: a single vulnerability, exposed
+// secret or failed check, flattened together with the workload and image it belongs to.
+// Export sinks consume findings instead of walking the report kinds themselves.
+type Finding struct {
+	Cluster     string `json:"cluster" parquet:"cluster,dict"`
+	Namespace   string `json:"namespace" parquet:"namespace,dict"`
+	Workload    string `json:"workload" parquet:"workload"`
+	Image       string `json:"image" parquet:"image,dict"`
+	Digest      string `json:"digest" parquet:"digest,dict"`
+	Kind        string `json:"kind" parquet:"kind,dict"`
+	ID          string `json:"id" parquet:"id,dict"`
+	Severity    string `json:"severity" parquet:"severity,dict"`
+	Fixable     bool   `json:"fixable" parquet:"fixable"`
+	FirstSeen   string `json:"firstSeen,omitempty" parquet:"firstSeen,optional"`
+	CollectedAt string `json:"collectedAt" parquet:"collectedAt"`
+}
+
+// Field holding the finding ID in each findings slice, keyed by the last path element of
+// summarySpec.entries
+var findingIDFields = map[string]string{
+	"vulnerabilities": "vulnerabilityID",
+	"secrets":         "ruleID",
+	"checks":          "checkID",
+}
+
+// workloadRef returns the <kind>/<name> of the workload a report item was created for
+func workloadRef(labels map[string]string) string {
+	workload := labels["trivy-operator.resource.name"]
+	if k := labels["trivy-operator.resource.kind"]; k != "" && workload != "" {
+		workload = k + "/" + workload
+	}
+	return workload
+}
+
+// flattenFindings returns the findings of a report item. It walks the same findings slices
+// as reconcileSummary, so audit checks that passed are not findings. The item's shape
+// decides where the digest and the passed checks are found.
+func flattenFindings(cluster, kind, collectedAt string, obj map[string]interface{}) []Finding {
+	spec, ok := summarySpecs[kind]
+	if !ok {
+		return nil
+	}
+	entries := nestedSlice(obj, spec.entries...)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	u := unstructured.Unstructured{Object: obj}
+	workload := workloadRef(u.GetLabels())
+	shape := reportShape(kind, obj)
+	digest := ""
+	if shape == shapeArtifactDigest {
+		digest, _, _ = unstructured.NestedString(obj, "report", "artifact", "digest")
+	}
+	idField := findingIDFields[spec.entries[len(spec.entries)-1]]
+
+	base := Finding{
+		Cluster:     cluster,
+		Namespace:   u.GetNamespace(),
+		Workload:    workload,
+		Image:       imageRef(obj),
+		Digest:      digest,
+		Kind:        kind,
+		CollectedAt: collectedAt,
+	}
+
+	findings := make([]Finding, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch shape {
+		case shapeChecksAll:
+			if success, _ := entry["success"].(bool); success {
+				continue
+			}
+		case shapeChecksFailed:
+			// Every recorded check failed, whether or not it carries a success flag
+		}
+		f := base
+		f.ID, _ = entry[idField].(string)
+		severity, _ := entry["severity"].(string)
+		f.Severity = strings.ToUpper(severity)
+		fixed, _ := entry["fixedVersion"].(string)
+		f.Fixable = fixed != ""
+		findings = append(findings, f)
+	}
+	return findings
+}
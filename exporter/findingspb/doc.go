@@ -0,0 +1,5 @@
+// Package findingspb holds the gRPC service the exporter serves on GRPC_ADDR. The code is
+// generated from findings.proto with protoc, protoc-gen-go and protoc-gen-go-grpc.
+package findingspb
+
+//go:generate protoc --proto_path=.. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative findingspb/findings.proto
@@ -0,0 +1,591 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.35.2
+// 	protoc        (unknown)
+// source: findingspb/findings.proto
+
+package findingspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type FindingEvent_Type int32
+
+const (
+	FindingEvent_TYPE_UNSPECIFIED FindingEvent_Type = 0
+	// A finding of the latest cycle, sent when the subscription starts
+	FindingEvent_SNAPSHOT FindingEvent_Type = 1
+	// Every snapshot finding was sent; incremental events follow
+	FindingEvent_SNAPSHOT_END FindingEvent_Type = 2
+	// A finding first seen by the cycle
+	FindingEvent_ADDED FindingEvent_Type = 3
+	// A finding the cycle no longer saw
+	FindingEvent_RESOLVED FindingEvent_Type = 4
+	// The subscriber fell behind and the oldest events were dropped; resubscribe for a
+	// consistent view
+	FindingEvent_GAP FindingEvent_Type = 5
+)
+
+// Enum value maps for FindingEvent_Type.
+var (
+	FindingEvent_Type_name = map[int32]string{
+		0: "TYPE_UNSPECIFIED",
+		1: "SNAPSHOT",
+		2: "SNAPSHOT_END",
+		3: "ADDED",
+		4: "RESOLVED",
+		5: "GAP",
+	}
+	FindingEvent_Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED": 0,
+		"SNAPSHOT":         1,
+		"SNAPSHOT_END":     2,
+		"ADDED":            3,
+		"RESOLVED":         4,
+		"GAP":              5,
+	}
+)
+
+func (x FindingEvent_Type) Enum() *FindingEvent_Type {
+	p := new(FindingEvent_Type)
+	*p = x
+	return p
+}
+
+func (x FindingEvent_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (FindingEvent_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_findingspb_findings_proto_enumTypes[0].Descriptor()
+}
+
+func (FindingEvent_Type) Type() protoreflect.EnumType {
+	return &file_findingspb_findings_proto_enumTypes[0]
+}
+
+func (x FindingEvent_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use FindingEvent_Type.Descriptor instead.
+func (FindingEvent_Type) EnumDescriptor() ([]byte, []int) {
+	return file_findingspb_findings_proto_rawDescGZIP(), []int{2, 0}
+}
+
+// SubscribeRequest filters the findings of a subscription; empty fields match everything
+type SubscribeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Clusters   []string `protobuf:"bytes,1,rep,name=clusters,proto3" json:"clusters,omitempty"`
+	Namespaces []string `protobuf:"bytes,2,rep,name=namespaces,proto3" json:"namespaces,omitempty"`
+	// Lowest severity sent: CRITICAL, HIGH, MEDIUM, LOW or UNKNOWN
+	MinSeverity string `protobuf:"bytes,3,opt,name=min_severity,json=minSeverity,proto3" json:"min_severity,omitempty"`
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_findingspb_findings_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_findingspb_findings_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_findingspb_findings_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubscribeRequest) GetClusters() []string {
+	if x != nil {
+		return x.Clusters
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetNamespaces() []string {
+	if x != nil {
+		return x.Namespaces
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetMinSeverity() string {
+	if x != nil {
+		return x.MinSeverity
+	}
+	return ""
+}
+
+// Finding is one row of the normalized findings stream
+type Finding struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Cluster     string `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	Namespace   string `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Workload    string `protobuf:"bytes,3,opt,name=workload,proto3" json:"workload,omitempty"`
+	Image       string `protobuf:"bytes,4,opt,name=image,proto3" json:"image,omitempty"`
+	Digest      string `protobuf:"bytes,5,opt,name=digest,proto3" json:"digest,omitempty"`
+	Kind        string `protobuf:"bytes,6,opt,name=kind,proto3" json:"kind,omitempty"`
+	Id          string `protobuf:"bytes,7,opt,name=id,proto3" json:"id,omitempty"`
+	Severity    string `protobuf:"bytes,8,opt,name=severity,proto3" json:"severity,omitempty"`
+	Fixable     bool   `protobuf:"varint,9,opt,name=fixable,proto3" json:"fixable,omitempty"`
+	FirstSeen   string `protobuf:"bytes,10,opt,name=first_seen,json=firstSeen,proto3" json:"first_seen,omitempty"`
+	CollectedAt string `protobuf:"bytes,11,opt,name=collected_at,json=collectedAt,proto3" json:"collected_at,omitempty"`
+}
+
+func (x *Finding) Reset() {
+	*x = Finding{}
+	mi := &file_findingspb_findings_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Finding) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Finding) ProtoMessage() {}
+
+func (x *Finding) ProtoReflect() protoreflect.Message {
+	mi := &file_findingspb_findings_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Finding.ProtoReflect.Descriptor instead.
+func (*Finding) Descriptor() ([]byte, []int) {
+	return file_findingspb_findings_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Finding) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *Finding) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *Finding) GetWorkload() string {
+	if x != nil {
+		return x.Workload
+	}
+	return ""
+}
+
+func (x *Finding) GetImage() string {
+	if x != nil {
+		return x.Image
+	}
+	return ""
+}
+
+func (x *Finding) GetDigest() string {
+	if x != nil {
+		return x.Digest
+	}
+	return ""
+}
+
+func (x *Finding) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Finding) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Finding) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *Finding) GetFixable() bool {
+	if x != nil {
+		return x.Fixable
+	}
+	return false
+}
+
+func (x *Finding) GetFirstSeen() string {
+	if x != nil {
+		return x.FirstSeen
+	}
+	return ""
+}
+
+func (x *Finding) GetCollectedAt() string {
+	if x != nil {
+		return x.CollectedAt
+	}
+	return ""
+}
+
+type FindingEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type FindingEvent_Type `protobuf:"varint,1,opt,name=type,proto3,enum=trivyexporter.findings.v1.FindingEvent_Type" json:"type,omitempty"`
+	// Increases by one per event of the subscription, including dropped events. A GAP event
+	// carries the sequence of the last event it stands for.
+	Sequence uint64   `protobuf:"varint,2,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	CycleId  string   `protobuf:"bytes,3,opt,name=cycle_id,json=cycleId,proto3" json:"cycle_id,omitempty"`
+	Finding  *Finding `protobuf:"bytes,4,opt,name=finding,proto3" json:"finding,omitempty"`
+	// Number of events dropped before this GAP event
+	Dropped uint64 `protobuf:"varint,5,opt,name=dropped,proto3" json:"dropped,omitempty"`
+}
+
+func (x *FindingEvent) Reset() {
+	*x = FindingEvent{}
+	mi := &file_findingspb_findings_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FindingEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindingEvent) ProtoMessage() {}
+
+func (x *FindingEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_findingspb_findings_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindingEvent.ProtoReflect.Descriptor instead.
+func (*FindingEvent) Descriptor() ([]byte, []int) {
+	return file_findingspb_findings_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *FindingEvent) GetType() FindingEvent_Type {
+	if x != nil {
+		return x.Type
+	}
+	return FindingEvent_TYPE_UNSPECIFIED
+}
+
+func (x *FindingEvent) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *FindingEvent) GetCycleId() string {
+	if x != nil {
+		return x.CycleId
+	}
+	return ""
+}
+
+func (x *FindingEvent) GetFinding() *Finding {
+	if x != nil {
+		return x.Finding
+	}
+	return nil
+}
+
+func (x *FindingEvent) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+type GetSummaryRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *GetSummaryRequest) Reset() {
+	*x = GetSummaryRequest{}
+	mi := &file_findingspb_findings_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSummaryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSummaryRequest) ProtoMessage() {}
+
+func (x *GetSummaryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_findingspb_findings_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSummaryRequest.ProtoReflect.Descriptor instead.
+func (*GetSummaryRequest) Descriptor() ([]byte, []int) {
+	return file_findingspb_findings_proto_rawDescGZIP(), []int{3}
+}
+
+type Summary struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Cluster string `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	CycleId string `protobuf:"bytes,2,opt,name=cycle_id,json=cycleId,proto3" json:"cycle_id,omitempty"`
+	// index.json of the latest cycle
+	IndexJson []byte `protobuf:"bytes,3,opt,name=index_json,json=indexJson,proto3" json:"index_json,omitempty"`
+}
+
+func (x *Summary) Reset() {
+	*x = Summary{}
+	mi := &file_findingspb_findings_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Summary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Summary) ProtoMessage() {}
+
+func (x *Summary) ProtoReflect() protoreflect.Message {
+	mi := &file_findingspb_findings_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Summary.ProtoReflect.Descriptor instead.
+func (*Summary) Descriptor() ([]byte, []int) {
+	return file_findingspb_findings_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Summary) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *Summary) GetCycleId() string {
+	if x != nil {
+		return x.CycleId
+	}
+	return ""
+}
+
+func (x *Summary) GetIndexJson() []byte {
+	if x != nil {
+		return x.IndexJson
+	}
+	return nil
+}
+
+var File_findingspb_findings_proto protoreflect.FileDescriptor
+
+var file_findingspb_findings_proto_rawDesc = []byte{
+	0x0a, 0x19, 0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x70, 0x62, 0x2f, 0x66, 0x69, 0x6e,
+	0x64, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x19, 0x74, 0x72, 0x69,
+	0x76, 0x79, 0x65, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x72, 0x2e, 0x66, 0x69, 0x6e, 0x64, 0x69,
+	0x6e, 0x67, 0x73, 0x2e, 0x76, 0x31, 0x22, 0x71, 0x0a, 0x10, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72,
+	0x69, 0x62, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x6c,
+	0x75, 0x73, 0x74, 0x65, 0x72, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x63, 0x6c,
+	0x75, 0x73, 0x74, 0x65, 0x72, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70,
+	0x61, 0x63, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x6e, 0x61, 0x6d, 0x65,
+	0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x6d, 0x69, 0x6e, 0x5f, 0x73, 0x65,
+	0x76, 0x65, 0x72, 0x69, 0x74, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x6d, 0x69,
+	0x6e, 0x53, 0x65, 0x76, 0x65, 0x72, 0x69, 0x74, 0x79, 0x22, 0xa7, 0x02, 0x0a, 0x07, 0x46, 0x69,
+	0x6e, 0x64, 0x69, 0x6e, 0x67, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x12,
+	0x1c, 0x0a, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x12, 0x1a, 0x0a,
+	0x08, 0x77, 0x6f, 0x72, 0x6b, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x77, 0x6f, 0x72, 0x6b, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6d, 0x61,
+	0x67, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x12,
+	0x16, 0x0a, 0x06, 0x64, 0x69, 0x67, 0x65, 0x73, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x06, 0x64, 0x69, 0x67, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x12, 0x0e, 0x0a, 0x02, 0x69,
+	0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x73,
+	0x65, 0x76, 0x65, 0x72, 0x69, 0x74, 0x79, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73,
+	0x65, 0x76, 0x65, 0x72, 0x69, 0x74, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x66, 0x69, 0x78, 0x61, 0x62,
+	0x6c, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x66, 0x69, 0x78, 0x61, 0x62, 0x6c,
+	0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x66, 0x69, 0x72, 0x73, 0x74, 0x5f, 0x73, 0x65, 0x65, 0x6e, 0x18,
+	0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x66, 0x69, 0x72, 0x73, 0x74, 0x53, 0x65, 0x65, 0x6e,
+	0x12, 0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74,
+	0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x65,
+	0x64, 0x41, 0x74, 0x22, 0xbf, 0x02, 0x0a, 0x0c, 0x46, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x12, 0x40, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0e, 0x32, 0x2c, 0x2e, 0x74, 0x72, 0x69, 0x76, 0x79, 0x65, 0x78, 0x70, 0x6f, 0x72, 0x74,
+	0x65, 0x72, 0x2e, 0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x46,
+	0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x54, 0x79, 0x70, 0x65,
+	0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e,
+	0x63, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e,
+	0x63, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x63, 0x79, 0x63, 0x6c, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x79, 0x63, 0x6c, 0x65, 0x49, 0x64, 0x12, 0x3c, 0x0a,
+	0x07, 0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x22,
+	0x2e, 0x74, 0x72, 0x69, 0x76, 0x79, 0x65, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x72, 0x2e, 0x66,
+	0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x69, 0x6e, 0x64, 0x69,
+	0x6e, 0x67, 0x52, 0x07, 0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x12, 0x18, 0x0a, 0x07, 0x64,
+	0x72, 0x6f, 0x70, 0x70, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x64, 0x72,
+	0x6f, 0x70, 0x70, 0x65, 0x64, 0x22, 0x5e, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x14, 0x0a,
+	0x10, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45,
+	0x44, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x53, 0x4e, 0x41, 0x50, 0x53, 0x48, 0x4f, 0x54, 0x10,
+	0x01, 0x12, 0x10, 0x0a, 0x0c, 0x53, 0x4e, 0x41, 0x50, 0x53, 0x48, 0x4f, 0x54, 0x5f, 0x45, 0x4e,
+	0x44, 0x10, 0x02, 0x12, 0x09, 0x0a, 0x05, 0x41, 0x44, 0x44, 0x45, 0x44, 0x10, 0x03, 0x12, 0x0c,
+	0x0a, 0x08, 0x52, 0x45, 0x53, 0x4f, 0x4c, 0x56, 0x45, 0x44, 0x10, 0x04, 0x12, 0x07, 0x0a, 0x03,
+	0x47, 0x41, 0x50, 0x10, 0x05, 0x22, 0x13, 0x0a, 0x11, 0x47, 0x65, 0x74, 0x53, 0x75, 0x6d, 0x6d,
+	0x61, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x5d, 0x0a, 0x07, 0x53, 0x75,
+	0x6d, 0x6d, 0x61, 0x72, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x12,
+	0x19, 0x0a, 0x08, 0x63, 0x79, 0x63, 0x6c, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x07, 0x63, 0x79, 0x63, 0x6c, 0x65, 0x49, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x69, 0x6e,
+	0x64, 0x65, 0x78, 0x5f, 0x6a, 0x73, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09,
+	0x69, 0x6e, 0x64, 0x65, 0x78, 0x4a, 0x73, 0x6f, 0x6e, 0x32, 0xcf, 0x01, 0x0a, 0x08, 0x46, 0x69,
+	0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x12, 0x63, 0x0a, 0x09, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72,
+	0x69, 0x62, 0x65, 0x12, 0x2b, 0x2e, 0x74, 0x72, 0x69, 0x76, 0x79, 0x65, 0x78, 0x70, 0x6f, 0x72,
+	0x74, 0x65, 0x72, 0x2e, 0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x76, 0x31, 0x2e,
+	0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x27, 0x2e, 0x74, 0x72, 0x69, 0x76, 0x79, 0x65, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x72,
+	0x2e, 0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x69, 0x6e,
+	0x64, 0x69, 0x6e, 0x67, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x30, 0x01, 0x12, 0x5e, 0x0a, 0x0a, 0x47,
+	0x65, 0x74, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x79, 0x12, 0x2c, 0x2e, 0x74, 0x72, 0x69, 0x76,
+	0x79, 0x65, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x72, 0x2e, 0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e,
+	0x67, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x79,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x22, 0x2e, 0x74, 0x72, 0x69, 0x76, 0x79, 0x65,
+	0x78, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x72, 0x2e, 0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73,
+	0x2e, 0x76, 0x31, 0x2e, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x79, 0x42, 0x1b, 0x5a, 0x19, 0x74,
+	0x72, 0x69, 0x76, 0x79, 0x2d, 0x65, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x72, 0x2f, 0x66, 0x69,
+	0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_findingspb_findings_proto_rawDescOnce sync.Once
+	file_findingspb_findings_proto_rawDescData = file_findingspb_findings_proto_rawDesc
+)
+
+func file_findingspb_findings_proto_rawDescGZIP() []byte {
+	file_findingspb_findings_proto_rawDescOnce.Do(func() {
+		file_findingspb_findings_proto_rawDescData = protoimpl.X.CompressGZIP(file_findingspb_findings_proto_rawDescData)
+	})
+	return file_findingspb_findings_proto_rawDescData
+}
+
+var file_findingspb_findings_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_findingspb_findings_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_findingspb_findings_proto_goTypes = []any{
+	(FindingEvent_Type)(0),    // 0: trivyexporter.findings.v1.FindingEvent.Type
+	(*SubscribeRequest)(nil),  // 1: trivyexporter.findings.v1.SubscribeRequest
+	(*Finding)(nil),           // 2: trivyexporter.findings.v1.Finding
+	(*FindingEvent)(nil),      // 3: trivyexporter.findings.v1.FindingEvent
+	(*GetSummaryRequest)(nil), // 4: trivyexporter.findings.v1.GetSummaryRequest
+	(*Summary)(nil),           // 5: trivyexporter.findings.v1.Summary
+}
+var file_findingspb_findings_proto_depIdxs = []int32{
+	0, // 0: trivyexporter.findings.v1.FindingEvent.type:type_name -> trivyexporter.findings.v1.FindingEvent.Type
+	2, // 1: trivyexporter.findings.v1.FindingEvent.finding:type_name -> trivyexporter.findings.v1.Finding
+	1, // 2: trivyexporter.findings.v1.Findings.Subscribe:input_type -> trivyexporter.findings.v1.SubscribeRequest
+	4, // 3: trivyexporter.findings.v1.Findings.GetSummary:input_type -> trivyexporter.findings.v1.GetSummaryRequest
+	3, // 4: trivyexporter.findings.v1.Findings.Subscribe:output_type -> trivyexporter.findings.v1.FindingEvent
+	5, // 5: trivyexporter.findings.v1.Findings.GetSummary:output_type -> trivyexporter.findings.v1.Summary
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_findingspb_findings_proto_init() }
+func file_findingspb_findings_proto_init() {
+	if File_findingspb_findings_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_findingspb_findings_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_findingspb_findings_proto_goTypes,
+		DependencyIndexes: file_findingspb_findings_proto_depIdxs,
+		EnumInfos:         file_findingspb_findings_proto_enumTypes,
+		MessageInfos:      file_findingspb_findings_proto_msgTypes,
+	}.Build()
+	File_findingspb_findings_proto = out.File
+	file_findingspb_findings_proto_rawDesc = nil
+	file_findingspb_findings_proto_goTypes = nil
+	file_findingspb_findings_proto_depIdxs = nil
+}
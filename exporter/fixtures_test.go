@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var (
+	updateGolden   = flag.Bool("update", false, "rewrite the golden files of the fixture tests")
+	fixtureVersion = flag.String("fixture-version", os.Getenv("FIXTURE_VERSION"), "only run the fixtures of this trivy-operator version, e.g. v0.24")
+)
+
+// Collection settings the fixtures run with; MaxItemBytes is low enough for the larger
+// fixture items to be trimmed
+var fixtureConfig = Config{
+	ClusterName:         "fixtures",
+	ReconcileSummaries:  true,
+	NormalizeTimestamps: true,
+	StrictEncoding:      encodingSanitize,
+	MaxItemBytes:        2048,
+}
+
+const fixtureCollectedAt = "2025-01-01T00:00:00Z"
+
+// fixtureItem is the golden record of one fixture item after the collection pipeline
+type fixtureItem struct {
+	Kind                 string                 `json:"kind"`
+	Namespace            string                 `json:"namespace,omitempty"`
+	Name                 string                 `json:"name"`
+	Shape                string                 `json:"shape,omitempty"`
+	SanitizedFields      int                    `json:"sanitizedFields,omitempty"`
+	TimestampParseErrors int                    `json:"timestampParseErrors,omitempty"`
+	Discrepancy          *SummaryDiscrepancy    `json:"discrepancy,omitempty"`
+	Summary              map[string]interface{} `json:"summary,omitempty"`
+	Timestamps           map[string]interface{} `json:"timestamps,omitempty"`
+	Truncated            bool                   `json:"truncated,omitempty"`
+	Findings             []Finding              `json:"findings"`
+}
+
+// runFixturePipeline applies the per-item steps of collectResourcePaged to the items of a
+// fixture directory, in the same order
+func runFixturePipeline(t *testing.T, dir string) []fixtureItem {
+	t.Helper()
+	objects, err := loadDump(dir, false)
+	if err != nil {
+		t.Fatalf("loading %s: %v", dir, err)
+	}
+
+	cfg := fixtureConfig
+	var out []fixtureItem
+	for _, obj := range objects {
+		kind := obj.GetKind()
+		item := fixtureItem{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+
+		item.SanitizedFields = sanitizeStrings(obj.Object, cfg.StrictEncoding != encodingFail)
+		item.Discrepancy = reconcileSummary(obj.Object, kind, cfg.ReconcileSummaries)
+		if cfg.NormalizeTimestamps {
+			item.TimestampParseErrors = normalizeTimestamps(obj.Object)
+		}
+		item.Shape = reportShape(kind, obj.Object)
+		item.Findings = flattenFindings(cfg.ClusterName, kind, fixtureCollectedAt, obj.Object)
+		if item.Findings == nil {
+			item.Findings = []Finding{}
+		}
+
+		item.Summary, _, _ = unstructured.NestedMap(obj.Object, "report", "summary")
+		item.Timestamps = make(map[string]interface{})
+		if v, found, _ := unstructured.NestedFieldNoCopy(obj.Object, "report", "updateTimestamp"); found {
+			item.Timestamps["updateTimestamp"] = v
+		}
+		for _, e := range nestedSlice(obj.Object, "report", "vulnerabilities") {
+			if v, ok := e.(map[string]interface{}); ok {
+				id, _ := v["vulnerabilityID"].(string)
+				item.Timestamps[id] = v["publishedDate"]
+			}
+		}
+		if len(item.Timestamps) == 0 {
+			item.Timestamps = nil
+		}
+
+		encoded, err := json.Marshal(obj.Object)
+		if err != nil {
+			t.Fatalf("encoding %s/%s: %v", item.Namespace, item.Name, err)
+		}
+		item.Truncated = cfg.MaxItemBytes > 0 && len(encoded) > cfg.MaxItemBytes
+		out = append(out, item)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return out
+}
+
+// TestFixtures runs every testdata/fixtures/<version> set through the pipeline and compares
+// the result with testdata/golden/<version>.json. Run with -update to rewrite the golden
+// files and FIXTURE_VERSION=<version> (or -fixture-version) to run a single version.
+func TestFixtures(t *testing.T) {
+	entries, err := os.ReadDir(filepath.Join("testdata", "fixtures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran := 0
+	for _, e := range entries {
+		version := e.Name()
+		if !e.IsDir() || (*fixtureVersion != "" && version != *fixtureVersion) {
+			continue
+		}
+		ran++
+		t.Run(version, func(t *testing.T) {
+			items := runFixturePipeline(t, filepath.Join("testdata", "fixtures", version))
+			got, err := json.MarshalIndent(items, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", "golden", version+".json")
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("pipeline output of %s differs from %s (run with -update after checking the change):\n%s", version, golden, got)
+			}
+		})
+	}
+	if *fixtureVersion != "" && ran == 0 {
+		t.Fatalf("no fixtures for FIXTURE_VERSION=%s", *fixtureVersion)
+	}
+}
+
+func TestReportShape(t *testing.T) {
+	tests := []struct {
+		name string
+		kind string
+		obj  map[string]interface{}
+		want string
+	}{
+		{
+			name: "vulnerability report with digest",
+			kind: "VulnerabilityReport",
+			obj:  map[string]interface{}{"report": map[string]interface{}{"artifact": map[string]interface{}{"repository": "nginx", "tag": "1.25", "digest": "sha256:abc"}}},
+			want: shapeArtifactDigest,
+		},
+		{
+			name: "vulnerability report with tag only",
+			kind: "ClusterVulnerabilityReport",
+			obj:  map[string]interface{}{"report": map[string]interface{}{"artifact": map[string]interface{}{"repository": "nginx", "tag": "1.25"}}},
+			want: shapeArtifactTag,
+		},
+		{
+			name: "audit report with passed checks",
+			kind: "ConfigAuditReport",
+			obj: map[string]interface{}{"report": map[string]interface{}{"checks": []interface{}{
+				map[string]interface{}{"checkID": "KSV001", "success": false},
+				map[string]interface{}{"checkID": "KSV011", "success": true},
+			}}},
+			want: shapeChecksAll,
+		},
+		{
+			name: "audit report with failed checks only",
+			kind: "RbacAssessmentReport",
+			obj: map[string]interface{}{"report": map[string]interface{}{"checks": []interface{}{
+				map[string]interface{}{"checkID": "KSV041", "success": false},
+			}}},
+			want: shapeChecksFailed,
+		},
+		{
+			name: "audit report without success flags",
+			kind: "ClusterRbacAssessmentReport",
+			obj: map[string]interface{}{"report": map[string]interface{}{"checks": []interface{}{
+				map[string]interface{}{"checkID": "KSV041"},
+			}}},
+			want: shapeChecksFailed,
+		},
+		{
+			name: "secret report",
+			kind: "ExposedSecretReport",
+			obj:  map[string]interface{}{"report": map[string]interface{}{"secrets": []interface{}{}}},
+			want: "",
+		},
+		{
+			name: "kind without findings",
+			kind: "ClusterComplianceReport",
+			obj:  map[string]interface{}{},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reportShape(tt.kind, tt.obj); got != tt.want {
+				t.Errorf("reportShape() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
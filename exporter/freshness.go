@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ReportFreshness describes how old the data of one report type is once it reaches the outputs
+type ReportFreshness struct {
+	NewestUpdate string `json:"newestUpdate,omitempty"`
+	OldestUpdate string `json:"oldestUpdate,omitempty"`
+	CollectedAt  string `json:"collectedAt"`
+	UploadedAt   string `json:"uploadedAt,omitempty"`
+	// Age of the oldest scan result at upload time; omitted when no report carries a timestamp
+	AgeSeconds *int64 `json:"ageSeconds,omitempty"`
+	SLOPass    bool   `json:"sloPass"`
+}
+
+// Freshness is published as freshness.json
+type Freshness struct {
+	Cluster     string                     `json:"cluster"`
+	GeneratedAt string                     `json:"generatedAt"`
+	SLO         string                     `json:"slo"`
+	SLOPass     bool                       `json:"sloPass"`
+	WorstAge    *int64                     `json:"worstAgeSeconds,omitempty"`
+	WorstType   string                     `json:"worstType,omitempty"`
+	ReportTypes map[string]ReportFreshness `json:"reportTypes"`
+}
+
+// reportUpdateTimestamp returns the operator's scan time of a report item. Most reports keep
+// it in report.updateTimestamp, ClusterComplianceReports in status.updateTimestamp.
+func reportUpdateTimestamp(obj map[string]interface{}) (time.Time, bool) {
+	for _, path := range [][]string{{"report", "updateTimestamp"}, {"status", "updateTimestamp"}} {
+		v, found, err := unstructured.NestedString(obj, path...)
+		if !found || err != nil || v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// observeUpdateTimestamp widens the newest/oldest scan time range of a resource. Items
+// flagged by normalizeTimestamps are of unknown age and do not count.
+func (s *ResourceStats) observeUpdateTimestamp(obj map[string]interface{}) {
+	if hasTimestampParseError(obj) {
+		return
+	}
+	t, ok := reportUpdateTimestamp(obj)
+	if !ok {
+		return
+	}
+	if s.newestUpdate.IsZero() || t.After(s.newestUpdate) {
+		s.newestUpdate = t
+	}
+	if s.oldestUpdate.IsZero() || t.Before(s.oldestUpdate) {
+		s.oldestUpdate = t
+	}
+}
+
+// buildFreshness combines operator scan times with the exporter's collection and upload
+// times into the per-cluster freshness document
+func buildFreshness(cluster string, slo time.Duration, resourceStats map[string]ResourceStats) Freshness {
+	f := Freshness{
+		Cluster:     cluster,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		SLO:         slo.String(),
+		SLOPass:     true,
+		ReportTypes: make(map[string]ReportFreshness),
+	}
+
+	names := make([]string, 0, len(resourceStats))
+	for name := range resourceStats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		stats := resourceStats[name]
+		rf := ReportFreshness{
+			CollectedAt: stats.collectedAt.UTC().Format(time.RFC3339),
+			SLOPass:     true,
+		}
+		if !stats.uploadedAt.IsZero() {
+			rf.UploadedAt = stats.uploadedAt.UTC().Format(time.RFC3339)
+		}
+		if !stats.oldestUpdate.IsZero() {
+			rf.NewestUpdate = stats.newestUpdate.UTC().Format(time.RFC3339)
+			rf.OldestUpdate = stats.oldestUpdate.UTC().Format(time.RFC3339)
+
+			uploaded := stats.uploadedAt
+			if uploaded.IsZero() {
+				uploaded = time.Now()
+			}
+			age := int64(uploaded.Sub(stats.oldestUpdate).Seconds())
+			rf.AgeSeconds = &age
+			rf.SLOPass = uploaded.Sub(stats.oldestUpdate) <= slo
+
+			if f.WorstAge == nil || age > *f.WorstAge {
+				worst := age
+				f.WorstAge = &worst
+				f.WorstType = name
+			}
+		}
+		if !rf.SLOPass {
+			f.SLOPass = false
+		}
+		f.ReportTypes[name] = rf
+	}
+	return f
+}
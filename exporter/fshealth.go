@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Name of the file touched by the FS_OUTPUT_DIR probe
+const fsProbeName = ".probe"
+
+// Reasons an output sink is unhealthy or an artifact write failed
+const (
+	sinkReasonReadOnly = "read-only"
+	sinkReasonNoSpace  = "no-space"
+	sinkReasonError    = "error"
+)
+
+// SinkHealth is the state of an output sink, published in index.json so a frozen output is
+// visible from the sinks that still work
+type SinkHealth struct {
+	Healthy   bool   `json:"healthy"`
+	Reason    string `json:"reason,omitempty"`
+	Since     string `json:"since,omitempty"` // Last health transition
+	LastError string `json:"lastError,omitempty"`
+	// Artifacts of the cycle the sink failed to write, with the failure reason
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+// sinkFailureReason classifies a write error
+func sinkFailureReason(err error) string {
+	switch {
+	case errors.Is(err, syscall.EROFS):
+		return sinkReasonReadOnly
+	case errors.Is(err, syscall.ENOSPC):
+		return sinkReasonNoSpace
+	default:
+		return sinkReasonError
+	}
+}
+
+// fsHealth tracks FS_OUTPUT_DIR across artifact writes and probes
+var fsHealth = &sinkHealthTracker{sink: "fs", name: "FS_OUTPUT_DIR", health: SinkHealth{Healthy: true}}
+
+type sinkHealthTracker struct {
+	mu     sync.Mutex
+	sink   string
+	name   string
+	health SinkHealth
+}
+
+// observe updates the health from the outcome of a write, logging transitions and
+// publishing SinkUnhealthy when the sink starts failing
+func (t *sinkHealthTracker) observe(err error) {
+	t.mu.Lock()
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err == nil {
+		if !t.health.Healthy {
+			log.Printf("✅ %s recovered after being unhealthy (%s) since %s", t.name, t.health.Reason, t.health.Since)
+			t.health.Healthy = true
+			t.health.Reason = ""
+			t.health.Since = now
+		}
+		t.mu.Unlock()
+		return
+	}
+
+	reason := sinkFailureReason(err)
+	t.health.LastError = err.Error()
+	transition := t.health.Healthy || t.health.Reason != reason
+	if transition {
+		log.Printf("🚨 %s is unhealthy (%s): %v", t.name, reason, err)
+		t.health.Healthy = false
+		t.health.Reason = reason
+		t.health.Since = now
+	}
+	t.mu.Unlock()
+
+	if transition {
+		events.publish(context.Background(), SinkUnhealthy{Sink: t.sink, Reason: reason, Err: err})
+	}
+}
+
+// fail records a failed artifact write
+func (t *sinkHealthTracker) fail(artifact string, err error) {
+	t.observe(err)
+	t.mu.Lock()
+	if t.health.Failures == nil {
+		t.health.Failures = make(map[string]string)
+	}
+	t.health.Failures[artifact] = sinkFailureReason(err)
+	t.mu.Unlock()
+}
+
+// snapshot returns the health for the index and starts a new failure list
+func (t *sinkHealthTracker) snapshot() SinkHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	health := t.health
+	t.health.Failures = nil
+	return health
+}
+
+// sinkHealthReport returns the health of the sinks tracked for the index
+func sinkHealthReport(cfg Config) map[string]SinkHealth {
+	if cfg.FSOutputDir == "" {
+		return nil
+	}
+	return map[string]SinkHealth{"fs": fsHealth.snapshot()}
+}
+
+// mergeSinkHealth combines the health both scopes of a split deployment reported for the
+// same sink: the sink is unhealthy if either scope saw it fail, and the failed artifacts of
+// both are listed
+func mergeSinkHealth(own, other SinkHealth) SinkHealth {
+	merged := own
+	if own.Healthy && !other.Healthy {
+		merged = other
+	}
+	if merged.LastError == "" {
+		merged.LastError = other.LastError
+	}
+	merged.Failures = nil
+	for _, failures := range []map[string]string{own.Failures, other.Failures} {
+		for artifact, reason := range failures {
+			if merged.Failures == nil {
+				merged.Failures = make(map[string]string)
+			}
+			merged.Failures[artifact] = reason
+		}
+	}
+	return merged
+}
+
+// probeFSOutput writes the probe file, which fails like artifact writes on a read-only or
+// full volume
+func probeFSOutput(dir string) error {
+	path := filepath.Join(dir, fsProbeName)
+	if err := os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// runFSProbe probes FS_OUTPUT_DIR between cycles so a recovery (or a failure) is detected
+// without waiting for the next write
+func runFSProbe(ctx context.Context, cfg Config) {
+	ticker := time.NewTicker(cfg.FSProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fsHealth.observe(probeFSOutput(cfg.FSOutputDir))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package fslock implements the advisory locks on <cluster>.lock in FS_OUTPUT_DIR that the
+// exporter takes with FS_LOCKING=true while it writes the files of a cluster. Readers of
+// the shared volume take a shared lock to never read a file while it is being replaced:
+//
+//	lock, err := fslock.Shared(filepath.Join(dir, cluster+".lock"), 5*time.Second)
+//	if err == nil {
+//		defer lock.Unlock()
+//	}
+//
+// A reader that cannot get the lock may still read; files are always replaced atomically.
+package fslock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrTimeout is returned when the lock is still held by others after the timeout
+var ErrTimeout = errors.New("timed out waiting for the lock")
+
+// ErrUnsupported is returned on volumes without flock, such as some NFS mounts
+var ErrUnsupported = errors.New("file locks are not supported")
+
+// How often a contended lock is retried
+const pollInterval = 50 * time.Millisecond
+
+// Lock is a held lock
+type Lock struct {
+	f *os.File
+}
+
+// Exclusive locks path for a writer, creating the file. A timeout of 0 waits forever.
+func Exclusive(path string, timeout time.Duration) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock %s: %w", path, err)
+	}
+	return lock(f, syscall.LOCK_EX, timeout)
+}
+
+// Shared locks path for a reader. It fails with os.ErrNotExist when no writer created the
+// lock file yet. A timeout of 0 waits forever.
+func Shared(path string, timeout time.Duration) (*Lock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock %s: %w", path, err)
+	}
+	return lock(f, syscall.LOCK_SH, timeout)
+}
+
+func lock(f *os.File, how int, timeout time.Duration) (*Lock, error) {
+	if timeout == 0 {
+		if err := syscall.Flock(int(f.Fd()), how); err != nil {
+			f.Close()
+			return nil, flockError(f.Name(), err)
+		}
+		return &Lock{f: f}, nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return &Lock{f: f}, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, flockError(f.Name(), err)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w after %v", f.Name(), ErrTimeout, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func flockError(path string, err error) error {
+	if errors.Is(err, syscall.ENOLCK) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EINVAL) {
+		return fmt.Errorf("%s: %w: %v", path, ErrUnsupported, err)
+	}
+	return fmt.Errorf("failed to lock %s: %w", path, err)
+}
+
+// Unlock releases the lock
+func (l *Lock) Unlock() error {
+	defer l.f.Close()
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// fileOptions controls how writeFile creates files and directories
+type fileOptions struct {
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	fsync    bool
+	uid, gid int // FS_CHOWN, -1 keeps the owner or group of the exporter
+}
+
+// defaultFileOptions are used by the git checkout and without FS_ settings
+var defaultFileOptions = fileOptions{fileMode: 0644, dirMode: 0755, fsync: true, uid: -1, gid: -1}
+
+// parseFileMode parses FS_FILE_MODE or FS_DIR_MODE, an octal permission such as 0640
+func parseFileMode(name, s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid %s %q (octal permissions, e.g. 0640)", name, s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// parseChown parses FS_CHOWN, uid:gid where either side may be empty to keep it
+func parseChown(s string) (int, int, error) {
+	if s == "" {
+		return -1, -1, nil
+	}
+	uidStr, gidStr, ok := strings.Cut(s, ":")
+	if !ok || (uidStr == "" && gidStr == "") {
+		return 0, 0, fmt.Errorf("invalid FS_CHOWN %q (uid:gid, e.g. 1000:2000 or :2000)", s)
+	}
+	ids := []int{-1, -1}
+	for i, part := range []string{uidStr, gidStr} {
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil || id < 0 {
+			return 0, 0, fmt.Errorf("invalid FS_CHOWN %q (uid:gid, e.g. 1000:2000 or :2000)", s)
+		}
+		ids[i] = id
+	}
+	return ids[0], ids[1], nil
+}
+
+// validateFileOptions checks FS_FILE_MODE, FS_DIR_MODE and FS_CHOWN
+func validateFileOptions(cfg Config) error {
+	fileMode, err := parseFileMode("FS_FILE_MODE", cfg.FSFileMode)
+	if err != nil {
+		return err
+	}
+	// The exporter reads its previous index back from FS_OUTPUT_DIR
+	if fileMode&0400 == 0 {
+		return fmt.Errorf("invalid FS_FILE_MODE %s: the owner must be able to read the files", cfg.FSFileMode)
+	}
+	dirMode, err := parseFileMode("FS_DIR_MODE", cfg.FSDirMode)
+	if err != nil {
+		return err
+	}
+	if dirMode&0700 != 0700 {
+		return fmt.Errorf("invalid FS_DIR_MODE %s: the owner must be able to create files in the directories", cfg.FSDirMode)
+	}
+	if _, _, err := parseChown(cfg.FSChown); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fsFileOptions returns the options of FS_OUTPUT_DIR; the settings are validated by loadConfig
+func fsFileOptions(cfg Config) fileOptions {
+	opts := defaultFileOptions
+	if mode, err := parseFileMode("FS_FILE_MODE", cfg.FSFileMode); err == nil {
+		opts.fileMode = mode
+	}
+	if mode, err := parseFileMode("FS_DIR_MODE", cfg.FSDirMode); err == nil {
+		opts.dirMode = mode
+	}
+	opts.fsync = cfg.FSFsync
+	opts.uid, opts.gid, _ = parseChown(cfg.FSChown)
+	return opts
+}
+
+// chownUnsupported is logged once, so a volume without ownership support does not flood
+// the logs with a warning per file
+var chownUnsupported sync.Once
+
+// chown applies FS_CHOWN to a path. Volumes that do not support ownership changes, and
+// exporters not running as root, only get a warning.
+func (o fileOptions) chown(path string) error {
+	if o.uid < 0 && o.gid < 0 {
+		return nil
+	}
+	err := os.Lchown(path, o.uid, o.gid)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSYS) {
+		chownUnsupported.Do(func() {
+			log.Printf("⚠️ FS_CHOWN cannot be applied, keeping the exporter's ownership: %v", err)
+		})
+		return nil
+	}
+	return fmt.Errorf("failed to apply FS_CHOWN: %w", err)
+}
+
+// mkdirAll creates dir and its missing parents with the directory mode and owner
+func (o fileOptions) mkdirAll(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := o.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, o.dirMode); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	// Mkdir applies the umask
+	if err := os.Chmod(dir, o.dirMode); err != nil {
+		return err
+	}
+	return o.chown(dir)
+}
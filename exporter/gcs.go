@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// newGCSClient builds a GCS client from Application Default Credentials, which resolve to
+// the pod's workload identity on GKE
+func newGCSClient(ctx context.Context) (*storage.Client, error) {
+	return storage.NewClient(ctx)
+}
+
+// gcsSink writes to GCS_BUCKET under <GCS_PREFIX>/<cluster>/
+type gcsSink struct {
+	client *storage.Client
+	bucket string
+	prefix string
+}
+
+func (s *gcsSink) Name() string { return "gcs" }
+
+// Put streams into the bucket. The object only becomes visible once the writer is closed,
+// so a failed upload never replaces the previous version.
+func (s *gcsSink) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	object := s.prefix + key
+	w := s.client.Bucket(s.bucket).Object(object).NewWriter(ctx)
+	w.ContentType = contentTypeFor(key)
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to upload gs://%s/%s: %w", s.bucket, object, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to upload gs://%s/%s: %w", s.bucket, object, err)
+	}
+	return nil
+}
+
+func (s *gcsSink) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.client.Bucket(s.bucket).Object(s.prefix + key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (s *gcsSink) Delete(ctx context.Context, key string) error {
+	err := s.client.Bucket(s.bucket).Object(s.prefix + key).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Close releases the client's connections
+func (s *gcsSink) Close() error {
+	return s.client.Close()
+}
+
+// isRetryableGCSError classifies GCS API errors by status code like other HTTP errors
+func isRetryableGCSError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == 429 || apiErr.Code >= 500
+	}
+	return isRetryableHTTPError(err)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trivy-exporter/fslock"
+)
+
+// fsGenerations writes report files to FS_OUTPUT_DIR as timestamped generations behind the
+// stable name the dashboard reads, keeping the newest FS_GENERATIONS of them. The stable
+// name is a symlink to the newest generation, or a copy of it on volumes without symlinks.
+type fsGenerations struct {
+	keep     int
+	symlinks bool
+	lockPath string // Serializes rotation between exporters sharing the directory
+
+	cfg   Config
+	files generationFiles // Removes old generations through deleteArtifacts
+}
+
+// generationFiles is the sink deleteArtifacts removes generations from. Its keys are
+// relative to the root of the cluster's files, like those of fsSink.
+type generationFiles struct {
+	root string
+}
+
+func (generationFiles) Name() string { return "fs" }
+
+func (generationFiles) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	return errors.New("generations are written by fsGenerations.write")
+}
+
+func (generationFiles) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, errors.New("generations are read through the stable name")
+}
+
+func (f generationFiles) Delete(ctx context.Context, key string) error {
+	err := os.Remove(f.root + filepath.FromSlash(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// key maps the path of a generation to its key
+func (f generationFiles) key(path string) string {
+	return filepath.ToSlash(strings.TrimPrefix(path, f.root))
+}
+
+// deleteGenerations removes generations through deleteArtifacts, so DESTRUCTIVE_OPS and
+// FS_OUTPUT_DIR ownership apply and every removal is audited
+func (g *fsGenerations) deleteGenerations(generations []string) error {
+	reqs := make([]DeletionRequest, 0, len(generations))
+	for _, generation := range generations {
+		size := int64(-1)
+		if info, err := os.Lstat(generation); err == nil {
+			size = info.Size()
+		}
+		reqs = append(reqs, DeletionRequest{Key: g.files.key(generation), Size: size, Feature: "fs-generations", Reason: "rotated"})
+	}
+	_, _, err := deleteArtifacts(context.Background(), []Sink{g.files}, g.cfg, reqs)
+	return err
+}
+
+// newFSGenerations returns nil without FS_GENERATIONS. Symlink support is probed in the
+// directory of the cluster.
+func newFSGenerations(cfg Config) *fsGenerations {
+	if cfg.FSGenerations <= 0 {
+		return nil
+	}
+	g := &fsGenerations{
+		keep:     cfg.FSGenerations,
+		lockPath: filepath.Join(cfg.FSOutputDir, "."+cfg.ClusterName+".lock"),
+		cfg:      cfg,
+		files:    generationFiles{root: (&fsSink{dir: cfg.FSOutputDir, cluster: cfg.ClusterName, layout: cfg.FSLayout}).root()},
+	}
+	probe := filepath.Join(fsClusterDir(cfg), ".symlink-probe")
+	os.Remove(probe)
+	err := fsFileOptions(cfg).mkdirAll(fsClusterDir(cfg))
+	if err == nil {
+		err = os.Symlink(ownerMarkerName, probe)
+	}
+	if err != nil {
+		log.Printf("🔗 %s does not support symlinks (%v), copying the newest generation of each report file instead", cfg.FSOutputDir, err)
+	} else {
+		g.symlinks = true
+		os.Remove(probe)
+	}
+	return g
+}
+
+// generationPath names a generation of the file at path, e.g.
+// prod-vulnerability-reports-20240102-150405.json for prod-vulnerability-reports.json
+func generationPath(path, timestamp string) string {
+	i := strings.LastIndex(path, ".json")
+	return path[:i] + "-" + timestamp + path[i:]
+}
+
+// generationsOf returns the generations of the file at path, oldest first
+func generationsOf(path string) ([]string, error) {
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(path)
+	i := strings.LastIndex(base, ".json")
+	stem, ext := base[:i]+"-", base[i:]
+	var generations []string
+	for _, entry := range entries {
+		ts, ok := strings.CutPrefix(entry.Name(), stem)
+		if !ok {
+			continue
+		}
+		if ts, ok = strings.CutSuffix(ts, ext); !ok {
+			continue
+		}
+		if _, err := time.Parse(snapshotTimestampLayout, ts); err == nil {
+			generations = append(generations, filepath.Join(dir, entry.Name()))
+		}
+	}
+	// The timestamp layout sorts chronologically
+	sort.Strings(generations)
+	return generations, nil
+}
+
+// write publishes r as a new generation of the file at path, repoints the stable name to it
+// and removes the generations beyond the newest keep
+func (g *fsGenerations) write(path string, r io.Reader, opts fileOptions) error {
+	unlock, err := lockFile(g.lockPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	generation := generationPath(path, time.Now().UTC().Format(snapshotTimestampLayout))
+	// Under the lock an existing generation can only come from an exporter ignoring it
+	if _, err := os.Lstat(generation); err == nil {
+		return fmt.Errorf("generation %s already exists; is another exporter writing to the same directory?", generation)
+	}
+	if err := writeFile(generation, r, opts); err != nil {
+		return err
+	}
+	if err := g.repoint(path, generation, opts); err != nil {
+		return err
+	}
+
+	generations, err := generationsOf(path)
+	if err != nil {
+		return err
+	}
+	var rotated []string
+	for _, old := range generations[:max(len(generations)-g.keep, 0)] {
+		if old != generation {
+			rotated = append(rotated, old)
+		}
+	}
+	if len(rotated) > 0 {
+		if err := g.deleteGenerations(rotated); err != nil {
+			log.Printf("⚠️ Failed to remove old generations of %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// repoint atomically replaces the stable name with a symlink to generation or a copy of it
+func (g *fsGenerations) repoint(path, generation string, opts fileOptions) error {
+	if !g.symlinks {
+		f, err := os.Open(generation)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return writeFile(path, f, opts)
+	}
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+	if err := os.Symlink(filepath.Base(generation), tmpPath); err != nil {
+		return fmt.Errorf("failed to link %s: %w", path, err)
+	}
+	if err := opts.chown(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move link %s into place: %w", path, err)
+	}
+	if !opts.fsync {
+		return nil
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// remove deletes every generation of the file at path
+func (g *fsGenerations) remove(path string) error {
+	unlock, err := lockFile(g.lockPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	generations, err := generationsOf(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(generations) == 0 {
+		return nil
+	}
+	return g.deleteGenerations(generations)
+}
+
+// lockUnsupported is logged once for volumes without flock, such as some NFS mounts
+var lockUnsupported sync.Once
+
+// lockFile takes an exclusive flock on path, creating it, and returns the function that
+// releases it. Volumes without flock are used unlocked after a warning.
+func lockFile(path string) (func(), error) {
+	lock, err := fslock.Exclusive(path, 0)
+	if errors.Is(err, fslock.ErrUnsupported) {
+		lockUnsupported.Do(func() {
+			log.Printf("⚠️ %s does not support file locks, rotating generations unlocked: %v", filepath.Dir(path), err)
+		})
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() { lock.Unlock() }, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gitSink commits the report files of each cycle to a git repository for auditors. Files
+// are written into a checkout as they are published and committed and pushed once per
+// cycle by finishCycle. Cluster metadata such as index.json changes every cycle and is not
+// committed, so unchanged reports leave no history.
+type gitSink struct {
+	dir     string // checkout
+	path    string // report directory within the repository
+	branch  string
+	cluster string
+	cfg     Config
+}
+
+// newGitSink uses the checkout in GIT_CHECKOUT_DIR, cloning GIT_REPO_URL into it first when
+// it is not a repository yet
+func newGitSink(ctx context.Context, cfg Config) (*gitSink, error) {
+	s := &gitSink{
+		dir:     cfg.GitCheckoutDir,
+		path:    cfg.GitPath,
+		branch:  cfg.GitBranch,
+		cluster: cfg.ClusterName,
+		cfg:     cfg,
+	}
+	if cfg.GitSSHKeyFile != "" && cfg.GitSkipHostKey {
+		log.Printf("⚠️ GIT_INSECURE_SKIP_HOST_KEY_CHECK is set: the SSH host key of the git remote is not verified, so a spoofed remote can receive the reports")
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, ".git")); err == nil {
+		log.Printf("📂 Using git checkout %s", s.dir)
+		return s, nil
+	}
+	if cfg.GitRepoURL == "" {
+		return nil, fmt.Errorf("%s is not a git checkout and GIT_REPO_URL is not set", s.dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.dir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create checkout directory: %w", err)
+	}
+	if _, err := s.git(ctx, filepath.Dir(s.dir), "clone", "--branch", s.branch, "--single-branch", cfg.GitRepoURL, s.dir); err != nil {
+		return nil, err
+	}
+	log.Printf("📂 Cloned %s into %s", cfg.GitRepoURL, s.dir)
+	return s, nil
+}
+
+func (s *gitSink) Name() string { return "git" }
+
+func (s *gitSink) file(key string) string {
+	return filepath.Join(s.dir, s.path, s.cluster, key)
+}
+
+// Put writes report files into the checkout and ignores every other artifact
+func (s *gitSink) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if !isReportFile(key) {
+		return nil
+	}
+	return writeFile(s.file(key), r, defaultFileOptions)
+}
+
+func (s *gitSink) Get(ctx context.Context, key string) ([]byte, error) {
+	if !isReportFile(key) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(s.file(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Delete removes the file from the checkout; the removal is committed with the next cycle
+func (s *gitSink) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.file(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// finishCycle commits the cluster's report directory when its contents changed and pushes.
+// A rejected push is retried after rebasing onto the remote branch, which other clusters
+// push to as well; a commit that could not be pushed goes out with the next cycle.
+func (s *gitSink) finishCycle(ctx context.Context, cycleID string) error {
+	clusterPath := filepath.Join(s.path, s.cluster)
+	if _, err := s.git(ctx, s.dir, "add", "--all", "--", clusterPath); err != nil {
+		return err
+	}
+	if _, err := s.git(ctx, s.dir, "diff", "--cached", "--quiet", "--", clusterPath); err == nil {
+		log.Printf("📂 Reports of %s unchanged, nothing to commit", s.cluster)
+	} else {
+		message := fmt.Sprintf("Update %s Trivy reports (%s)", s.cluster, cycleID)
+		if _, err := s.git(ctx, s.dir, "commit", "--quiet", "-m", message); err != nil {
+			return err
+		}
+		log.Printf("📝 Committed %s", message)
+	}
+
+	// Also pushes commits left behind by earlier failed pushes
+	ahead, err := s.git(ctx, s.dir, "rev-list", "--count", "@{upstream}..HEAD")
+	if err != nil || strings.TrimSpace(ahead) == "0" {
+		return err
+	}
+	return s.cfg.retryPolicy("git").Do(ctx, "git", func() error {
+		if _, err := s.git(ctx, s.dir, "pull", "--rebase", "--quiet", "origin", s.branch); err != nil {
+			s.git(ctx, s.dir, "rebase", "--abort")
+			return err
+		}
+		if _, err := s.git(ctx, s.dir, "push", "--quiet", "origin", "HEAD:"+s.branch); err != nil {
+			return err
+		}
+		log.Printf("🚀 Pushed reports of %s to %s", s.cluster, s.branch)
+		return nil
+	})
+}
+
+// git runs a git command with the credentials of GIT_SSH_KEY_FILE or GIT_TOKEN_FILE
+func (s *gitSink) git(ctx context.Context, dir string, args ...string) (string, error) {
+	env, err := s.env()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// env returns the environment of git commands. The token is passed through the environment
+// so it never shows up in the process list, as an extra entry after any GIT_CONFIG_COUNT
+// entries of the pod. SSH host keys must be listed in GIT_KNOWN_HOSTS_FILE, or else in the
+// system known_hosts files; only GIT_INSECURE_SKIP_HOST_KEY_CHECK accepts unknown hosts.
+func (s *gitSink) env() ([]string, error) {
+	env := append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME="+s.cfg.GitAuthorName,
+		"GIT_AUTHOR_EMAIL="+s.cfg.GitAuthorEmail,
+		"GIT_COMMITTER_NAME="+s.cfg.GitAuthorName,
+		"GIT_COMMITTER_EMAIL="+s.cfg.GitAuthorEmail,
+	)
+
+	if s.cfg.GitSSHKeyFile != "" {
+		// GIT_SSH_COMMAND is run by a shell
+		sshCommand := fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes", shellQuote(s.cfg.GitSSHKeyFile))
+		switch {
+		case s.cfg.GitSkipHostKey:
+			sshCommand += " -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no"
+		case s.cfg.GitKnownHostsFile != "":
+			sshCommand += fmt.Sprintf(" -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes", shellQuote(s.cfg.GitKnownHostsFile))
+		default:
+			sshCommand += " -o StrictHostKeyChecking=yes"
+		}
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	if s.cfg.GitTokenFile != "" {
+		token, err := os.ReadFile(s.cfg.GitTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GIT_TOKEN_FILE: %w", err)
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + strings.TrimSpace(string(token))))
+		// Later entries of env win, so the count below replaces the pod's
+		n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+		if n < 0 {
+			n = 0
+		}
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+1),
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=http.extraHeader", n),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", n, credentials),
+		)
+	}
+	return env, nil
+}
+
+// shellQuote quotes s as a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"trivy-exporter/findingspb"
+)
+
+// findingsFeed fans the findings of every cycle out to gRPC subscribers, nil unless
+// GRPC_ADDR is set
+var findingsFeed *findingsBroker
+
+// findingsBroker keeps the findings of the latest cycle per report kind and turns each
+// cycle into added and resolved events for the subscribers
+type findingsBroker struct {
+	bufferSize int
+
+	mu          sync.Mutex
+	latest      map[string]map[string]Finding // kind -> finding key -> finding
+	pending     map[string]map[string]Finding // kinds collected by the running cycle
+	cluster     string
+	cycleID     string
+	index       []byte
+	subscribers map[*findingsSubscriber]struct{}
+}
+
+func newFindingsBroker(bufferSize int) *findingsBroker {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	return &findingsBroker{
+		bufferSize:  bufferSize,
+		latest:      make(map[string]map[string]Finding),
+		pending:     make(map[string]map[string]Finding),
+		subscribers: make(map[*findingsSubscriber]struct{}),
+	}
+}
+
+// findingKey identifies a finding across cycles
+func findingKey(f Finding) string {
+	return strings.Join([]string{f.Cluster, f.Namespace, f.Workload, f.Image, f.Kind, f.ID}, "|")
+}
+
+// begin starts collecting a report kind. Kinds that are not collected successfully keep
+// the findings of their last successful cycle.
+func (b *findingsBroker) begin(kind string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.pending[kind] = make(map[string]Finding)
+	b.mu.Unlock()
+}
+
+// collect adds findings of a kind to the running cycle
+func (b *findingsBroker) collect(kind string, findings []Finding) {
+	if b == nil || len(findings) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending, ok := b.pending[kind]
+	if !ok {
+		return
+	}
+	for _, f := range findings {
+		pending[findingKey(f)] = f
+	}
+}
+
+// discard drops the findings of a kind whose collection failed
+func (b *findingsBroker) discard(kind string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	delete(b.pending, kind)
+	b.mu.Unlock()
+}
+
+// publish completes the cycle: the findings of every kind it collected replace those of the
+// previous cycle, and subscribers are sent what was added and resolved
+func (b *findingsBroker) publish(index ClusterIndex) {
+	if b == nil {
+		return
+	}
+	indexJSON, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		log.Printf("⚠️ Failed to encode index for gRPC subscribers: %v", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var added, resolved []Finding
+	for kind, current := range b.pending {
+		previous := b.latest[kind]
+		for key, f := range current {
+			if _, ok := previous[key]; !ok {
+				added = append(added, f)
+			}
+		}
+		for key, f := range previous {
+			if _, ok := current[key]; !ok {
+				resolved = append(resolved, f)
+			}
+		}
+		b.latest[kind] = current
+	}
+	b.pending = make(map[string]map[string]Finding)
+	b.cluster = index.Cluster
+	b.cycleID = index.CycleID
+	if indexJSON != nil {
+		b.index = indexJSON
+	}
+
+	sortFindings(added)
+	sortFindings(resolved)
+	for s := range b.subscribers {
+		for _, f := range resolved {
+			s.push(findingspb.FindingEvent_RESOLVED, b.cycleID, f)
+		}
+		for _, f := range added {
+			s.push(findingspb.FindingEvent_ADDED, b.cycleID, f)
+		}
+	}
+	if len(added) > 0 || len(resolved) > 0 {
+		log.Printf("📡 Sent %d added and %d resolved findings to %d gRPC subscribers", len(added), len(resolved), len(b.subscribers))
+	}
+}
+
+// subscribe registers a subscriber and returns it with the snapshot of the latest cycle
+// it has to be sent first. Its sequence numbers start after the snapshot, so events of
+// later cycles buffered while the snapshot is sent follow it in order.
+func (b *findingsBroker) subscribe(filter findingsFilter) (*findingsSubscriber, []Finding, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var snapshot []Finding
+	for _, findings := range b.latest {
+		for _, f := range findings {
+			if filter.match(f) {
+				snapshot = append(snapshot, f)
+			}
+		}
+	}
+	sortFindings(snapshot)
+
+	s := &findingsSubscriber{
+		filter: filter,
+		max:    b.bufferSize,
+		seq:    uint64(len(snapshot)) + 1, // snapshot events and SNAPSHOT_END
+		notify: make(chan struct{}, 1),
+	}
+	b.subscribers[s] = struct{}{}
+	return s, snapshot, b.cycleID
+}
+
+func (b *findingsBroker) unsubscribe(s *findingsSubscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, s)
+	b.mu.Unlock()
+}
+
+// summary returns the index of the latest cycle, nil before the first cycle completed
+func (b *findingsBroker) summary() *findingspb.Summary {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.index == nil {
+		return nil
+	}
+	return &findingspb.Summary{Cluster: b.cluster, CycleId: b.cycleID, IndexJson: b.index}
+}
+
+func sortFindings(findings []Finding) {
+	sort.Slice(findings, func(i, j int) bool {
+		return findingKey(findings[i]) < findingKey(findings[j])
+	})
+}
+
+// findingsFilter holds the server-side filters of a subscription
+type findingsFilter struct {
+	clusters    map[string]bool
+	namespaces  map[string]bool
+	minSeverity string // empty: every severity
+}
+
+func newFindingsFilter(req *findingspb.SubscribeRequest) (findingsFilter, error) {
+	filter := findingsFilter{minSeverity: strings.ToUpper(req.GetMinSeverity())}
+	switch filter.minSeverity {
+	case "", "CRITICAL", "HIGH", "MEDIUM", "LOW", "UNKNOWN":
+	default:
+		return filter, fmt.Errorf("invalid min_severity %q (valid: CRITICAL, HIGH, MEDIUM, LOW, UNKNOWN)", req.GetMinSeverity())
+	}
+	if len(req.GetClusters()) > 0 {
+		filter.clusters = make(map[string]bool)
+		for _, c := range req.GetClusters() {
+			filter.clusters[c] = true
+		}
+	}
+	if len(req.GetNamespaces()) > 0 {
+		filter.namespaces = make(map[string]bool)
+		for _, ns := range req.GetNamespaces() {
+			filter.namespaces[ns] = true
+		}
+	}
+	return filter, nil
+}
+
+func (f findingsFilter) match(finding Finding) bool {
+	if f.clusters != nil && !f.clusters[finding.Cluster] {
+		return false
+	}
+	if f.namespaces != nil && !f.namespaces[finding.Namespace] {
+		return false
+	}
+	return f.minSeverity == "" || severityRank(finding.Severity) <= severityRank(f.minSeverity)
+}
+
+// findingsSubscriber buffers the events of one subscription. A consumer that cannot keep
+// up loses the oldest events; the next event it receives is a GAP marker counting them.
+type findingsSubscriber struct {
+	filter findingsFilter
+	notify chan struct{}
+
+	mu       sync.Mutex
+	events   []*findingspb.FindingEvent
+	max      int
+	seq      uint64 // sequence of the last event
+	dropped  uint64 // events dropped since the last drain
+	lastDrop uint64 // sequence of the last dropped event
+}
+
+func (s *findingsSubscriber) push(typ findingspb.FindingEvent_Type, cycleID string, f Finding) {
+	if !s.filter.match(f) {
+		return
+	}
+	s.mu.Lock()
+	s.seq++
+	if len(s.events) >= s.max {
+		s.dropped++
+		s.lastDrop = s.events[0].Sequence
+		s.events = s.events[1:]
+	}
+	s.events = append(s.events, &findingspb.FindingEvent{Type: typ, Sequence: s.seq, CycleId: cycleID, Finding: findingProto(f)})
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// drain returns the buffered events, led by a GAP marker when events were dropped
+func (s *findingsSubscriber) drain() []*findingspb.FindingEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := s.events
+	s.events = nil
+	if s.dropped > 0 {
+		gap := &findingspb.FindingEvent{Type: findingspb.FindingEvent_GAP, Sequence: s.lastDrop, Dropped: s.dropped}
+		events = append([]*findingspb.FindingEvent{gap}, events...)
+		s.dropped = 0
+	}
+	return events
+}
+
+func findingProto(f Finding) *findingspb.Finding {
+	return &findingspb.Finding{
+		Cluster:     f.Cluster,
+		Namespace:   f.Namespace,
+		Workload:    f.Workload,
+		Image:       f.Image,
+		Digest:      f.Digest,
+		Kind:        f.Kind,
+		Id:          f.ID,
+		Severity:    f.Severity,
+		Fixable:     f.Fixable,
+		FirstSeen:   f.FirstSeen,
+		CollectedAt: f.CollectedAt,
+	}
+}
+
+// findingsService implements the Findings gRPC service on top of the broker
+type findingsService struct {
+	findingspb.UnimplementedFindingsServer
+	broker *findingsBroker
+}
+
+func (s *findingsService) Subscribe(req *findingspb.SubscribeRequest, stream grpc.ServerStreamingServer[findingspb.FindingEvent]) error {
+	filter, err := newFindingsFilter(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	sub, snapshot, cycleID := s.broker.subscribe(filter)
+	defer s.broker.unsubscribe(sub)
+
+	for i, f := range snapshot {
+		event := &findingspb.FindingEvent{Type: findingspb.FindingEvent_SNAPSHOT, Sequence: uint64(i) + 1, CycleId: cycleID, Finding: findingProto(f)}
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+	end := &findingspb.FindingEvent{Type: findingspb.FindingEvent_SNAPSHOT_END, Sequence: uint64(len(snapshot)) + 1, CycleId: cycleID}
+	if err := stream.Send(end); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-sub.notify:
+			for _, event := range sub.drain() {
+				if err := stream.Send(event); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (s *findingsService) GetSummary(ctx context.Context, req *findingspb.GetSummaryRequest) (*findingspb.Summary, error) {
+	summary := s.broker.summary()
+	if summary == nil {
+		return nil, status.Error(codes.NotFound, "no collection cycle has completed yet")
+	}
+	return summary, nil
+}
+
+// checkToken accepts requests carrying "authorization: Bearer <GRPC_TOKEN>"
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(v), []byte("Bearer "+token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+// newFindingsServer builds the gRPC server with the TLS and token settings of cfg
+func newFindingsServer(cfg Config, broker *findingsBroker) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if cfg.GRPCTLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.GRPCTLSCert, cfg.GRPCTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load gRPC TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if token := cfg.GRPCToken; token != "" {
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if err := checkToken(ctx, token); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := checkToken(ss.Context(), token); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}),
+		)
+	}
+	server := grpc.NewServer(opts...)
+	findingspb.RegisterFindingsServer(server, &findingsService{broker: broker})
+	return server, nil
+}
+
+// startFindingsServer serves the Findings service on GRPC_ADDR until the returned server
+// is stopped
+func startFindingsServer(cfg Config, broker *findingsBroker) (*grpc.Server, error) {
+	server, err := newFindingsServer(cfg, broker)
+	if err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on GRPC_ADDR %s: %w", cfg.GRPCAddr, err)
+	}
+	if cfg.GRPCToken != "" && cfg.GRPCTLSCert == "" {
+		log.Printf("⚠️ GRPC_TOKEN is set without GRPC_TLS_CERT, tokens are sent in plain text")
+	}
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			log.Printf("⚠️ gRPC server stopped: %v", err)
+		}
+	}()
+	log.Printf("📡 Serving findings over gRPC on %s (tls=%t, token=%t)", lis.Addr(), cfg.GRPCTLSCert != "", cfg.GRPCToken != "")
+	return server, nil
+}
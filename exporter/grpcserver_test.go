@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"trivy-exporter/findingspb"
+)
+
+// startTestFindingsServer serves the broker over an in-memory listener and returns a client
+func startTestFindingsServer(t *testing.T, cfg Config, broker *findingsBroker) findingspb.FindingsClient {
+	t.Helper()
+	server, err := newFindingsServer(cfg, broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return findingspb.NewFindingsClient(conn)
+}
+
+func testFinding(namespace, id, severity string) Finding {
+	return Finding{Cluster: "prod", Namespace: namespace, Workload: "Deployment/api", Image: "ghcr.io/example/api:1.0", Kind: "VulnerabilityReport", ID: id, Severity: severity}
+}
+
+// runTestCycle feeds one collection cycle of vulnerability findings to the broker
+func runTestCycle(b *findingsBroker, cycleID string, findings ...Finding) {
+	b.begin("VulnerabilityReport")
+	b.collect("VulnerabilityReport", findings)
+	b.publish(ClusterIndex{Cluster: "prod", CycleID: cycleID})
+}
+
+func recvEvent(t *testing.T, stream grpc.ServerStreamingClient[findingspb.FindingEvent]) *findingspb.FindingEvent {
+	t.Helper()
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	return event
+}
+
+// waitForSubscribers waits until the server registered n subscriptions
+func waitForSubscribers(t *testing.T, b *findingsBroker, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		got := len(b.subscribers)
+		b.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d subscribers", n)
+}
+
+func TestFindingsSubscribe(t *testing.T) {
+	broker := newFindingsBroker(16)
+	client := startTestFindingsServer(t, Config{}, broker)
+	runTestCycle(broker, "c1", testFinding("web", "CVE-1", "HIGH"), testFinding("web", "CVE-2", "LOW"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &findingspb.SubscribeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		typ findingspb.FindingEvent_Type
+		id  string
+	}{
+		{findingspb.FindingEvent_SNAPSHOT, "CVE-1"},
+		{findingspb.FindingEvent_SNAPSHOT, "CVE-2"},
+		{findingspb.FindingEvent_SNAPSHOT_END, ""},
+		{findingspb.FindingEvent_RESOLVED, "CVE-1"},
+		{findingspb.FindingEvent_ADDED, "CVE-3"},
+	}
+	for i, w := range want[:3] {
+		event := recvEvent(t, stream)
+		if event.GetType() != w.typ || event.GetFinding().GetId() != w.id || event.GetSequence() != uint64(i+1) {
+			t.Fatalf("event %d = %v, want %v %s with sequence %d", i, event, w.typ, w.id, i+1)
+		}
+	}
+
+	waitForSubscribers(t, broker, 1)
+	runTestCycle(broker, "c2", testFinding("web", "CVE-2", "LOW"), testFinding("web", "CVE-3", "CRITICAL"))
+	for i, w := range want[3:] {
+		event := recvEvent(t, stream)
+		seq := uint64(i + 4)
+		if event.GetType() != w.typ || event.GetFinding().GetId() != w.id || event.GetSequence() != seq || event.GetCycleId() != "c2" {
+			t.Fatalf("event = %v, want %v %s with sequence %d of cycle c2", event, w.typ, w.id, seq)
+		}
+	}
+}
+
+func TestFindingsSubscribeFilters(t *testing.T) {
+	findings := []Finding{
+		testFinding("web", "CVE-1", "CRITICAL"),
+		testFinding("web", "CVE-2", "MEDIUM"),
+		testFinding("payments", "CVE-3", "HIGH"),
+		testFinding("payments", "CVE-4", "unknown"),
+	}
+	tests := []struct {
+		name    string
+		req     *findingspb.SubscribeRequest
+		want    []string
+		wantErr codes.Code
+	}{
+		{name: "no filter", req: &findingspb.SubscribeRequest{}, want: []string{"CVE-3", "CVE-4", "CVE-1", "CVE-2"}},
+		{name: "namespace", req: &findingspb.SubscribeRequest{Namespaces: []string{"web"}}, want: []string{"CVE-1", "CVE-2"}},
+		{name: "min severity", req: &findingspb.SubscribeRequest{MinSeverity: "high"}, want: []string{"CVE-3", "CVE-1"}},
+		{name: "namespace and severity", req: &findingspb.SubscribeRequest{Namespaces: []string{"payments"}, MinSeverity: "CRITICAL"}, want: nil},
+		{name: "other cluster", req: &findingspb.SubscribeRequest{Clusters: []string{"staging"}}, want: nil},
+		{name: "invalid severity", req: &findingspb.SubscribeRequest{MinSeverity: "SEVERE"}, wantErr: codes.InvalidArgument},
+	}
+
+	broker := newFindingsBroker(16)
+	client := startTestFindingsServer(t, Config{}, broker)
+	runTestCycle(broker, "c1", findings...)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream, err := client.Subscribe(ctx, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for {
+				event, err := stream.Recv()
+				if tt.wantErr != codes.OK {
+					if status.Code(err) != tt.wantErr {
+						t.Fatalf("Recv error = %v, want %v", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if event.GetType() == findingspb.FindingEvent_SNAPSHOT_END {
+					break
+				}
+				got = append(got, event.GetFinding().GetId())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("snapshot = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A consumer that stops reading must not hold up the exporter: its buffer drops the oldest
+// events and the next event it reads is a GAP marker that accounts for all of them
+func TestFindingsSlowConsumer(t *testing.T) {
+	const buffer = 8
+	const total = 5000
+
+	broker := newFindingsBroker(buffer)
+	client := startTestFindingsServer(t, Config{}, broker)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &findingspb.SubscribeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := recvEvent(t, stream); event.GetType() != findingspb.FindingEvent_SNAPSHOT_END {
+		t.Fatalf("first event = %v, want SNAPSHOT_END", event)
+	}
+	waitForSubscribers(t, broker, 1)
+
+	// The client reads nothing while the cycle is published
+	var findings []Finding
+	for i := 0; i < total; i++ {
+		findings = append(findings, testFinding("web", fmt.Sprintf("CVE-%05d", i), "HIGH"))
+	}
+	done := make(chan struct{})
+	go func() {
+		runTestCycle(broker, "c1", findings...)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked on a slow consumer")
+	}
+
+	var received, dropped, gaps uint64
+	last := uint64(1) // SNAPSHOT_END
+	for received+dropped < total {
+		event := recvEvent(t, stream)
+		switch event.GetType() {
+		case findingspb.FindingEvent_GAP:
+			gaps++
+			dropped += event.GetDropped()
+			if event.GetSequence() != last+event.GetDropped() {
+				t.Fatalf("gap of %d events after sequence %d has sequence %d", event.GetDropped(), last, event.GetSequence())
+			}
+		case findingspb.FindingEvent_ADDED:
+			received++
+			if event.GetSequence() != last+1 {
+				t.Fatalf("sequence %d follows %d without a gap marker", event.GetSequence(), last)
+			}
+		default:
+			t.Fatalf("unexpected event %v", event)
+		}
+		last = event.GetSequence()
+	}
+	if gaps == 0 || dropped == 0 {
+		t.Fatalf("no events were dropped for a consumer that did not read (received %d)", received)
+	}
+	if last != total+1 {
+		t.Errorf("last sequence = %d, want %d", last, total+1)
+	}
+}
+
+func TestFindingsSubscriberBuffer(t *testing.T) {
+	s := &findingsSubscriber{max: 3, notify: make(chan struct{}, 1)}
+	for i := 1; i <= 5; i++ {
+		s.push(findingspb.FindingEvent_ADDED, "c1", testFinding("web", fmt.Sprintf("CVE-%d", i), "LOW"))
+	}
+	events := s.drain()
+	var got []string
+	for _, e := range events {
+		got = append(got, fmt.Sprintf("%s:%d:%d", e.GetType(), e.GetSequence(), e.GetDropped()))
+	}
+	want := []string{"GAP:2:2", "ADDED:3:0", "ADDED:4:0", "ADDED:5:0"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("drain() = %v, want %v", got, want)
+	}
+	if events := s.drain(); len(events) != 0 {
+		t.Errorf("second drain() = %d events, want none", len(events))
+	}
+}
+
+func TestFindingsFailedKindKeepsFindings(t *testing.T) {
+	broker := newFindingsBroker(16)
+	runTestCycle(broker, "c1", testFinding("web", "CVE-1", "HIGH"))
+
+	sub, _, _ := broker.subscribe(findingsFilter{})
+	broker.begin("VulnerabilityReport")
+	broker.discard("VulnerabilityReport")
+	broker.publish(ClusterIndex{Cluster: "prod", CycleID: "c2"})
+	if events := sub.drain(); len(events) != 0 {
+		t.Errorf("failed collection produced events: %v", events)
+	}
+	if _, snapshot, _ := broker.subscribe(findingsFilter{}); len(snapshot) != 1 {
+		t.Errorf("snapshot after a failed collection = %v, want the finding of c1", snapshot)
+	}
+}
+
+func TestFindingsGetSummaryAndToken(t *testing.T) {
+	broker := newFindingsBroker(16)
+	client := startTestFindingsServer(t, Config{GRPCToken: "s3cret"}, broker)
+	authed := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer s3cret")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{name: "no token", ctx: context.Background(), want: codes.Unauthenticated},
+		{name: "wrong token", ctx: metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer nope"), want: codes.Unauthenticated},
+		{name: "before the first cycle", ctx: authed, want: codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.GetSummary(tt.ctx, &findingspb.GetSummaryRequest{})
+			if status.Code(err) != tt.want {
+				t.Errorf("GetSummary error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	runTestCycle(broker, "c1")
+	summary, err := client.GetSummary(authed, &findingspb.GetSummaryRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.GetCluster() != "prod" || summary.GetCycleId() != "c1" || len(summary.GetIndexJson()) == 0 {
+		t.Errorf("GetSummary() = %v", summary)
+	}
+
+	stream, err := client.Subscribe(context.Background(), &findingspb.SubscribeRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Subscribe without token error = %v, want Unauthenticated", err)
+	}
+}
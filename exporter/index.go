@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Collection scopes (SCOPE)
+const (
+	scopeAll        = "all"
+	scopeCluster    = "cluster"
+	scopeNamespaced = "namespaced"
+)
+
+// ClusterIndex is published as index.json, and as index-<scope>.json when SCOPE is set
+type ClusterIndex struct {
+	Cluster         string                   `json:"cluster"`
+	CycleID         string                   `json:"cycleId,omitempty"`
+	LastUpdated     string                   `json:"lastUpdated"`
+	LastChecked     string                   `json:"lastChecked,omitempty"` // Also set when unchanged reports were not uploaded
+	CollectionStats map[string]int           `json:"collectionStats"`
+	CollectionOrder []string                 `json:"collectionOrder"`
+	ResourceStats   map[string]ResourceStats `json:"resourceStats"`
+	Checksums       map[string]string        `json:"checksums,omitempty"`  // SHA-256 per report file
+	ObjectKeys      map[string]string        `json:"objectKeys,omitempty"` // S3 key per report file
+	Retries         map[string]int           `json:"retries"`
+	Deletions       map[string]int           `json:"deletions,omitempty"`       // Per reason code
+	SnapshotsPruned int                      `json:"snapshotsPruned,omitempty"` // Objects of expired snapshots deleted
+	SkippedUploads  map[string]int           `json:"skippedUploads,omitempty"`  // Artifacts not uploaded per reason code
+	CDNInvalidation string                   `json:"cdnInvalidation,omitempty"` // CloudFront invalidation ID of the cycle
+	TempFreeBytes   int64                    `json:"tempFreeBytes,omitempty"`   // Lowest free temp space seen during the cycle
+	S3Streaming     string                   `json:"s3Streaming,omitempty"`     // Whether compressed S3 uploads are streamed
+	Phases          []PhaseTiming            `json:"phases,omitempty"`
+	// Artifacts published by the cycle and the capabilities of the exporter that ran it
+	Artifacts    []string `json:"artifacts,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	OperatorCrossCheck *OperatorCrossCheck `json:"operatorCrossCheck,omitempty"`
+	// Namespace hard limits omitted items; see resourceStats and namespaces.json
+	Truncated bool `json:"truncated,omitempty"`
+	// Report types whose CRD is not installed in the cluster
+	UnavailableResources []string `json:"unavailableResources,omitempty"`
+	// Report types the service account may not list; they are missing from resourceStats
+	ForbiddenResources []string `json:"forbiddenResources,omitempty"`
+	// Report types of CUSTOM_RESOURCES_FILE; their stats are in resourceStats with the others
+	CustomResources map[string]CustomResourceInfo `json:"customResources,omitempty"`
+	// Health and failed writes of the output sinks during the cycle
+	Sinks map[string]SinkHealth `json:"sinks,omitempty"`
+	// Last update per scope; only set on the merged index of a split deployment
+	Scopes map[string]string `json:"scopes,omitempty"`
+}
+
+// inScope reports whether a resource is collected under the given scope
+func inScope(resource ReportResource, scope string) bool {
+	switch scope {
+	case scopeCluster:
+		return resource.ClusterScoped
+	case scopeNamespaced:
+		return !resource.ClusterScoped
+	default:
+		return true
+	}
+}
+
+// otherScope returns the scope of the deployment sharing the cluster prefix
+func otherScope(scope string) string {
+	if scope == scopeCluster {
+		return scopeNamespaced
+	}
+	return scopeCluster
+}
+
+// publishIndex publishes the index of the cycle. With SCOPE=cluster or SCOPE=namespaced the
+// exporter owns index-<scope>.json only and rebuilds index.json from both scope files, so
+// whichever deployment finishes last leaves a merged view that neither can clobber.
+func publishIndex(ctx context.Context, sinks []Sink, cfg Config, index ClusterIndex) error {
+	if cfg.Scope != scopeCluster && cfg.Scope != scopeNamespaced {
+		return publishJSON(ctx, sinks, cfg, "index.json", index)
+	}
+
+	if err := publishJSON(ctx, sinks, cfg, ownIndexName(cfg), index); err != nil {
+		return err
+	}
+
+	var other *ClusterIndex
+	name := fmt.Sprintf("index-%s.json", otherScope(cfg.Scope))
+	data, err := readArtifact(ctx, sinks, cfg, name)
+	if errors.Is(err, errReadUnsupported) {
+		// A merged view built from one scope would drop the other scope's stats
+		log.Printf("⚠️ No sink can read %s back, publishing %s only: %v", name, ownIndexName(cfg), err)
+		return nil
+	}
+	if err != nil {
+		// Without the other scope's index the merged view would drop its stats
+		return fmt.Errorf("failed to read %s, index.json not updated: %w", name, err)
+	}
+	if data != nil {
+		other = &ClusterIndex{}
+		if err := json.Unmarshal(data, other); err != nil {
+			log.Printf("⚠️ Ignoring unreadable %s: %v", name, err)
+			other = nil
+		}
+	}
+
+	return publishJSON(ctx, sinks, cfg, "index.json", mergeIndexes(cfg.Scope, index, other))
+}
+
+// ownIndexName is the index file written from this exporter's stats alone
+func ownIndexName(cfg Config) string {
+	if cfg.Scope != scopeCluster && cfg.Scope != scopeNamespaced {
+		return "index.json"
+	}
+	return fmt.Sprintf("index-%s.json", cfg.Scope)
+}
+
+// mergeIndexes combines the index of this exporter's scope with the other scope's index.
+// Each side only contributes the resources of its own scope, so stale entries left by an
+// earlier SCOPE=all run never override fresh stats. Retries, deletions, pruned snapshots and
+// skipped uploads are summed.
+func mergeIndexes(scope string, own ClusterIndex, other *ClusterIndex) ClusterIndex {
+	merged := ClusterIndex{
+		Cluster:         own.Cluster,
+		CycleID:         own.CycleID,
+		LastUpdated:     own.LastUpdated,
+		LastChecked:     own.LastChecked,
+		CDNInvalidation: own.CDNInvalidation,
+		TempFreeBytes:   own.TempFreeBytes,
+		S3Streaming:     own.S3Streaming,
+		CollectionStats: make(map[string]int),
+		ResourceStats:   make(map[string]ResourceStats),
+		Checksums:       make(map[string]string),
+		ObjectKeys:      make(map[string]string),
+		Retries:         make(map[string]int),
+		Deletions:       maps.Clone(own.Deletions),
+		SnapshotsPruned: own.SnapshotsPruned,
+		SkippedUploads:  maps.Clone(own.SkippedUploads),
+		Phases:          own.Phases,
+		Artifacts:       own.Artifacts,
+		Capabilities:    own.Capabilities,
+		Scopes:          map[string]string{scope: own.LastUpdated},
+
+		OperatorCrossCheck: own.OperatorCrossCheck,
+		Truncated:          own.Truncated || (other != nil && other.Truncated),
+	}
+	// Only the namespaced scope collects the vulnerability reports the check is based on
+	if merged.OperatorCrossCheck == nil && other != nil {
+		merged.OperatorCrossCheck = other.OperatorCrossCheck
+	}
+
+	sides := []struct {
+		scope string
+		index *ClusterIndex
+	}{{scope, &own}, {otherScope(scope), other}}
+
+	for _, side := range sides {
+		if side.index == nil {
+			continue
+		}
+		for _, r := range orderedResources() {
+			if !inScope(r, side.scope) {
+				continue
+			}
+			if count, ok := side.index.CollectionStats[r.Name]; ok {
+				merged.CollectionStats[r.Name] = count
+			}
+			if stats, ok := side.index.ResourceStats[r.Name]; ok {
+				merged.ResourceStats[r.Name] = stats
+			}
+			if sum, ok := side.index.Checksums[r.FileName+".json"]; ok {
+				merged.Checksums[r.FileName+".json"] = sum
+			}
+			if key, ok := side.index.ObjectKeys[r.FileName+".json"]; ok {
+				merged.ObjectKeys[r.FileName+".json"] = key
+			}
+		}
+		for name, info := range side.index.CustomResources {
+			if merged.CustomResources == nil {
+				merged.CustomResources = make(map[string]CustomResourceInfo)
+			}
+			merged.CustomResources[name] = info
+		}
+		for component, n := range side.index.Retries {
+			merged.Retries[component] += n
+		}
+		for name, health := range side.index.Sinks {
+			if merged.Sinks == nil {
+				merged.Sinks = make(map[string]SinkHealth)
+			}
+			if seen, ok := merged.Sinks[name]; ok {
+				health = mergeSinkHealth(seen, health)
+			}
+			merged.Sinks[name] = health
+		}
+		merged.Scopes[side.scope] = side.index.LastUpdated
+		if laterThan(side.index.LastUpdated, merged.LastUpdated) {
+			merged.LastUpdated = side.index.LastUpdated
+		}
+	}
+
+	if other != nil {
+		merged.SnapshotsPruned += other.SnapshotsPruned
+		for reason, n := range other.Deletions {
+			if merged.Deletions == nil {
+				merged.Deletions = make(map[string]int)
+			}
+			merged.Deletions[reason] += n
+		}
+		for reason, n := range other.SkippedUploads {
+			if merged.SkippedUploads == nil {
+				merged.SkippedUploads = make(map[string]int)
+			}
+			merged.SkippedUploads[reason] += n
+		}
+	}
+
+	// Keep the priority order across both scopes
+	for _, r := range orderedResources() {
+		if _, ok := merged.CollectionStats[r.Name]; ok {
+			merged.CollectionOrder = append(merged.CollectionOrder, r.Name)
+		}
+	}
+	merged.UnavailableResources = unavailableResources(merged.ResourceStats)
+	for _, r := range orderedResources() {
+		for _, side := range sides {
+			if side.index != nil && inScope(r, side.scope) && slices.Contains(side.index.ForbiddenResources, r.Name) {
+				merged.ForbiddenResources = append(merged.ForbiddenResources, r.Name)
+			}
+		}
+	}
+	return merged
+}
+
+// unavailableResources lists the report types collected without their CRD in priority order
+func unavailableResources(stats map[string]ResourceStats) []string {
+	var names []string
+	for _, r := range orderedResources() {
+		if stats[r.Name].Unavailable {
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+// laterThan compares two RFC3339 timestamps; unparsable values never win
+func laterThan(a, b string) bool {
+	ta, err := time.Parse(time.RFC3339, a)
+	if err != nil {
+		return false
+	}
+	tb, err := time.Parse(time.RFC3339, b)
+	if err != nil {
+		return true
+	}
+	return ta.After(tb)
+}
+
+// publishJSON marshals a cluster metadata document and publishes it
+func publishJSON(ctx context.Context, sinks []Sink, cfg Config, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	return publishArtifact(ctx, sinks, cfg, Artifact{Name: name, Data: data})
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// Deletions of both scopes are summed per reason code
+func TestMergeIndexesDeletions(t *testing.T) {
+	tests := []struct {
+		name  string
+		own   map[string]int
+		other *ClusterIndex
+		want  map[string]int
+	}{
+		{name: "no deletions", other: &ClusterIndex{}},
+		{name: "own only", own: map[string]int{"expired": 2}, want: map[string]int{"expired": 2}},
+		{
+			name:  "other only",
+			other: &ClusterIndex{Deletions: map[string]int{"stale-cluster": 3}},
+			want:  map[string]int{"stale-cluster": 3},
+		},
+		{
+			name:  "both",
+			own:   map[string]int{"expired": 2, "rotated": 1},
+			other: &ClusterIndex{Deletions: map[string]int{"expired": 5, "stale-cluster": 3}},
+			want:  map[string]int{"expired": 7, "rotated": 1, "stale-cluster": 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			own := ClusterIndex{Cluster: "prod", Deletions: maps.Clone(tt.own)}
+			merged := mergeIndexes(scopeCluster, own, tt.other)
+			if !maps.Equal(merged.Deletions, tt.want) {
+				t.Errorf("deletions = %v, want %v", merged.Deletions, tt.want)
+			}
+			if !maps.Equal(own.Deletions, tt.own) {
+				t.Errorf("merging changed the deletions of the own index to %v", own.Deletions)
+			}
+		})
+	}
+}
+
+// A sink is unhealthy in the merged index if either scope saw it fail
+func TestMergeIndexesSinks(t *testing.T) {
+	healthy := SinkHealth{Healthy: true, Since: "2026-03-01T09:00:00Z"}
+	readOnly := SinkHealth{Reason: sinkReasonReadOnly, Since: "2026-03-01T10:00:00Z", LastError: "read-only file system",
+		Failures: map[string]string{"config-audit-reports.json": sinkReasonReadOnly}}
+	tests := []struct {
+		name  string
+		own   map[string]SinkHealth
+		other *ClusterIndex
+		want  map[string]SinkHealth
+	}{
+		{name: "no other index", own: map[string]SinkHealth{"fs": healthy}, want: map[string]SinkHealth{"fs": healthy}},
+		{
+			name:  "other scope only",
+			other: &ClusterIndex{Sinks: map[string]SinkHealth{"fs": readOnly}},
+			want:  map[string]SinkHealth{"fs": readOnly},
+		},
+		{
+			name:  "unhealthy in the other scope",
+			own:   map[string]SinkHealth{"fs": healthy},
+			other: &ClusterIndex{Sinks: map[string]SinkHealth{"fs": readOnly}},
+			want:  map[string]SinkHealth{"fs": readOnly},
+		},
+		{
+			name: "failures of both scopes",
+			own: map[string]SinkHealth{"fs": {Reason: sinkReasonNoSpace, Since: "2026-03-01T10:05:00Z", LastError: "no space left on device",
+				Failures: map[string]string{"vulnerability-reports.json": sinkReasonNoSpace}}},
+			other: &ClusterIndex{Sinks: map[string]SinkHealth{"fs": readOnly}},
+			want: map[string]SinkHealth{"fs": {Reason: sinkReasonNoSpace, Since: "2026-03-01T10:05:00Z", LastError: "no space left on device",
+				Failures: map[string]string{"vulnerability-reports.json": sinkReasonNoSpace, "config-audit-reports.json": sinkReasonReadOnly}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergeIndexes(scopeNamespaced, ClusterIndex{Cluster: "prod", Sinks: tt.own}, tt.other)
+			if !reflect.DeepEqual(merged.Sinks, tt.want) {
+				t.Errorf("sinks = %+v, want %+v", merged.Sinks, tt.want)
+			}
+		})
+	}
+}
+
+func TestInScope(t *testing.T) {
+	namespaced := ReportResource{Name: "vulnerabilityreports"}
+	clusterScoped := ReportResource{Name: "clustervulnerabilityreports", ClusterScoped: true}
+	tests := []struct {
+		scope         string
+		namespaced    bool
+		clusterScoped bool
+	}{
+		{scope: scopeAll, namespaced: true, clusterScoped: true},
+		{scope: "", namespaced: true, clusterScoped: true},
+		{scope: scopeCluster, clusterScoped: true},
+		{scope: scopeNamespaced, namespaced: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			if got := inScope(namespaced, tt.scope); got != tt.namespaced {
+				t.Errorf("inScope(namespaced) = %v, want %v", got, tt.namespaced)
+			}
+			if got := inScope(clusterScoped, tt.scope); got != tt.clusterScoped {
+				t.Errorf("inScope(cluster-scoped) = %v, want %v", got, tt.clusterScoped)
+			}
+		})
+	}
+}
+
+func TestMergeIndexes(t *testing.T) {
+	// The cluster deployment's index still lists vulnerabilityreports from an earlier
+	// SCOPE=all run, and the namespaced one clustervulnerabilityreports
+	own := ClusterIndex{
+		Cluster:         "prod",
+		CycleID:         "20260301-100500",
+		LastUpdated:     "2026-03-01T10:05:00Z",
+		SnapshotsPruned: 2,
+		CollectionStats: map[string]int{"clustervulnerabilityreports": 3, "clusterconfigauditreports": 0, "vulnerabilityreports": 99},
+		ResourceStats: map[string]ResourceStats{
+			"clustervulnerabilityreports": {Items: 3},
+			"clusterconfigauditreports":   {Unavailable: true},
+			"vulnerabilityreports":        {Items: 99},
+		},
+		Checksums:          map[string]string{"cluster-vulnerability-reports.json": "c1", "vulnerability-reports.json": "stale"},
+		Retries:            map[string]int{"s3": 1, "kubernetes": 2},
+		ForbiddenResources: []string{"clusterrbacassessmentreports", "exposedsecretreports"},
+	}
+	other := &ClusterIndex{
+		Cluster:         "prod",
+		CycleID:         "20260301-100000",
+		LastUpdated:     "2026-03-01T10:00:00Z",
+		SnapshotsPruned: 1,
+		CollectionStats: map[string]int{"vulnerabilityreports": 10, "configauditreports": 0, "clustervulnerabilityreports": 42},
+		ResourceStats: map[string]ResourceStats{
+			"vulnerabilityreports":        {Items: 10},
+			"configauditreports":          {Unavailable: true},
+			"clustervulnerabilityreports": {Items: 42},
+		},
+		Checksums:          map[string]string{"vulnerability-reports.json": "v1", "cluster-vulnerability-reports.json": "stale"},
+		Retries:            map[string]int{"s3": 4},
+		ForbiddenResources: []string{"exposedsecretreports", "clusterrbacassessmentreports"},
+	}
+	later := *other
+	later.LastUpdated = "2026-03-01T10:10:00Z"
+
+	tests := []struct {
+		name        string
+		other       *ClusterIndex
+		stats       map[string]int
+		order       []string
+		checksums   map[string]string
+		retries     map[string]int
+		lastUpdated string
+		scopes      map[string]string
+		pruned      int
+		unavailable []string
+		forbidden   []string
+	}{
+		{
+			name:        "no other index",
+			stats:       map[string]int{"clustervulnerabilityreports": 3, "clusterconfigauditreports": 0},
+			order:       []string{"clustervulnerabilityreports", "clusterconfigauditreports"},
+			checksums:   map[string]string{"cluster-vulnerability-reports.json": "c1"},
+			retries:     map[string]int{"s3": 1, "kubernetes": 2},
+			lastUpdated: "2026-03-01T10:05:00Z",
+			scopes:      map[string]string{scopeCluster: "2026-03-01T10:05:00Z"},
+			pruned:      2,
+			unavailable: []string{"clusterconfigauditreports"},
+			forbidden:   []string{"clusterrbacassessmentreports"},
+		},
+		{
+			name:        "other scope updated earlier",
+			other:       other,
+			stats:       map[string]int{"vulnerabilityreports": 10, "configauditreports": 0, "clustervulnerabilityreports": 3, "clusterconfigauditreports": 0},
+			order:       []string{"vulnerabilityreports", "configauditreports", "clustervulnerabilityreports", "clusterconfigauditreports"},
+			checksums:   map[string]string{"cluster-vulnerability-reports.json": "c1", "vulnerability-reports.json": "v1"},
+			retries:     map[string]int{"s3": 5, "kubernetes": 2},
+			lastUpdated: "2026-03-01T10:05:00Z",
+			scopes:      map[string]string{scopeCluster: "2026-03-01T10:05:00Z", scopeNamespaced: "2026-03-01T10:00:00Z"},
+			pruned:      3,
+			unavailable: []string{"configauditreports", "clusterconfigauditreports"},
+			forbidden:   []string{"exposedsecretreports", "clusterrbacassessmentreports"},
+		},
+		{
+			name:        "other scope updated later",
+			other:       &later,
+			stats:       map[string]int{"vulnerabilityreports": 10, "configauditreports": 0, "clustervulnerabilityreports": 3, "clusterconfigauditreports": 0},
+			order:       []string{"vulnerabilityreports", "configauditreports", "clustervulnerabilityreports", "clusterconfigauditreports"},
+			checksums:   map[string]string{"cluster-vulnerability-reports.json": "c1", "vulnerability-reports.json": "v1"},
+			retries:     map[string]int{"s3": 5, "kubernetes": 2},
+			lastUpdated: "2026-03-01T10:10:00Z",
+			scopes:      map[string]string{scopeCluster: "2026-03-01T10:05:00Z", scopeNamespaced: "2026-03-01T10:10:00Z"},
+			pruned:      3,
+			unavailable: []string{"configauditreports", "clusterconfigauditreports"},
+			forbidden:   []string{"exposedsecretreports", "clusterrbacassessmentreports"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergeIndexes(scopeCluster, own, tt.other)
+			if merged.Cluster != "prod" || merged.CycleID != own.CycleID {
+				t.Errorf("cluster %q, cycle %q; want prod and the own cycle %s", merged.Cluster, merged.CycleID, own.CycleID)
+			}
+			if !maps.Equal(merged.CollectionStats, tt.stats) {
+				t.Errorf("collectionStats = %v, want %v", merged.CollectionStats, tt.stats)
+			}
+			if len(merged.ResourceStats) != len(tt.stats) {
+				t.Errorf("resourceStats of %v, want those of %v", sortedKeys(merged.ResourceStats), sortedKeys(tt.stats))
+			}
+			if !slices.Equal(merged.CollectionOrder, tt.order) {
+				t.Errorf("collectionOrder = %v, want %v", merged.CollectionOrder, tt.order)
+			}
+			if !maps.Equal(merged.Checksums, tt.checksums) {
+				t.Errorf("checksums = %v, want %v", merged.Checksums, tt.checksums)
+			}
+			if !maps.Equal(merged.Retries, tt.retries) {
+				t.Errorf("retries = %v, want %v", merged.Retries, tt.retries)
+			}
+			if merged.LastUpdated != tt.lastUpdated || !maps.Equal(merged.Scopes, tt.scopes) {
+				t.Errorf("lastUpdated %s, scopes %v; want %s, %v", merged.LastUpdated, merged.Scopes, tt.lastUpdated, tt.scopes)
+			}
+			if merged.SnapshotsPruned != tt.pruned {
+				t.Errorf("snapshotsPruned = %d, want %d", merged.SnapshotsPruned, tt.pruned)
+			}
+			if !slices.Equal(merged.UnavailableResources, tt.unavailable) {
+				t.Errorf("unavailableResources = %v, want %v", merged.UnavailableResources, tt.unavailable)
+			}
+			if !slices.Equal(merged.ForbiddenResources, tt.forbidden) {
+				t.Errorf("forbiddenResources = %v, want %v", merged.ForbiddenResources, tt.forbidden)
+			}
+		})
+	}
+}
+
+// With SCOPE=all the index is published as is; split deployments publish their scope's
+// index and rebuild index.json from both scope files
+func TestPublishIndex(t *testing.T) {
+	clusterIndex := ClusterIndex{Cluster: "prod", CycleID: "c1", LastUpdated: "2026-03-01T10:05:00Z",
+		CollectionStats: map[string]int{"clustervulnerabilityreports": 3}}
+	tests := []struct {
+		name    string
+		scope   string
+		other   string // index-namespaced.json already published, if any
+		files   []string
+		stats   map[string]int
+		scopes  []string
+		wantErr bool
+	}{
+		{
+			name:  "SCOPE=all",
+			scope: scopeAll,
+			files: []string{"prod-index.json"},
+			stats: map[string]int{"clustervulnerabilityreports": 3},
+		},
+		{
+			name:   "first of the split deployments",
+			scope:  scopeCluster,
+			files:  []string{"prod-index-cluster.json", "prod-index.json"},
+			stats:  map[string]int{"clustervulnerabilityreports": 3},
+			scopes: []string{scopeCluster},
+		},
+		{
+			name:   "second of the split deployments",
+			scope:  scopeCluster,
+			other:  `{"cluster": "prod", "lastUpdated": "2026-03-01T10:00:00Z", "collectionStats": {"vulnerabilityreports": 10}}`,
+			files:  []string{"prod-index-cluster.json", "prod-index-namespaced.json", "prod-index.json"},
+			stats:  map[string]int{"clustervulnerabilityreports": 3, "vulnerabilityreports": 10},
+			scopes: []string{scopeCluster, scopeNamespaced},
+		},
+		{
+			name:   "unreadable index of the other scope",
+			scope:  scopeCluster,
+			other:  `{"cluster": `,
+			files:  []string{"prod-index-cluster.json", "prod-index-namespaced.json", "prod-index.json"},
+			stats:  map[string]int{"clustervulnerabilityreports": 3},
+			scopes: []string{scopeCluster},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := Config{ClusterName: "prod", FSOutputDir: dir, FSLayout: fsLayoutFlat, Scope: tt.scope}
+			sinks := []Sink{&fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}}
+			if tt.other != "" {
+				os.WriteFile(filepath.Join(dir, "prod-index-namespaced.json"), []byte(tt.other), 0644)
+			}
+
+			if err := publishIndex(context.Background(), sinks, cfg, clusterIndex); err != nil {
+				t.Fatal(err)
+			}
+			entries, _ := os.ReadDir(dir)
+			var files []string
+			for _, e := range entries {
+				files = append(files, e.Name())
+			}
+			if !slices.Equal(files, tt.files) {
+				t.Errorf("files = %v, want %v", files, tt.files)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "prod-index.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var index ClusterIndex
+			if err := json.Unmarshal(data, &index); err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(index.CollectionStats, tt.stats) {
+				t.Errorf("index.json collectionStats = %v, want %v", index.CollectionStats, tt.stats)
+			}
+			if scopes := sortedKeys(index.Scopes); !slices.Equal(scopes, tt.scopes) {
+				t.Errorf("index.json scopes = %v, want %v", scopes, tt.scopes)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Shortest STALE_CLUSTER_MAX_AGE accepted, so a cluster is never taken for retired
+// because its exporter was down for a while
+const minStaleClusterAge = 24 * time.Hour
+
+// staleClusterCycles counts the cycles for STALE_CLUSTER_CLEANUP_EVERY
+var staleClusterCycles = &cycleCounter{}
+
+type cycleCounter struct {
+	n int
+}
+
+// due reports whether a task running every nth cycle runs in this one, starting with the
+// first cycle
+func (c *cycleCounter) due(every int) bool {
+	due := c.n%every == 0
+	c.n++
+	return due
+}
+
+// staleClusterFileNames returns the files an exporter writes for its cluster that the
+// cleanup may delete: report files with their checksums and the index files. Anything
+// else under another cluster's name is left alone.
+func staleClusterFileNames() map[string]bool {
+	names := maps.Clone(indexFileNames)
+	for _, r := range reportResources {
+		names[r.FileName+".json"] = true
+		names[r.FileName+".json.gz"] = true
+		names[r.FileName+".json"+checksumSuffix] = true
+	}
+	return names
+}
+
+// isStaleClusterFile reports whether the cleanup may delete a key of another cluster: one of
+// staleClusterFileNames or its copy in a snapshot
+func isStaleClusterFile(names map[string]bool, key string) bool {
+	if names[key] {
+		return true
+	}
+	rest, ok := strings.CutPrefix(key, snapshotDir+"/")
+	if !ok {
+		return false
+	}
+	ts, name, ok := strings.Cut(rest, "/")
+	if !ok {
+		return false
+	}
+	_, err := time.Parse(snapshotTimestampLayout, ts)
+	return err == nil && names[name]
+}
+
+// clusterFiles are the files of another cluster found by the cleanup
+type clusterFiles struct {
+	sink    Sink // addresses the keys of the cluster
+	objects []listedObject
+}
+
+// updated returns when the newest file of the cluster was written
+func (c *clusterFiles) updated() time.Time {
+	var newest time.Time
+	for _, obj := range c.objects {
+		if obj.Modified.After(newest) {
+			newest = obj.Modified
+		}
+	}
+	return newest
+}
+
+// fsClusters finds the files of the other clusters sharing FS_OUTPUT_DIR in its FS_LAYOUT
+func fsClusters(ctx context.Context, cfg Config, names map[string]bool) (map[string]*clusterFiles, error) {
+	entries, err := os.ReadDir(cfg.FSOutputDir)
+	if err != nil {
+		return nil, err
+	}
+	clusters := make(map[string]*clusterFiles)
+	add := func(cluster string) *clusterFiles {
+		if clusters[cluster] == nil {
+			// Keys are file names as found, so the sink never adds a .gz of its own
+			clusters[cluster] = &clusterFiles{sink: &fsSink{dir: cfg.FSOutputDir, cluster: cluster, layout: cfg.FSLayout}}
+		}
+		return clusters[cluster]
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if cfg.FSLayout == fsLayoutNested {
+			if entry.IsDir() && name != cfg.ClusterName {
+				add(name)
+			}
+			continue
+		}
+		if entry.IsDir() {
+			if cluster, ok := strings.CutSuffix(name, "-"+snapshotDir); ok && cluster != cfg.ClusterName {
+				add(cluster)
+			}
+			continue
+		}
+		// <cluster>-<file>; the longest known file name wins, so that
+		// prod-cluster-config-audit-reports.json belongs to prod and not to prod-cluster
+		file := ""
+		for known := range names {
+			if strings.HasSuffix(name, "-"+known) && len(known) > len(file) {
+				file = known
+			}
+		}
+		cluster := strings.TrimSuffix(name, "-"+file)
+		if file == "" || cluster == "" || cluster == cfg.ClusterName {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		c := add(cluster)
+		c.objects = append(c.objects, listedObject{Key: file, Size: info.Size(), Modified: info.ModTime()})
+	}
+
+	for _, c := range clusters {
+		prefix := ""
+		if cfg.FSLayout == fsLayoutFlat {
+			prefix = snapshotDir + "/"
+		}
+		objects, err := c.sink.(lister).list(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			if isStaleClusterFile(names, obj.Key) {
+				c.objects = append(c.objects, obj)
+			}
+		}
+	}
+	return clusters, nil
+}
+
+// s3Clusters finds the files of the other clusters under S3_PREFIX. Report types written
+// under S3_PREFIX_<TYPE> by other clusters are not found.
+func s3Clusters(ctx context.Context, s *s3Sink, cfg Config, names map[string]bool) (map[string]*clusterFiles, error) {
+	root := cfg.S3Prefix + "/"
+	clusters := make(map[string]*clusterFiles)
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(root),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.CommonPrefixes {
+			cluster := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), root), "/")
+			if cluster == "" || cluster == cfg.ClusterName {
+				continue
+			}
+			clusters[cluster] = &clusterFiles{sink: &s3Sink{client: s.client, bucket: s.bucket, prefix: objectPrefix(cfg.S3Prefix, cluster), cluster: cluster}}
+		}
+	}
+
+	for _, c := range clusters {
+		objects, err := c.sink.(lister).list(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			if isStaleClusterFile(names, obj.Key) {
+				c.objects = append(c.objects, obj)
+			}
+		}
+	}
+	return clusters, nil
+}
+
+// cleanupStaleClusters deletes the report files of the clusters in FS_OUTPUT_DIR and under
+// S3_PREFIX whose newest file is older than STALE_CLUSTER_MAX_AGE, such as decommissioned
+// clusters that would otherwise stay on the dashboard. Failures are logged and the cluster
+// is retried on the next run.
+func cleanupStaleClusters(ctx context.Context, sinks []Sink, cfg Config, cycleID string) {
+	names := staleClusterFileNames()
+	cutoff := time.Now().Add(-cfg.StaleClusterMaxAge)
+	for _, sink := range sinks {
+		var clusters map[string]*clusterFiles
+		err := withRetry(ctx, cfg, sink, func() error {
+			var err error
+			switch s := sink.(type) {
+			case *fsSink:
+				clusters, err = fsClusters(ctx, cfg, names)
+			case *s3Sink:
+				clusters, err = s3Clusters(ctx, s, cfg, names)
+			}
+			return err
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("⚠️ Failed to look for stale clusters in %s: %v", sink.Name(), err)
+			continue
+		}
+
+		for _, cluster := range sortedKeys(clusters) {
+			c := clusters[cluster]
+			updated := c.updated()
+			if len(c.objects) == 0 || updated.After(cutoff) {
+				continue
+			}
+			log.Printf("🧓 Cluster %s was last exported to %s on %s", cluster, sink.Name(), updated.UTC().Format(time.RFC3339))
+			reqs := make([]DeletionRequest, len(c.objects))
+			for i, obj := range c.objects {
+				reqs[i] = DeletionRequest{
+					Key:     obj.Key,
+					Cluster: cluster,
+					Size:    obj.Size,
+					Feature: "stale-cluster-cleanup",
+					Reason:  "stale-cluster",
+					CycleID: cycleID,
+					DryRun:  cfg.StaleClusterDryRun,
+				}
+			}
+			n, freed, err := deleteArtifacts(ctx, []Sink{c.sink}, cfg, reqs)
+			if err != nil {
+				log.Printf("⚠️ Failed to clean up cluster %s in %s: %v", cluster, sink.Name(), err)
+			}
+			if !cfg.StaleClusterDryRun {
+				log.Printf("🧹 Removed %d of %d files of stale cluster %s from %s, reclaiming %s",
+					n, len(reqs), cluster, sink.Name(), formatSize(freed))
+			}
+		}
+	}
+}
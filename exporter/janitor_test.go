@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeClusterFile writes a key of cluster to dir in the given FS_LAYOUT, last modified at
+// modified
+func writeClusterFile(t *testing.T, dir, layout, cluster, key string, modified time.Time) {
+	t.Helper()
+	path := (&fsSink{dir: dir, cluster: cluster, layout: layout}).path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsStaleClusterFile(t *testing.T) {
+	names := staleClusterFileNames()
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "vulnerability-reports.json", want: true},
+		{key: "vulnerability-reports.json.gz", want: true},
+		{key: "vulnerability-reports.json" + checksumSuffix, want: true},
+		{key: "index.json", want: true},
+		{key: "index-delta-namespaced.json", want: true},
+		{key: "snapshots/20260301-100000/vulnerability-reports.json", want: true},
+		{key: "snapshots/20260301-100000/index.json", want: true},
+		{key: "snapshots/latest/vulnerability-reports.json", want: false},
+		{key: "snapshots/20260301-100000/notes.txt", want: false},
+		{key: "snapshots/20260301-100000", want: false},
+		{key: "archive/20260301-100000/vulnerability-reports.json", want: false},
+		{key: "deletions-audit.jsonl", want: false},
+		{key: "notes.txt", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := isStaleClusterFile(names, tt.key); got != tt.want {
+				t.Errorf("isStaleClusterFile(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+// The files of prod, prod-cluster and old are found next to those of staging, the exporter's
+// own cluster. In the flat layout the longest known file name decides between prod and
+// prod-cluster: prod-cluster-config-audit-reports.json belongs to prod, while
+// prod-cluster-index.json and prod-cluster-exposed-secret-reports.json belong to prod-cluster.
+func TestFSClusters(t *testing.T) {
+	snapshot := "snapshots/20260301-100000/vulnerability-reports.json"
+	want := map[string][]string{
+		"old":          {snapshot},
+		"prod":         {"cluster-config-audit-reports.json", "index.json", snapshot, "vulnerability-reports.json"},
+		"prod-cluster": {"exposed-secret-reports.json", "index.json"},
+	}
+	for _, layout := range []string{fsLayoutFlat, fsLayoutNested} {
+		t.Run(layout, func(t *testing.T) {
+			dir := t.TempDir()
+			now := time.Now()
+			for cluster, keys := range want {
+				for _, key := range keys {
+					writeClusterFile(t, dir, layout, cluster, key, now)
+				}
+			}
+			writeClusterFile(t, dir, layout, "staging", "vulnerability-reports.json", now)
+			writeClusterFile(t, dir, layout, "staging", snapshot, now)
+			writeClusterFile(t, dir, layout, "prod", "notes.txt", now)
+			writeClusterFile(t, dir, layout, "prod", "snapshots/latest/vulnerability-reports.json", now)
+			os.WriteFile(filepath.Join(dir, ownerMarkerName), []byte("{}"), 0644)
+
+			cfg := Config{ClusterName: "staging", FSOutputDir: dir, FSLayout: layout}
+			clusters, err := fsClusters(context.Background(), cfg, staleClusterFileNames())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string][]string)
+			for cluster, c := range clusters {
+				var keys []string
+				for _, obj := range c.objects {
+					keys = append(keys, obj.Key)
+				}
+				slices.Sort(keys)
+				got[cluster] = keys
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("fsClusters() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// Clusters are removed once their newest file is older than STALE_CLUSTER_MAX_AGE, and
+// never in a dry run
+func TestCleanupStaleClusters(t *testing.T) {
+	t.Cleanup(func() {
+		pendingDeletions.mu.Lock()
+		pendingDeletions.records = nil
+		pendingDeletions.mu.Unlock()
+		deletionCounts.reset()
+	})
+	tests := []struct {
+		name   string
+		layout string
+		dryRun bool
+		want   []string // clusters left in FS_OUTPUT_DIR
+	}{
+		{name: "flat", layout: fsLayoutFlat, want: []string{"recent", "staging"}},
+		{name: "nested", layout: fsLayoutNested, want: []string{"recent", "staging"}},
+		{name: "flat dry run", layout: fsLayoutFlat, dryRun: true, want: []string{"old", "recent", "staging"}},
+		{name: "nested dry run", layout: fsLayoutNested, dryRun: true, want: []string{"old", "recent", "staging"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			now := time.Now()
+			longAgo := now.Add(-60 * 24 * time.Hour)
+			writeClusterFile(t, dir, tt.layout, "staging", "vulnerability-reports.json", longAgo)
+			writeClusterFile(t, dir, tt.layout, "old", "vulnerability-reports.json", longAgo)
+			writeClusterFile(t, dir, tt.layout, "old", "snapshots/20260101-000000/vulnerability-reports.json", longAgo)
+			writeClusterFile(t, dir, tt.layout, "recent", "vulnerability-reports.json", longAgo)
+			writeClusterFile(t, dir, tt.layout, "recent", "index.json", now.Add(-24*time.Hour))
+
+			cfg := Config{
+				ClusterName:        "staging",
+				FSOutputDir:        dir,
+				FSLayout:           tt.layout,
+				StaleClusterMaxAge: 30 * 24 * time.Hour,
+				StaleClusterDryRun: tt.dryRun,
+			}
+			sinks := []Sink{&fsSink{dir: dir, cluster: "staging", layout: tt.layout}}
+			cleanupStaleClusters(context.Background(), sinks, cfg, "20260301-100000")
+
+			clusters, err := fsClusters(context.Background(), Config{FSOutputDir: dir, FSLayout: tt.layout}, staleClusterFileNames())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for cluster, c := range clusters {
+				if len(c.objects) > 0 {
+					got = append(got, cluster)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("clusters left = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonStream reads a list document ({"apiVersion": ..., "items": [...]}) one item at a time.
+// It accepts both the files written by collectResourcePaged and `kubectl get -o json` output,
+// so large report files never have to be held in memory as a whole.
+type jsonStream struct {
+	dec        *json.Decoder
+	APIVersion string
+	Kind       string
+	inItems    bool
+	done       bool
+}
+
+func newJSONStream(r io.Reader) *jsonStream {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &jsonStream{dec: dec}
+}
+
+// Next returns the next item of the list, or io.EOF once the items array is exhausted
+func (s *jsonStream) Next() (map[string]interface{}, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+
+	if !s.inItems {
+		if err := s.seekItems(); err != nil {
+			return nil, err
+		}
+		if s.done {
+			return nil, io.EOF
+		}
+	}
+
+	if s.dec.More() {
+		var item map[string]interface{}
+		if err := s.dec.Decode(&item); err != nil {
+			return nil, fmt.Errorf("failed to decode item: %w", err)
+		}
+		return item, nil
+	}
+
+	// Consume the closing bracket and any trailing keys so top-level fields
+	// placed after the items array are still picked up
+	if _, err := s.dec.Token(); err != nil {
+		return nil, fmt.Errorf("failed to read end of items: %w", err)
+	}
+	s.inItems = false
+	if err := s.readKeys(false); err != nil {
+		return nil, err
+	}
+	s.done = true
+	return nil, io.EOF
+}
+
+// seekItems advances the decoder to the first element of the items array
+func (s *jsonStream) seekItems() error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read document start: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected JSON object, got %v", tok)
+	}
+	if err := s.readKeys(true); err != nil {
+		return err
+	}
+	if !s.inItems {
+		// Document without an items array
+		s.done = true
+	}
+	return nil
+}
+
+// readKeys consumes top-level keys, recording the ones we care about. When stopAtItems
+// is set it returns as soon as the decoder is positioned inside the items array.
+func (s *jsonStream) readKeys(stopAtItems bool) error {
+	for s.dec.More() {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return fmt.Errorf("failed to read key: %w", err)
+		}
+		key, _ := tok.(string)
+
+		switch {
+		case key == "items" && stopAtItems:
+			tok, err := s.dec.Token()
+			if err != nil {
+				return fmt.Errorf("failed to read items: %w", err)
+			}
+			if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+				if tok == nil {
+					// "items": null
+					continue
+				}
+				return fmt.Errorf("expected items array, got %v", tok)
+			}
+			s.inItems = true
+			return nil
+		case key == "apiVersion":
+			if err := s.dec.Decode(&s.APIVersion); err != nil {
+				return fmt.Errorf("failed to decode apiVersion: %w", err)
+			}
+		case key == "kind":
+			if err := s.dec.Decode(&s.Kind); err != nil {
+				return fmt.Errorf("failed to decode kind: %w", err)
+			}
+		default:
+			var skip json.RawMessage
+			if err := s.dec.Decode(&skip); err != nil {
+				return fmt.Errorf("failed to skip %q: %w", key, err)
+			}
+		}
+	}
+	if !stopAtItems || !s.inItems {
+		// Closing brace of the document
+		if _, err := s.dec.Token(); err != nil {
+			return fmt.Errorf("failed to read document end: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Bytes of a message reserved for its key, headers and record framing
+const kafkaMessageOverhead = 1024
+
+// reportStream produces one Kafka message per collected report item (KAFKA_BROKERS)
+type reportStream struct {
+	writer *kafka.Writer
+	cfg    Config
+}
+
+// reportEvents is the stream of the collection loop, nil without KAFKA_BROKERS
+var reportEvents *reportStream
+
+func newReportStream(cfg Config) (*reportStream, error) {
+	transport := &kafka.Transport{}
+	if cfg.KafkaTLS {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.KafkaCAFile != "" {
+			pem, err := os.ReadFile(cfg.KafkaCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read KAFKA_CA_FILE: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in KAFKA_CA_FILE")
+			}
+		}
+		transport.TLS = tlsConfig
+	}
+	mechanism, err := kafkaSASLMechanism(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.SASL = mechanism
+
+	var brokers []string
+	for _, b := range strings.Split(cfg.KafkaBrokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	return &reportStream{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        cfg.KafkaTopic,
+			Balancer:     &kafka.Hash{}, // Updates of a report stay in order on one partition
+			RequiredAcks: kafka.RequireAll,
+			BatchBytes:   int64(cfg.KafkaMaxMessageBytes),
+			BatchSize:    cfg.KafkaBatchSize,
+			BatchTimeout: 10 * time.Millisecond, // flush sends complete batches itself
+			Transport:    transport,
+		},
+		cfg: cfg,
+	}, nil
+}
+
+// kafkaSASLMechanism returns the KAFKA_SASL_MECHANISM, nil when SASL is not used
+func kafkaSASLMechanism(cfg Config) (sasl.Mechanism, error) {
+	switch strings.ToLower(cfg.KafkaSASLMechanism) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: cfg.KafkaUsername, Password: cfg.KafkaPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.KafkaUsername, cfg.KafkaPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.KafkaUsername, cfg.KafkaPassword)
+	default:
+		return nil, fmt.Errorf("unsupported KAFKA_SASL_MECHANISM %q (plain, scram-sha-256 or scram-sha-512)", cfg.KafkaSASLMechanism)
+	}
+}
+
+func (s *reportStream) Close() error {
+	return s.writer.Close()
+}
+
+// producer returns the producer of a report type, or nil without a stream
+func (s *reportStream) producer(resource ReportResource, collectedAt string) *reportProducer {
+	if s == nil {
+		return nil
+	}
+	return &reportProducer{stream: s, kind: resource.Kind, collectedAt: collectedAt}
+}
+
+// reportProducer sends the items of one report type as the pagination loop produces them,
+// holding at most one batch of messages
+type reportProducer struct {
+	stream      *reportStream
+	kind        string
+	collectedAt string
+	pending     []kafka.Message
+
+	// Values produced this cycle by key. Once the list restarts, items produced by the
+	// abandoned pass are only produced again when they changed.
+	sent      map[string]uint64
+	restarted bool
+
+	produced int
+	failed   int
+	skipped  int
+}
+
+// add queues an encoded item. Items over KAFKA_MAX_MESSAGE_BYTES are replaced by the
+// truncated stub used for MAX_ITEM_BYTES.
+func (p *reportProducer) add(ctx context.Context, namespace, name string, obj map[string]interface{}, encoded []byte) {
+	key := p.stream.cfg.ClusterName + "/" + namespace + "/" + name
+	if len(encoded) > p.stream.cfg.KafkaMaxMessageBytes-kafkaMessageOverhead {
+		stub, err := oversizedStub(obj, len(encoded))
+		if err != nil {
+			p.failed++
+			log.Printf("⚠️ Skipping Kafka message for %s: %v", key, err)
+			return
+		}
+		log.Printf("⚠️ %s %s is %d bytes (Kafka limit %d), producing a truncated stub", p.kind, key, len(encoded), p.stream.cfg.KafkaMaxMessageBytes)
+		encoded = stub
+	}
+	if sum, ok := p.sent[key]; p.restarted && ok && sum == valueSum(encoded) {
+		p.skipped++
+		return
+	}
+
+	p.pending = append(p.pending, kafka.Message{
+		Key:   []byte(key),
+		Value: append([]byte(nil), encoded...),
+		Headers: []kafka.Header{
+			{Key: "kind", Value: []byte(p.kind)},
+			{Key: "collectedAt", Value: []byte(p.collectedAt)},
+		},
+	})
+	if len(p.pending) >= p.stream.cfg.KafkaBatchSize {
+		p.flush(ctx)
+	}
+}
+
+func (p *reportProducer) flush(ctx context.Context) {
+	if len(p.pending) == 0 {
+		return
+	}
+	messages := p.pending
+	p.pending = nil
+
+	err := p.stream.writer.WriteMessages(ctx, messages...)
+	var writeErrs kafka.WriteErrors
+	switch {
+	case err == nil:
+		p.produced += len(messages)
+		p.record(messages, nil)
+	case errors.As(err, &writeErrs):
+		p.failed += writeErrs.Count()
+		p.produced += len(messages) - writeErrs.Count()
+		p.record(messages, writeErrs)
+		log.Printf("⚠️ Failed to produce %d of %d %s messages: %v", writeErrs.Count(), len(messages), p.kind, err)
+	default:
+		p.failed += len(messages)
+		log.Printf("⚠️ Failed to produce %d %s messages: %v", len(messages), p.kind, err)
+	}
+}
+
+// record remembers the values of the messages that were produced, see restart
+func (p *reportProducer) record(messages []kafka.Message, writeErrs kafka.WriteErrors) {
+	if p.stream.cfg.ListRestarts == 0 {
+		return
+	}
+	if p.sent == nil {
+		p.sent = make(map[string]uint64)
+	}
+	for i, m := range messages {
+		if writeErrs == nil || writeErrs[i] == nil {
+			p.sent[string(m.Key)] = valueSum(m.Value)
+		}
+	}
+}
+
+// restart drops the queued messages when the list of the report type starts over. Messages
+// cannot be taken back, so unchanged items of the abandoned pass are not produced twice.
+func (p *reportProducer) restart() {
+	if p == nil {
+		return
+	}
+	p.pending = nil
+	p.restarted = true
+}
+
+func valueSum(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value)
+	return h.Sum64()
+}
+
+// finish sends the remaining messages and returns the produced and failed counts
+func (p *reportProducer) finish(ctx context.Context) (produced, failed int) {
+	p.flush(ctx)
+	log.Printf("📨 Produced %d %s messages to %s (%d failed)", p.produced, p.kind, p.stream.cfg.KafkaTopic, p.failed)
+	if p.skipped > 0 {
+		log.Printf("⏭️ Skipped %d unchanged %s messages produced before the list restarted", p.skipped, p.kind)
+	}
+	return p.produced, p.failed
+}
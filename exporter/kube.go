@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeconfigFromArgs removes --kubeconfig=<path> from the arguments
+func kubeconfigFromArgs(args []string) (string, []string) {
+	path := ""
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.HasPrefix(args[i], "--kubeconfig="):
+			path = strings.TrimPrefix(args[i], "--kubeconfig=")
+		case args[i] == "--kubeconfig" && i+1 < len(args):
+			path = args[i+1]
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return path, rest
+}
+
+// Characters of a kubeconfig context that cannot be used in file names and object keys
+var unsafeClusterNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// clusterNameFromContext turns a context name such as
+// arn:aws:eks:eu-west-1:123456789012:cluster/prod into a CLUSTER_NAME
+func clusterNameFromContext(context string) string {
+	return strings.Trim(unsafeClusterNameChars.ReplaceAllString(context, "-"), "-.")
+}
+
+// kubeRESTConfig returns the configuration of the in-cluster service account. Outside a
+// pod, or with --kubeconfig or KUBE_CONTEXT, the kubeconfig is loaded like kubectl does
+// from the flag, KUBECONFIG or ~/.kube/config. Without CLUSTER_NAME the cluster is then
+// named after the kubeconfig context.
+func kubeRESTConfig(cfg *Config, kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" && cfg.KubeContext == "" {
+		k8sConfig, err := rest.InClusterConfig()
+		if !errors.Is(err, rest.ErrNotInCluster) {
+			if err != nil {
+				return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
+			}
+			return k8sConfig, nil
+		}
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cfg.KubeContext}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	k8sConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	raw, err := clientConfig.RawConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	context := raw.CurrentContext
+	if cfg.KubeContext != "" {
+		context = cfg.KubeContext
+	}
+	log.Printf("🔑 Using kubeconfig context %q (server %s)", context, k8sConfig.Host)
+
+	if os.Getenv("CLUSTER_NAME") == "" {
+		name := clusterNameFromContext(context)
+		if name == "" {
+			return nil, fmt.Errorf("cannot name the cluster after kubeconfig context %q, set CLUSTER_NAME", context)
+		}
+		cfg.ClusterName = name
+		if os.Getenv("AWS_ROLE_SESSION_NAME") == "" {
+			cfg.AWSRoleSessionName = "trivy-exporter-" + name
+		}
+		log.Printf("🏷️ CLUSTER_NAME not set, naming the cluster %s after the kubeconfig context", name)
+	}
+	return k8sConfig, nil
+}
+
+// isRetryableK8sError retries throttled (429) and timed out requests, transient API server
+// errors such as an etcd leader change, and network failures. Errors such as a forbidden
+// LIST, a missing CRD or an expired continue token fail fast.
+func isRetryableK8sError(err error) bool {
+	switch {
+	case apierrors.IsTooManyRequests(err), apierrors.IsServerTimeout(err), apierrors.IsTimeout(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return true
+	case utilnet.IsConnectionReset(err), utilnet.IsProbableEOF(err):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
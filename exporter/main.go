@@ -3,36 +3,263 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"mime"
 	"os"
 	"os/signal"
 	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
 )
 
 // Configuration from environment variables
 type Config struct {
 	ClusterName  string
+	KubeContext  string // Context of the kubeconfig used out of cluster
 	S3Bucket     string
 	S3Prefix     string
+	GCSBucket    string // Optional: upload to Google Cloud Storage
+	GCSPrefix    string
 	SyncInterval time.Duration
 	AWSRegion    string
 	PageSize     int
 	FSOutputDir  string // Optional: write to local filesystem
+	FSOutputGzip bool   // Write report files to FS_OUTPUT_DIR as .json.gz
+	FSLayout     string // flat: <cluster>-<file>, nested: <cluster>/<file>
+	FSFileMode   string // Octal permissions of the files written to FS_OUTPUT_DIR
+	FSDirMode    string // Octal permissions of the directories created in FS_OUTPUT_DIR
+	FSFsync      bool   // Sync files and directories to disk before moving on
+	FSChown      string // Optional: uid:gid of the files and directories, for exporters running as root
+	// Optional: keep this many timestamped generations of each report file behind its name
+	FSGenerations int
+	// Optional: lock <cluster>.lock while writing, for readers taking a shared lock
+	FSLocking     bool
+	FSLockTimeout time.Duration
+
+	// Optional: S3-compatible endpoint such as MinIO
+	S3Endpoint       string
+	S3ForcePathStyle bool
+	S3DisableSSL     bool
+	// Optional: private CA of S3_ENDPOINT, and a last resort for self-signed endpoints
+	S3CABundle           string
+	S3InsecureSkipVerify bool
+
+	// Optional: server-side encryption of S3 uploads (AES256 or aws:kms)
+	S3SSE      string
+	S3KMSKeyID string
+
+	S3StorageClass string            // Optional: storage class of uploaded objects, e.g. STANDARD_IA
+	S3Tags         map[string]string // Extra object tags; cluster, report-type and collected-at are always set
+	// Optional: S3_PREFIX_<TYPE> per report type, e.g. a restricted prefix for exposed secrets
+	S3PrefixOverrides map[string]string
+
+	// Optional: HTTP headers stored with uploaded objects
+	S3CacheControl       string
+	S3IndexCacheControl  string // index.json and index-delta.json, defaults to S3CacheControl
+	S3ContentDisposition string // inline or attachment, for report files
+	S3ContentTypes       map[string]string
+
+	// Multipart uploads of large report files
+	S3PartSizeMB        int
+	S3UploadConcurrency int
+	S3ProgressParts     int // Log progress every N parts, 0 to disable
+
+	CompressUploads string // Optional: gzip report files uploaded to S3 (Content-Encoding)
+
+	// Optional: role assumed for S3, e.g. a writer role in the account of a central bucket
+	AWSRoleARN         string
+	AWSExternalID      string
+	AWSRoleSessionName string
+
+	SkipStartupChecks bool // Do not verify output access before the first cycle
+	S3PreflightWrite  bool // Also write and remove a probe object during the check
+	S3VerifyUploads   bool // HeadObject every upload and compare it with what was sent
+	S3RequireLength   bool // Compress uploads to temp files for stores rejecting uploads without a length
+
+	// Optional: CloudFront distribution serving S3_BUCKET, invalidated after each cycle
+	CloudFrontDistributionID string
+
+	// Optional: POST artifacts to a remote dashboard API
+	PushURL     string
+	PushToken   string
+	PushGzip    bool
+	PushTimeout time.Duration
+	// Chunks of resumable pushes, 0 sends every artifact in a single request
+	PushChunkSize int
+
+	// Optional: commit report files to a git repository
+	GitRepoURL        string
+	GitCheckoutDir    string
+	GitBranch         string
+	GitPath           string
+	GitSSHKeyFile     string
+	GitKnownHostsFile string
+	GitSkipHostKey    bool // Accept any SSH host key; only for remotes whose key cannot be pinned
+	GitTokenFile      string
+	GitAuthorName     string
+	GitAuthorEmail    string
+
+	// Optional: push report bundles to an OCI registry
+	OCIRepository   string
+	OCIUsername     string
+	OCIPassword     string
+	OCIDockerConfig string
+	OCIPlainHTTP    bool
+
+	DatabaseURL string // Optional: load findings into PostgreSQL
+
+	// Optional: bulk-index findings into OpenSearch or Elasticsearch
+	OpenSearchURL       string
+	OpenSearchIndex     string
+	OpenSearchUsername  string
+	OpenSearchPassword  string
+	OpenSearchAPIKey    string
+	OpenSearchBatchSize int
+	OpenSearchTimeout   time.Duration
+
+	// Optional: produce every report item to Kafka
+	KafkaBrokers         string
+	KafkaTopic           string
+	KafkaSASLMechanism   string
+	KafkaUsername        string
+	KafkaPassword        string
+	KafkaTLS             bool
+	KafkaCAFile          string
+	KafkaMaxMessageBytes int
+	KafkaBatchSize       int
+
+	// Optional: upload to Azure Blob Storage
+	AzureStorageAccount   string
+	AzureConnectionString string
+	AzureContainer        string
+	AzurePrefix           string
+
+	FSProbeInterval time.Duration // How often FS_OUTPUT_DIR is probed between cycles
+
+	ReconcileSummaries bool // Rewrite report.summary counts that disagree with the findings
+
+	NormalizeTimestamps bool // Rewrite timestamps inside items as RFC3339 UTC
+
+	NamespaceLimits         NamespaceLimits            // Global per-namespace item quotas per report type
+	NamespaceLimitOverrides map[string]NamespaceLimits // Per-namespace quotas
+	NamespacesInclude       []string                   // Globs of the namespaces whose namespaced reports are collected
+	NamespacesExclude       []string                   // Globs of the namespaces whose namespaced reports are dropped
+
+	StrictEncoding string // sanitize: repair invalid strings in items; fail: skip such items
+
+	DestructiveOps string // allow, or deny to skip every deletion of published artifacts
+
+	ExportParquet bool // Publish the findings of each cycle as findings.parquet
+
+	GRPCAddr       string // Serve the Findings gRPC service on this address when set
+	GRPCTLSCert    string // TLS certificate and key of the gRPC server
+	GRPCTLSKey     string
+	GRPCToken      string // Bearer token gRPC clients must send
+	GRPCBufferSize int    // Events buffered per subscriber before the oldest are dropped
+
+	CrossCheckOperatorMetrics bool    // Compare vulnerability totals with the operator's metrics
+	OperatorMetricsURL        string  // trivy-operator metrics endpoint
+	CrossCheckTolerance       float64 // Allowed relative difference per severity
+
+	AutoPageSize      bool // Derive the LIST limit per resource from observed item sizes
+	PageMemoryBudget  int  // Target encoded size of one page in MB when AutoPageSize is set
+	FixedPageSize     bool // PAGE_SIZE was set explicitly, AutoPageSize leaves it alone
+	FixedSBOMPageSize bool // SBOM_PAGE_SIZE was set explicitly, AutoPageSize leaves it alone
+
+	K8sQPS       float64       // Client-side rate limit of requests to the API server
+	K8sBurst     int           // Requests allowed above K8sQPS in bursts
+	PageDelay    time.Duration // Pause between LIST pages
+	ListRestarts int           // Restarts of a list whose continue token expired
+
+	CollectionMode   string        // poll lists every cycle, watch keeps informer caches
+	WatchSyncTimeout time.Duration // Wait for an informer cache before listing instead
+	WatchMaxFailures int           // Consecutive watch errors before a type is listed again
+	WatchPruneFields []string      // Field paths dropped from cached objects
+
+	WatchFeed              bool          // Append the changes the informers observe to feed/<date>.ndjson
+	WatchFeedFlushInterval time.Duration // How often queued feed events are appended
+	WatchFeedRetention     time.Duration // Feed files older than this are deleted, 0 keeps all
+
+	FreshnessSLO time.Duration // Maximum acceptable age of exported scan results
+
+	TempDir       string // Report files are staged in a per-run directory below it
+	TempMinFreeMB int    // Report types are skipped while the temp directory has less free space
+
+	// Publish nothing for report types whose CRD is missing instead of an empty report file
+	SkipMissingResources bool
+
+	MaxItemBytes   int  // Items encoding larger than this are replaced by a stub
+	StoreOversized bool // Keep oversized items as overflow/<uid>.json.gz artifacts
+
+	ForceUpload bool   // Upload report files even when their content did not change
+	UploadMode  string // files, or bundle to publish the report files as bundle.tar.gz
+	// Report types listed in parallel, each streaming to its own temp file
+	CollectConcurrency int
+	// Report files published in parallel while the next report type is collected
+	UploadConcurrency int
+	// Optional: aggregate rate of uploads to remote outputs, e.g. 5MBps or 20Mbps
+	UploadBandwidthLimit string
+
+	EnableSnapshots     bool          // Also keep every cycle's reports under snapshots/<timestamp>/
+	SnapshotRetention   time.Duration // Snapshots older than this are pruned, 0 keeps them
+	SnapshotKeepLast    int           // Snapshots beyond the newest N are pruned, 0 keeps them
+	FSRetention         time.Duration // Overrides SnapshotRetention for FS_OUTPUT_DIR
+	FSKeepLast          int           // Overrides SnapshotKeepLast for FS_OUTPUT_DIR
+	SnapshotPruneDryRun bool          // Log the snapshots that would be pruned instead
+
+	SpreadCollection bool // Give each resource its own slot within SYNC_INTERVAL
+
+	CycleBudget time.Duration // Optional phases are skipped when a cycle is about to exceed it
+
+	OwnerStaleAfter time.Duration // Ownership markers without a heartbeat for this long are taken over
+
+	StaleClusterCleanup bool          // Delete the files of other clusters that stopped exporting
+	StaleClusterMaxAge  time.Duration // A cluster whose newest file is older than this is stale
+	StaleClusterEvery   int           // The cleanup runs on every Nth cycle
+	StaleClusterDryRun  bool          // Log the files that would be deleted instead
+
+	TerminationLogPath string // Where the termination message is written on exit
+
+	Scope string // Which reports this deployment collects: all, cluster or namespaced
+
+	ReportTypes          []string // Report types to collect, every discovered one when empty
+	ReportTypesExclude   []string // Report types never collected
+	ReportDiscoveryEvery int      // Report CRDs are discovered on every Nth cycle, 0 disables discovery
+	EnableSBOMReports    bool     // Also collect sbomreports and clustersbomreports
+	SBOMPageSize         int      // LIST limit of the SBOM report types instead of PageSize
+
+	CustomResourcesFile string           // YAML or JSON list of CRDs of other scanners to collect
+	CustomResources     []ReportResource // Parsed from CustomResourcesFile by loadConfig
+
+	Retry           RetryPolicy            // Global RETRY_* policy
+	RetryPolicies   map[string]RetryPolicy // Per-component RETRY_<COMPONENT>_* overrides
+	RetryPolicyFile string                 // YAML or JSON of both, applied by loadConfig below the variables
 }
 
 // ReportResource defines the K8s resource to collect
@@ -40,19 +267,124 @@ type ReportResource struct {
 	Name     string // e.g., "vulnerabilityreports"
 	Kind     string // e.g., "VulnerabilityReport"
 	FileName string // JSON filename prefix, e.g., "vulnerability-reports"
+	Priority int    // Higher priorities are collected first
+
+	ClusterScoped bool // Cluster-scoped reports are collected by SCOPE=cluster
+	SBOM          bool // Collected with ENABLE_SBOM_REPORTS and listed in pages of SBOM_PAGE_SIZE
+
+	// Group, version and resource of CUSTOM_RESOURCES_FILE types; Name is <resource>.<group>
+	Group, Version, Resource string
+}
+
+// custom reports whether the resource was declared in CUSTOM_RESOURCES_FILE
+func (r ReportResource) custom() bool {
+	return r.Version != ""
+}
+
+// Collection priorities: the data the dashboard leads with is refreshed first, so a cycle
+// interrupted by a rollout or timeout still updates it
+const (
+	priorityCritical = 100
+	priorityNormal   = 50
+	priorityLow      = 10
+)
+
+// Report types known to the exporter; the CRDs of the cluster are discovered at startup, see
+// discovery.go. SBOM reports are only collected with ENABLE_SBOM_REPORTS, as their items are
+// an order of magnitude larger than those of the other report types.
+var knownReportResources = []ReportResource{
+	{Name: "vulnerabilityreports", Kind: "VulnerabilityReport", FileName: "vulnerability-reports", Priority: priorityCritical},
+	{Name: "configauditreports", Kind: "ConfigAuditReport", FileName: "config-audit-reports", Priority: priorityNormal},
+	{Name: "clusterconfigauditreports", Kind: "ClusterConfigAuditReport", FileName: "cluster-config-audit-reports", Priority: priorityLow, ClusterScoped: true},
+	{Name: "clusterrbacassessmentreports", Kind: "ClusterRbacAssessmentReport", FileName: "cluster-rbac-assessment-reports", Priority: priorityLow, ClusterScoped: true},
+	{Name: "exposedsecretreports", Kind: "ExposedSecretReport", FileName: "exposed-secret-reports", Priority: priorityCritical},
+	{Name: "clustercompliancereports", Kind: "ClusterComplianceReport", FileName: "cluster-compliance-reports", Priority: priorityNormal, ClusterScoped: true},
+	{Name: "clustervulnerabilityreports", Kind: "ClusterVulnerabilityReport", FileName: "cluster-vulnerability-reports", Priority: priorityNormal, ClusterScoped: true},
+	{Name: "rbacassessmentreports", Kind: "RbacAssessmentReport", FileName: "rbac-assessment-reports", Priority: priorityLow},
+	{Name: "infraassessmentreports", Kind: "InfraAssessmentReport", FileName: "infra-assessment-reports", Priority: priorityLow},
+	{Name: "clusterinfraassessmentreports", Kind: "ClusterInfraAssessmentReport", FileName: "cluster-infra-assessment-reports", Priority: priorityLow, ClusterScoped: true},
+	{Name: "sbomreports", Kind: "SbomReport", FileName: "sbom-reports", Priority: priorityLow, SBOM: true},
+	{Name: "clustersbomreports", Kind: "ClusterSbomReport", FileName: "cluster-sbom-reports", Priority: priorityLow, ClusterScoped: true, SBOM: true},
+}
+
+// Resources to collect, the discovered report types once the exporter runs
+var reportResources = knownReportResources
+
+// ResourceStats holds per-resource statistics of a collection cycle
+type ResourceStats struct {
+	Items        int `json:"items"`
+	PageSize     int `json:"pageSize"`
+	ListCalls    int `json:"listCalls"`              // LIST requests made, one per page and retry
+	ListRetries  int `json:"listRetries,omitempty"`  // LIST requests retried under RETRY_KUBERNETES_*
+	ListRestarts int `json:"listRestarts,omitempty"` // Lists restarted after their continue token expired
+	// Retries of the calls made for the report type by component, such as its LIST requests
+	// and the uploads of its report file to each sink
+	Retries map[string]int `json:"retries,omitempty"`
+	// Items were served from the informer cache of COLLECTION_MODE=watch without LIST requests
+	Watched              bool `json:"watched,omitempty"`
+	SummaryDiscrepancies int  `json:"summaryDiscrepancies,omitempty"`
+	OversizedItems       int  `json:"oversizedItems,omitempty"`
+	TimestampParseErrors int  `json:"timestampParseErrors,omitempty"`
+	SanitizedFields      int  `json:"sanitizedFields,omitempty"`
+	InvalidEncodingItems int  `json:"invalidEncodingItems,omitempty"`
+	IndexingFailures     int  `json:"indexingFailures,omitempty"` // Findings OpenSearch rejected
+	KafkaProduced        int  `json:"kafkaProduced,omitempty"`
+	KafkaFailed          int  `json:"kafkaFailed,omitempty"`
+
+	// SHA-256 of the report file; unchanged files are not uploaded again
+	Digest string `json:"digest,omitempty"`
+	// Whether the report file was also written to the snapshot of the cycle
+	Snapshot bool `json:"snapshot,omitempty"`
+	// Time spent publishing the report file, its checksum and snapshot
+	UploadDurationMs int64 `json:"uploadDurationMs,omitempty"`
+	// Size of the report object on S3 as verified after its last upload (S3_VERIFY_UPLOADS)
+	VerifiedSize int64 `json:"verifiedSize,omitempty"`
+	// How the report file was last sent to S3, with the size and SHA-256 of the object
+	S3Upload *S3Upload `json:"s3Upload,omitempty"`
+	// Sinks the report file was not uploaded to this cycle, with the reason code
+	SkippedUploads map[string]string `json:"skippedUploads,omitempty"`
+	// The CRD of the report type is not installed; its report file has no items
+	Unavailable bool `json:"unavailable,omitempty"`
+	// Size of the temp file the report was streamed to, and the largest since the exporter
+	// started, for sizing the temp directory
+	TempFileBytes     int64 `json:"tempFileBytes,omitempty"`
+	PeakTempFileBytes int64 `json:"peakTempFileBytes,omitempty"`
+
+	// NAMESPACES_INCLUDE and NAMESPACES_EXCLUDE: items dropped from a cluster-wide list, and
+	// the namespaces listed one by one for an include list
+	FilteredItems    int `json:"filteredItems,omitempty"`
+	ListedNamespaces int `json:"listedNamespaces,omitempty"`
+
+	// Namespace quotas: items omitted past the hard limit, per namespace, and the
+	// namespaces over their soft limit
+	OmittedItems        int            `json:"omittedItems,omitempty"`
+	TruncatedNamespaces map[string]int `json:"truncatedNamespaces,omitempty"`
+	SoftLimitNamespaces []string       `json:"softLimitNamespaces,omitempty"`
+
+	// Items per detected report shape, see shapes.go
+	Shapes map[string]int `json:"shapes,omitempty"`
+
+	discrepancySamples []SummaryDiscrepancy
+
+	// Findings per severity, kept for the operator metrics cross-check
+	severityTotals map[string]int
+
+	// Exported items per namespace, counted when quotas are configured
+	namespaceItems map[string]int
+
+	// Freshness inputs, see buildFreshness
+	newestUpdate time.Time
+	oldestUpdate time.Time
+	collectedAt  time.Time
+	uploadedAt   time.Time
 }
 
-// List of resources to collect
-// Note: SBOM reports (sbomreports, clustersbomreports) are disabled to reduce storage and improve performance
-var reportResources = []ReportResource{
-	{Name: "vulnerabilityreports", Kind: "VulnerabilityReport", FileName: "vulnerability-reports"},
-	{Name: "configauditreports", Kind: "ConfigAuditReport", FileName: "config-audit-reports"},
-	{Name: "clusterconfigauditreports", Kind: "ClusterConfigAuditReport", FileName: "cluster-config-audit-reports"},
-	{Name: "clusterrbacassessmentreports", Kind: "ClusterRbacAssessmentReport", FileName: "cluster-rbac-assessment-reports"},
-	{Name: "exposedsecretreports", Kind: "ExposedSecretReport", FileName: "exposed-secret-reports"},
-	{Name: "clustercompliancereports", Kind: "ClusterComplianceReport", FileName: "cluster-compliance-reports"},
-	{Name: "clustervulnerabilityreports", Kind: "ClusterVulnerabilityReport", FileName: "cluster-vulnerability-reports"},
-	{Name: "rbacassessmentreports", Kind: "RbacAssessmentReport", FileName: "rbac-assessment-reports"},
+// Diagnostics is published as diagnostics.json for upstream bug reports
+type Diagnostics struct {
+	Cluster              string               `json:"cluster"`
+	GeneratedAt          string               `json:"generatedAt"`
+	SummaryDiscrepancies []SummaryDiscrepancy `json:"summaryDiscrepancies"`
+	OperatorCrossCheck   *OperatorCrossCheck  `json:"operatorCrossCheck,omitempty"`
 }
 
 // CollectionMetadata represents metadata about a collection run
@@ -65,44 +397,148 @@ type CollectionMetadata struct {
 }
 
 func main() {
+	// Subcommands; without one the exporter runs its collection loop.
+	// Failures exit with the codes in exitcodes.go.
+	errorFormat, args := errorFormatFromArgs(os.Args[1:])
+	kubeconfig, args := kubeconfigFromArgs(args)
+	if len(args) > 0 {
+		switch args[0] {
+		case "compare":
+			if err := runCompare(args[1:]); err != nil {
+				exitWithError(errorFormat, "Compare", err)
+			}
+			return
+		case "simulate":
+			if err := runSimulate(args[1:]); err != nil {
+				exitWithError(errorFormat, "Simulate", err)
+			}
+			return
+		}
+	}
+
 	log.Println("🚀 Starting Trivy Exporter (Optimized v3 - PVC)...")
 
 	// Load configuration
-	cfg := loadConfig()
+	// Fatal errors of the collection loop leave a termination message before exiting, as
+	// os.Exit skips deferred cleanup
+	cfg, err := loadConfig()
+	fatal := func(err error) {
+		writeTerminationMessage(cfg.TerminationLogPath, err)
+		exitWithError(errorFormat, "Startup", err)
+	}
+	if err != nil {
+		fatal(configError(err))
+	}
+
+	// Create Kubernetes client; out of cluster it may also name the cluster
+	k8sConfig, err := kubeRESTConfig(&cfg, kubeconfig)
+	if err != nil {
+		fatal(k8sError(err))
+	}
+	k8sConfig.QPS = float32(cfg.K8sQPS)
+	k8sConfig.Burst = cfg.K8sBurst
+	log.Printf("🚦 API server requests limited to %v/s with bursts of %d, %v between LIST pages", cfg.K8sQPS, cfg.K8sBurst, cfg.PageDelay)
 	log.Printf("📋 Configuration: cluster=%s, bucket=%s, interval=%v, pageSize=%d, fsDir=%s",
 		cfg.ClusterName, cfg.S3Bucket, cfg.SyncInterval, cfg.PageSize, cfg.FSOutputDir)
 
-	// Create Kubernetes client
-	k8sConfig, err := rest.InClusterConfig()
+	dynamicClient, err := dynamic.NewForConfig(k8sConfig)
 	if err != nil {
-		log.Fatalf("❌ Failed to get in-cluster config: %v", err)
+		fatal(k8sError(fmt.Errorf("failed to create Kubernetes client: %w", err)))
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(k8sConfig)
+	if err != nil {
+		fatal(k8sError(fmt.Errorf("failed to create discovery client: %w", err)))
 	}
 
-	dynamicClient, err := dynamic.NewForConfig(k8sConfig)
+	// Report types: the known and custom ones allowed by REPORT_TYPES/REPORT_TYPES_EXCLUDE
+	// until the CRDs of the cluster are discovered
+	availableReportResources = baseReportResources(cfg)
+	reportResources = allowedReportResources(availableReportResources, cfg)
+	refreshReportResources(discoveryClient, cfg)
+	if err := validateReportTypes(cfg, availableReportResources); err != nil {
+		fatal(configError(err))
+	}
+	if cfg.CollectionMode == collectionWatch {
+		reportWatches = newReportWatcher(dynamicClient, cfg)
+		log.Printf("👀 Watching report types; cycles publish the informer caches (pruned fields: %s)", strings.Join(cfg.WatchPruneFields, ", "))
+	}
+
+	// Report files are staged under TEMP_DIR until they are published
+	removeStagingDir, err := setupStagingDir(cfg)
 	if err != nil {
-		log.Fatalf("❌ Failed to create Kubernetes client: %v", err)
+		fatal(storageError(err))
 	}
+	defer removeStagingDir()
 
-	// Create AWS S3 client only if Bucket is provided
-	var s3Client *s3.Client
-	if cfg.S3Bucket != "" {
-		awsCfg, err := config.LoadDefaultConfig(context.Background(),
-			config.WithRegion(cfg.AWSRegion),
-		)
+	// Outputs: FS_OUTPUT_DIR and every configured object store
+	sinks, err := newSinks(context.Background(), cfg)
+	if err != nil {
+		fatal(storageError(err))
+	}
+	defer closeSinks(sinks)
+	if err := preflightSinks(context.Background(), sinks, cfg); err != nil {
+		fatal(storageError(err))
+	}
+	if cfg.DatabaseURL != "" {
+		db, err := openFindingsDB(context.Background(), cfg)
 		if err != nil {
-			log.Fatalf("❌ Failed to load AWS config: %v", err)
+			fatal(storageError(err))
 		}
-		s3Client = s3.NewFromConfig(awsCfg)
-	} else {
+		defer db.Close()
+		findingsStore = db
+	}
+	if cfg.OpenSearchURL != "" {
+		findingsSearch = newSearchIndex(cfg)
+	}
+	if cfg.KafkaBrokers != "" {
+		stream, err := newReportStream(cfg)
+		if err != nil {
+			fatal(configError(err))
+		}
+		defer stream.Close()
+		reportEvents = stream
+	}
+	if limit, _ := parseBandwidth(cfg.UploadBandwidthLimit); limit > 0 {
+		uploadBandwidth = newBandwidthLimiter(limit)
+		log.Printf("📶 Uploads limited to %s", formatBandwidth(float64(limit)))
+	}
+	if cfg.S3Bucket == "" {
 		log.Println("ℹ️ S3_BUCKET not set. S3 upload disabled.")
 	}
+	if cfg.WatchFeed {
+		feed, err := newWatchFeed(context.Background(), sinks, cfg)
+		if err != nil {
+			fatal(storageError(err))
+		}
+		reportFeed = feed
+	}
 
 	// Prepare output directory if needed
 	if cfg.FSOutputDir != "" {
-		if err := os.MkdirAll(fmt.Sprintf("%s/%s", cfg.FSOutputDir, cfg.ClusterName), 0755); err != nil {
-			log.Fatalf("❌ Failed to create output directory: %v", err)
+		if err := fsFileOptions(cfg).mkdirAll(fsClusterDir(cfg)); err != nil {
+			fatal(storageError(fmt.Errorf("failed to create output directory: %w", err)))
 		}
 	}
+	checkOutputDirOwnership(cfg)
+
+	// Effective settings for support, independent of the Helm values used to deploy
+	runtimeConfig := buildRuntimeConfig(cfg)
+	if data, err := json.Marshal(runtimeConfig); err == nil {
+		log.Printf("🧾 Runtime config: %s", data)
+	}
+	if err := publishJSON(context.Background(), sinks, cfg, "runtime-config.json", runtimeConfig); err != nil {
+		log.Printf("⚠️ Failed to publish runtime config: %v", err)
+	}
+
+	// Optional push-style delivery of the findings to internal consumers
+	if cfg.GRPCAddr != "" {
+		findingsFeed = newFindingsBroker(cfg.GRPCBufferSize)
+		grpcServer, err := startFindingsServer(cfg, findingsFeed)
+		if err != nil {
+			fatal(configError(err))
+		}
+		defer grpcServer.Stop()
+	}
 
 	// Set up signal handling for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -110,12 +546,29 @@ func main() {
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		log.Printf("📤 Received signal %v, shutting down...", sig)
+		close(shutdownRequested)
+	}()
+
+	if cfg.FSOutputDir != "" && cfg.FSProbeInterval > 0 {
+		go runFSProbe(ctx, cfg)
+	}
+	go reportFeed.run(ctx)
+	defer reportFeed.close()
 
 	// Run initial collection
 	log.Println("🔄 Running initial collection...")
-	if err := collectAndUploadAll(ctx, dynamicClient, s3Client, cfg); err != nil {
+	if cfg.SpreadCollection {
+		log.Printf("🕐 Spreading collection across %v", cfg.SyncInterval)
+	}
+	err = collectAndUploadAll(ctx, dynamicClient, sinks, cfg)
+	recordCycle(err)
+	if err != nil {
 		log.Printf("⚠️ Initial collection failed: %v", err)
 	}
+	checkOutputDirOwnership(cfg)
 
 	// Start periodic collection
 	ticker := time.NewTicker(cfg.SyncInterval)
@@ -127,35 +580,488 @@ func main() {
 		select {
 		case <-ticker.C:
 			log.Println("🔄 Running scheduled collection...")
-			if err := collectAndUploadAll(ctx, dynamicClient, s3Client, cfg); err != nil {
+			refreshReportResources(discoveryClient, cfg)
+			err := collectAndUploadAll(ctx, dynamicClient, sinks, cfg)
+			recordCycle(err)
+			if err != nil {
 				log.Printf("⚠️ Collection failed: %v", err)
 			}
-		case sig := <-sigCh:
-			log.Printf("📤 Received signal %v, shutting down...", sig)
+			checkOutputDirOwnership(cfg)
+		case <-shutdownRequested:
+			writeTerminationMessage(cfg.TerminationLogPath, nil)
 			return
 		case <-ctx.Done():
 			log.Println("📤 Context cancelled, shutting down...")
+			writeTerminationMessage(cfg.TerminationLogPath, nil)
 			return
 		}
 	}
 }
 
-func loadConfig() Config {
+func loadConfig() (Config, error) {
+	cfg := configFromEnv()
+
+	if cfg.S3Bucket == "" && cfg.GCSBucket == "" && cfg.AzureContainer == "" && cfg.PushURL == "" && cfg.GitCheckoutDir == "" && cfg.OCIRepository == "" && cfg.FSOutputDir == "" {
+		return cfg, fmt.Errorf("one of S3_BUCKET, GCS_BUCKET, AZURE_CONTAINER, PUSH_URL, GIT_REPO_URL, GIT_CHECKOUT_DIR, OCI_REPOSITORY or FS_OUTPUT_DIR environment variables is required")
+	}
+	if cfg.FSLayout != fsLayoutFlat && cfg.FSLayout != fsLayoutNested {
+		return cfg, fmt.Errorf("invalid FS_LAYOUT %q (valid: flat, nested)", cfg.FSLayout)
+	}
+	if err := validateFileOptions(cfg); err != nil {
+		return cfg, err
+	}
+	if err := validateNamespacePatterns("NAMESPACES_INCLUDE", cfg.NamespacesInclude); err != nil {
+		return cfg, err
+	}
+	if err := validateNamespacePatterns("NAMESPACES_EXCLUDE", cfg.NamespacesExclude); err != nil {
+		return cfg, err
+	}
+	custom, err := loadCustomResources(cfg.CustomResourcesFile)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.CustomResources = custom
+	retryFile, err := loadRetryPolicyFile(cfg.RetryPolicyFile)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Retry, cfg.RetryPolicies = loadRetryPolicies(retryFile)
+	for _, r := range knownReportResources {
+		if r.SBOM && !cfg.EnableSBOMReports && slices.Contains(cfg.ReportTypes, r.Name) {
+			return cfg, fmt.Errorf("REPORT_TYPES lists %s, which requires ENABLE_SBOM_REPORTS=true", r.Name)
+		}
+	}
+	if cfg.EnableSBOMReports && cfg.SBOMPageSize <= 0 {
+		return cfg, fmt.Errorf("invalid SBOM_PAGE_SIZE %d: must be positive", cfg.SBOMPageSize)
+	}
+	if cfg.K8sQPS <= 0 {
+		return cfg, fmt.Errorf("invalid K8S_QPS %v: must be positive", cfg.K8sQPS)
+	}
+	if cfg.K8sBurst < 1 {
+		return cfg, fmt.Errorf("invalid K8S_BURST %d: must be at least 1", cfg.K8sBurst)
+	}
+	if cfg.PageDelay < 0 {
+		return cfg, fmt.Errorf("invalid PAGE_DELAY %v: must not be negative", cfg.PageDelay)
+	}
+	if cfg.ListRestarts < 0 {
+		return cfg, fmt.Errorf("invalid LIST_RESTARTS %d: must not be negative", cfg.ListRestarts)
+	}
+	if cfg.CollectionMode != collectionPoll && cfg.CollectionMode != collectionWatch {
+		return cfg, fmt.Errorf("invalid COLLECTION_MODE %q (valid: poll, watch)", cfg.CollectionMode)
+	}
+	if cfg.CollectionMode == collectionWatch {
+		if cfg.WatchSyncTimeout <= 0 {
+			return cfg, fmt.Errorf("invalid WATCH_SYNC_TIMEOUT %v: must be positive", cfg.WatchSyncTimeout)
+		}
+		if cfg.WatchMaxFailures < 1 {
+			return cfg, fmt.Errorf("invalid WATCH_MAX_FAILURES %d: must be at least 1", cfg.WatchMaxFailures)
+		}
+	}
+	if cfg.WatchFeed {
+		if cfg.CollectionMode != collectionWatch || (cfg.FSOutputDir == "" && cfg.S3Bucket == "") {
+			return cfg, fmt.Errorf("WATCH_FEED requires COLLECTION_MODE=watch and FS_OUTPUT_DIR or S3_BUCKET")
+		}
+		if cfg.WatchFeedFlushInterval <= 0 {
+			return cfg, fmt.Errorf("invalid WATCH_FEED_FLUSH_INTERVAL %v: must be positive", cfg.WatchFeedFlushInterval)
+		}
+		if cfg.WatchFeedRetention < 0 {
+			return cfg, fmt.Errorf("invalid WATCH_FEED_RETENTION %v: must not be negative", cfg.WatchFeedRetention)
+		}
+	}
+	if cfg.ReportDiscoveryEvery < 0 {
+		return cfg, fmt.Errorf("invalid REPORT_DISCOVERY_EVERY %d: must not be negative", cfg.ReportDiscoveryEvery)
+	}
+	if cfg.FSGenerations < 0 {
+		return cfg, fmt.Errorf("invalid FS_GENERATIONS %d: must not be negative", cfg.FSGenerations)
+	}
+	if cfg.FSLocking && cfg.FSLockTimeout <= 0 {
+		return cfg, fmt.Errorf("invalid FS_LOCK_TIMEOUT %v: must be positive", cfg.FSLockTimeout)
+	}
+	if (cfg.FSRetention > 0 || cfg.FSKeepLast > 0) && (cfg.FSOutputDir == "" || !cfg.EnableSnapshots) {
+		return cfg, fmt.Errorf("FS_RETENTION and FS_KEEP_LAST require FS_OUTPUT_DIR and ENABLE_SNAPSHOTS=true")
+	}
+	if cfg.StaleClusterCleanup {
+		if cfg.StaleClusterMaxAge < minStaleClusterAge {
+			return cfg, fmt.Errorf("invalid STALE_CLUSTER_MAX_AGE %v: must be at least %v", cfg.StaleClusterMaxAge, minStaleClusterAge)
+		}
+		if cfg.StaleClusterEvery < 1 {
+			return cfg, fmt.Errorf("invalid STALE_CLUSTER_CLEANUP_EVERY %d: must be at least 1", cfg.StaleClusterEvery)
+		}
+		if cfg.FSOutputDir == "" && cfg.S3Bucket == "" {
+			return cfg, fmt.Errorf("STALE_CLUSTER_CLEANUP requires FS_OUTPUT_DIR or S3_BUCKET")
+		}
+	}
+	switch cfg.Scope {
+	case scopeAll, scopeCluster, scopeNamespaced:
+	default:
+		return cfg, fmt.Errorf("invalid SCOPE %q (valid: all, cluster, namespaced)", cfg.Scope)
+	}
+	switch types.ServerSideEncryption(cfg.S3SSE) {
+	case "", types.ServerSideEncryptionAes256:
+		if cfg.S3KMSKeyID != "" {
+			return cfg, fmt.Errorf("S3_KMS_KEY_ID requires S3_SSE=aws:kms")
+		}
+	case types.ServerSideEncryptionAwsKms:
+	default:
+		return cfg, fmt.Errorf("invalid S3_SSE %q (valid: AES256, aws:kms)", cfg.S3SSE)
+	}
+	if cfg.S3StorageClass != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(cfg.S3StorageClass)) {
+		var valid []string
+		for _, c := range types.StorageClass("").Values() {
+			valid = append(valid, string(c))
+		}
+		return cfg, fmt.Errorf("invalid S3_STORAGE_CLASS %q (valid: %s)", cfg.S3StorageClass, strings.Join(valid, ", "))
+	}
+	if int64(cfg.S3PartSizeMB)*1024*1024 < manager.MinUploadPartSize {
+		return cfg, fmt.Errorf("S3_PART_SIZE_MB must be at least 5, got %d", cfg.S3PartSizeMB)
+	}
+	if cfg.PushChunkSize < 0 {
+		return cfg, fmt.Errorf("PUSH_CHUNK_SIZE must not be negative, got %d", cfg.PushChunkSize)
+	}
+	if cfg.CollectConcurrency < 1 {
+		return cfg, fmt.Errorf("COLLECT_CONCURRENCY must be at least 1, got %d", cfg.CollectConcurrency)
+	}
+	if cfg.UploadConcurrency < 1 {
+		return cfg, fmt.Errorf("UPLOAD_CONCURRENCY must be at least 1, got %d", cfg.UploadConcurrency)
+	}
+	if _, err := parseBandwidth(cfg.UploadBandwidthLimit); err != nil {
+		return cfg, err
+	}
+	if cfg.S3UploadConcurrency < 1 {
+		return cfg, fmt.Errorf("S3_UPLOAD_CONCURRENCY must be at least 1, got %d", cfg.S3UploadConcurrency)
+	}
+	if cfg.GitSkipHostKey && cfg.GitKnownHostsFile != "" {
+		return cfg, fmt.Errorf("GIT_INSECURE_SKIP_HOST_KEY_CHECK cannot be combined with GIT_KNOWN_HOSTS_FILE")
+	}
+	if cfg.CloudFrontDistributionID != "" && cfg.S3Bucket == "" {
+		return cfg, fmt.Errorf("CLOUDFRONT_DISTRIBUTION_ID requires S3_BUCKET")
+	}
+	if cfg.CompressUploads != "" && cfg.CompressUploads != compressGzip {
+		return cfg, fmt.Errorf("invalid COMPRESS_UPLOADS %q (valid: gzip)", cfg.CompressUploads)
+	}
+	switch cfg.UploadMode {
+	case uploadFiles:
+	case uploadBundle:
+		// Both publish the report files themselves, one commit or layer per report type
+		if cfg.GitCheckoutDir != "" || cfg.OCIRepository != "" {
+			return cfg, fmt.Errorf("UPLOAD_MODE=bundle cannot be combined with GIT_REPO_URL, GIT_CHECKOUT_DIR or OCI_REPOSITORY")
+		}
+	default:
+		return cfg, fmt.Errorf("invalid UPLOAD_MODE %q (valid: files, bundle)", cfg.UploadMode)
+	}
+	if err := validateS3PrefixOverrides(cfg); err != nil {
+		return cfg, err
+	}
+	if len(cfg.S3Tags) > maxS3Tags-len(s3BuiltinTags) {
+		return cfg, fmt.Errorf("S3_TAGS has %d tags, at most %d fit next to %s", len(cfg.S3Tags), maxS3Tags-len(s3BuiltinTags), strings.Join(s3BuiltinTags, ", "))
+	}
+	for name, v := range map[string]string{"S3_CACHE_CONTROL": cfg.S3CacheControl, "S3_INDEX_CACHE_CONTROL": cfg.S3IndexCacheControl} {
+		if !validHeaderValue(v) {
+			return cfg, fmt.Errorf("invalid %s %q: control characters such as newlines are not allowed", name, v)
+		}
+	}
+	switch cfg.S3ContentDisposition {
+	case "", "inline", "attachment":
+	default:
+		return cfg, fmt.Errorf("invalid S3_CONTENT_DISPOSITION %q (valid: inline, attachment)", cfg.S3ContentDisposition)
+	}
+	for ext, t := range cfg.S3ContentTypes {
+		if _, _, err := mime.ParseMediaType(t); err != nil || !validHeaderValue(t) {
+			return cfg, fmt.Errorf("invalid S3_CONTENT_TYPES entry %s=%q: not a content type", ext, t)
+		}
+	}
+	if cfg.StrictEncoding != encodingSanitize && cfg.StrictEncoding != encodingFail {
+		return cfg, fmt.Errorf("invalid STRICT_ENCODING %q (valid: sanitize, fail)", cfg.StrictEncoding)
+	}
+	if (cfg.GRPCTLSCert == "") != (cfg.GRPCTLSKey == "") {
+		return cfg, fmt.Errorf("GRPC_TLS_CERT and GRPC_TLS_KEY must be set together")
+	}
+	if cfg.DestructiveOps != destructiveAllow && cfg.DestructiveOps != destructiveDeny {
+		return cfg, fmt.Errorf("invalid DESTRUCTIVE_OPS %q (valid: allow, deny)", cfg.DestructiveOps)
+	}
+
+	return cfg, nil
+}
+
+// configFromEnv reads the configuration without validating the outputs, so subcommands
+// that supply their own outputs share the collection settings of a live run
+func configFromEnv() Config {
 	cfg := Config{
 		ClusterName:  getEnv("CLUSTER_NAME", "dev"),
+		KubeContext:  getEnv("KUBE_CONTEXT", ""),
 		S3Bucket:     getEnv("S3_BUCKET", ""),
 		S3Prefix:     getEnv("S3_PREFIX", "vuln"),
-		AWSRegion:    getEnv("AWS_REGION", "eu-west-1"),
-		SyncInterval: parseDuration(getEnv("SYNC_INTERVAL", "5m")),
+		GCSBucket:    getEnv("GCS_BUCKET", ""),
+		GCSPrefix:    getEnv("GCS_PREFIX", "vuln"),
+		SyncInterval: parseDuration(getEnv("SYNC_INTERVAL", "5m"), 5*time.Minute),
 		PageSize:     parseInt(getEnv("PAGE_SIZE", "20"), 20),
 		FSOutputDir:  getEnv("FS_OUTPUT_DIR", ""),
+		FSOutputGzip: parseBool(getEnv("FS_OUTPUT_GZIP", "false"), false),
+		FSLayout:     getEnv("FS_LAYOUT", fsLayoutFlat),
+		FSFileMode:   getEnv("FS_FILE_MODE", "0644"),
+		FSDirMode:    getEnv("FS_DIR_MODE", "0755"),
+		FSFsync:      parseBool(getEnv("FS_FSYNC", "true"), true),
+		FSChown:      getEnv("FS_CHOWN", ""),
+
+		FSGenerations: parseInt(getEnv("FS_GENERATIONS", "0"), 0),
+		FSLocking:     parseBool(getEnv("FS_LOCKING", "false"), false),
+		FSLockTimeout: parseDuration(getEnv("FS_LOCK_TIMEOUT", "10s"), 10*time.Second),
+		Scope:         getEnv("SCOPE", scopeAll),
+
+		ReportTypes:          splitList(getEnv("REPORT_TYPES", "")),
+		ReportTypesExclude:   splitList(getEnv("REPORT_TYPES_EXCLUDE", "")),
+		EnableSBOMReports:    parseBool(getEnv("ENABLE_SBOM_REPORTS", "false"), false),
+		SBOMPageSize:         parseInt(getEnv("SBOM_PAGE_SIZE", "5"), 5),
+		CustomResourcesFile:  getEnv("CUSTOM_RESOURCES_FILE", ""),
+		ReportDiscoveryEvery: parseInt(getEnv("REPORT_DISCOVERY_EVERY", "12"), 12),
+
+		PushURL:       getEnv("PUSH_URL", ""),
+		PushToken:     getEnv("PUSH_TOKEN", ""),
+		PushGzip:      parseBool(getEnv("PUSH_GZIP", "true"), true),
+		PushTimeout:   parseDuration(getEnv("PUSH_TIMEOUT", "5m"), 5*time.Minute),
+		PushChunkSize: parseInt(getEnv("PUSH_CHUNK_SIZE", "8388608"), 8*1024*1024),
+
+		GitRepoURL:        getEnv("GIT_REPO_URL", ""),
+		GitCheckoutDir:    getEnv("GIT_CHECKOUT_DIR", ""),
+		GitBranch:         getEnv("GIT_BRANCH", "main"),
+		GitPath:           getEnv("GIT_PATH", "reports"),
+		GitSSHKeyFile:     getEnv("GIT_SSH_KEY_FILE", ""),
+		GitKnownHostsFile: getEnv("GIT_KNOWN_HOSTS_FILE", ""),
+		GitSkipHostKey:    parseBool(getEnv("GIT_INSECURE_SKIP_HOST_KEY_CHECK", "false"), false),
+		GitTokenFile:      getEnv("GIT_TOKEN_FILE", ""),
+		GitAuthorName:     getEnv("GIT_AUTHOR_NAME", "trivy-exporter"),
+		GitAuthorEmail:    getEnv("GIT_AUTHOR_EMAIL", "trivy-exporter@localhost"),
+
+		OCIRepository:   getEnv("OCI_REPOSITORY", ""),
+		OCIUsername:     getEnv("OCI_USERNAME", ""),
+		OCIPassword:     getEnv("OCI_PASSWORD", ""),
+		OCIDockerConfig: getEnv("OCI_DOCKER_CONFIG", ""),
+		OCIPlainHTTP:    parseBool(getEnv("OCI_PLAIN_HTTP", "false"), false),
+
+		DatabaseURL: getEnv("DATABASE_URL", ""),
+
+		OpenSearchURL:       getEnv("OPENSEARCH_URL", ""),
+		OpenSearchIndex:     getEnv("OPENSEARCH_INDEX", "trivy-findings-{cluster}-{yyyy.MM}"),
+		OpenSearchUsername:  getEnv("OPENSEARCH_USERNAME", ""),
+		OpenSearchPassword:  getEnv("OPENSEARCH_PASSWORD", ""),
+		OpenSearchAPIKey:    getEnv("OPENSEARCH_API_KEY", ""),
+		OpenSearchBatchSize: parseInt(getEnv("OPENSEARCH_BATCH_SIZE", "500"), 500),
+		OpenSearchTimeout:   parseDuration(getEnv("OPENSEARCH_TIMEOUT", "1m"), time.Minute),
+
+		KafkaBrokers:         getEnv("KAFKA_BROKERS", ""),
+		KafkaTopic:           getEnv("KAFKA_TOPIC", "trivy-reports"),
+		KafkaSASLMechanism:   getEnv("KAFKA_SASL_MECHANISM", ""),
+		KafkaUsername:        getEnv("KAFKA_USERNAME", ""),
+		KafkaPassword:        getEnv("KAFKA_PASSWORD", ""),
+		KafkaTLS:             parseBool(getEnv("KAFKA_TLS", "false"), false),
+		KafkaCAFile:          getEnv("KAFKA_CA_FILE", ""),
+		KafkaMaxMessageBytes: parseInt(getEnv("KAFKA_MAX_MESSAGE_BYTES", "1000000"), 1000000),
+		KafkaBatchSize:       parseInt(getEnv("KAFKA_BATCH_SIZE", "100"), 100),
+
+		AzureStorageAccount:   getEnv("AZURE_STORAGE_ACCOUNT", ""),
+		AzureConnectionString: getEnv("AZURE_STORAGE_CONNECTION_STRING", ""),
+		AzureContainer:        getEnv("AZURE_CONTAINER", ""),
+		AzurePrefix:           getEnv("AZURE_PREFIX", "vuln"),
+
+		TerminationLogPath: getEnv("TERMINATION_LOG_PATH", "/dev/termination-log"),
+
+		SpreadCollection: parseBool(getEnv("SPREAD_COLLECTION", "false"), false),
+
+		ReconcileSummaries: parseBool(getEnv("RECONCILE_SUMMARIES", "true"), true),
+
+		NormalizeTimestamps: parseBool(getEnv("NORMALIZE_TIMESTAMPS", "true"), true),
+
+		StrictEncoding: getEnv("STRICT_ENCODING", encodingSanitize),
+
+		DestructiveOps: getEnv("DESTRUCTIVE_OPS", destructiveAllow),
+
+		ExportParquet: parseBool(getEnv("EXPORT_PARQUET", "false"), false),
+
+		GRPCAddr:       getEnv("GRPC_ADDR", ""),
+		GRPCTLSCert:    getEnv("GRPC_TLS_CERT", ""),
+		GRPCTLSKey:     getEnv("GRPC_TLS_KEY", ""),
+		GRPCToken:      getEnv("GRPC_TOKEN", ""),
+		GRPCBufferSize: parseInt(getEnv("GRPC_BUFFER_SIZE", "1024"), 1024),
+
+		CrossCheckOperatorMetrics: parseBool(getEnv("CROSSCHECK_OPERATOR_METRICS", "false"), false),
+		OperatorMetricsURL:        getEnv("OPERATOR_METRICS_URL", "http://trivy-operator.trivy-system.svc:80/metrics"),
+		CrossCheckTolerance:       parseFloat(getEnv("CROSSCHECK_TOLERANCE", "0.01"), 0.01),
+
+		AutoPageSize:      parseBool(getEnv("AUTO_PAGE_SIZE", "false"), false),
+		PageMemoryBudget:  parseInt(getEnv("PAGE_MEMORY_BUDGET_MB", "32"), 32),
+		FixedPageSize:     os.Getenv("PAGE_SIZE") != "",
+		FixedSBOMPageSize: os.Getenv("SBOM_PAGE_SIZE") != "",
+
+		K8sQPS:       parseFloat(getEnv("K8S_QPS", "5"), 5),
+		K8sBurst:     parseInt(getEnv("K8S_BURST", "10"), 10),
+		PageDelay:    parseDuration(getEnv("PAGE_DELAY", "0s"), 0),
+		ListRestarts: parseInt(getEnv("LIST_RESTARTS", "2"), 2),
+
+		CollectionMode:   getEnv("COLLECTION_MODE", collectionPoll),
+		WatchSyncTimeout: parseDuration(getEnv("WATCH_SYNC_TIMEOUT", "2m"), 2*time.Minute),
+		WatchMaxFailures: parseInt(getEnv("WATCH_MAX_FAILURES", "5"), 5),
+		WatchPruneFields: splitList(getEnv("WATCH_PRUNE_FIELDS", "metadata.managedFields")),
+
+		WatchFeed:              parseBool(getEnv("WATCH_FEED", "false"), false),
+		WatchFeedFlushInterval: parseDuration(getEnv("WATCH_FEED_FLUSH_INTERVAL", "10s"), 10*time.Second),
+		WatchFeedRetention:     parseRetention(getEnv("WATCH_FEED_RETENTION", "7d"), 7*24*time.Hour),
+
+		FreshnessSLO: parseDuration(getEnv("FRESHNESS_SLO", "24h"), 24*time.Hour),
+
+		TempDir:       getEnv("TEMP_DIR", ""),
+		TempMinFreeMB: parseInt(getEnv("TEMP_MIN_FREE_MB", "0"), 0),
+
+		SkipMissingResources: parseBool(getEnv("SKIP_MISSING_RESOURCES", "false"), false),
+
+		MaxItemBytes:   parseInt(getEnv("MAX_ITEM_BYTES", "52428800"), 50*1024*1024),
+		StoreOversized: parseBool(getEnv("STORE_OVERSIZED", "false"), false),
+
+		ForceUpload: parseBool(getEnv("FORCE_UPLOAD", "false"), false),
+		UploadMode:  getEnv("UPLOAD_MODE", uploadFiles),
+
+		CollectConcurrency:   parseInt(getEnv("COLLECT_CONCURRENCY", "2"), 2),
+		UploadConcurrency:    parseInt(getEnv("UPLOAD_CONCURRENCY", "3"), 3),
+		UploadBandwidthLimit: getEnv("UPLOAD_BANDWIDTH_LIMIT", ""),
+
+		SkipStartupChecks: parseBool(getEnv("SKIP_STARTUP_CHECKS", "false"), false),
+
+		EnableSnapshots:     parseBool(getEnv("ENABLE_SNAPSHOTS", "false"), false),
+		SnapshotRetention:   parseRetention(getEnv("SNAPSHOT_RETENTION", "0"), 0),
+		SnapshotKeepLast:    parseInt(getEnv("SNAPSHOT_KEEP_LAST", "0"), 0),
+		FSRetention:         parseRetention(getEnv("FS_RETENTION", "0"), 0),
+		FSKeepLast:          parseInt(getEnv("FS_KEEP_LAST", "0"), 0),
+		SnapshotPruneDryRun: parseBool(getEnv("SNAPSHOT_PRUNE_DRY_RUN", "false"), false),
+
+		FSProbeInterval: parseDuration(getEnv("FS_PROBE_INTERVAL", "30s"), 30*time.Second),
+
+		StaleClusterCleanup: parseBool(getEnv("STALE_CLUSTER_CLEANUP", "false"), false),
+		StaleClusterMaxAge:  parseRetention(getEnv("STALE_CLUSTER_MAX_AGE", "30d"), 30*24*time.Hour),
+		StaleClusterEvery:   parseInt(getEnv("STALE_CLUSTER_CLEANUP_EVERY", "12"), 12),
+		StaleClusterDryRun:  parseBool(getEnv("STALE_CLUSTER_DRY_RUN", "false"), false),
+	}
+	cfg.NamespaceLimits = NamespaceLimits{
+		Soft: parseInt(getEnv("NAMESPACE_SOFT_LIMIT", "0"), 0),
+		Hard: parseInt(getEnv("NAMESPACE_HARD_LIMIT", "0"), 0),
+	}
+	cfg.NamespaceLimitOverrides = parseNamespaceLimitOverrides(getEnv("NAMESPACE_LIMIT_OVERRIDES", ""), cfg.NamespaceLimits)
+	cfg.NamespacesInclude = splitList(getEnv("NAMESPACES_INCLUDE", ""))
+	cfg.NamespacesExclude = splitList(getEnv("NAMESPACES_EXCLUDE", ""))
+	cfg.OwnerStaleAfter = parseDuration(getEnv("OWNER_STALE_AFTER", (3*cfg.SyncInterval).String()), 3*cfg.SyncInterval)
+	cfg.CycleBudget = parseDuration(getEnv("CYCLE_BUDGET", cfg.SyncInterval.String()), cfg.SyncInterval)
+	loadS3Settings(&cfg)
+	if cfg.GitRepoURL != "" && cfg.GitCheckoutDir == "" {
+		cfg.GitCheckoutDir = "/tmp/trivy-git"
 	}
+	cfg.RetryPolicyFile = getEnv("RETRY_POLICY_FILE", "")
+	cfg.Retry, cfg.RetryPolicies = loadRetryPolicies(nil)
+	return cfg
+}
 
-	if cfg.S3Bucket == "" && cfg.FSOutputDir == "" {
-		log.Fatal("❌ Either S3_BUCKET or FS_OUTPUT_DIR environment variable is required")
+// loadS3Settings reads the S3 connection settings shared with the compare subcommand
+func loadS3Settings(cfg *Config) {
+	cfg.S3Endpoint = getEnv("S3_ENDPOINT", "")
+	cfg.S3ForcePathStyle = parseBool(getEnv("S3_FORCE_PATH_STYLE", "false"), false)
+	cfg.S3DisableSSL = parseBool(getEnv("S3_DISABLE_SSL", "false"), false)
+	cfg.S3CABundle = getEnv("S3_CA_BUNDLE", "")
+	cfg.S3InsecureSkipVerify = parseBool(getEnv("S3_INSECURE_SKIP_VERIFY", "false"), false)
+	cfg.S3SSE = getEnv("S3_SSE", "")
+	cfg.S3KMSKeyID = getEnv("S3_KMS_KEY_ID", "")
+	cfg.S3StorageClass = getEnv("S3_STORAGE_CLASS", "")
+	cfg.S3Tags = parseS3Tags(getEnv("S3_TAGS", ""))
+	cfg.S3PrefixOverrides = loadS3PrefixOverrides()
+	cfg.S3CacheControl = getEnv("S3_CACHE_CONTROL", "")
+	cfg.S3IndexCacheControl = getEnv("S3_INDEX_CACHE_CONTROL", "")
+	cfg.S3ContentDisposition = getEnv("S3_CONTENT_DISPOSITION", "")
+	cfg.S3ContentTypes = parseS3ContentTypes(getEnv("S3_CONTENT_TYPES", ""))
+	cfg.S3PartSizeMB = parseInt(getEnv("S3_PART_SIZE_MB", "64"), 64)
+	cfg.S3UploadConcurrency = parseInt(getEnv("S3_UPLOAD_CONCURRENCY", "4"), 4)
+	cfg.S3ProgressParts = parseInt(getEnv("S3_PROGRESS_PARTS", "10"), 10)
+	cfg.CompressUploads = getEnv("COMPRESS_UPLOADS", "")
+	cfg.S3PreflightWrite = parseBool(getEnv("S3_PREFLIGHT_WRITE", "false"), false)
+	cfg.S3VerifyUploads = parseBool(getEnv("S3_VERIFY_UPLOADS", "true"), true)
+	cfg.S3RequireLength = parseBool(getEnv("S3_REQUIRE_LENGTH", "false"), false)
+	cfg.CloudFrontDistributionID = getEnv("CLOUDFRONT_DISTRIBUTION_ID", "")
+	cfg.AWSRoleARN = getEnv("AWS_ROLE_ARN", "")
+	cfg.AWSExternalID = getEnv("AWS_EXTERNAL_ID", "")
+	sessionName := "trivy-exporter"
+	if cfg.ClusterName != "" {
+		sessionName += "-" + cfg.ClusterName
+	}
+	cfg.AWSRoleSessionName = getEnv("AWS_ROLE_SESSION_NAME", sessionName)
+	// S3-compatible stores ignore the region, but requests still need one to be signed
+	defaultRegion := "eu-west-1"
+	if cfg.S3Endpoint != "" {
+		defaultRegion = "us-east-1"
 	}
+	cfg.AWSRegion = getEnv("AWS_REGION", defaultRegion)
+}
 
-	return cfg
+// s3EndpointURL returns S3_ENDPOINT with a scheme; S3_DISABLE_SSL switches it to plain HTTP
+func s3EndpointURL(cfg Config) string {
+	endpoint := cfg.S3Endpoint
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		endpoint = endpoint[i+3:]
+	}
+	if cfg.S3DisableSSL {
+		return "http://" + endpoint
+	}
+	return "https://" + endpoint
+}
+
+// newS3Client builds an S3 client from the default AWS credential chain, which includes
+// static AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY credentials
+func newS3Client(ctx context.Context, cfg Config) (*s3.Client, error) {
+	awsCfg, err := loadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s3ClientFromConfig(awsCfg, cfg), nil
+}
+
+// loadAWSConfig loads the AWS config shared by the S3 and CloudFront clients
+func loadAWSConfig(ctx context.Context, cfg Config) (aws.Config, error) {
+	// Retries are driven by RetryPolicy, so the SDK makes a single attempt per call
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(cfg.AWSRegion),
+		config.WithRetryMaxAttempts(1),
+	}
+	httpClient, err := s3HTTPClient(cfg)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if httpClient != nil {
+		opts = append(opts, config.WithHTTPClient(httpClient))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	// With a web identity token (IRSA) the default chain already assumes AWS_ROLE_ARN
+	if cfg.AWSRoleARN != "" && os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") == "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.AWSRoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = cfg.AWSRoleSessionName
+			if cfg.AWSExternalID != "" {
+				o.ExternalID = aws.String(cfg.AWSExternalID)
+			}
+		})
+		// The cache renews the temporary credentials before they expire
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+		if _, err := awsCfg.Credentials.Retrieve(ctx); err != nil {
+			return aws.Config{}, fmt.Errorf("failed to assume role %s: %w", cfg.AWSRoleARN, err)
+		}
+		log.Printf("🎭 Assumed role %s for S3", cfg.AWSRoleARN)
+	}
+	return awsCfg, nil
+}
+
+// s3ClientFromConfig applies S3_ENDPOINT and S3_FORCE_PATH_STYLE to the S3 client
+func s3ClientFromConfig(awsCfg aws.Config, cfg Config) *s3.Client {
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(s3EndpointURL(cfg))
+		}
+		o.UsePathStyle = cfg.S3ForcePathStyle
+	})
 }
 
 func getEnv(key, defaultValue string) string {
@@ -165,11 +1071,11 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func parseDuration(s string) time.Duration {
+func parseDuration(s string, defaultVal time.Duration) time.Duration {
 	d, err := time.ParseDuration(s)
 	if err != nil {
-		log.Printf("⚠️ Invalid duration %q, using default 5m", s)
-		return 5 * time.Minute
+		log.Printf("⚠️ Invalid duration %q, using default %v", s, defaultVal)
+		return defaultVal
 	}
 	return d
 }
@@ -182,24 +1088,223 @@ func parseInt(s string, defaultVal int) int {
 	return v
 }
 
-func collectAndUploadAll(ctx context.Context, k8s dynamic.Interface, s3Client *s3.Client, cfg Config) error {
+func parseFloat(s string, defaultVal float64) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return defaultVal
+	}
+	return v
+}
+
+func parseBool(s string, defaultVal bool) bool {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return defaultVal
+	}
+	return v
+}
+
+func collectAndUploadAll(ctx context.Context, k8s dynamic.Interface, sinks []Sink, cfg Config) error {
 	startTime := time.Now()
 	timestamp := time.Now().UTC().Format("20060102-150405")
-	s3Path := fmt.Sprintf("%s/%s", cfg.S3Prefix, cfg.ClusterName)
+	ctx = withCycleID(ctx, timestamp)
+	timer := newCycleTimer(cfg.CycleBudget)
+	cycleArtifacts.reset()
+	// Readers of FS_OUTPUT_DIR wait for the whole cycle, reports and index alike
+	defer lockSinks(sinks)()
 
 	collectionStats := make(map[string]int)
+	resourceStats := make(map[string]ResourceStats)
+	diagnostics := Diagnostics{Cluster: cfg.ClusterName, SummaryDiscrepancies: []SummaryDiscrepancy{}}
+	var failures []error
 
-	// Collect each report type
-	for _, resource := range reportResources {
-		log.Printf("📥 Fetching %s...", resource.Name)
-		count, err := collectResourcePaged(ctx, k8s, s3Client, cfg, resource, s3Path, timestamp)
-		if err != nil {
-			log.Printf("⚠️ Failed to collect %s: %v", resource.Name, err)
-			continue
+	// Collect each report type, most important first
+	var resources []ReportResource
+	var order []string
+	for _, r := range orderedResources() {
+		if inScope(r, cfg.Scope) {
+			resources = append(resources, r)
+			order = append(order, r.Name)
+		}
+	}
+	log.Printf("📋 Collection order (scope %s): %s", cfg.Scope, strings.Join(order, ", "))
+	reportWatches.watch(resources)
+
+	// Report uploads are the core of the cycle and never skipped. With SPREAD_COLLECTION each
+	// resource gets its own slot within the interval instead of running back-to-back.
+	var slot time.Duration
+	if cfg.SpreadCollection {
+		slot = spreadSlot(cfg.SyncInterval, len(resources))
+	}
+	var findingsOut *findingsParquet
+	if cfg.ExportParquet {
+		var err error
+		if findingsOut, err = newFindingsParquet(); err != nil {
+			log.Printf("⚠️ Parquet export disabled for this cycle: %v", err)
+		} else {
+			defer findingsOut.cleanup()
+		}
+	}
+
+	var bundle *reportBundle
+	if cfg.UploadMode == uploadBundle {
+		var err error
+		if bundle, err = newReportBundle(); err != nil {
+			return storageError(fmt.Errorf("failed to create bundle: %w", err))
+		}
+		defer bundle.cleanup()
+	}
+
+	failedKinds := make(map[string]bool)
+	var forbiddenResources []string
+	stopped := false
+	type collected struct {
+		resource ReportResource
+		stats    ResourceStats
+		err      error
+	}
+	var results []collected
+	timer.run("collect", false, func() {
+		// Report files are published on UPLOAD_CONCURRENCY workers as soon as their type is
+		// listed, while COLLECT_CONCURRENCY workers list the next types
+		uploads := newUploadQueue(cfg.UploadConcurrency)
+		results = make([]collected, len(resources))
+		jobs := make(chan int)
+		var workers sync.WaitGroup
+		for w := 0; w < cfg.CollectConcurrency; w++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for i := range jobs {
+					resource := resources[i]
+					log.Printf("📥 Fetching %s...", resource.Name)
+					findingsFeed.begin(resource.Kind)
+					ctx := withRetryResource(ctx, resource.Name)
+					stats, err := collectResourcePaged(ctx, k8s, sinks, cfg, resource, timestamp, findingsOut, bundle, uploads)
+					results[i] = collected{resource, stats, err}
+				}
+			}()
+		}
+		// Types are handed out in priority order; with SPREAD_COLLECTION each at its slot
+		dispatched := 0
+		for i := range resources {
+			if slot > 0 && !waitForSlot(ctx, startTime, time.Duration(i)*slot) {
+				stopped = true
+				break
+			}
+			jobs <- i
+			dispatched++
+		}
+		close(jobs)
+		workers.Wait()
+		results = results[:dispatched]
+		uploaded := uploads.wait()
+
+		for _, r := range results {
+			resource, stats, err := r.resource, r.stats, r.err
+			if u, ok := uploaded[resource.Name]; ok && err == nil {
+				err = u.err
+				stats.UploadDurationMs = u.duration.Milliseconds()
+				stats.Snapshot = u.snapshot
+				stats.VerifiedSize = u.verifiedSize
+				stats.S3Upload = u.s3Upload
+				stats.SkippedUploads = u.skipped
+				stats.uploadedAt = u.finishedAt
+			}
+			if err != nil {
+				stats = ResourceStats{}
+			}
+			stats.Retries = retryCounts.resource(resource.Name)
+			events.publish(ctx, ResourceCollected{CycleID: timestamp, Resource: resource.Name, Stats: stats, Err: err})
+			if err != nil {
+				findingsFeed.discard(resource.Kind)
+				log.Printf("⚠️ Failed to collect %s: %v", resource.Name, err)
+				failures = append(failures, err)
+				if apierrors.IsForbidden(err) {
+					forbiddenResources = append(forbiddenResources, resource.Name)
+				}
+				failedKinds[resource.Kind] = true
+				continue
+			}
+			collectionStats[resource.Name] = stats.Items
+			resourceStats[resource.Name] = stats
+			for _, d := range stats.discrepancySamples {
+				if len(diagnostics.SummaryDiscrepancies) < maxDiscrepancySamples {
+					diagnostics.SummaryDiscrepancies = append(diagnostics.SummaryDiscrepancies, d)
+				}
+			}
 		}
-		collectionStats[resource.Name] = count
+	})
+
+	if stopped {
+		log.Printf("⏹️ Shutdown requested, skipping the remaining slots of this cycle")
+		return nil
+	}
+
+	// Derived checks and artifacts give way when the cycle is about to run over budget
+	// Quota results are part of the core output so truncated numbers are never silent
+	truncated := false
+	if cfg.quotasEnabled() {
+		timer.run("namespaces", false, func() {
+			report := buildNamespaceReport(cfg, resourceStats)
+			truncated = report.Truncated
+			if err := publishJSON(ctx, sinks, cfg, "namespaces.json", report); err != nil {
+				log.Printf("⚠️ Failed to publish namespaces: %v", err)
+			}
+		})
+	}
+
+	var crossCheck *OperatorCrossCheck
+	if stats, ok := resourceStats["vulnerabilityreports"]; ok && stats.severityTotals != nil {
+		timer.run("crosscheck", true, func() {
+			operator, err := scrapeOperatorVulnerabilities(ctx, cfg)
+			if err != nil {
+				log.Printf("⚠️ Operator metrics cross-check skipped: %v", err)
+				return
+			}
+			check := crossCheckOperator(cfg.OperatorMetricsURL, cfg.CrossCheckTolerance, stats.severityTotals, operator)
+			if !check.Pass {
+				log.Printf("⚠️ Vulnerability totals differ from operator metrics for %s: exporter=%v operator=%v",
+					strings.Join(check.Mismatches, ", "), check.Exporter, check.Operator)
+			}
+			crossCheck = &check
+			diagnostics.OperatorCrossCheck = &check
+		})
 	}
 
+	timer.run("freshness", true, func() {
+		freshness := buildFreshness(cfg.ClusterName, cfg.FreshnessSLO, resourceStats)
+		if !freshness.SLOPass {
+			log.Printf("⚠️ Freshness SLO (%v) breached: %s data is %ds old", cfg.FreshnessSLO, freshness.WorstType, *freshness.WorstAge)
+		}
+		freshnessJSON, _ := json.MarshalIndent(freshness, "", "  ")
+		if err := publishArtifact(ctx, sinks, cfg, Artifact{Name: "freshness.json", Data: freshnessJSON}); err != nil {
+			log.Printf("⚠️ Failed to publish freshness: %v", err)
+		}
+	})
+
+	if findingsOut != nil {
+		timer.run("parquet", true, func() {
+			a, err := findingsOut.artifact()
+			if err == nil {
+				err = publishArtifact(ctx, sinks, cfg, a)
+			}
+			if err != nil {
+				log.Printf("⚠️ Failed to publish findings.parquet: %v", err)
+				return
+			}
+			log.Printf("📊 Exported %d findings to findings.parquet", findingsOut.rows)
+		})
+	}
+
+	timer.run("diagnostics", true, func() {
+		diagnostics.GeneratedAt = time.Now().UTC().Format(time.RFC3339)
+		diagnosticsJSON, _ := json.MarshalIndent(diagnostics, "", "  ")
+		if err := publishArtifact(ctx, sinks, cfg, Artifact{Name: "diagnostics.json", Data: diagnosticsJSON}); err != nil {
+			log.Printf("⚠️ Failed to publish diagnostics: %v", err)
+		}
+	})
+
 	// Upload metadata/index for the whole collection
 	metadata := CollectionMetadata{
 		Cluster:         cfg.ClusterName,
@@ -214,40 +1319,137 @@ func collectAndUploadAll(ctx context.Context, k8s dynamic.Interface, s3Client *s
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	// Note: Metadata is now only stored locally if FS output is enabled
-	// S3 only contains latest reports (no timestamped snapshots)
-	_ = metadataJSON // Suppress unused variable warning
+	// The metadata is only kept with the snapshot of the cycle
+	if snapshotSinks := fsSnapshotsFull.sinks(sinks, timestamp); cfg.EnableSnapshots && len(snapshotSinks) > 0 {
+		timer.run("snapshot", false, func() {
+			if err := publishArtifact(ctx, snapshotSinks, cfg, Artifact{Name: snapshotKey(timestamp, "metadata.json"), Data: metadataJSON}); err != nil {
+				fsSnapshotsFull.observe(timestamp, err)
+				log.Printf("⚠️ Failed to publish snapshot metadata: %v", err)
+				return
+			}
+			log.Printf("📸 Wrote snapshot %s", snapshotKey(timestamp, ""))
+		})
+	}
 
-	// Update cluster index (generic)
-	indexData := map[string]interface{}{
-		"cluster":         cfg.ClusterName,
-		"lastUpdated":     time.Now().UTC().Format(time.RFC3339),
-		"collectionStats": collectionStats,
+	// Expired snapshots are only pruned after a cycle that collected every report type
+	snapshotsPruned := 0
+	if cfg.snapshotPruningEnabled() && len(failures) == 0 {
+		timer.run("snapshot-prune", true, func() {
+			snapshotsPruned = pruneSnapshots(ctx, sinks, cfg, timestamp)
+		})
 	}
-	indexJSON, _ := json.MarshalIndent(indexData, "", "  ")
 
-	if s3Client != nil {
-		indexKey := fmt.Sprintf("%s/index.json", s3Path)
-		if err := uploadBufferToS3(ctx, s3Client, cfg.S3Bucket, indexKey, indexJSON); err != nil {
-			log.Printf("⚠️ Failed to upload index to S3: %v", err)
-		}
+	if cfg.StaleClusterCleanup && staleClusterCycles.due(cfg.StaleClusterEvery) {
+		timer.run("stale-clusters", true, func() {
+			cleanupStaleClusters(ctx, sinks, cfg, timestamp)
+		})
 	}
 
-	// Write to FS if enabled
-	if cfg.FSOutputDir != "" {
-		// Just write main cluster index locally as "index.json" or similar?
-		// Usually dashboard expects <cluster>-<report>.json.
-		// Actually, we probably don't need the metadata/index files locally for the dashboard,
-		// as it iterates known report types. But let's write index.json anyway for completeness.
-		fsClusterDir := fmt.Sprintf("%s/%s", cfg.FSOutputDir, cfg.ClusterName)
-		if err := os.WriteFile(fmt.Sprintf("%s/index.json", fsClusterDir), indexJSON, 0644); err != nil {
-			log.Printf("⚠️ Failed to write index to FS: %v", err)
+	// Deletions requested during the cycle are audited before the index lists the artifacts
+	timer.run("deletions-audit", false, func() {
+		if err := flushDeletionAudit(ctx, sinks, cfg); err != nil {
+			log.Printf("⚠️ %v", err)
 		}
+	})
+
+	if bundle != nil {
+		cycleArtifacts.record(bundleName)
+	}
+	checksums := make(map[string]string)
+	for _, r := range resources {
+		if stats, ok := resourceStats[r.Name]; ok && stats.Digest != "" {
+			checksums[r.FileName+".json"] = stats.Digest
+		}
+	}
+
+	// Update cluster index (generic)
+	index := ClusterIndex{
+		Cluster:         cfg.ClusterName,
+		CycleID:         timestamp,
+		LastUpdated:     time.Now().UTC().Format(time.RFC3339),
+		LastChecked:     time.Now().UTC().Format(time.RFC3339),
+		CollectionStats: collectionStats,
+		CollectionOrder: order,
+		ResourceStats:   resourceStats,
+		Checksums:       checksums,
+		ObjectKeys:      s3ObjectKeys(cfg),
+		Retries:         retryCounts.reset(),
+		Deletions:       deletionCounts.reset(),
+		SnapshotsPruned: snapshotsPruned,
+		SkippedUploads:  uploadSkips.counts.reset(),
+		TempFreeBytes:   tempSpace.reset(),
+
+		UnavailableResources: unavailableResources(resourceStats),
+		ForbiddenResources:   forbiddenResources,
+		CustomResources:      customResourceIndex(cfg),
+		Phases:               timer.phases,
+		Artifacts:            cycleArtifacts.withIndexes(cfg),
+		Capabilities:         buildRuntimeConfig(cfg).Capabilities,
+
+		OperatorCrossCheck: crossCheck,
+		Truncated:          truncated,
+		Sinks:              sinkHealthReport(cfg),
+		S3Streaming:        s3StreamingOf(sinks, cfg),
+	}
+
+	// The CDN is invalidated once every report type is published. The invalidation covers
+	// the index files published right after it, so its ID is part of the index.
+	if len(failures) == 0 {
+		timer.run("cdn-invalidation", true, func() {
+			pending := []string{ownIndexName(cfg), "index.json", ownDeltaName(cfg)}
+			if bundle != nil {
+				pending = append(pending, bundleName)
+			}
+			index.CDNInvalidation = invalidateCDN(ctx, sinks, cfg, timestamp, pending)
+		})
 	}
 
+	// The bundle carries a copy of the index and is published right before it
+	if bundle != nil {
+		timer.run("bundle", false, func() {
+			if err := publishBundle(ctx, sinks, cfg, bundle, index); err != nil {
+				log.Printf("⚠️ Failed to publish %s: %v", bundleName, err)
+			}
+		})
+	}
+
+	// The index goes through the same pipeline as the reports and is published last
+	timer.run("index", false, func() {
+		previous := loadPreviousIndex(ctx, sinks, cfg)
+		if err := publishIndex(ctx, sinks, cfg, index); err != nil {
+			log.Printf("⚠️ Failed to publish index: %v", err)
+		}
+		if err := publishIndexDelta(ctx, sinks, cfg, previous, index); err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+	})
+	findingsFeed.publish(index)
+
+	// Batching sinks commit the cycle once everything else is published
+	timer.run("sinks", false, func() {
+		finishSinks(ctx, sinks, timestamp)
+	})
+
 	duration := time.Since(startTime)
 	log.Printf("🎉 Collection cycle complete in %v!", duration)
-	return nil
+	timer.logBreakdown()
+	if len(index.SkippedUploads) > 0 {
+		var skipped []string
+		for _, reason := range sortedKeys(index.SkippedUploads) {
+			skipped = append(skipped, fmt.Sprintf("%s=%d", reason, index.SkippedUploads[reason]))
+		}
+		log.Printf("⏭️ Skipped uploads: %s", strings.Join(skipped, " "))
+	}
+
+	var cycleErr error
+	if len(failures) > 0 {
+		cycleErr = partialError(fmt.Errorf("%d of %d report types failed: %w", len(failures), len(resources), errors.Join(failures...)))
+	}
+	if findingEventsWanted() {
+		trackedFindings.publishChanges(ctx, timestamp, failedKinds)
+	}
+	events.publish(ctx, CycleCompleted{Index: index, Err: cycleErr})
+	return cycleErr
 }
 
 func getReportTypeNames() []string {
@@ -258,160 +1460,456 @@ func getReportTypeNames() []string {
 	return names
 }
 
-// collectResourcePaged uses pagination and streaming to temp file to reduce memory usage
-func collectResourcePaged(ctx context.Context, k8s dynamic.Interface, s3Client *s3.Client, cfg Config, resource ReportResource, s3Path, timestamp string) (int, error) {
-	// ... (setup GVR and temp file) ...
-	// RE-IMPLEMENTING START OF FUNCTION DUE TO TOOL LIMITATIONS - KEEPING CONTEXT
-	gvr := schema.GroupVersionResource{
+// orderedResources returns the resources to collect sorted by descending priority,
+// keeping the declaration order for equal priorities
+func orderedResources() []ReportResource {
+	resources := append([]ReportResource(nil), reportResources...)
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Priority > resources[j].Priority
+	})
+	return resources
+}
+
+// reportGVR returns the GroupVersionResource of a trivy-operator or custom report resource
+func reportGVR(resource ReportResource) schema.GroupVersionResource {
+	if resource.custom() {
+		return schema.GroupVersionResource{Group: resource.Group, Version: resource.Version, Resource: resource.Resource}
+	}
+	return schema.GroupVersionResource{
 		Group:    "aquasecurity.github.io",
 		Version:  "v1alpha1",
 		Resource: resource.Name,
 	}
+}
+
+// restartReportFile empties the temp file of a report type and writes its header again
+func restartReportFile(tmpFile *os.File, digester hash.Hash, header string) error {
+	if err := tmpFile.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate %s: %w", tmpFile.Name(), err)
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind %s: %w", tmpFile.Name(), err)
+	}
+	digester.Reset()
+	if _, err := io.WriteString(io.MultiWriter(tmpFile, digester), header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	return nil
+}
+
+// resourceLogger prefixes log lines with the report type, as the lines of report types
+// collected concurrently interleave
+func resourceLogger(name string) *log.Logger {
+	return log.New(log.Writer(), "["+name+"] ", log.Flags()|log.Lmsgprefix)
+}
+
+// collectResourcePaged uses pagination and streaming to temp file to reduce memory usage
+// Outside UPLOAD_MODE=bundle the report file is handed to uploads, which publishes it
+// and removes the temp file while the next report type is collected.
+func collectResourcePaged(ctx context.Context, k8s dynamic.Interface, sinks []Sink, cfg Config, resource ReportResource, timestamp string, findingsOut *findingsParquet, bundle *reportBundle, uploads *uploadQueue) (ResourceStats, error) {
+	gvr := reportGVR(resource)
+	rlog := resourceLogger(resource.Name)
+	started := time.Now().UTC()
+	collectedAt := started.Format(time.RFC3339)
+	// Findings are flattened for FindingAdded/FindingResolved only while someone subscribes
+	trackFindings := findingEventsWanted()
+	// With DATABASE_URL, findings are also loaded into the table of the report type
+	rows := findingsStore.loader(resource, timestamp, started)
+	search := findingsSearch.bulk(started)
+	// With KAFKA_BROKERS, every item is also produced as a message
+	producer := reportEvents.producer(resource, collectedAt)
+
+	// A full temp directory fails writes halfway; skip the report type before that
+	if err := tempSpace.check(cfg, resource.Name); err != nil {
+		return ResourceStats{}, storageError(err)
+	}
+
+	// COLLECTION_MODE=watch: pages come from the informer cache instead of LIST requests
+	snapshot := reportWatches.snapshot(ctx, resource)
+
+	// With NAMESPACES_INCLUDE the matching namespaces are listed one by one; otherwise the
+	// whole cluster is listed and NAMESPACES_EXCLUDE applied to the items
+	namespaces := []string{metav1.NamespaceAll}
+	filterItems := false
+	if !resource.ClusterScoped {
+		if snapshot != nil {
+			// Informers watch every namespace
+			filterItems = len(cfg.NamespacesInclude) > 0 || len(cfg.NamespacesExclude) > 0
+		} else if len(cfg.NamespacesInclude) > 0 {
+			resolved, err := includedNamespaces(ctx, k8s, cfg)
+			if err != nil {
+				return ResourceStats{}, k8sError(err)
+			}
+			namespaces = resolved
+		} else {
+			filterItems = len(cfg.NamespacesExclude) > 0
+		}
+	}
 
 	// Create temp file
-	tmpFile, err := os.CreateTemp("", fmt.Sprintf("%s-*.json", resource.FileName))
+	tmpFile, err := os.CreateTemp(stagingDir, fmt.Sprintf("%s-*.json", resource.FileName))
 	if err != nil {
-		return 0, fmt.Errorf("failed to create temp file: %w", err)
+		return ResourceStats{}, storageError(fmt.Errorf("failed to create temp file: %w", err))
 	}
-	defer func() {
+	cleanup := func() {
 		tmpFile.Close()
-		os.Remove(tmpFile.Name()) // Remove temp file after uploading/copying
+		os.Remove(tmpFile.Name())
+	}
+	// Once submitted, the upload job owns the temp file and removes it when done
+	submitted := false
+	defer func() {
+		if !submitted {
+			cleanup()
+		}
 	}()
 
+	// The report file is hashed as it is written, see Artifact.Digest
+	digester := sha256.New()
+	out := io.MultiWriter(tmpFile, digester)
+
 	// Write JSON header
-	_, err = tmpFile.WriteString(fmt.Sprintf(`{
-  "apiVersion": "aquasecurity.github.io/v1alpha1",
+	header := fmt.Sprintf(`{
+  "apiVersion": %q,
   "items": [
-`))
-	if err != nil {
-		return 0, fmt.Errorf("failed to write header: %w", err)
+`, gvr.GroupVersion().String())
+	if _, err := io.WriteString(out, header); err != nil {
+		return ResourceStats{}, fmt.Errorf("failed to write header: %w", err)
 	}
 
 	// ... Pagination Logic (Keep existing logic) ...
 	limit := int64(cfg.PageSize)
+	if resource.SBOM {
+		limit = int64(cfg.SBOMPageSize)
+	}
 	if limit <= 0 {
 		limit = 20
 	}
+	// A calibrated value replaces the default PAGE_SIZE and SBOM_PAGE_SIZE alike; sizes set
+	// explicitly are kept
+	calibrate := cfg.AutoPageSize && !cfg.fixedPageSize(resource)
+	if calibrate {
+		if remembered, ok := rememberedPageSize(resource.Name); ok {
+			limit = remembered
+		}
+	}
 	continueToken := ""
-	totalCount := 0
+	var stats ResourceStats
+	quotas := cfg.quotasEnabled()
+	if cfg.CrossCheckOperatorMetrics && resource.Kind == "VulnerabilityReport" {
+		stats.severityTotals = make(map[string]int)
+	}
 	firstItem := true
+	pages := 0
+	exportedItems := make(map[string]bool)
+	// Findings for FindingAdded/FindingResolved are staged until the list completes, so a
+	// restarted list does not keep those of items gone since the abandoned pass
+	var tracked []Finding
 
-	encoder := json.NewEncoder(tmpFile)
+	if len(cfg.NamespacesInclude) > 0 && !resource.ClusterScoped && snapshot == nil {
+		stats.ListedNamespaces = len(namespaces)
+	}
+	stats.Watched = snapshot != nil
 
-	for {
+	counter := &countingWriter{w: out}
+
+	// Items are encoded into a buffer first so a failing or oversized item never leaves a
+	// partial document in the report file
+	var itemBuf bytes.Buffer
+	encoder := json.NewEncoder(&itemBuf)
+
+	// RETRY_KUBERNETES_DEADLINE bounds the whole paged list of the report type, pauses and
+	// retries included, rather than each page
+	listPolicy := cfg.retryPolicy("kubernetes")
+	listDeadline := listPolicy.Deadline
+	listCtx := ctx
+	if listDeadline > 0 {
+		var cancel context.CancelFunc
+		listCtx, cancel = context.WithTimeout(ctx, listDeadline)
+		defer cancel()
+		listPolicy.Deadline = 0
+	}
+	deadlineExceeded := func(err error) bool {
+		return listDeadline > 0 && ctx.Err() == nil && (listCtx.Err() != nil || errors.Is(err, errDeadlineAhead))
+	}
+
+	for ns := 0; ns < len(namespaces); {
+		// PAGE_DELAY spares fragile API servers; the first page is listed right away
+		if cfg.PageDelay > 0 && stats.ListCalls > 0 {
+			select {
+			case <-listCtx.Done():
+				if deadlineExceeded(nil) {
+					return ResourceStats{}, k8sError(fmt.Errorf("failed to list %s: RETRY_KUBERNETES_DEADLINE of %v exceeded", resource.Name, listDeadline))
+				}
+				return ResourceStats{}, ctx.Err()
+			case <-time.After(cfg.PageDelay):
+			}
+		}
 		listOpts := metav1.ListOptions{
 			Limit:    limit,
 			Continue: continueToken,
 		}
 
-		list, err := k8s.Resource(gvr).List(ctx, listOpts)
+		var list *unstructured.UnstructuredList
+		var err error
+		if snapshot != nil {
+			list = snapshot.next(limit)
+		} else {
+			attempts := 0
+			err = listPolicy.Do(listCtx, "kubernetes", func() error {
+				attempts++
+				stats.ListCalls++
+				var err error
+				list, err = k8s.Resource(gvr).Namespace(namespaces[ns]).List(listCtx, listOpts)
+				return err
+			})
+			stats.ListRetries += attempts - 1
+			if err != nil && deadlineExceeded(err) {
+				err = fmt.Errorf("RETRY_KUBERNETES_DEADLINE of %v exceeded: %w", listDeadline, err)
+			}
+		}
 		if err != nil {
-			if strings.Contains(err.Error(), "could not find the requested resource") {
-				log.Printf("ℹ️ Resource %s not found in cluster (CRD missing?)", resource.Name)
-				return 0, nil
+			// The continue token of a long list can expire (410 Gone) before the last page.
+			// The list starts over on an empty report file, so items of the partial pass are
+			// not written twice.
+			if apierrors.IsResourceExpired(err) && continueToken != "" && stats.ListRestarts < cfg.ListRestarts {
+				rlog.Printf("🔄 Continue token of %s expired after %d pages and %d items (namespace %d/%d), restarting the list (%d/%d)",
+					resource.Name, pages, stats.Items, ns+1, len(namespaces), stats.ListRestarts+1, cfg.ListRestarts)
+				if err := restartReportFile(tmpFile, digester, header); err != nil {
+					return ResourceStats{}, storageError(err)
+				}
+				// The outputs fed item by item start over too, as far as they can
+				producer.restart()
+				rows.restart(time.Now().UTC())
+				search.restart()
+				findingsFeed.begin(resource.Kind)
+				tracked = nil
+				restarted := ResourceStats{
+					ListCalls:        stats.ListCalls,
+					ListRetries:      stats.ListRetries,
+					ListRestarts:     stats.ListRestarts + 1,
+					ListedNamespaces: stats.ListedNamespaces,
+					Watched:          stats.Watched,
+				}
+				if stats.severityTotals != nil {
+					restarted.severityTotals = make(map[string]int)
+				}
+				stats = restarted
+				counter = &countingWriter{w: out}
+				firstItem = true
+				continueToken = ""
+				pages = 0
+				ns = 0
+				continue
+			}
+			// A missing CRD still gets an empty report file, so the previous one is not taken
+			// for current data
+			if apierrors.IsNotFound(err) && continueToken == "" && ns == 0 {
+				rlog.Printf("ℹ️ Resource %s not found in cluster (CRD missing?)", resource.Name)
+				if cfg.SkipMissingResources {
+					return ResourceStats{Unavailable: true, ListCalls: stats.ListCalls, ListRetries: stats.ListRetries}, nil
+				}
+				stats.Unavailable = true
+				break
 			}
-			return 0, fmt.Errorf("failed to list %s: %w", resource.Name, err)
+			if apierrors.IsForbidden(err) {
+				return ResourceStats{}, k8sError(fmt.Errorf("missing RBAC for %s, need list on %s: %w", resource.Name, gvr.GroupResource(), err))
+			}
+			return ResourceStats{}, k8sError(fmt.Errorf("failed to list %s: %w", resource.Name, err))
 		}
 
 		for _, item := range list.Items {
-			if !firstItem {
-				if _, err := tmpFile.WriteString(","); err != nil {
-					return 0, err
+			if filterItems && cfg.namespaceFiltered(item.GetNamespace()) {
+				stats.FilteredItems++
+				continue
+			}
+			if quotas && !stats.admitNamespaceItem(cfg, resource.Name, item.GetNamespace()) {
+				continue
+			}
+			if n := sanitizeStrings(item.Object, cfg.StrictEncoding != encodingFail); n > 0 {
+				if cfg.StrictEncoding == encodingFail {
+					stats.InvalidEncodingItems++
+					rlog.Printf("⚠️ Skipping %s %s/%s: %d fields with invalid UTF-8 or control characters",
+						resource.Kind, item.GetNamespace(), item.GetName(), n)
+					continue
+				}
+				stats.SanitizedFields += n
+			}
+			if d := reconcileSummary(item.Object, resource.Kind, cfg.ReconcileSummaries); d != nil {
+				stats.SummaryDiscrepancies++
+				if len(stats.discrepancySamples) < maxDiscrepancySamples {
+					stats.discrepancySamples = append(stats.discrepancySamples, *d)
+				}
+			}
+			if cfg.NormalizeTimestamps {
+				stats.TimestampParseErrors += normalizeTimestamps(item.Object)
+			}
+			stats.observeUpdateTimestamp(item.Object)
+			stats.observeShape(reportShape(resource.Kind, item.Object))
+			if stats.severityTotals != nil {
+				for severity, n := range severityCounts(item.Object, summarySpecs[resource.Kind]) {
+					stats.severityTotals[severity] += n
 				}
 			}
+			if findingsOut != nil || findingsFeed != nil || trackFindings || search != nil {
+				findings := flattenFindings(cfg.ClusterName, resource.Kind, collectedAt, item.Object)
+				if search != nil {
+					search.add(ctx, item.GetName(), findings)
+				}
+				if trackFindings {
+					tracked = append(tracked, findings...)
+				}
+				// findings.parquet cannot be rewound like the report file, so a restarted
+				// list skips the items it already exported
+				if findingsOut != nil && !exportedItems[string(item.GetUID())] {
+					exportedItems[string(item.GetUID())] = true
+					if err := findingsOut.write(findings); err != nil {
+						rlog.Printf("⚠️ Failed to export findings of %s/%s: %v", item.GetNamespace(), item.GetName(), err)
+					}
+				}
+				findingsFeed.collect(resource.Kind, findings)
+			}
+			if rows != nil {
+				rows.add(ctx, item.Object)
+			}
+
+			itemBuf.Reset()
 			if err := encoder.Encode(item.Object); err != nil {
-				log.Printf("⚠️ Failed to encode item: %v", err)
+				rlog.Printf("⚠️ Failed to encode item: %v", err)
 				continue
 			}
+			encoded := itemBuf.Bytes()
+
+			if cfg.MaxItemBytes > 0 && len(encoded) > cfg.MaxItemBytes {
+				stats.OversizedItems++
+				rlog.Printf("⚠️ %s %s/%s is %d bytes (limit %d), writing a truncated stub",
+					resource.Kind, item.GetNamespace(), item.GetName(), len(encoded), cfg.MaxItemBytes)
+				if cfg.StoreOversized {
+					if overflow, err := overflowArtifact(item.Object, encoded); err != nil {
+						rlog.Printf("⚠️ Failed to compress oversized item: %v", err)
+					} else if err := publishArtifact(ctx, sinks, cfg, overflow); err != nil {
+						rlog.Printf("⚠️ Failed to publish oversized item: %v", err)
+					}
+				}
+				stub, err := oversizedStub(item.Object, len(encoded))
+				if err != nil {
+					rlog.Printf("⚠️ Failed to encode stub: %v", err)
+					continue
+				}
+				encoded = stub
+			}
+
+			if producer != nil {
+				producer.add(ctx, item.GetNamespace(), item.GetName(), item.Object, encoded)
+			}
+
+			if !firstItem {
+				if _, err := io.WriteString(out, ","); err != nil {
+					return ResourceStats{}, err
+				}
+			}
+			if _, err := counter.Write(encoded); err != nil {
+				return ResourceStats{}, fmt.Errorf("failed to write item: %w", err)
+			}
 			firstItem = false
-			totalCount++
+			stats.Items++
+		}
+
+		if calibrate {
+			// Measure the first page only; later pages use the calibrated limit
+			calibrate = false
+			if size := calibratePageSize(resource.Name, cfg.PageMemoryBudget, counter.n, stats.Items); size > 0 {
+				rlog.Printf("📐 Calibrated page size for %s: %d (avg item %d bytes)", resource.Name, size, counter.n/int64(stats.Items))
+				limit = size
+			}
 		}
 
+		pages++
 		continueToken = list.GetContinue()
 		list = nil
 		runtime.GC()
 
 		if continueToken == "" {
-			break
+			ns++
 		}
 	}
 
-	// Write JSON footer
-	_, err = tmpFile.WriteString(`
-  ]
+	// Write JSON footer; a truncation marker tells consumers the items are incomplete, and
+	// resourceAvailable that the CRD is not installed
+	footer := `
+  ]`
+	if stats.OmittedItems > 0 {
+		marker, _ := json.Marshal(map[string]interface{}{
+			"omittedItems": stats.OmittedItems,
+			"namespaces":   stats.TruncatedNamespaces,
+		})
+		footer += fmt.Sprintf(`,
+  "truncated": %s`, marker)
+	}
+	if stats.Unavailable {
+		footer += `,
+  "resourceAvailable": false`
+	}
+	_, err = io.WriteString(out, footer+`
 }`)
 	if err != nil {
-		return 0, fmt.Errorf("failed to write footer: %w", err)
+		return ResourceStats{}, fmt.Errorf("failed to write footer: %w", err)
 	}
 
-	log.Printf("✅ Found %d %s", totalCount, resource.Name)
-
-	// Reset file pointer for reading
-	if _, err := tmpFile.Seek(0, 0); err != nil {
-		return 0, fmt.Errorf("failed to seek temp file: %w", err)
+	stats.PageSize = int(limit)
+	stats.collectedAt = time.Now()
+	rlog.Printf("✅ Found %d %s", stats.Items, resource.Name)
+	if info, err := tmpFile.Stat(); err == nil {
+		stats.TempFileBytes = info.Size()
+		stats.PeakTempFileBytes = tempSpace.record(resource.Name, info.Size())
+		rlog.Printf("📏 Temp file of %s: %s (peak %s)", resource.Name, formatSize(stats.TempFileBytes), formatSize(stats.PeakTempFileBytes))
 	}
-
-	// Upload to S3 if enabled
-	if s3Client != nil {
-		// latest
-		latestKey := fmt.Sprintf("%s/%s.json", s3Path, resource.FileName)
-		if err := uploadFileToS3(ctx, s3Client, cfg.S3Bucket, latestKey, tmpFile); err != nil {
-			return 0, fmt.Errorf("failed to upload latest %s: %w", resource.Name, err)
+	if stats.FilteredItems > 0 {
+		rlog.Printf("🔎 Dropped %d %s in namespaces filtered by NAMESPACES_EXCLUDE", stats.FilteredItems, resource.Name)
+	}
+	if stats.OmittedItems > 0 {
+		rlog.Printf("✂️ Omitted %d %s over namespace hard limits", stats.OmittedItems, resource.Name)
+	}
+	if stats.SummaryDiscrepancies > 0 {
+		rlog.Printf("⚠️ %d %s had a summary that disagreed with their findings (reconciled=%t)",
+			stats.SummaryDiscrepancies, resource.Name, cfg.ReconcileSummaries)
+	}
+	if trackFindings {
+		trackedFindings.observe(tracked)
+	}
+	if rows != nil {
+		if err := rows.finish(ctx); err != nil {
+			rlog.Printf("⚠️ %v", err)
 		}
-
-		// Note: Timestamped snapshots disabled - only latest reports are stored
+	}
+	if search != nil {
+		stats.IndexingFailures = search.finish(ctx)
+	}
+	if producer != nil {
+		stats.KafkaProduced, stats.KafkaFailed = producer.finish(ctx)
 	}
 
-	// Write to FS if enabled
-	if cfg.FSOutputDir != "" {
-		// Reset file pointer
-		if _, err := tmpFile.Seek(0, 0); err != nil {
-			return 0, err
+	stats.Digest = hex.EncodeToString(digester.Sum(nil))
+	artifact := Artifact{Name: resource.FileName + ".json", File: tmpFile, Digest: stats.Digest}
+	if bundle != nil {
+		// UPLOAD_MODE=bundle: published with the other reports as bundle.tar.gz
+		if err := bundle.add(artifact); err != nil {
+			return ResourceStats{}, storageError(err)
 		}
-
-		// Destination path: /output/cluster-name/report-filename.json
-		// Note: Dashboard expects <cluster>-<report>.json in its data dir.
-		// If we mount /data in dashboard, we should write directly to /data/<cluster>-<report>.json
-		// OR write to /data/<cluster>/<report>.json and update dashboard to look there.
-		// Current dashboard expects: /data/<cluster>-<report>.json.
-		// Let's stick to that flat structure in the output dir if we want minimal dashboard changes?
-		// Actually, the `FSOutputDir` logic above created a subdirectory `cfg.ClusterName`.
-		// Let's adjust to match existing dashboard expectations.
-
-		destPath := fmt.Sprintf("%s/%s-%s.json", cfg.FSOutputDir, cfg.ClusterName, resource.FileName)
-
-		outFile, err := os.Create(destPath)
-		if err != nil {
-			return 0, fmt.Errorf("failed to create FS output file: %w", err)
+		if err := bundle.add(checksumSidecar(artifact)); err != nil {
+			return ResourceStats{}, storageError(err)
 		}
-		defer outFile.Close()
-
-		if _, err := io.Copy(outFile, tmpFile); err != nil {
-			return 0, fmt.Errorf("failed to write FS output: %w", err)
+		stats.uploadedAt = time.Now()
+		for _, sink := range sinks {
+			uploadSkips.record(artifact.Name, sink.Name(), skipDisabledByMode)
 		}
-		log.Printf("💾 Saved to %s", destPath)
+		stats.SkippedUploads = uploadSkips.artifact(artifact.Name)
+		return stats, nil
 	}
 
-	return totalCount, nil
-}
-
-func uploadFileToS3(ctx context.Context, client *s3.Client, bucket, key string, file *os.File) error {
-	// PutObject with os.File automatically handles content length
-	_, err := client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(bucket),
-		Key:         aws.String(key),
-		Body:        file,
-		ContentType: aws.String("application/json"),
-	})
-	return err
-}
-
-func uploadBufferToS3(ctx context.Context, client *s3.Client, bucket, key string, data []byte) error {
-	_, err := client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(bucket),
-		Key:         aws.String(key),
-		Body:        bytes.NewReader(data),
-		ContentType: aws.String("application/json"),
+	submitted = true
+	uploads.submit(resource.Name, func() uploadResult {
+		defer cleanup()
+		return uploadReport(ctx, sinks, cfg, resource, timestamp, artifact)
 	})
-	return err
+	return stats, nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Multipart uploads initiated longer ago are left over from crashed cycles. The margin is
+// generous because sibling exporters of a split deployment upload under the same prefix.
+const staleMultipartAge = 24 * time.Hour
+
+// uploadProgress logs every S3_PROGRESS_PARTS parts the multipart uploader reads, so slow
+// uploads of large report files visibly make progress
+type uploadProgress struct {
+	r        io.Reader
+	key      string
+	partSize int64
+	parts    int64 // total
+	every    int64
+	read     int64
+	logged   int64 // parts
+}
+
+func (p *uploadProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if parts := p.read / p.partSize; parts-p.logged >= p.every {
+		p.logged = parts
+		log.Printf("⏫ Uploading %s: %d/%d parts sent", p.key, parts, p.parts)
+	}
+	return n, err
+}
+
+// withProgress wraps r for uploads that span more than S3_PROGRESS_PARTS parts
+func (s *s3Sink) withProgress(key string, r io.Reader, size int64) io.Reader {
+	if s.progressParts <= 0 || size <= s.partSize*int64(s.progressParts) {
+		return r
+	}
+	return &uploadProgress{
+		r:        r,
+		key:      key,
+		partSize: s.partSize,
+		parts:    (size + s.partSize - 1) / s.partSize,
+		every:    int64(s.progressParts),
+	}
+}
+
+// abortStaleUploads aborts the incomplete multipart uploads under the cluster prefix that a
+// crashed exporter left behind; S3 bills their parts until they are aborted. Failed uploads
+// of a running exporter are aborted by the uploader itself.
+func (s *s3Sink) abortStaleUploads(ctx context.Context) {
+	cutoff := time.Now().Add(-staleMultipartAge)
+	paginator := s3.NewListMultipartUploadsPaginator(s.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("⚠️ Failed to list incomplete multipart uploads: %v", err)
+			return
+		}
+		for _, u := range page.Uploads {
+			if u.Initiated == nil || u.Initiated.After(cutoff) {
+				continue
+			}
+			_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(s.bucket),
+				Key:      u.Key,
+				UploadId: u.UploadId,
+			})
+			if err != nil {
+				log.Printf("⚠️ Failed to abort multipart upload of %s: %v", aws.ToString(u.Key), err)
+				continue
+			}
+			log.Printf("🧹 Aborted incomplete multipart upload of %s started %s", aws.ToString(u.Key), u.Initiated.Format(time.RFC3339))
+		}
+	}
+}
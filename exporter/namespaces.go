@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var namespaceGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// validateNamespacePatterns checks the globs of NAMESPACES_INCLUDE or NAMESPACES_EXCLUDE
+func validateNamespacePatterns(name string, patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid %s pattern %q (globs such as team-*)", name, p)
+		}
+	}
+	return nil
+}
+
+// matchesNamespace reports whether namespace matches any of the patterns
+func matchesNamespace(patterns []string, namespace string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceFiltered reports whether NAMESPACES_INCLUDE and NAMESPACES_EXCLUDE drop the
+// items of a namespace
+func (c Config) namespaceFiltered(namespace string) bool {
+	if len(c.NamespacesInclude) > 0 && !matchesNamespace(c.NamespacesInclude, namespace) {
+		return true
+	}
+	return matchesNamespace(c.NamespacesExclude, namespace)
+}
+
+// includedNamespaces resolves NAMESPACES_INCLUDE to the namespaces listed one by one.
+// Plain names are used as they are; globs are matched against the namespaces of the cluster.
+func includedNamespaces(ctx context.Context, k8s dynamic.Interface, cfg Config) ([]string, error) {
+	seen := make(map[string]bool)
+	var globs []string
+	for _, p := range cfg.NamespacesInclude {
+		if strings.ContainsAny(p, `*?[\`) {
+			globs = append(globs, p)
+			continue
+		}
+		seen[p] = true
+	}
+	if len(globs) > 0 {
+		list, err := k8s.Resource(namespaceGVR).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list namespaces for NAMESPACES_INCLUDE: %w", err)
+		}
+		for _, item := range list.Items {
+			if matchesNamespace(globs, item.GetName()) {
+				seen[item.GetName()] = true
+			}
+		}
+	}
+
+	var namespaces []string
+	for ns := range seen {
+		if !matchesNamespace(cfg.NamespacesExclude, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// Media types of the report bundle
+const (
+	ociArtifactType    = "application/vnd.trivy-dashboard.reports.v1"
+	ociReportMediaType = "application/vnd.trivy-dashboard.report+json"
+)
+
+// Manifest annotation prefix of the per-type item counts, e.g.
+// vnd.trivy-dashboard.count.vulnerabilityreports
+const ociCountAnnotation = "vnd.trivy-dashboard.count."
+
+// ociSink pushes the report files of each cycle to OCI_REPOSITORY as one artifact with a
+// layer per report type, tagged with the cluster name and latest. The files are spooled to
+// disk as they are published and pushed once per cycle by finishCycle.
+type ociSink struct {
+	repo    *remote.Repository
+	cluster string
+	cfg     Config
+	spool   string
+
+	mu      sync.Mutex // report files are published by several upload workers
+	layers  []ocispec.Descriptor
+	created string
+	counts  map[string]int
+}
+
+func newOCISink(cfg Config) (*ociSink, error) {
+	repo, err := remote.NewRepository(cfg.OCIRepository)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI_REPOSITORY: %w", err)
+	}
+	repo.PlainHTTP = cfg.OCIPlainHTTP
+
+	// A plain HTTP client: retries are handled by RETRY_OCI_*
+	client := &auth.Client{Client: &http.Client{}, Cache: auth.NewCache()}
+	switch {
+	case cfg.OCIUsername != "":
+		client.Credential = auth.StaticCredential(repo.Reference.Registry, auth.Credential{
+			Username: cfg.OCIUsername,
+			Password: cfg.OCIPassword,
+		})
+	case cfg.OCIDockerConfig != "":
+		store, err := credentials.NewStore(cfg.OCIDockerConfig, credentials.StoreOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load OCI_DOCKER_CONFIG: %w", err)
+		}
+		client.Credential = credentials.Credential(store)
+	}
+	repo.Client = client
+
+	spool, err := os.MkdirTemp(stagingDir, "trivy-oci-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCI spool directory: %w", err)
+	}
+	return &ociSink{repo: repo, cluster: cfg.ClusterName, cfg: cfg, spool: spool}, nil
+}
+
+func (s *ociSink) Name() string { return "oci" }
+
+// Put spools report files as layers and reads the collection timestamp and counts from
+// the index; every other artifact is ignored
+func (s *ociSink) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if key == "index.json" {
+		var index ClusterIndex
+		if err := json.NewDecoder(r).Decode(&index); err != nil {
+			return fmt.Errorf("failed to decode index: %w", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.created = index.LastUpdated
+		s.counts = index.CollectionStats
+		return nil
+	}
+	if !isReportFile(key) {
+		return nil
+	}
+
+	f, err := os.Create(filepath.Join(s.spool, key))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	digester := digest.Canonical.Digester()
+	written, err := io.Copy(f, io.TeeReader(r, digester.Hash()))
+	if err != nil {
+		return err
+	}
+
+	layer := ocispec.Descriptor{
+		MediaType:   ociReportMediaType,
+		Digest:      digester.Digest(),
+		Size:        written,
+		Annotations: map[string]string{ocispec.AnnotationTitle: key},
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A retried Put replaces the layer spooled before
+	for i, l := range s.layers {
+		if l.Annotations[ocispec.AnnotationTitle] == key {
+			s.layers[i] = layer
+			return nil
+		}
+	}
+	s.layers = append(s.layers, layer)
+	return nil
+}
+
+// Get is unsupported: bundles are not read back
+func (s *ociSink) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, errReadUnsupported
+}
+
+// Delete is unsupported: old bundles are left to the registry's retention policy
+func (s *ociSink) Delete(ctx context.Context, key string) error {
+	return errDeleteUnsupported
+}
+
+// finishCycle pushes the spooled layers and a manifest annotated with the collection
+// timestamp and item counts, then tags it. Report types that failed this cycle are missing
+// from the bundle.
+func (s *ociSink) finishCycle(ctx context.Context, cycleID string) error {
+	s.mu.Lock()
+	layers, created, counts := s.layers, s.created, s.counts
+	s.layers, s.created, s.counts = nil, "", nil
+	s.mu.Unlock()
+	if len(layers) == 0 {
+		return nil
+	}
+
+	annotations := map[string]string{
+		"vnd.trivy-dashboard.cluster": s.cluster,
+		"vnd.trivy-dashboard.cycle":   cycleID,
+	}
+	if created != "" {
+		annotations[ocispec.AnnotationCreated] = created
+	}
+	for _, r := range reportResources {
+		if n, ok := counts[r.Name]; ok {
+			annotations[ociCountAnnotation+r.Name] = fmt.Sprint(n)
+		}
+	}
+
+	err := s.cfg.retryPolicy("oci").Do(ctx, "oci", func() error {
+		for _, layer := range layers {
+			if err := s.pushLayer(ctx, layer); err != nil {
+				return err
+			}
+		}
+		manifest, err := oras.PackManifest(ctx, s.repo, oras.PackManifestVersion1_1, ociArtifactType, oras.PackManifestOptions{
+			Layers:              layers,
+			ManifestAnnotations: annotations,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to push manifest: %w", err)
+		}
+		for _, tag := range []string{s.cluster, "latest"} {
+			if err := s.repo.Tag(ctx, manifest, tag); err != nil {
+				return fmt.Errorf("failed to tag %s: %w", tag, err)
+			}
+		}
+		log.Printf("📦 Pushed %d reports of %s to %s (%s)", len(layers), s.cluster, s.cfg.OCIRepository, manifest.Digest)
+		return nil
+	})
+
+	for _, layer := range layers {
+		os.Remove(filepath.Join(s.spool, layer.Annotations[ocispec.AnnotationTitle]))
+	}
+	return err
+}
+
+// pushLayer uploads a spooled layer unless the registry already has it, which is the
+// case for report types that did not change since the last push
+func (s *ociSink) pushLayer(ctx context.Context, layer ocispec.Descriptor) error {
+	exists, err := s.repo.Exists(ctx, layer)
+	if err != nil || exists {
+		return err
+	}
+	f, err := os.Open(filepath.Join(s.spool, layer.Annotations[ocispec.AnnotationTitle]))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.repo.Push(ctx, layer, f); err != nil {
+		return fmt.Errorf("failed to push %s: %w", layer.Annotations[ocispec.AnnotationTitle], err)
+	}
+	return nil
+}
+
+// Close removes the spool directory
+func (s *ociSink) Close() error {
+	return os.RemoveAll(s.spool)
+}
+
+// isRetryableOCIError retries registry responses with status 429 or 5xx and network errors
+func isRetryableOCIError(err error) bool {
+	var resp *errcode.ErrorResponse
+	if errors.As(err, &resp) {
+		return resp.StatusCode == 429 || resp.StatusCode >= 500
+	}
+	return isRetryableHTTPError(err)
+}
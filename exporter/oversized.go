@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// oversizedStub replaces an item whose encoding exceeds MAX_ITEM_BYTES in the main report
+// file. It keeps enough metadata for the dashboard to show that the report exists.
+func oversizedStub(obj map[string]interface{}, size int) ([]byte, error) {
+	u := unstructured.Unstructured{Object: obj}
+	metadata := map[string]interface{}{
+		"name": u.GetName(),
+		"uid":  string(u.GetUID()),
+	}
+	if ts, found, _ := unstructured.NestedString(obj, "metadata", "creationTimestamp"); found {
+		metadata["creationTimestamp"] = ts
+	}
+	if ns := u.GetNamespace(); ns != "" {
+		metadata["namespace"] = ns
+	}
+	if labels := u.GetLabels(); len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(map[string]interface{}{
+		"apiVersion":    u.GetAPIVersion(),
+		"kind":          u.GetKind(),
+		"metadata":      metadata,
+		"truncated":     true,
+		"originalBytes": size,
+	})
+	return buf.Bytes(), err
+}
+
+// overflowArtifact packs an oversized item into overflow/<uid>.json.gz
+func overflowArtifact(obj map[string]interface{}, encoded []byte) (Artifact, error) {
+	u := unstructured.Unstructured{Object: obj}
+	id := string(u.GetUID())
+	if id == "" {
+		id = fmt.Sprintf("%s-%s", u.GetNamespace(), u.GetName())
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(encoded); err != nil {
+		return Artifact{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{
+		Name: fmt.Sprintf("overflow/%s.json.gz", id),
+		Data: buf.Bytes(),
+	}, nil
+}
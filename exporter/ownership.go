@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"trivy-exporter/fslock"
+)
+
+// Name of the ownership marker in FS_OUTPUT_DIR
+const ownerMarkerName = ".owner"
+
+// How long a claim waits for another exporter checking the marker at the same time. A
+// claim file older than this on volumes without flock was left by a crashed exporter.
+const ownershipLockTimeout = 10 * time.Second
+
+// OwnerMarker records which exporter owns an output directory. Several exporters may write
+// their reports into a shared directory, but only the owner may delete files from it.
+type OwnerMarker struct {
+	Cluster   string `json:"cluster"`
+	WriterID  string `json:"writerId"`
+	Heartbeat string `json:"heartbeat"`
+}
+
+// writerID identifies this process among the exporters sharing an output directory
+var writerID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}()
+
+// Result of the last ownership check
+var (
+	ownershipMu      sync.Mutex
+	ownsOutputDir    bool
+	ownershipChecked bool
+)
+
+// lockOwnership serializes the ownership checks of the exporters sharing dir, so two of them
+// never both find the marker stale and take the directory over. Volumes without flock fall
+// back to a claim file created with O_EXCL.
+func lockOwnership(dir string) (func(), error) {
+	lock, err := fslock.Exclusive(filepath.Join(dir, ownerMarkerName+".lock"), ownershipLockTimeout)
+	if err == nil {
+		return func() { lock.Unlock() }, nil
+	}
+	if !errors.Is(err, fslock.ErrUnsupported) {
+		return nil, err
+	}
+
+	claim := filepath.Join(dir, ownerMarkerName+".claim")
+	deadline := time.Now().Add(ownershipLockTimeout)
+	for {
+		f, err := os.OpenFile(claim, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(claim) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create %s: %w", claim, err)
+		}
+		if info, err := os.Stat(claim); err == nil && time.Since(info.ModTime()) > ownershipLockTimeout {
+			os.Remove(claim)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s: %w after %v", claim, fslock.ErrTimeout, ownershipLockTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// claimOutputDir refreshes the heartbeat of the ownership marker for writer, taking the
+// directory over when it has no marker or the owner's heartbeat is older than staleAfter.
+// It returns false when another live writer owns the directory. The marker is read and
+// replaced under lockOwnership.
+func claimOutputDir(dir, cluster, writer string, staleAfter time.Duration) (bool, error) {
+	path := filepath.Join(dir, ownerMarkerName)
+	unlock, err := lockOwnership(dir)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err == nil {
+		var marker OwnerMarker
+		if jsonErr := json.Unmarshal(data, &marker); jsonErr != nil {
+			log.Printf("⚠️ Replacing unreadable %s: %v", path, jsonErr)
+		} else if marker.WriterID != writer {
+			heartbeat, parseErr := time.Parse(time.RFC3339, marker.Heartbeat)
+			if parseErr == nil && time.Since(heartbeat) < staleAfter {
+				return false, nil
+			}
+			log.Printf("🔑 Taking over %s from %s (%s), last heartbeat %s", dir, marker.WriterID, marker.Cluster, marker.Heartbeat)
+		}
+	}
+
+	marker, _ := json.Marshal(OwnerMarker{
+		Cluster:   cluster,
+		WriterID:  writer,
+		Heartbeat: time.Now().UTC().Format(time.RFC3339),
+	})
+	// Replaced atomically, so exporters on volumes without locks never read half a marker
+	if err := writeFile(path, bytes.NewReader(marker), fileOptions{fileMode: 0644, dirMode: 0755, uid: -1, gid: -1}); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// checkOutputDirOwnership runs the ownership check for FS_OUTPUT_DIR at startup, after
+// every cycle and before deletions, logging when ownership changes
+func checkOutputDirOwnership(cfg Config) {
+	if cfg.FSOutputDir == "" {
+		return
+	}
+	ownershipMu.Lock()
+	defer ownershipMu.Unlock()
+	owned, err := claimOutputDir(cfg.FSOutputDir, cfg.ClusterName, writerID, cfg.OwnerStaleAfter)
+	if err != nil {
+		log.Printf("⚠️ Ownership check failed: %v", err)
+	}
+	if err == nil && !owned && (ownsOutputDir || !ownershipChecked) {
+		log.Printf("❌ %s is owned by another live exporter; destructive operations are disabled", cfg.FSOutputDir)
+	}
+	ownsOutputDir = owned
+	ownershipChecked = true
+}
+
+// canDeleteFromOutputDir is consulted by deleteArtifact before any prune or cleanup of
+// FS_OUTPUT_DIR. Ownership is checked again each time, which also refreshes the heartbeat,
+// so no other exporter can take the directory over for OWNER_STALE_AFTER.
+func canDeleteFromOutputDir(cfg Config, operation string) bool {
+	if cfg.FSOutputDir == "" {
+		return true
+	}
+	checkOutputDirOwnership(cfg)
+	ownershipMu.Lock()
+	owned := ownsOutputDir
+	ownershipMu.Unlock()
+	if owned {
+		return true
+	}
+	log.Printf("❌ Refusing %s in %s: the directory is owned by another exporter", operation, cfg.FSOutputDir)
+	return false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeOwnerMarker(t *testing.T, dir, writer string, heartbeat time.Time) {
+	t.Helper()
+	data, _ := json.Marshal(OwnerMarker{Cluster: "other", WriterID: writer, Heartbeat: heartbeat.UTC().Format(time.RFC3339)})
+	if err := os.WriteFile(filepath.Join(dir, ownerMarkerName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClaimOutputDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		marker func(t *testing.T, dir string)
+		want   bool
+	}{
+		{name: "no marker", marker: func(*testing.T, string) {}, want: true},
+		{name: "own marker", marker: func(t *testing.T, dir string) { writeOwnerMarker(t, dir, "a", time.Now()) }, want: true},
+		{name: "live owner", marker: func(t *testing.T, dir string) { writeOwnerMarker(t, dir, "b", time.Now()) }, want: false},
+		{name: "stale owner", marker: func(t *testing.T, dir string) { writeOwnerMarker(t, dir, "b", time.Now().Add(-2*time.Hour)) }, want: true},
+		{name: "unreadable marker", marker: func(t *testing.T, dir string) {
+			os.WriteFile(filepath.Join(dir, ownerMarkerName), []byte("{"), 0644)
+		}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.marker(t, dir)
+			got, err := claimOutputDir(dir, "prod", "a", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("claimOutputDir() = %v, want %v", got, tt.want)
+			}
+			data, _ := os.ReadFile(filepath.Join(dir, ownerMarkerName))
+			if owner := strings.Contains(string(data), `"writerId":"a"`); owner != tt.want {
+				t.Errorf("marker after claim: %s", data)
+			}
+		})
+	}
+}
+
+// Two exporters starting on the same empty or stale directory at once must not both take it
+func TestClaimOutputDirTwoInstances(t *testing.T) {
+	for _, stale := range []bool{false, true} {
+		for round := 0; round < 50; round++ {
+			dir := t.TempDir()
+			if stale {
+				writeOwnerMarker(t, dir, "crashed", time.Now().Add(-2*time.Hour))
+			}
+			var wg sync.WaitGroup
+			owned := make([]bool, 2)
+			for i, writer := range []string{"a", "b"} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var err error
+					if owned[i], err = claimOutputDir(dir, "prod", writer, time.Hour); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if owned[0] == owned[1] {
+				t.Fatalf("stale=%v round %d: both instances got ownership %v", stale, round, owned[0])
+			}
+		}
+	}
+}
+
+// An exporter that lost the directory to another instance after its last check must not
+// delete from it, and deletes again once the other instance's heartbeat went stale
+func TestDeleteArtifactRechecksOwnership(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{FSOutputDir: dir, ClusterName: "prod", FSLayout: fsLayoutFlat, OwnerStaleAfter: time.Hour, DestructiveOps: destructiveAllow}
+	sink := &fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}
+	checkOutputDirOwnership(cfg)
+	defer func() { ownsOutputDir, ownershipChecked = false, false }()
+
+	path := filepath.Join(dir, "prod-snapshots", "20240101-000000", "index.json")
+	req := DeletionRequest{Key: "snapshots/20240101-000000/index.json", Feature: "snapshot-retention", Reason: "expired"}
+	for _, tt := range []struct {
+		name      string
+		heartbeat time.Time
+		deleted   bool
+	}{
+		{name: "taken over by a live instance", heartbeat: time.Now(), deleted: false},
+		{name: "other instance went stale", heartbeat: time.Now().Add(-2 * time.Hour), deleted: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			writeOwnerMarker(t, dir, "other-instance", tt.heartbeat)
+			if err := deleteArtifact(context.Background(), []Sink{sink}, cfg, req); err != nil {
+				t.Fatal(err)
+			}
+			_, err := os.Stat(path)
+			if deleted := os.IsNotExist(err); deleted != tt.deleted {
+				t.Errorf("file deleted = %v, want %v", deleted, tt.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// Bounds for calibrated page sizes
+const (
+	minAutoPageSize = 1
+	maxAutoPageSize = 500
+)
+
+// calibratedPageSizes remembers the page size computed for each resource so later cycles
+// start from it instead of PAGE_SIZE. It lives for the lifetime of the process.
+var calibratedPageSizes = struct {
+	sync.Mutex
+	sizes map[string]int64
+}{sizes: make(map[string]int64)}
+
+func rememberedPageSize(resource string) (int64, bool) {
+	calibratedPageSizes.Lock()
+	defer calibratedPageSizes.Unlock()
+	size, ok := calibratedPageSizes.sizes[resource]
+	return size, ok
+}
+
+// fixedPageSize reports whether the page size of resource was set explicitly, with
+// SBOM_PAGE_SIZE for the SBOM report types and PAGE_SIZE for the others
+func (c Config) fixedPageSize(resource ReportResource) bool {
+	if resource.SBOM {
+		return c.FixedSBOMPageSize
+	}
+	return c.FixedPageSize
+}
+
+// calibratePageSize derives a LIST limit from the average encoded item size of the first
+// page so a page stays within the configured memory budget
+func calibratePageSize(resource string, budgetMB int, pageBytes int64, pageItems int) int64 {
+	if pageItems == 0 || pageBytes == 0 {
+		return 0
+	}
+	avg := pageBytes / int64(pageItems)
+	if avg == 0 {
+		avg = 1
+	}
+	size := int64(budgetMB) * 1024 * 1024 / avg
+	if size < minAutoPageSize {
+		size = minAutoPageSize
+	}
+	if size > maxAutoPageSize {
+		size = maxAutoPageSize
+	}
+
+	calibratedPageSizes.Lock()
+	calibratedPageSizes.sizes[resource] = size
+	calibratedPageSizes.Unlock()
+	return size
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
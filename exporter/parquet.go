@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// Version of the findings.parquet schema, advertised as a capability in runtime-config.json
+	findingsParquetSchemaVersion = "1"
+
+	// Target uncompressed size of a row group, sized for S3 range reads
+	parquetRowGroupBytes = 64 * 1024 * 1024
+
+	parquetContentType = "application/vnd.apache.parquet"
+)
+
+// findingsParquet streams the findings of a cycle into findings.parquet. Rows are buffered
+// for one row group at a time, so memory stays bounded by the row group size.
+type findingsParquet struct {
+	mu         sync.Mutex // Report types are collected concurrently
+	file       *os.File
+	writer     *parquet.GenericWriter[Finding]
+	groupBytes int
+	rows       int64
+	err        error // first write error; the file is unusable after it
+}
+
+func newFindingsParquet() (*findingsParquet, error) {
+	file, err := os.CreateTemp(stagingDir, "findings-*.parquet")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	writer := parquet.NewGenericWriter[Finding](file,
+		parquet.Compression(&parquet.Zstd),
+		parquet.KeyValueMetadata("schemaVersion", findingsParquetSchemaVersion),
+	)
+	return &findingsParquet{file: file, writer: writer}, nil
+}
+
+// write appends findings, closing the row group once it reaches parquetRowGroupBytes.
+// Only the first failure is returned; artifact reports it again at the end of the cycle.
+func (p *findingsParquet) write(findings []Finding) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(findings) == 0 || p.err != nil {
+		return nil
+	}
+	if _, err := p.writer.Write(findings); err != nil {
+		p.err = fmt.Errorf("failed to write parquet rows: %w", err)
+		return p.err
+	}
+	p.rows += int64(len(findings))
+	for _, f := range findings {
+		p.groupBytes += len(f.Cluster) + len(f.Namespace) + len(f.Workload) + len(f.Image) + len(f.Digest) +
+			len(f.Kind) + len(f.ID) + len(f.Severity) + len(f.FirstSeen) + len(f.CollectedAt) + 1
+	}
+	if p.groupBytes >= parquetRowGroupBytes {
+		if err := p.writer.Flush(); err != nil {
+			p.err = fmt.Errorf("failed to flush parquet row group: %w", err)
+			return p.err
+		}
+		p.groupBytes = 0
+	}
+	return nil
+}
+
+// artifact finishes the file and returns it as findings.parquet
+func (p *findingsParquet) artifact() (Artifact, error) {
+	if p.err != nil {
+		return Artifact{}, p.err
+	}
+	if err := p.writer.Close(); err != nil {
+		return Artifact{}, fmt.Errorf("failed to close parquet writer: %w", err)
+	}
+	return Artifact{Name: "findings.parquet", File: p.file}, nil
+}
+
+// cleanup removes the temp file
+func (p *findingsParquet) cleanup() {
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
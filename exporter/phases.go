@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// PhaseTiming is the wall time of one phase of a collection cycle
+type PhaseTiming struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"durationMs"`
+	Optional   bool   `json:"optional,omitempty"`
+	Skipped    bool   `json:"skipped,omitempty"`
+}
+
+// lastPhaseDurations remembers how long each phase took in the previous cycle, which is
+// the estimate used to decide whether an optional phase still fits the budget
+var lastPhaseDurations = struct {
+	sync.Mutex
+	durations map[string]time.Duration
+}{durations: make(map[string]time.Duration)}
+
+// cycleTimer records the phases of one collection cycle against CYCLE_BUDGET
+type cycleTimer struct {
+	start  time.Time
+	budget time.Duration
+	phases []PhaseTiming
+}
+
+func newCycleTimer(budget time.Duration) *cycleTimer {
+	return &cycleTimer{start: time.Now(), budget: budget}
+}
+
+// run times a phase. Optional phases are skipped when their duration in the previous cycle
+// would push the cycle past the budget, so core report uploads always complete first.
+func (t *cycleTimer) run(name string, optional bool, fn func()) {
+	if optional && t.budget > 0 {
+		lastPhaseDurations.Lock()
+		estimate := lastPhaseDurations.durations[name]
+		lastPhaseDurations.Unlock()
+
+		if elapsed := time.Since(t.start); elapsed+estimate > t.budget {
+			log.Printf("⏭️ Skipping optional phase %s: %v elapsed, ~%v needed, budget %v",
+				name, elapsed.Round(time.Millisecond), estimate.Round(time.Millisecond), t.budget)
+			t.phases = append(t.phases, PhaseTiming{Name: name, Optional: true, Skipped: true})
+			return
+		}
+	}
+
+	start := time.Now()
+	fn()
+	d := time.Since(start)
+
+	lastPhaseDurations.Lock()
+	lastPhaseDurations.durations[name] = d
+	lastPhaseDurations.Unlock()
+	t.phases = append(t.phases, PhaseTiming{Name: name, DurationMs: d.Milliseconds(), Optional: optional})
+}
+
+// logBreakdown prints the per-phase table at the end of the cycle and warns when the
+// cycle ran over budget
+func (t *cycleTimer) logBreakdown() {
+	total := time.Since(t.start)
+	log.Printf("⏱️ Cycle phases (total %v, budget %v):", total.Round(time.Millisecond), t.budget)
+	for _, p := range t.phases {
+		switch {
+		case p.Skipped:
+			log.Printf("   %-14s skipped", p.Name)
+		case p.Optional:
+			log.Printf("   %-14s %8dms (optional)", p.Name, p.DurationMs)
+		default:
+			log.Printf("   %-14s %8dms", p.Name, p.DurationMs)
+		}
+	}
+	if t.budget > 0 && total > t.budget {
+		log.Printf("⚠️ Cycle took %v, over the %v budget", total.Round(time.Millisecond), t.budget)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// loadS3PrefixOverrides reads S3_PREFIX_<TYPE>, e.g. S3_PREFIX_EXPOSEDSECRETREPORTS, for
+// report types that are written under a different prefix than S3_PREFIX
+func loadS3PrefixOverrides() map[string]string {
+	overrides := make(map[string]string)
+	for _, r := range reportResources {
+		if p := strings.Trim(getEnv("S3_PREFIX_"+strings.ToUpper(r.Name), ""), "/"); p != "" {
+			overrides[r.Name] = p
+		}
+	}
+	return overrides
+}
+
+// s3ReportPrefixes returns the object prefix of every report type with an override
+func s3ReportPrefixes(cfg Config) map[string]string {
+	prefixes := make(map[string]string, len(cfg.S3PrefixOverrides))
+	for name, p := range cfg.S3PrefixOverrides {
+		prefixes[name] = objectPrefix(p, cfg.ClusterName)
+	}
+	return prefixes
+}
+
+// s3ObjectKeys returns the bucket key of every report file for index.json
+func s3ObjectKeys(cfg Config) map[string]string {
+	if cfg.S3Bucket == "" {
+		return nil
+	}
+	prefixes := s3ReportPrefixes(cfg)
+	keys := make(map[string]string, len(reportResources))
+	for _, r := range reportResources {
+		if !inScope(r, cfg.Scope) {
+			continue
+		}
+		prefix, ok := prefixes[r.Name]
+		if !ok {
+			prefix = objectPrefix(cfg.S3Prefix, cfg.ClusterName)
+		}
+		keys[r.FileName+".json"] = prefix + r.FileName + ".json"
+	}
+	return keys
+}
+
+// validateS3PrefixOverrides rejects overrides whose objects would land under another prefix
+// in use, where they could collide with or be read through the other report types' keys
+func validateS3PrefixOverrides(cfg Config) error {
+	if len(cfg.S3PrefixOverrides) == 0 {
+		return nil
+	}
+	if cfg.UploadMode == uploadBundle {
+		return fmt.Errorf("S3_PREFIX_<TYPE> overrides cannot be combined with UPLOAD_MODE=bundle, which publishes every report type in one archive")
+	}
+	owners := map[string]string{objectPrefix(cfg.S3Prefix, cfg.ClusterName): "S3_PREFIX"}
+	names := sortedKeys(cfg.S3PrefixOverrides)
+	for _, name := range names {
+		owners[objectPrefix(cfg.S3PrefixOverrides[name], cfg.ClusterName)] = "S3_PREFIX_" + strings.ToUpper(name)
+	}
+	prefixes := make([]string, 0, len(owners))
+	for p := range owners {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	for _, a := range prefixes {
+		for _, b := range prefixes {
+			if a != b && strings.HasPrefix(b, a) {
+				return fmt.Errorf("%s writes to %s, inside %s of %s; report types would collide", owners[b], b, a, owners[a])
+			}
+		}
+	}
+
+	seen := make(map[string]string)
+	for file, key := range s3ObjectKeys(cfg) {
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("%s and %s would both be written to %s", other, file, key)
+		}
+		seen[key] = file
+	}
+	return nil
+}
+
+// objectKey maps an artifact key to its bucket key. Report files, their checksums and
+// snapshot copies of a report type with an S3_PREFIX_<TYPE> override go under it.
+func (s *s3Sink) objectKey(key string) string {
+	if p, ok := s.reportPrefixes[reportTypeOf(key)]; ok {
+		return p + key
+	}
+	return s.prefix + key
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// Object written and removed by the S3_PREFLIGHT_WRITE check
+const preflightKey = ".preflight"
+
+// preflighter is implemented by sinks that can verify their access before the first cycle
+type preflighter interface {
+	preflight(ctx context.Context, cfg Config) error
+}
+
+// preflightSinks verifies the access of every sink that supports it, so a misconfigured
+// output stops the exporter instead of failing every upload. It runs whenever sinks are
+// created; SKIP_STARTUP_CHECKS turns it off.
+func preflightSinks(ctx context.Context, sinks []Sink, cfg Config) error {
+	if cfg.SkipStartupChecks {
+		log.Printf("⏭️ SKIP_STARTUP_CHECKS set, not verifying output access")
+		return nil
+	}
+	for _, sink := range sinks {
+		p, ok := sink.(preflighter)
+		if !ok {
+			continue
+		}
+		if err := p.preflight(ctx, cfg); err != nil {
+			return fmt.Errorf("%s preflight failed: %w", sink.Name(), err)
+		}
+		log.Printf("✅ %s output is reachable", sink.Name())
+	}
+	return nil
+}
+
+// preflight checks that the bucket exists and is accessible, and with S3_PREFLIGHT_WRITE
+// that objects can be written under the cluster prefix
+func (s *s3Sink) preflight(ctx context.Context, cfg Config) error {
+	err := withRetry(ctx, cfg, s, func() error {
+		_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+		return err
+	})
+	if err != nil {
+		return describeS3AccessError(err, "s3:ListBucket", "bucket "+s.bucket)
+	}
+	if !cfg.S3PreflightWrite {
+		return nil
+	}
+
+	err = withRetry(ctx, cfg, s, func() error {
+		_, err := s.client.PutObject(ctx, s.putObjectInput(preflightKey, bytes.NewReader(nil), 0, ""))
+		return err
+	})
+	if err != nil {
+		return describeS3AccessError(err, "s3:PutObject", s.prefix+preflightKey)
+	}
+	// Removed like any artifact, so DESTRUCTIVE_OPS=deny leaves the probe behind and the
+	// removal is audited. Deletions may be denied on purpose, so that is not an error.
+	req := DeletionRequest{Key: preflightKey, Size: 0, Feature: "preflight", Reason: "preflight"}
+	if err := deleteArtifact(ctx, []Sink{s}, cfg, req); err != nil {
+		log.Printf("⚠️ Could not remove %s%s: %v", s.prefix, preflightKey, err)
+	}
+	return nil
+}
+
+// describeS3AccessError names the missing bucket or permission. HEAD responses carry no
+// error body, so their status code is mapped to the action that was checked; other
+// errors keep AWS's message, which names the denied action and principal.
+func describeS3AccessError(err error, action, resource string) error {
+	var status interface{ HTTPStatusCode() int }
+	if errors.As(err, &status) {
+		switch status.HTTPStatusCode() {
+		case 404:
+			return fmt.Errorf("%s does not exist: %w", resource, err)
+		case 403:
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && apiErr.ErrorMessage() != "" {
+				return fmt.Errorf("access to %s denied: %s", resource, apiErr.ErrorMessage())
+			}
+			return fmt.Errorf("access to %s denied, the credentials lack %s: %w", resource, action, err)
+		}
+	}
+	return fmt.Errorf("failed to access %s: %w", resource, err)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Headers of the resumable push protocol. A dashboard API supporting it answers OPTIONS on
+// PUSH_URL with X-Trivy-Upload: resumable. An upload session is then opened with a POST to
+// <PUSH_URL>/<cluster>/<key> carrying the same header, whose response names the session;
+// the chunks are sent as PATCH requests to <PUSH_URL>/uploads/<session> with Content-Range,
+// and HEAD on the session returns the acknowledged offset to resume from.
+const (
+	pushUploadHeader  = "X-Trivy-Upload"
+	pushSessionHeader = "X-Trivy-Upload-Session"
+	pushOffsetHeader  = "X-Trivy-Upload-Offset"
+	pushLengthHeader  = "X-Trivy-Upload-Length"
+
+	pushResumable = "resumable"
+)
+
+// pushSink POSTs every artifact to <PUSH_URL>/<cluster>/<key>, for clusters that can reach
+// a central dashboard API but no object store
+type pushSink struct {
+	client    *http.Client
+	url       string
+	token     string
+	cluster   string
+	gzip      bool
+	chunkSize int         // PUSH_CHUNK_SIZE
+	resume    RetryPolicy // Attempts per chunk, resuming from the acknowledged offset
+
+	probeOnce sync.Once
+	resumable bool // The API advertised resumable uploads
+}
+
+func newPushSink(cfg Config) *pushSink {
+	return &pushSink{
+		client:    &http.Client{Timeout: cfg.PushTimeout},
+		url:       strings.TrimSuffix(cfg.PushURL, "/"),
+		token:     cfg.PushToken,
+		cluster:   cfg.ClusterName,
+		gzip:      cfg.PushGzip,
+		chunkSize: cfg.PushChunkSize,
+		resume:    cfg.retryPolicy("push"),
+	}
+}
+
+func (s *pushSink) Name() string { return "push" }
+
+// Put streams the artifact, gzip-compressed on the fly with PUSH_GZIP. Artifacts larger
+// than PUSH_CHUNK_SIZE are sent in resumable chunks when the API supports it.
+func (s *pushSink) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	body, length := r, size
+	if s.gzip {
+		pr, pw := io.Pipe()
+		go func() {
+			zw := gzip.NewWriter(pw)
+			_, err := io.Copy(zw, r)
+			if err == nil {
+				err = zw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		defer pr.Close()
+		body, length = pr, -1
+	}
+	if s.chunkSize <= 0 {
+		return s.post(ctx, key, body, length, size)
+	}
+
+	// The first chunk tells whether the artifact fits in a single request
+	br := bufio.NewReader(body)
+	chunk := make([]byte, s.chunkSize)
+	n, last, err := readChunk(br, chunk)
+	if err != nil {
+		return err
+	}
+	if last {
+		return s.post(ctx, key, bytes.NewReader(chunk[:n]), int64(n), size)
+	}
+	if !s.supportsResumable(ctx) {
+		return s.post(ctx, key, io.MultiReader(bytes.NewReader(chunk[:n]), br), length, size)
+	}
+
+	session, err := s.openSession(ctx, key, length)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for {
+		if err := s.sendChunk(ctx, session, offset, chunk[:n], last); err != nil {
+			return fmt.Errorf("failed to push %s at offset %d of session %s: %w", key, offset, session, err)
+		}
+		offset += int64(n)
+		if last {
+			return nil
+		}
+		if n, last, err = readChunk(br, chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// readChunk fills buf from r and reports whether the chunk ends the stream
+func readChunk(r *bufio.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+	if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+		return n, true, nil
+	}
+	return n, false, nil
+}
+
+// supportsResumable probes PUSH_URL with OPTIONS once. Any failure of the probe falls back
+// to single requests, which every API accepts.
+func (s *pushSink) supportsResumable(ctx context.Context) bool {
+	s.probeOnce.Do(func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodOptions, s.url, nil)
+		if err != nil {
+			return
+		}
+		s.authorize(req)
+		resp, err := s.client.Do(req)
+		if err != nil {
+			log.Printf("⚠️ OPTIONS %s failed, pushing artifacts in single requests: %v", s.url, err)
+			return
+		}
+		resp.Body.Close()
+		s.resumable = resp.StatusCode < 300 && strings.Contains(resp.Header.Get(pushUploadHeader), pushResumable)
+		if s.resumable {
+			log.Printf("📡 %s supports resumable uploads, pushing large artifacts in chunks of %d bytes", s.url, s.chunkSize)
+		} else {
+			log.Printf("📡 %s does not advertise resumable uploads, pushing artifacts in single requests", s.url)
+		}
+	})
+	return s.resumable
+}
+
+// post sends the artifact in a single request of length bytes, -1 when unknown. size is
+// the uncompressed size of the artifact.
+func (s *pushSink) post(ctx context.Context, key string, body io.Reader, length, size int64) error {
+	target := fmt.Sprintf("%s/%s/%s", s.url, s.cluster, key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, body)
+	if err != nil {
+		return err
+	}
+	if length >= 0 {
+		req.ContentLength = length
+	}
+	s.describe(req, key)
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		log.Printf("📦 %s rejected %s of cluster %s as too large (%d bytes uncompressed)", s.url, key, s.cluster, size)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &httpStatusError{code: resp.StatusCode, url: target}
+	}
+	return nil
+}
+
+// openSession starts a resumable upload and returns its session ID. size is -1 when
+// unknown, e.g. for gzip-compressed artifacts.
+func (s *pushSink) openSession(ctx context.Context, key string, size int64) (string, error) {
+	target := fmt.Sprintf("%s/%s/%s", s.url, s.cluster, key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, nil)
+	if err != nil {
+		return "", err
+	}
+	s.describe(req, key)
+	req.Header.Set(pushUploadHeader, pushResumable)
+	if size >= 0 {
+		req.Header.Set(pushLengthHeader, strconv.FormatInt(size, 10))
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", &httpStatusError{code: resp.StatusCode, url: target}
+	}
+	session := resp.Header.Get(pushSessionHeader)
+	if session == "" {
+		return "", fmt.Errorf("%s opened no upload session for %s", s.url, key)
+	}
+	return session, nil
+}
+
+// sendChunk PATCHes a chunk starting at offset. A failed attempt is resumed from the
+// offset the API acknowledged, so a connection dropped mid-chunk only resends the rest.
+func (s *pushSink) sendChunk(ctx context.Context, session string, offset int64, chunk []byte, last bool) error {
+	target := fmt.Sprintf("%s/uploads/%s", s.url, session)
+	end := offset + int64(len(chunk))
+	total := "*"
+	if last {
+		total = strconv.FormatInt(end, 10)
+	}
+
+	resuming := false
+	return s.resume.Do(ctx, "push", func() error {
+		from := offset
+		if resuming {
+			acked, err := s.acknowledgedOffset(ctx, target)
+			if err != nil {
+				return err
+			}
+			if acked < offset || acked > end {
+				return fmt.Errorf("%s acknowledged offset %d outside the chunk %d-%d", target, acked, offset, end)
+			}
+			if acked == end && !last {
+				return nil
+			}
+			from = acked
+		}
+		resuming = true
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPatch, target, bytes.NewReader(chunk[from-offset:]))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = end - from
+		if from < end {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", from, end-1, total))
+		} else {
+			// Every byte arrived before the connection dropped; only the completion is missing
+			req.Header.Set("Content-Range", "bytes */"+total)
+		}
+		s.authorize(req)
+
+		resp, err := s.do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return &httpStatusError{code: resp.StatusCode, url: target}
+		}
+		return nil
+	})
+}
+
+// acknowledgedOffset asks the API how much of the session it has stored
+func (s *pushSink) acknowledgedOffset(ctx context.Context, target string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	s.authorize(req)
+	resp, err := s.do(req)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, &httpStatusError{code: resp.StatusCode, url: target}
+	}
+	offset, err := strconv.ParseInt(resp.Header.Get(pushOffsetHeader), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s returned no valid %s: %w", target, pushOffsetHeader, err)
+	}
+	return offset, nil
+}
+
+// describe sets the headers naming the artifact
+func (s *pushSink) describe(req *http.Request, key string) {
+	if s.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("Content-Type", contentTypeFor(key))
+	req.Header.Set("X-Trivy-Cluster", s.cluster)
+	req.Header.Set("X-Trivy-Artifact", key)
+	s.authorize(req)
+}
+
+func (s *pushSink) authorize(req *http.Request) {
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+}
+
+// do sends the request and drains the response, which only carries status and headers
+func (s *pushSink) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, nil
+}
+
+// Get is unsupported: the push API is write-only
+func (s *pushSink) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, errReadUnsupported
+}
+
+func (s *pushSink) Delete(ctx context.Context, key string) error {
+	return errDeleteUnsupported
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushTestServer is a dashboard API accepting single POSTs and, when resumable, the chunked
+// upload sessions of the push sink. From the dropFrom-th PATCH on, drops PATCHes read
+// dropAfter bytes of their chunk and drop the connection, keeping what they read with
+// keepPartial.
+type pushTestServer struct {
+	resumable   bool
+	dropFrom    int
+	drops       int
+	dropAfter   int
+	keepPartial bool
+
+	mu       sync.Mutex
+	patches  int
+	requests []string // method and Content-Range of every request
+	sessions map[string]*pushTestSession
+	objects  map[string][]byte
+}
+
+type pushTestSession struct {
+	key  string
+	gzip bool
+	data []byte
+}
+
+func newPushTestServer(t *testing.T, s *pushTestServer) *httptest.Server {
+	s.sessions = make(map[string]*pushTestSession)
+	s.objects = make(map[string][]byte)
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *pushTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, strings.TrimSpace(r.Method+" "+r.Header.Get("Content-Range")))
+	gzipped := r.Header.Get("Content-Encoding") == "gzip"
+
+	switch {
+	case r.Method == http.MethodOptions:
+		if s.resumable {
+			w.Header().Set(pushUploadHeader, pushResumable)
+		}
+	case r.Method == http.MethodPost && r.Header.Get(pushUploadHeader) == pushResumable:
+		if !s.resumable {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id := fmt.Sprintf("session-%d", len(s.sessions)+1)
+		s.sessions[id] = &pushTestSession{key: strings.TrimPrefix(r.URL.Path, "/prod/"), gzip: gzipped}
+		w.Header().Set(pushSessionHeader, id)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPost:
+		data, _ := io.ReadAll(r.Body)
+		s.objects[strings.TrimPrefix(r.URL.Path, "/prod/")] = decodePushed(data, gzipped)
+	case r.Method == http.MethodHead:
+		session, ok := s.sessions[strings.TrimPrefix(r.URL.Path, "/uploads/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set(pushOffsetHeader, strconv.Itoa(len(session.data)))
+	case r.Method == http.MethodPatch:
+		session, ok := s.sessions[strings.TrimPrefix(r.URL.Path, "/uploads/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var from, to, total int
+		rangeHeader := r.Header.Get("Content-Range")
+		if strings.HasPrefix(rangeHeader, "bytes */") {
+			from, to = len(session.data), len(session.data)-1
+			total, _ = strconv.Atoi(strings.TrimPrefix(rangeHeader, "bytes */"))
+		} else if n, _ := fmt.Sscanf(rangeHeader, "bytes %d-%d/%d", &from, &to, &total); n < 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		} else if n == 2 {
+			total = -1 // bytes a-b/*
+		}
+		if from != len(session.data) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		s.patches++
+		if s.patches >= s.dropFrom && s.patches < s.dropFrom+s.drops {
+			buf := make([]byte, s.dropAfter)
+			n, _ := io.ReadFull(r.Body, buf)
+			if s.keepPartial {
+				session.data = append(session.data, buf[:n]...)
+			}
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if len(data) != to-from+1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		session.data = append(session.data, data...)
+		if total >= 0 && len(session.data) == total {
+			s.objects[session.key] = decodePushed(session.data, session.gzip)
+		}
+		w.Header().Set(pushOffsetHeader, strconv.Itoa(len(session.data)))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// methods returns the methods of the requests received so far
+func (s *pushTestServer) methods() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var methods []string
+	for _, r := range s.requests {
+		methods = append(methods, strings.Fields(r)[0])
+	}
+	return methods
+}
+
+func decodePushed(data []byte, gzipped bool) []byte {
+	if !gzipped {
+		return data
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	decoded, _ := io.ReadAll(zr)
+	return decoded
+}
+
+// randomArtifact returns incompressible content, so gzip-compressed pushes stay chunked
+func randomArtifact(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func testPushSink(url string, gzip bool, chunkSize int) *pushSink {
+	return &pushSink{
+		client:    &http.Client{Timeout: 5 * time.Second},
+		url:       url,
+		cluster:   "prod",
+		gzip:      gzip,
+		chunkSize: chunkSize,
+		resume:    RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Retryable: isRetryableHTTPError},
+	}
+}
+
+func TestPushSinkUploadModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		resumable bool
+		gzip      bool
+		chunkSize int
+		size      int
+		want      []string // request methods; PATCH... stands for at least two PATCHes
+	}{
+		{name: "chunking disabled", resumable: true, size: 4096, want: []string{"POST"}},
+		{name: "artifact within a chunk", resumable: true, chunkSize: 1024, size: 1024, want: []string{"POST"}},
+		{name: "empty artifact", resumable: true, chunkSize: 1024, want: []string{"POST"}},
+		{name: "resumable API", resumable: true, chunkSize: 1024, size: 2560, want: []string{"OPTIONS", "POST", "PATCH", "PATCH", "PATCH"}},
+		{name: "exact multiple of the chunk size", resumable: true, chunkSize: 1024, size: 2048, want: []string{"OPTIONS", "POST", "PATCH", "PATCH"}},
+		{name: "gzip to a resumable API", resumable: true, gzip: true, chunkSize: 1024, size: 8192, want: []string{"OPTIONS", "POST", "PATCH..."}},
+		{name: "API without resumable uploads", chunkSize: 1024, size: 2560, want: []string{"OPTIONS", "POST"}},
+		{name: "gzip to an API without resumable uploads", gzip: true, chunkSize: 1024, size: 8192, want: []string{"OPTIONS", "POST"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &pushTestServer{resumable: tt.resumable}
+			server := newPushTestServer(t, api)
+			sink := testPushSink(server.URL, tt.gzip, tt.chunkSize)
+
+			data := randomArtifact(tt.size)
+			if err := sink.Put(context.Background(), "vulnerability-reports.json", bytes.NewReader(data), int64(len(data))); err != nil {
+				t.Fatal(err)
+			}
+			api.mu.Lock()
+			got := api.objects["vulnerability-reports.json"]
+			api.mu.Unlock()
+			if !bytes.Equal(got, data) {
+				t.Errorf("API stored %d bytes, want the %d bytes pushed", len(got), len(data))
+			}
+
+			methods := api.methods()
+			want := tt.want
+			if want[len(want)-1] == "PATCH..." {
+				want = want[:len(want)-1]
+				if patches := methods[len(want):]; len(patches) < 2 || strings.Trim(strings.Join(patches, ""), "PATCH") != "" {
+					t.Errorf("requests = %v, want %v followed by PATCH requests", methods, want)
+				}
+				methods = methods[:len(want)]
+			}
+			if strings.Join(methods, " ") != strings.Join(want, " ") {
+				t.Errorf("requests = %v, want %v", methods, want)
+			}
+		})
+	}
+}
+
+// Connections dropped in the middle of a chunk are resumed from the offset the API
+// acknowledged, within the same upload session
+func TestPushSinkResumesAfterDisconnect(t *testing.T) {
+	tests := []struct {
+		name        string
+		dropFrom    int
+		drops       int
+		dropAfter   int
+		keepPartial bool
+		requests    []string
+		err         bool
+	}{
+		{
+			name:     "partial chunk discarded",
+			dropFrom: 2, drops: 1, dropAfter: 100,
+			requests: []string{"OPTIONS", "POST", "PATCH bytes 0-1023/*", "PATCH bytes 1024-2047/*", "HEAD", "PATCH bytes 1024-2047/*", "PATCH bytes 2048-2559/2560"},
+		},
+		{
+			name:     "partial chunk kept",
+			dropFrom: 2, drops: 1, dropAfter: 100, keepPartial: true,
+			requests: []string{"OPTIONS", "POST", "PATCH bytes 0-1023/*", "PATCH bytes 1024-2047/*", "HEAD", "PATCH bytes 1124-2047/*", "PATCH bytes 2048-2559/2560"},
+		},
+		{
+			name:     "last chunk received before the disconnect",
+			dropFrom: 3, drops: 1, dropAfter: 512, keepPartial: true,
+			requests: []string{"OPTIONS", "POST", "PATCH bytes 0-1023/*", "PATCH bytes 1024-2047/*", "PATCH bytes 2048-2559/2560", "HEAD", "PATCH bytes */2560"},
+		},
+		{
+			name:     "consecutive disconnects",
+			dropFrom: 1, drops: 2, dropAfter: 10, keepPartial: true,
+			requests: []string{"OPTIONS", "POST", "PATCH bytes 0-1023/*", "HEAD", "PATCH bytes 10-1023/*", "HEAD", "PATCH bytes 20-1023/*", "PATCH bytes 1024-2047/*", "PATCH bytes 2048-2559/2560"},
+		},
+		{
+			name:     "disconnects outlast the attempts",
+			dropFrom: 2, drops: 3, dropAfter: 100,
+			requests: []string{"OPTIONS", "POST", "PATCH bytes 0-1023/*", "PATCH bytes 1024-2047/*", "HEAD", "PATCH bytes 1024-2047/*", "HEAD", "PATCH bytes 1024-2047/*"},
+			err:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &pushTestServer{resumable: true, dropFrom: tt.dropFrom, drops: tt.drops, dropAfter: tt.dropAfter, keepPartial: tt.keepPartial}
+			server := newPushTestServer(t, api)
+			sink := testPushSink(server.URL, false, 1024)
+
+			data := randomArtifact(2560)
+			err := sink.Put(context.Background(), "vulnerability-reports.json", bytes.NewReader(data), int64(len(data)))
+			if tt.err != (err != nil) {
+				t.Fatalf("Put() = %v, want error %v", err, tt.err)
+			}
+
+			api.mu.Lock()
+			defer api.mu.Unlock()
+			if strings.Join(api.requests, ", ") != strings.Join(tt.requests, ", ") {
+				t.Errorf("requests =\n%v\nwant\n%v", api.requests, tt.requests)
+			}
+			if len(api.sessions) != 1 {
+				t.Errorf("%d upload sessions opened, want 1", len(api.sessions))
+			}
+			if got := api.objects["vulnerability-reports.json"]; !tt.err && !bytes.Equal(got, data) {
+				t.Errorf("API stored %d bytes, want the %d bytes pushed", len(got), len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NamespaceLimits are the item quotas of a namespace per report type; 0 means unlimited
+type NamespaceLimits struct {
+	Soft int `json:"soft,omitempty"`
+	Hard int `json:"hard,omitempty"`
+}
+
+// parseNamespaceLimitOverrides parses NAMESPACE_LIMIT_OVERRIDES, e.g.
+// "ci-runners=100/500,legacy=/2000". An empty side inherits the global limit.
+func parseNamespaceLimitOverrides(s string, global NamespaceLimits) map[string]NamespaceLimits {
+	overrides := make(map[string]NamespaceLimits)
+	for _, entry := range splitList(s) {
+		ns, limits, ok := strings.Cut(entry, "=")
+		soft, hard, ok2 := strings.Cut(limits, "/")
+		if !ok || !ok2 || strings.TrimSpace(ns) == "" {
+			log.Printf("⚠️ Ignoring invalid NAMESPACE_LIMIT_OVERRIDES entry %q (expected ns=soft/hard)", entry)
+			continue
+		}
+		l := global
+		if v, err := strconv.Atoi(strings.TrimSpace(soft)); err == nil {
+			l.Soft = v
+		}
+		if v, err := strconv.Atoi(strings.TrimSpace(hard)); err == nil {
+			l.Hard = v
+		}
+		overrides[strings.TrimSpace(ns)] = l
+	}
+	return overrides
+}
+
+// namespaceLimits returns the quotas of a namespace
+func (c Config) namespaceLimits(namespace string) NamespaceLimits {
+	if l, ok := c.NamespaceLimitOverrides[namespace]; ok {
+		return l
+	}
+	return c.NamespaceLimits
+}
+
+// quotasEnabled reports whether any namespace quota is configured
+func (c Config) quotasEnabled() bool {
+	return c.NamespaceLimits.Soft > 0 || c.NamespaceLimits.Hard > 0 || len(c.NamespaceLimitOverrides) > 0
+}
+
+// admitNamespaceItem counts an item against its namespace's quotas and reports whether it
+// may be exported. Items past the hard limit are counted as omitted.
+func (s *ResourceStats) admitNamespaceItem(cfg Config, resource, namespace string) bool {
+	if namespace == "" {
+		return true
+	}
+	if s.namespaceItems == nil {
+		s.namespaceItems = make(map[string]int)
+	}
+	limits := cfg.namespaceLimits(namespace)
+
+	if limits.Hard > 0 && s.namespaceItems[namespace] >= limits.Hard {
+		if s.TruncatedNamespaces == nil {
+			s.TruncatedNamespaces = make(map[string]int)
+		}
+		if s.TruncatedNamespaces[namespace] == 0 {
+			log.Printf("✂️ %s in %s reached the hard limit of %d items; omitting the rest this cycle", resource, namespace, limits.Hard)
+		}
+		s.TruncatedNamespaces[namespace]++
+		s.OmittedItems++
+		return false
+	}
+
+	s.namespaceItems[namespace]++
+	if limits.Soft > 0 && s.namespaceItems[namespace] == limits.Soft+1 {
+		log.Printf("⚠️ %s in %s exceeded the soft limit of %d items", resource, namespace, limits.Soft)
+		s.SoftLimitNamespaces = append(s.SoftLimitNamespaces, namespace)
+	}
+	return true
+}
+
+// NamespaceUsage is the item count of one namespace for one report type
+type NamespaceUsage struct {
+	Items        int             `json:"items"`
+	Limits       NamespaceLimits `json:"limits"`
+	SoftExceeded bool            `json:"softExceeded,omitempty"`
+	// Items not exported because the hard limit was reached
+	Omitted int `json:"omitted,omitempty"`
+}
+
+// NamespaceReport is published as namespaces.json when quotas are configured
+type NamespaceReport struct {
+	Cluster     string                               `json:"cluster"`
+	GeneratedAt string                               `json:"generatedAt"`
+	Truncated   bool                                 `json:"truncated"`
+	ReportTypes map[string]map[string]NamespaceUsage `json:"reportTypes"`
+}
+
+func buildNamespaceReport(cfg Config, resourceStats map[string]ResourceStats) NamespaceReport {
+	report := NamespaceReport{
+		Cluster:     cfg.ClusterName,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		ReportTypes: make(map[string]map[string]NamespaceUsage),
+	}
+	for name, stats := range resourceStats {
+		if len(stats.namespaceItems) == 0 && len(stats.TruncatedNamespaces) == 0 {
+			continue
+		}
+		soft := make(map[string]bool, len(stats.SoftLimitNamespaces))
+		for _, ns := range stats.SoftLimitNamespaces {
+			soft[ns] = true
+		}
+		usage := make(map[string]NamespaceUsage, len(stats.namespaceItems))
+		for ns, items := range stats.namespaceItems {
+			usage[ns] = NamespaceUsage{
+				Items:        items,
+				Limits:       cfg.namespaceLimits(ns),
+				SoftExceeded: soft[ns],
+				Omitted:      stats.TruncatedNamespaces[ns],
+			}
+		}
+		if stats.OmittedItems > 0 {
+			report.Truncated = true
+		}
+		report.ReportTypes[name] = usage
+	}
+	return report
+}
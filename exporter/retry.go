@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Components with their own retry policy overrides (RETRY_<COMPONENT>_*) and the
+// classifier deciding which of their errors are worth retrying
+var retryComponents = map[string]func(error) bool{
+	"s3":               isRetryableAWSError,
+	"gcs":              isRetryableGCSError,
+	"azure":            isRetryableAzureError,
+	"push":             isRetryableHTTPError,
+	"git":              nil, // pull/push failures are mostly rejected pushes or network errors
+	"oci":              isRetryableOCIError,
+	"opensearch":       isRetryableHTTPError,
+	"operator-metrics": isRetryableHTTPError,
+	"cloudfront":       isRetryableAWSError,
+	"kubernetes":       isRetryableK8sError,
+}
+
+// errDeadlineAhead is returned by RetryPolicy.Do when the next backoff would outlast the
+// deadline of its context
+var errDeadlineAhead = errors.New("deadline reached before the next attempt")
+
+// RetryPolicy is the backoff policy shared by every outbound call
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64 // fraction of each delay randomized, 0..1
+	// Deadline bounds the attempts and backoff sleeps of one call; 0 means no bound
+	Deadline time.Duration
+
+	// Retryable classifies errors; nil retries everything except context cancellation
+	Retryable func(error) bool
+}
+
+// loadRetryPolicy reads <prefix>MAX_ATTEMPTS, <prefix>BASE_DELAY, <prefix>MAX_DELAY,
+// <prefix>JITTER and <prefix>DEADLINE, falling back to base for unset values
+func loadRetryPolicy(prefix string, base RetryPolicy) RetryPolicy {
+	p := base
+	if v := getEnv(prefix+"MAX_ATTEMPTS", ""); v != "" {
+		p.MaxAttempts = parseInt(v, base.MaxAttempts)
+	}
+	if v := getEnv(prefix+"BASE_DELAY", ""); v != "" {
+		p.BaseDelay = parseDuration(v, base.BaseDelay)
+	}
+	if v := getEnv(prefix+"MAX_DELAY", ""); v != "" {
+		p.MaxDelay = parseDuration(v, base.MaxDelay)
+	}
+	if v := getEnv(prefix+"JITTER", ""); v != "" {
+		p.Jitter = parseFloat(v, base.Jitter)
+	}
+	if v := getEnv(prefix+"DEADLINE", ""); v != "" {
+		p.Deadline = parseDuration(v, base.Deadline)
+	}
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	return p
+}
+
+// RetryPolicyFile is the YAML or JSON of RETRY_POLICY_FILE: settings of the global policy
+// and per-component overrides, e.g.
+//
+//	maxAttempts: 5
+//	baseDelay: 2s
+//	components:
+//	  s3:
+//	    maxAttempts: 8
+//	  kubernetes:
+//	    deadline: 10m
+//
+// RETRY_* environment variables take precedence over the file at the same level, and the
+// settings of a component over the global ones.
+type RetryPolicyFile struct {
+	RetryOverrides
+	Components map[string]RetryOverrides `json:"components,omitempty"`
+}
+
+// RetryOverrides are the settings of a policy in RETRY_POLICY_FILE; unset ones are inherited
+type RetryOverrides struct {
+	MaxAttempts *int     `json:"maxAttempts,omitempty"`
+	BaseDelay   string   `json:"baseDelay,omitempty"`
+	MaxDelay    string   `json:"maxDelay,omitempty"`
+	Jitter      *float64 `json:"jitter,omitempty"`
+	Deadline    string   `json:"deadline,omitempty"`
+}
+
+// loadRetryPolicyFile reads RETRY_POLICY_FILE, nil without one
+func loadRetryPolicyFile(path string) (*RetryPolicyFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RETRY_POLICY_FILE: %w", err)
+	}
+	var file RetryPolicyFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid RETRY_POLICY_FILE %s: %w", path, err)
+	}
+	if _, err := file.RetryOverrides.apply(RetryPolicy{}); err != nil {
+		return nil, fmt.Errorf("invalid RETRY_POLICY_FILE %s: %w", path, err)
+	}
+	for _, c := range sortedKeys(file.Components) {
+		if _, ok := retryComponents[c]; !ok {
+			return nil, fmt.Errorf("invalid RETRY_POLICY_FILE %s: unknown component %q (valid: %s)", path, c, strings.Join(sortedKeys(retryComponents), ", "))
+		}
+		if _, err := file.Components[c].apply(RetryPolicy{}); err != nil {
+			return nil, fmt.Errorf("invalid RETRY_POLICY_FILE %s: component %s: %w", path, c, err)
+		}
+	}
+	return &file, nil
+}
+
+// apply returns base with the settings that are set
+func (o RetryOverrides) apply(base RetryPolicy) (RetryPolicy, error) {
+	p := base
+	if o.MaxAttempts != nil {
+		p.MaxAttempts = *o.MaxAttempts
+	}
+	if o.Jitter != nil {
+		p.Jitter = *o.Jitter
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		into  *time.Duration
+	}{
+		{"baseDelay", o.BaseDelay, &p.BaseDelay},
+		{"maxDelay", o.MaxDelay, &p.MaxDelay},
+		{"deadline", o.Deadline, &p.Deadline},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return base, fmt.Errorf("invalid %s %q: %w", d.name, d.value, err)
+		}
+		*d.into = parsed
+	}
+	return p, nil
+}
+
+// loadRetryPolicies returns the global policy and the resolved policy of every component,
+// applying RETRY_POLICY_FILE when file is not nil
+func loadRetryPolicies(file *RetryPolicyFile) (RetryPolicy, map[string]RetryPolicy) {
+	if file == nil {
+		file = &RetryPolicyFile{}
+	}
+	// Validated by loadRetryPolicyFile
+	defaults, _ := file.RetryOverrides.apply(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.2,
+	})
+	global := loadRetryPolicy("RETRY_", defaults)
+	components := make(map[string]RetryPolicy, len(retryComponents))
+	for c, classify := range retryComponents {
+		base, _ := file.Components[c].apply(global)
+		p := loadRetryPolicy(fmt.Sprintf("RETRY_%s_", strings.ToUpper(c)), base)
+		p.Retryable = classify
+		components[c] = p
+	}
+	return global, components
+}
+
+// retryPolicy returns the policy of a component, falling back to the global policy
+func (c Config) retryPolicy(component string) RetryPolicy {
+	if p, ok := c.RetryPolicies[component]; ok {
+		return p
+	}
+	return c.Retry
+}
+
+// delay returns the backoff before the given retry (1-based)
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(float64(d) * p.Jitter * (rand.Float64()*2 - 1))
+	}
+	return d
+}
+
+// Do runs fn until it succeeds, returns a non-retryable error, or the attempts or the
+// deadline of the policy or ctx run out. A longer wait asked for by the server replaces the backoff delay.
+// Backoff sleeps are interrupted by context cancellation.
+func (p RetryPolicy) Do(ctx context.Context, component string, fn func() error) error {
+	classify := p.Retryable
+	if classify == nil {
+		classify = func(error) bool { return true }
+	}
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		if !classify(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		wait := p.delay(attempt)
+		if d := serverDelay(err); d > wait {
+			wait = d
+		}
+		if p.Deadline > 0 && time.Since(start)+wait > p.Deadline {
+			return fmt.Errorf("giving up after %d attempts, retry deadline %v reached: %w", attempt, p.Deadline, err)
+		}
+		// No point in sleeping into the deadline of the caller
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("giving up after %d attempts, %w: %w", attempt, errDeadlineAhead, err)
+		}
+		retryCounts.addRetry(ctx, component)
+		log.Printf("🔁 %s attempt %d/%d failed, retrying in %v: %v", component, attempt, p.MaxAttempts, wait.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("retry interrupted after %d attempts: %w", attempt, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// serverDelay returns the wait the server asked for, such as the Retry-After of a throttled
+// Kubernetes API request, or 0
+func serverDelay(err error) time.Duration {
+	if seconds, ok := apierrors.SuggestsClientDelay(err); ok {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// isRetryableHTTPError retries throttling (429), server errors (5xx) and network failures.
+// Other client errors such as 403 or 404 will not succeed on retry.
+func isRetryableHTTPError(err error) bool {
+	var status interface{ HTTPStatusCode() int }
+	if errors.As(err, &status) {
+		code := status.HTTPStatusCode()
+		return code == 429 || code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// httpStatusError is returned for unexpected HTTP responses; its status code drives
+// isRetryableHTTPError
+type httpStatusError struct {
+	code int
+	url  string
+}
+
+func (e *httpStatusError) Error() string       { return fmt.Sprintf("%s returned HTTP %d", e.url, e.code) }
+func (e *httpStatusError) HTTPStatusCode() int { return e.code }
+
+// isRetryableAWSError extends isRetryableHTTPError with the SDK's throttle and transient codes,
+// uploads that failed verification and streamed uploads rejected without a length
+func isRetryableAWSError(err error) bool {
+	var mismatch *uploadMismatchError
+	if errors.As(err, &mismatch) {
+		return true
+	}
+	var lengthRequired *lengthRequiredError
+	if errors.As(err, &lengthRequired) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if _, ok := retry.DefaultThrottleErrorCodes[apiErr.ErrorCode()]; ok {
+			return true
+		}
+		if _, ok := retry.DefaultRetryableErrorCodes[apiErr.ErrorCode()]; ok {
+			return true
+		}
+	}
+	return isRetryableHTTPError(err)
+}
+
+// retryCounts tracks retries per component for the current cycle, and per component of
+// each report type whose calls carry it in their context
+var retryCounts = &retryCounter{counts: make(map[string]int), byResource: make(map[string]map[string]int)}
+
+type retryCounter struct {
+	mu         sync.Mutex
+	counts     map[string]int
+	byResource map[string]map[string]int
+}
+
+type retryResourceKey struct{}
+
+// withRetryResource attributes the retries of the calls made with ctx to a report type
+func withRetryResource(ctx context.Context, resource string) context.Context {
+	return context.WithValue(ctx, retryResourceKey{}, resource)
+}
+
+func (r *retryCounter) add(key string) {
+	r.mu.Lock()
+	r.counts[key]++
+	r.mu.Unlock()
+}
+
+// addRetry counts a retry of component, also for the report type of ctx
+func (r *retryCounter) addRetry(ctx context.Context, component string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[component]++
+	if resource, _ := ctx.Value(retryResourceKey{}).(string); resource != "" {
+		if r.byResource[resource] == nil {
+			r.byResource[resource] = make(map[string]int)
+		}
+		r.byResource[resource][component]++
+	}
+}
+
+// resource returns and clears the retries of a report type by component, nil without any
+func (r *retryCounter) resource(name string) map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := r.byResource[name]
+	delete(r.byResource, name)
+	return counts
+}
+
+// reset returns the counts accumulated since the last reset
+func (r *retryCounter) reset() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := r.counts
+	r.counts = make(map[string]int)
+	r.byResource = make(map[string]map[string]int)
+	return counts
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRetryClassifiers(t *testing.T) {
+	reports := schema.GroupResource{Group: "aquasecurity.github.io", Resource: "vulnerabilityreports"}
+	tests := []struct {
+		name      string
+		component string
+		err       error
+		want      bool
+	}{
+		{"throttled", "push", &httpStatusError{code: 429}, true},
+		{"forbidden", "push", &httpStatusError{code: 403}, false},
+		{"not found", "opensearch", &httpStatusError{code: 404}, false},
+		{"server error", "opensearch", &httpStatusError{code: 503}, true},
+		{"s3 throttled", "s3", &httpStatusError{code: 429}, true},
+		{"s3 forbidden", "s3", &httpStatusError{code: 403}, false},
+		{"k8s throttled", "kubernetes", apierrors.NewTooManyRequests("slow down", 1), true},
+		{"k8s forbidden", "kubernetes", apierrors.NewForbidden(reports, "", errors.New("rbac")), false},
+		{"k8s server timeout", "kubernetes", apierrors.NewServerTimeout(reports, "list", 1), true},
+		{"k8s unavailable", "kubernetes", apierrors.NewServiceUnavailable("etcd leader change"), true},
+		{"k8s expired continue token", "kubernetes", apierrors.NewResourceExpired("too old"), false},
+		{"k8s missing CRD", "kubernetes", apierrors.NewNotFound(reports, ""), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryComponents[tt.component](tt.err); got != tt.want {
+				t.Errorf("retryable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	throttled := &httpStatusError{code: 429}
+	tests := []struct {
+		name     string
+		errs     []error // Results of the attempts in order, nil for success
+		attempts int
+		wantErr  error
+	}{
+		{name: "succeeds after retries", errs: []error{throttled, throttled, nil}, attempts: 3},
+		{name: "gives up after max attempts", errs: []error{throttled, throttled, throttled, throttled}, attempts: 3, wantErr: throttled},
+		{name: "non-retryable fails fast", errs: []error{&httpStatusError{code: 403}}, attempts: 1, wantErr: &httpStatusError{code: 403}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Retryable: isRetryableHTTPError}
+			attempts := 0
+			err := p.Do(context.Background(), "push", func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			if attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.attempts)
+			}
+			var status *httpStatusError
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && (!errors.As(err, &status) || status.code != tt.wantErr.(*httpStatusError).code) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoInterruptedByCancel(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	started := time.Now()
+	attempts := 0
+	err := p.Do(ctx, "push", func() error {
+		attempts++
+		return &httpStatusError{code: 503}
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("err = %v after %d attempts, want the first failure", err, attempts)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("cancel interrupted the backoff after %v", elapsed)
+	}
+}
+
+func TestLoadRetryPoliciesPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "retry.yaml")
+	os.WriteFile(file, []byte(`maxAttempts: 5
+baseDelay: 2s
+components:
+  s3:
+    maxAttempts: 8
+  kubernetes:
+    deadline: 10m
+`), 0644)
+	t.Setenv("RETRY_MAX_DELAY", "1m")
+	t.Setenv("RETRY_GIT_BASE_DELAY", "5s")
+	t.Setenv("RETRY_KUBERNETES_DEADLINE", "3m")
+
+	policies, err := loadRetryPolicyFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, components := loadRetryPolicies(policies)
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+		base     time.Duration
+		max      time.Duration
+		deadline time.Duration
+	}{
+		{name: "global from file and variables", policy: global, attempts: 5, base: 2 * time.Second, max: time.Minute},
+		{name: "component inherits the global policy", policy: components["push"], attempts: 5, base: 2 * time.Second, max: time.Minute},
+		{name: "component in the file", policy: components["s3"], attempts: 8, base: 2 * time.Second, max: time.Minute},
+		{name: "component variable", policy: components["git"], attempts: 5, base: 5 * time.Second, max: time.Minute},
+		{name: "component variable over the file", policy: components["kubernetes"], attempts: 5, base: 2 * time.Second, max: time.Minute, deadline: 3 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.policy
+			if p.MaxAttempts != tt.attempts || p.BaseDelay != tt.base || p.MaxDelay != tt.max || p.Deadline != tt.deadline {
+				t.Errorf("policy = %d attempts, %v base, %v max, %v deadline; want %d, %v, %v, %v",
+					p.MaxAttempts, p.BaseDelay, p.MaxDelay, p.Deadline, tt.attempts, tt.base, tt.max, tt.deadline)
+			}
+		})
+	}
+}
+
+func TestLoadRetryPolicyFileErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data string
+	}{
+		{"unknown component", "components:\n  jira:\n    maxAttempts: 2\n"},
+		{"invalid duration", "baseDelay: soon\n"},
+		{"invalid component duration", "components:\n  s3:\n    deadline: 5\n"},
+		{"unknown setting", "attempts: 2\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "retry.yaml")
+			os.WriteFile(file, []byte(tt.data), 0644)
+			if _, err := loadRetryPolicyFile(file); err == nil {
+				t.Error("loadRetryPolicyFile() succeeded")
+			}
+		})
+	}
+}
+
+func TestRetryCountsPerResource(t *testing.T) {
+	counts := &retryCounter{counts: make(map[string]int), byResource: make(map[string]map[string]int)}
+	ctx := withRetryResource(context.Background(), "vulnerabilityreports")
+	counts.addRetry(ctx, "kubernetes")
+	counts.addRetry(ctx, "s3")
+	counts.addRetry(ctx, "s3")
+	counts.addRetry(context.Background(), "s3")
+
+	if got := counts.resource("vulnerabilityreports"); got["kubernetes"] != 1 || got["s3"] != 2 {
+		t.Errorf("retries of the report type = %v", got)
+	}
+	if got := counts.reset(); got["kubernetes"] != 1 || got["s3"] != 3 {
+		t.Errorf("retries of the cycle = %v", got)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3TestEnv configures an S3-compatible endpoint the way a MinIO deployment would: plain
+// HTTP, path-style addressing, static credentials and no AWS_REGION
+func s3TestEnv(t *testing.T, endpoint, bucket, accessKey, secretKey string) Config {
+	t.Helper()
+	t.Setenv("S3_ENDPOINT", endpoint)
+	t.Setenv("S3_FORCE_PATH_STYLE", "true")
+	t.Setenv("S3_DISABLE_SSL", "true")
+	t.Setenv("S3_BUCKET", bucket)
+	t.Setenv("AWS_ACCESS_KEY_ID", accessKey)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", secretKey)
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("CLUSTER_NAME", "prod")
+	cfg := configFromEnv()
+	if cfg.AWSRegion != "us-east-1" {
+		t.Fatalf("region = %q, want us-east-1 for a custom endpoint", cfg.AWSRegion)
+	}
+	return cfg
+}
+
+// publishSmokeArtifacts publishes a streamed report file and a small buffer through the S3 sink
+func publishSmokeArtifacts(t *testing.T, sink Sink, cfg Config) map[string]string {
+	t.Helper()
+	artifacts := map[string]string{
+		"vulnerability-reports.json": `{"items": [{"metadata": {"name": "a"}}]}`,
+		"index.json":                 `{"cluster": "prod"}`,
+	}
+	for _, name := range sortedKeys(artifacts) {
+		a := Artifact{Name: name, Data: []byte(artifacts[name])}
+		if isReportFile(name) {
+			f, err := os.CreateTemp(t.TempDir(), "report-*.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			f.WriteString(artifacts[name])
+			a = Artifact{Name: name, File: f}
+		}
+		if err := publishArtifact(context.Background(), []Sink{sink}, cfg, a); err != nil {
+			t.Fatalf("publishing %s: %v", name, err)
+		}
+	}
+	return artifacts
+}
+
+// With S3_FORCE_PATH_STYLE the bucket is part of the path, not the host name
+func TestS3SinkPathStyleRequests(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.Host+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Has("uploads"):
+			io.WriteString(w, `<ListMultipartUploadsResult><Bucket>exports</Bucket></ListMultipartUploadsResult>`)
+		case r.Method == http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(data)
+		case r.Method == http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+				return
+			}
+			io.WriteString(w, data)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	t.Setenv("S3_VERIFY_UPLOADS", "false")
+	cfg := s3TestEnv(t, host, "exports", "minio", "minio123")
+	sinks, err := newSinks(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts := publishSmokeArtifacts(t, sinks[0], cfg)
+	for _, name := range sortedKeys(artifacts) {
+		if data, err := sinks[0].Get(context.Background(), name); err != nil || string(data) != artifacts[name] {
+			t.Errorf("Get(%s) = %q, %v; want %q", name, data, err, artifacts[name])
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range sortedKeys(artifacts) {
+		want := "PUT " + host + "/exports/" + sinks[0].(*s3Sink).objectKey(name)
+		found := false
+		for _, r := range requests {
+			found = found || r == want
+		}
+		if !found {
+			t.Errorf("no %s request, got %v", want, requests)
+		}
+	}
+	for _, r := range requests {
+		if !strings.HasPrefix(strings.Fields(r)[1], host+"/exports") {
+			t.Errorf("request %s does not address the bucket by path on %s", r, host)
+		}
+	}
+}
+
+// TestMinIOSmoke uploads to a real MinIO server when MINIO_ENDPOINT is set, e.g.
+//
+//	docker run -d -p 9000:9000 minio/minio server /data
+//	MINIO_ENDPOINT=localhost:9000 go test -run TestMinIOSmoke .
+//
+// MINIO_ACCESS_KEY and MINIO_SECRET_KEY default to the minioadmin credentials of the image.
+func TestMinIOSmoke(t *testing.T) {
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_ENDPOINT not set")
+	}
+	cfg := s3TestEnv(t, endpoint, "trivy-exporter-smoke",
+		getEnv("MINIO_ACCESS_KEY", "minioadmin"), getEnv("MINIO_SECRET_KEY", "minioadmin"))
+	ctx := context.Background()
+
+	client, err := newS3Client(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(cfg.S3Bucket)})
+	var owned *types.BucketAlreadyOwnedByYou
+	if err != nil && !errors.As(err, &owned) {
+		t.Fatalf("creating bucket %s: %v", cfg.S3Bucket, err)
+	}
+
+	sinks, err := newSinks(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Uploads are verified against the stored object, as S3_VERIFY_UPLOADS is on by default
+	artifacts := publishSmokeArtifacts(t, sinks[0], cfg)
+	for _, name := range sortedKeys(artifacts) {
+		key := sinks[0].(*s3Sink).objectKey(name)
+		obj, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(cfg.S3Bucket), Key: aws.String(key)})
+		if err != nil {
+			t.Fatalf("object of %s: %v", name, err)
+		}
+		data, _ := io.ReadAll(obj.Body)
+		obj.Body.Close()
+		if string(data) != artifacts[name] {
+			t.Errorf("object of %s = %q, want %q", name, data, artifacts[name])
+		}
+	}
+}
+
+func TestS3PutObjectInputStorageClass(t *testing.T) {
+	tests := []struct {
+		name  string
+		class types.StorageClass
+		key   string
+	}{
+		{name: "bucket default", key: "vulnerability-reports.json"},
+		{name: "report file", class: types.StorageClassStandardIa, key: "vulnerability-reports.json"},
+		{name: "snapshot", class: types.StorageClassIntelligentTiering, key: snapshotKey("20260301-100000", "vulnerability-reports.json")},
+		{name: "index", class: types.StorageClassStandardIa, key: "index.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &s3Sink{bucket: "exports", prefix: objectPrefix("vuln", "prod"), class: tt.class}
+			input := sink.putObjectInput(tt.key, strings.NewReader("{}"), 2, "20260301-100000")
+			if input.StorageClass != tt.class {
+				t.Errorf("StorageClass = %q, want %q", input.StorageClass, tt.class)
+			}
+			if got := aws.ToString(input.Key); got != "vuln/prod/"+tt.key {
+				t.Errorf("Key = %q, want %q", got, "vuln/prod/"+tt.key)
+			}
+		})
+	}
+}
+
+func TestLoadConfigStorageClass(t *testing.T) {
+	tests := []struct {
+		class string
+		valid bool
+	}{
+		{class: "", valid: true},
+		{class: "STANDARD_IA", valid: true},
+		{class: "INTELLIGENT_TIERING", valid: true},
+		{class: "GLACIER_IR", valid: true},
+		{class: "standard_ia"},
+		{class: "COLD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			t.Setenv("FS_OUTPUT_DIR", t.TempDir())
+			t.Setenv("S3_STORAGE_CLASS", tt.class)
+			cfg, err := loadConfig()
+			if !tt.valid {
+				// The error lists the accepted classes
+				if err == nil || !strings.Contains(err.Error(), "S3_STORAGE_CLASS") || !strings.Contains(err.Error(), "STANDARD_IA") {
+					t.Errorf("loadConfig() = %v, want an error listing the valid storage classes", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.S3StorageClass != tt.class {
+				t.Errorf("S3StorageClass = %q, want %q", cfg.S3StorageClass, tt.class)
+			}
+		})
+	}
+}
+
+// Compressed report files are streamed with their size and SHA-256 computed on the fly, or
+// compressed to a temp file first for stores that need the length up front
+func TestS3SinkCompressedUploadModes(t *testing.T) {
+	tests := []struct {
+		name          string
+		requireLength bool
+		rejects       int // Leading PUTs answered with 411 MissingContentLength
+		puts          int
+		modes         []string // Of the first and second upload
+		streaming     string
+	}{
+		{name: "streamed", puts: 2, modes: []string{s3UploadStreamed, s3UploadStreamed}, streaming: s3StreamingEnabled},
+		{name: "S3_REQUIRE_LENGTH", requireLength: true, puts: 2, modes: []string{s3UploadSpooled, s3UploadSpooled}, streaming: s3StreamingDisabled},
+		{name: "store rejects streamed uploads", rejects: 1, puts: 3, modes: []string{s3UploadSpooled, s3UploadSpooled}, streaming: s3StreamingRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var stored []byte
+			puts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case r.Method == http.MethodGet && r.URL.Query().Has("uploads"):
+					io.WriteString(w, `<ListMultipartUploadsResult><Bucket>exports</Bucket></ListMultipartUploadsResult>`)
+				case r.Method == http.MethodPut:
+					puts++
+					body, _ := io.ReadAll(r.Body)
+					if puts <= tt.rejects {
+						w.WriteHeader(http.StatusLengthRequired)
+						io.WriteString(w, `<Error><Code>MissingContentLength</Code><Message>You must provide the Content-Length HTTP header.</Message></Error>`)
+						return
+					}
+					stored = body
+				default:
+					w.WriteHeader(http.StatusNotImplemented)
+				}
+			}))
+			defer server.Close()
+
+			savedStaging := stagingDir
+			defer func() { stagingDir = savedStaging }()
+			stagingDir = t.TempDir()
+			t.Setenv("S3_VERIFY_UPLOADS", "false")
+			t.Setenv("COMPRESS_UPLOADS", compressGzip)
+			t.Setenv("S3_REQUIRE_LENGTH", fmt.Sprint(tt.requireLength))
+			t.Setenv("RETRY_S3_MAX_ATTEMPTS", "2")
+			t.Setenv("RETRY_S3_BASE_DELAY", "1ms")
+			cfg := s3TestEnv(t, strings.TrimPrefix(server.URL, "http://"), "exports", "minio", "minio123")
+			cfg.ForceUpload = true
+			sinks, err := newSinks(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, mode := range tt.modes {
+				f, err := os.CreateTemp(t.TempDir(), "report-*.json")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				fmt.Fprintf(f, `{"items": [{"metadata": {"name": "upload-%d"}}]}`, i)
+				if err := publishArtifact(context.Background(), sinks, cfg, Artifact{Name: "vulnerability-reports.json", File: f}); err != nil {
+					t.Fatal(err)
+				}
+
+				mu.Lock()
+				sum := sha256.Sum256(stored)
+				want := S3Upload{Mode: mode, Bytes: int64(len(stored)), SHA256: hex.EncodeToString(sum[:])}
+				mu.Unlock()
+				if got := s3Uploads.lookup("vulnerability-reports.json"); got == nil || *got != want {
+					t.Errorf("upload %d = %+v, want %+v", i, got, want)
+				}
+			}
+
+			if puts != tt.puts {
+				t.Errorf("%d PUT requests, want %d", puts, tt.puts)
+			}
+			if got := s3StreamingOf(sinks, cfg); got != tt.streaming {
+				t.Errorf("s3Streaming = %q, want %q", got, tt.streaming)
+			}
+			if entries, _ := os.ReadDir(stagingDir); len(entries) != 0 {
+				t.Errorf("temp files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+// resetPendingDeletions drops the audit records left by earlier tests
+func resetPendingDeletions() {
+	pendingDeletions.mu.Lock()
+	pendingDeletions.records = nil
+	pendingDeletions.mu.Unlock()
+	deletionCounts.reset()
+}
+
+// The S3_PREFLIGHT_WRITE probe is removed through deleteArtifact: audited, and left in place
+// with DESTRUCTIVE_OPS=deny
+func TestS3PreflightWriteProbe(t *testing.T) {
+	tests := []struct {
+		destructiveOps string
+		deletes        int
+		skipped        string
+	}{
+		{destructiveOps: "allow", deletes: 1},
+		{destructiveOps: destructiveDeny, skipped: "denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.destructiveOps, func(t *testing.T) {
+			var mu sync.Mutex
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				switch r.Method {
+				case http.MethodHead, http.MethodPut:
+				case http.MethodDelete:
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					w.WriteHeader(http.StatusNotImplemented)
+				}
+			}))
+			defer server.Close()
+			resetPendingDeletions()
+			t.Cleanup(resetPendingDeletions)
+
+			t.Setenv("S3_PREFLIGHT_WRITE", "true")
+			t.Setenv("DESTRUCTIVE_OPS", tt.destructiveOps)
+			cfg := s3TestEnv(t, strings.TrimPrefix(server.URL, "http://"), "exports", "minio", "minio123")
+			sinks, err := newSinks(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := preflightSinks(context.Background(), sinks, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			deletes := 0
+			for _, r := range requests {
+				if strings.HasPrefix(r, "DELETE ") && strings.HasSuffix(r, "/prod/"+preflightKey) {
+					deletes++
+				}
+			}
+			mu.Unlock()
+			if deletes != tt.deletes {
+				t.Errorf("%d DELETE requests for the probe, want %d: %v", deletes, tt.deletes, requests)
+			}
+			pendingDeletions.mu.Lock()
+			records := pendingDeletions.records
+			pendingDeletions.mu.Unlock()
+			if len(records) != 1 || records[0].Feature != "preflight" || records[0].Key != preflightKey || records[0].Skipped != tt.skipped {
+				t.Errorf("audit records = %+v, want one preflight record skipped %q", records, tt.skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/smithy-go"
+)
+
+// How a report file was sent to S3
+const (
+	s3UploadSized    = "sized"    // from the report file, with its length
+	s3UploadStreamed = "streamed" // gzip-compressed on the fly, without a length
+	s3UploadSpooled  = "spooled"  // gzip-compressed to a temp file first (S3_REQUIRE_LENGTH)
+)
+
+// Streaming of compressed uploads, published in index.json as s3Streaming
+const (
+	s3StreamingEnabled  = "enabled"
+	s3StreamingDisabled = "disabled" // S3_REQUIRE_LENGTH
+	s3StreamingRejected = "rejected" // the store rejected a streamed upload without a length
+)
+
+// S3Upload describes the last upload of a report file to S3. Bytes and SHA256 are those of
+// the object as sent, computed while compressed uploads are streamed.
+type S3Upload struct {
+	Mode   string `json:"mode"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256,omitempty"` // Only for compressed uploads; see digest otherwise
+}
+
+// s3Uploads remembers the last S3 upload of each report file, so report files skipped as
+// unchanged keep describing the object they left on S3
+var s3Uploads = &uploadRecorder{uploads: make(map[string]S3Upload)}
+
+type uploadRecorder struct {
+	mu      sync.Mutex
+	uploads map[string]S3Upload
+}
+
+func (r *uploadRecorder) record(key string, u S3Upload) {
+	r.mu.Lock()
+	r.uploads[key] = u
+	r.mu.Unlock()
+}
+
+// lookup returns the last upload of a report file, nil without S3 uploads
+func (r *uploadRecorder) lookup(key string) *S3Upload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.uploads[key]
+	if !ok {
+		return nil
+	}
+	return &u
+}
+
+// lengthRequiredError reports a streamed upload the store rejected for its missing length.
+// It is retried like a transient S3 error; the retry is compressed to a temp file.
+type lengthRequiredError struct {
+	key string
+	err error
+}
+
+func (e *lengthRequiredError) Error() string {
+	return fmt.Sprintf("streamed upload of %s rejected without a length: %v", e.key, e.err)
+}
+
+func (e *lengthRequiredError) Unwrap() error { return e.err }
+
+// isLengthRequiredError recognizes the responses of S3-compatible stores, such as older
+// MinIO and Ceph RGW releases, that do not accept uploads without a Content-Length
+func isLengthRequiredError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "MissingContentLength", "NotImplemented":
+			return true
+		}
+	}
+	var status interface{ HTTPStatusCode() int }
+	return errors.As(err, &status) && status.HTTPStatusCode() == 411
+}
+
+// streaming reports whether compressed uploads are streamed, "" when nothing is compressed
+func (s *s3Sink) streaming(cfg Config) string {
+	switch {
+	case !s.compress:
+		return ""
+	case cfg.S3RequireLength:
+		return s3StreamingDisabled
+	case s.requireLength.Load():
+		return s3StreamingRejected
+	default:
+		return s3StreamingEnabled
+	}
+}
+
+// s3StreamingOf returns the streaming state of the S3 sink among sinks, "" without one
+func s3StreamingOf(sinks []Sink, cfg Config) string {
+	for _, sink := range sinks {
+		if s, ok := sink.(*s3Sink); ok {
+			return s.streaming(cfg)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// s3HTTPClient returns the HTTP client of the AWS config when S3_CA_BUNDLE or
+// S3_INSECURE_SKIP_VERIFY is set, nil to keep the SDK default
+func s3HTTPClient(cfg Config) (*awshttp.BuildableClient, error) {
+	if cfg.S3CABundle == "" && !cfg.S3InsecureSkipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.S3CABundle != "" {
+		pool, err := loadS3CABundle(cfg.S3CABundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.S3InsecureSkipVerify {
+		log.Printf("⚠️ S3_INSECURE_SKIP_VERIFY is set, S3 server certificates are not verified")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+		t.TLSClientConfig = tlsConfig
+	}), nil
+}
+
+// loadS3CABundle adds the certificates of a PEM file to the system roots, which STS and
+// AWS endpoints still need. The subject of every certificate is logged so that a wrong
+// or empty mount shows up at startup.
+func loadS3CABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S3_CA_BUNDLE: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	loaded := 0
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in S3_CA_BUNDLE: %w", err)
+		}
+		pool.AddCert(cert)
+		loaded++
+		log.Printf("🔐 Trusting CA %q for S3 (expires %s)", cert.Subject.String(), cert.NotAfter.Format("2006-01-02"))
+	}
+	if loaded == 0 {
+		return nil, fmt.Errorf("no certificates found in S3_CA_BUNDLE %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// STRICT_ENCODING modes
+const (
+	encodingSanitize = "sanitize" // repair strings in place
+	encodingFail     = "fail"     // skip items that would need repairs
+)
+
+// sanitizeString replaces invalid UTF-8 with U+FFFD and strips C0 control characters other
+// than \n and \t. It reports whether the string changed.
+func sanitizeString(s string) (string, bool) {
+	clean := true
+	if !utf8.ValidString(s) {
+		clean = false
+	} else {
+		for i := 0; i < len(s); i++ {
+			if isStrippedControl(s[i]) {
+				clean = false
+				break
+			}
+		}
+	}
+	if clean {
+		return s, false
+	}
+
+	s = strings.ToValidUTF8(s, "�")
+	s = strings.Map(func(r rune) rune {
+		if r < 0x80 && isStrippedControl(byte(r)) {
+			return -1
+		}
+		return r
+	}, s)
+	return s, true
+}
+
+func isStrippedControl(b byte) bool {
+	return b < 0x20 && b != '\n' && b != '\t'
+}
+
+// sanitizeStrings repairs every string value of a decoded item in place and returns the
+// number of fields it changed. With fix unset nothing is modified, only counted.
+func sanitizeStrings(v interface{}, fix bool) int {
+	changed := 0
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if s, ok := child.(string); ok {
+				if clean, dirty := sanitizeString(s); dirty {
+					changed++
+					if fix {
+						t[k] = clean
+					}
+				}
+				continue
+			}
+			changed += sanitizeStrings(child, fix)
+		}
+	case []interface{}:
+		for i, child := range t {
+			if s, ok := child.(string); ok {
+				if clean, dirty := sanitizeString(s); dirty {
+					changed++
+					if fix {
+						t[i] = clean
+					}
+				}
+				continue
+			}
+			changed += sanitizeStrings(child, fix)
+		}
+	}
+	return changed
+}
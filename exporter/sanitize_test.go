@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"unicode/utf8"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSanitizeString(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		want  string
+		dirty bool
+	}{
+		{name: "clean", in: "CVE-2024-1234: heap overflow in libfoo", want: "CVE-2024-1234: heap overflow in libfoo"},
+		{name: "newlines and tabs are kept", in: "line one\n\tline two", want: "line one\n\tline two"},
+		{name: "multi-byte UTF-8 is kept", in: "naïve café ✓", want: "naïve café ✓"},
+		{name: "bell and escape", in: "title\x07 with \x1b[31mcolour", want: "title with [31mcolour", dirty: true},
+		{name: "NUL and carriage return", in: "a\x00b\r\n", want: "ab\n", dirty: true},
+		{name: "invalid byte", in: "match: \xff\xfe", want: "match: �", dirty: true},
+		{name: "truncated sequence", in: "euro \xe2\x82", want: "euro �", dirty: true},
+		{name: "overlong encoding", in: "slash \xc0\xaf", want: "slash �", dirty: true},
+		{name: "binary blob", in: "AKIA\x00\x01\x9c\xd3secret\x7f", want: "AKIA�secret\x7f", dirty: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dirty := sanitizeString(tt.in)
+			if got != tt.want || dirty != tt.dirty {
+				t.Errorf("sanitizeString(%q) = %q, %v; want %q, %v", tt.in, got, dirty, tt.want, tt.dirty)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("sanitizeString(%q) = %q is not valid UTF-8", tt.in, got)
+			}
+		})
+	}
+}
+
+// dirtyReport returns a report item with invalid UTF-8 in a nested map and a list
+func dirtyReport() map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "secrets", "namespace": "default"},
+		"report": map[string]interface{}{
+			"secrets": []interface{}{
+				map[string]interface{}{"ruleID": "aws-access-key-id", "match": "AKIA\xff\xfe\x00"},
+				map[string]interface{}{"ruleID": "private-key", "match": "-----BEGIN"},
+			},
+			"tags": []interface{}{"ok", "bad\x1b"},
+		},
+	}
+}
+
+func TestSanitizeStringsModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		fix    bool
+		match  string
+		tag    string
+		fields int
+	}{
+		{name: "sanitize repairs the fields", fix: true, match: "AKIA�", tag: "bad", fields: 2},
+		{name: "fail only counts them", fix: false, match: "AKIA\xff\xfe\x00", tag: "bad\x1b", fields: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := dirtyReport()
+			if n := sanitizeStrings(obj, tt.fix); n != tt.fields {
+				t.Errorf("sanitizeStrings() = %d fields, want %d", n, tt.fields)
+			}
+			report := obj["report"].(map[string]interface{})
+			if match := report["secrets"].([]interface{})[0].(map[string]interface{})["match"]; match != tt.match {
+				t.Errorf("match = %q, want %q", match, tt.match)
+			}
+			if tag := report["tags"].([]interface{})[1]; tag != tt.tag {
+				t.Errorf("tag = %q, want %q", tag, tt.tag)
+			}
+			if tt.fix {
+				// A sanitized item encodes to JSON strict parsers accept
+				data, err := json.Marshal(obj)
+				if err != nil || !utf8.Valid(data) {
+					t.Errorf("sanitized item encodes to %q, %v", data, err)
+				}
+				if n := sanitizeStrings(obj, true); n != 0 {
+					t.Errorf("second pass changed %d fields", n)
+				}
+			}
+		})
+	}
+}
+
+// STRICT_ENCODING decides whether dirty items are repaired or skipped during collection
+func TestCollectResourcePagedStrictEncoding(t *testing.T) {
+	tests := []struct {
+		mode      string
+		items     []string
+		sanitized int
+		skipped   int
+	}{
+		{mode: encodingSanitize, items: []string{"clean", "secrets"}, sanitized: 2},
+		{mode: encodingFail, items: []string{"clean"}, skipped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			gvr := reportGVR(vulnerabilityResource)
+			client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{gvr: "VulnerabilityReportList"})
+			client.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				list := &unstructured.UnstructuredList{Object: map[string]interface{}{
+					"apiVersion": gvr.GroupVersion().String(),
+					"kind":       "VulnerabilityReportList",
+				}}
+				list.Items = []unstructured.Unstructured{*testReport("default", "clean", "1"), {Object: dirtyReport()}}
+				return true, list, nil
+			})
+			dir := t.TempDir()
+			cfg := Config{ClusterName: "prod", PageSize: 10, StrictEncoding: tt.mode, FSOutputDir: dir, FSLayout: fsLayoutFlat}
+			sinks := []Sink{&fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions}}
+
+			uploads := newUploadQueue(1)
+			stats, err := collectResourcePaged(context.Background(), client, sinks, cfg, vulnerabilityResource, "20260301-100000", nil, nil, uploads)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u := uploads.wait()[vulnerabilityResource.Name]; u.err != nil {
+				t.Fatal(u.err)
+			}
+			if stats.SanitizedFields != tt.sanitized || stats.InvalidEncodingItems != tt.skipped {
+				t.Errorf("stats sanitized=%d skipped=%d, want %d and %d", stats.SanitizedFields, stats.InvalidEncodingItems, tt.sanitized, tt.skipped)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "prod-vulnerability-reports.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !utf8.Valid(data) || !json.Valid(data) {
+				t.Fatalf("report file is not valid UTF-8 JSON:\n%q", data)
+			}
+			var report struct {
+				Items []unstructured.Unstructured `json:"items"`
+			}
+			json.Unmarshal(data, &report)
+			var names []string
+			for _, item := range report.Items {
+				names = append(names, item.GetName())
+			}
+			if !slices.Equal(names, tt.items) {
+				t.Errorf("report items = %v, want %v", names, tt.items)
+			}
+		})
+	}
+}
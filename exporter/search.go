@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Date tokens of OPENSEARCH_INDEX and their Go layouts
+var searchIndexDateTokens = strings.NewReplacer("yyyy", "2006", "MM", "01", "dd", "02")
+
+// searchIndex bulk-indexes findings into OpenSearch or Elasticsearch (OPENSEARCH_URL)
+type searchIndex struct {
+	client  *http.Client
+	bulkURL string
+	cfg     Config
+}
+
+// findingsSearch is the search index of the collection loop, nil without OPENSEARCH_URL
+var findingsSearch *searchIndex
+
+func newSearchIndex(cfg Config) *searchIndex {
+	return &searchIndex{
+		client:  &http.Client{Timeout: cfg.OpenSearchTimeout},
+		bulkURL: strings.TrimSuffix(cfg.OpenSearchURL, "/") + "/_bulk",
+		cfg:     cfg,
+	}
+}
+
+// FindingDocument is the indexed form of a finding
+type FindingDocument struct {
+	Finding
+	Report string `json:"report"`
+}
+
+// indexName expands {cluster} and dates such as {yyyy.MM} in OPENSEARCH_INDEX
+func indexName(pattern, cluster string, t time.Time) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(pattern, "{")
+		end := strings.Index(pattern, "}")
+		if start < 0 || end < start {
+			b.WriteString(pattern)
+			return strings.ToLower(b.String())
+		}
+		b.WriteString(pattern[:start])
+		token := pattern[start+1 : end]
+		if token == "cluster" {
+			b.WriteString(cluster)
+		} else {
+			b.WriteString(t.Format(searchIndexDateTokens.Replace(token)))
+		}
+		pattern = pattern[end+1:]
+	}
+}
+
+// bulk returns the bulk writer of a report type, or nil without a search index
+func (s *searchIndex) bulk(collectedAt time.Time) *searchBulk {
+	if s == nil {
+		return nil
+	}
+	return &searchBulk{index: s, name: indexName(s.cfg.OpenSearchIndex, s.cfg.ClusterName, collectedAt)}
+}
+
+// searchBulk sends findings in _bulk requests of OPENSEARCH_BATCH_SIZE documents. Document
+// IDs are derived from cluster, namespace, report name and finding ID, so every cycle
+// updates the documents of the previous one instead of adding duplicates.
+type searchBulk struct {
+	index   *searchIndex
+	name    string
+	pending []bulkDocument
+
+	indexed  int
+	failures int
+}
+
+type bulkDocument struct {
+	id   string
+	body []byte
+}
+
+// add queues the findings of a report item
+func (b *searchBulk) add(ctx context.Context, report string, findings []Finding) {
+	for _, f := range findings {
+		doc := FindingDocument{Finding: f, Report: report}
+		body, err := json.Marshal(doc)
+		if err != nil {
+			b.failures++
+			continue
+		}
+		id := strings.Join([]string{f.Cluster, f.Namespace, report, f.ID}, "/")
+		b.pending = append(b.pending, bulkDocument{id: id, body: body})
+		if len(b.pending) >= b.index.cfg.OpenSearchBatchSize {
+			b.flush(ctx)
+		}
+	}
+}
+
+// restart drops the pending documents when the list of the report type starts over.
+// Documents the abandoned pass already indexed are overwritten by the second pass under
+// the same IDs, so only the counts start over.
+func (b *searchBulk) restart() {
+	if b == nil {
+		return
+	}
+	b.pending = nil
+	b.indexed, b.failures = 0, 0
+}
+
+// flush sends the pending documents. Documents rejected with 429 are resent under the
+// RETRY_OPENSEARCH_* policy; other rejections are counted as failures.
+func (b *searchBulk) flush(ctx context.Context) {
+	docs := b.pending
+	b.pending = nil
+	if len(docs) == 0 {
+		return
+	}
+
+	err := b.index.cfg.retryPolicy("opensearch").Do(ctx, "opensearch", func() error {
+		var err error
+		docs, err = b.send(ctx, docs)
+		if err == nil && len(docs) > 0 {
+			return &httpStatusError{code: http.StatusTooManyRequests, url: b.index.bulkURL}
+		}
+		return err
+	})
+	if err != nil {
+		b.failures += len(docs)
+		log.Printf("⚠️ Failed to index %d findings into %s: %v", len(docs), b.name, err)
+	}
+}
+
+// send posts one _bulk request and returns the documents to retry
+func (b *searchBulk) send(ctx context.Context, docs []bulkDocument) ([]bulkDocument, error) {
+	var body bytes.Buffer
+	for _, d := range docs {
+		action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": b.name, "_id": d.id}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(d.body)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.index.bulkURL, &body)
+	if err != nil {
+		return docs, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case b.index.cfg.OpenSearchAPIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+b.index.cfg.OpenSearchAPIKey)
+	case b.index.cfg.OpenSearchUsername != "":
+		req.SetBasicAuth(b.index.cfg.OpenSearchUsername, b.index.cfg.OpenSearchPassword)
+	}
+
+	resp, err := b.index.client.Do(req)
+	if err != nil {
+		return docs, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return docs, &httpStatusError{code: resp.StatusCode, url: b.index.bulkURL}
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return docs, fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !result.Errors {
+		b.indexed += len(docs)
+		return nil, nil
+	}
+
+	var retry []bulkDocument
+	for i, item := range result.Items {
+		if i >= len(docs) {
+			break
+		}
+		for _, r := range item {
+			switch {
+			case r.Status == http.StatusTooManyRequests:
+				retry = append(retry, docs[i])
+			case r.Status >= 300:
+				b.failures++
+				if b.failures <= 5 {
+					log.Printf("⚠️ Failed to index %s into %s: %s: %s", docs[i].id, b.name, r.Error.Type, r.Error.Reason)
+				}
+			default:
+				b.indexed++
+			}
+		}
+	}
+	return retry, nil
+}
+
+// finish sends the remaining documents and returns the number of failed documents
+func (b *searchBulk) finish(ctx context.Context) int {
+	b.flush(ctx)
+	log.Printf("🔎 Indexed %d findings into %s (%d failed)", b.indexed, b.name, b.failures)
+	return b.failures
+}
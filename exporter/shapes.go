@@ -0,0 +1,57 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Report shapes. trivy-operator releases changed how some report kinds lay out their
+// findings; the shape of every item is detected so the mappers branch on it explicitly and
+// resourceStats records which shapes a cluster's operator produced.
+const (
+	// Vulnerability reports: newer operators put the image digest in report.artifact, older
+	// ones identify the image by repository and tag only
+	shapeArtifactDigest = "artifact-digest"
+	shapeArtifactTag    = "artifact-tag"
+
+	// Audit reports: older operators record passed checks too, with success=true; newer ones
+	// record failed checks only by default and may omit the success flag altogether
+	shapeChecksAll    = "checks-all"
+	shapeChecksFailed = "checks-failed-only"
+)
+
+// reportShape returns the shape of a report item, or "" for kinds whose layout has not
+// changed across the supported operator versions
+func reportShape(kind string, obj map[string]interface{}) string {
+	spec, ok := summarySpecs[kind]
+	if !ok {
+		return ""
+	}
+	if spec.failedOnly {
+		for _, e := range nestedSlice(obj, spec.entries...) {
+			if entry, ok := e.(map[string]interface{}); ok {
+				if success, _ := entry["success"].(bool); success {
+					return shapeChecksAll
+				}
+			}
+		}
+		return shapeChecksFailed
+	}
+	if spec.entries[len(spec.entries)-1] != "vulnerabilities" {
+		return ""
+	}
+	if digest, _, _ := unstructured.NestedString(obj, "report", "artifact", "digest"); digest != "" {
+		return shapeArtifactDigest
+	}
+	return shapeArtifactTag
+}
+
+// observeShape counts an item under its detected shape
+func (s *ResourceStats) observeShape(shape string) {
+	if shape == "" {
+		return
+	}
+	if s.Shapes == nil {
+		s.Shapes = make(map[string]int)
+	}
+	s.Shapes[shape]++
+}
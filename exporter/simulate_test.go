@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Dumps of the report types of CUSTOM_RESOURCES_FILE are simulated like the built-in ones
+func TestSimulateCustomResources(t *testing.T) {
+	saved, savedDigests := reportResources, uploadedDigests
+	defer func() { reportResources, uploadedDigests = saved, savedDigests }()
+	uploadedDigests = &digestRecorder{digests: make(map[string]string)}
+
+	dir := t.TempDir()
+	customFile := filepath.Join(dir, "custom-resources.yaml")
+	os.WriteFile(customFile, []byte(`- group: kube-bench.example.com
+  version: v1
+  resource: benchreports
+  kind: BenchReport
+  fileName: kube-bench-reports
+`), 0644)
+	input := filepath.Join(dir, "dumps")
+	os.Mkdir(input, 0755)
+	os.WriteFile(filepath.Join(input, "kube-bench-reports.json"), []byte(
+		`{"items": [{"apiVersion": "kube-bench.example.com/v1", "kind": "BenchReport", "metadata": {"name": "node-1"}}]}`), 0644)
+	os.WriteFile(filepath.Join(input, "vulnerability-reports.json"), []byte(validDump), 0644)
+	t.Setenv("CUSTOM_RESOURCES_FILE", customFile)
+
+	output := filepath.Join(dir, "output")
+	if err := runSimulate([]string{"--input", input, "--output", output}); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"kube-bench-reports.json", "vulnerability-reports.json"} {
+		data, err := os.ReadFile(filepath.Join(output, "simulate-"+file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Items) != 1 {
+			t.Errorf("%s has %d items, want 1", file, len(report.Items))
+		}
+	}
+}
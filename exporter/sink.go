@@ -0,0 +1,792 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"trivy-exporter/fslock"
+)
+
+// Sink is an output the artifacts of a cluster are published to. Keys are relative to the
+// cluster, e.g. "vulnerability-reports.json" or "overflow/<uid>.json.gz"; each sink maps
+// them onto its own layout.
+type Sink interface {
+	// Name identifies the sink in errors and selects its RETRY_<NAME>_* policy
+	Name() string
+	// Put stores size bytes read from r under key
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get reads back a key, returning nil data and no error when it does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes a key; a missing key is not an error. Only deleteArtifact calls it.
+	Delete(ctx context.Context, key string) error
+}
+
+// newSinks creates the configured sinks, listed in the order artifacts are read back:
+// the local directory first, then the object stores, the write-only push API, git and the
+// OCI registry
+func newSinks(ctx context.Context, cfg Config) ([]Sink, error) {
+	var sinks []Sink
+	if cfg.FSOutputDir != "" {
+		sinks = append(sinks, &fsSink{
+			dir:         cfg.FSOutputDir,
+			cluster:     cfg.ClusterName,
+			layout:      cfg.FSLayout,
+			gzip:        cfg.FSOutputGzip,
+			opts:        fsFileOptions(cfg),
+			generations: newFSGenerations(cfg),
+			lockPath:    fsLockPath(cfg),
+			lockTimeout: cfg.FSLockTimeout,
+		})
+	}
+	if cfg.S3Bucket != "" {
+		awsCfg, err := loadAWSConfig(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		client := s3ClientFromConfig(awsCfg, cfg)
+		switch {
+		case cfg.S3SSE == "":
+			log.Printf("🔐 S3 encryption: bucket default")
+		case cfg.S3KMSKeyID != "":
+			log.Printf("🔐 S3 encryption: %s with key %s", cfg.S3SSE, cfg.S3KMSKeyID)
+		default:
+			log.Printf("🔐 S3 encryption: %s", cfg.S3SSE)
+		}
+		partSize := int64(cfg.S3PartSizeMB) * 1024 * 1024
+		sink := &s3Sink{
+			client: client,
+			// Small objects are sent with a single PutObject. Retries are driven by
+			// RetryPolicy, and failed multipart uploads are aborted.
+			uploader: manager.NewUploader(client, func(u *manager.Uploader) {
+				u.PartSize = partSize
+				u.Concurrency = cfg.S3UploadConcurrency
+			}),
+			partSize:      partSize,
+			progressParts: cfg.S3ProgressParts,
+			bucket:        cfg.S3Bucket,
+			prefix:        objectPrefix(cfg.S3Prefix, cfg.ClusterName),
+			sse:           types.ServerSideEncryption(cfg.S3SSE),
+			kmsKeyID:      cfg.S3KMSKeyID,
+			class:         types.StorageClass(cfg.S3StorageClass),
+			cluster:       cfg.ClusterName,
+			tags:          cfg.S3Tags,
+			compress:      cfg.CompressUploads == compressGzip,
+			headers: s3Headers{
+				cacheControl:      cfg.S3CacheControl,
+				indexCacheControl: cfg.S3IndexCacheControl,
+				disposition:       cfg.S3ContentDisposition,
+				contentTypes:      cfg.S3ContentTypes,
+			},
+			cdn:    newCloudFrontInvalidation(awsCfg, cfg),
+			verify: cfg.S3VerifyUploads,
+
+			reportPrefixes: s3ReportPrefixes(cfg),
+		}
+		sink.requireLength.Store(cfg.S3RequireLength)
+		sink.abortStaleUploads(ctx)
+		sinks = append(sinks, sink)
+	}
+	if cfg.GCSBucket != "" {
+		client, err := newGCSClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCS client: %w", err)
+		}
+		sinks = append(sinks, &gcsSink{client: client, bucket: cfg.GCSBucket, prefix: objectPrefix(cfg.GCSPrefix, cfg.ClusterName)})
+	}
+	if cfg.AzureContainer != "" {
+		client, err := newAzureClient(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure Blob client: %w", err)
+		}
+		sinks = append(sinks, &azureSink{client: client, container: cfg.AzureContainer, prefix: objectPrefix(cfg.AzurePrefix, cfg.ClusterName)})
+	}
+	if cfg.PushURL != "" {
+		sinks = append(sinks, newPushSink(cfg))
+	}
+	if cfg.GitCheckoutDir != "" {
+		sink, err := newGitSink(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare git checkout: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.OCIRepository != "" {
+		sink, err := newOCISink(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// cycleSink is implemented by sinks that batch the writes of a cycle, such as git and OCI
+type cycleSink interface {
+	finishCycle(ctx context.Context, cycleID string) error
+}
+
+// cycleLocker is implemented by sinks whose readers must see the files of a cycle together,
+// such as FS_OUTPUT_DIR with FS_LOCKING. lockCycle returns the release of the lock.
+type cycleLocker interface {
+	lockCycle() func()
+}
+
+// lockSinks locks the sinks for the write phase of a cycle and returns the release
+func lockSinks(sinks []Sink) func() {
+	var unlocks []func()
+	for _, s := range sinks {
+		if l, ok := s.(cycleLocker); ok {
+			unlocks = append(unlocks, l.lockCycle())
+		}
+	}
+	return func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+}
+
+// listedObject is an artifact found by a lister, keyed like Sink.Put
+type listedObject struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// lister is implemented by sinks that can enumerate the keys under a prefix, such as S3
+// and FS_OUTPUT_DIR
+type lister interface {
+	list(ctx context.Context, prefix string) ([]listedObject, error)
+}
+
+// finishSinks completes the cycle of batching sinks. Their failures are logged and do not
+// affect the outputs already written by the other sinks.
+func finishSinks(ctx context.Context, sinks []Sink, cycleID string) {
+	for _, s := range sinks {
+		if c, ok := s.(cycleSink); ok {
+			if err := c.finishCycle(ctx, cycleID); err != nil {
+				log.Printf("⚠️ Failed to finish the cycle of the %s sink: %v", s.Name(), err)
+			}
+		}
+	}
+}
+
+// objectPrefix is the <prefix>/<cluster>/ layout shared by the object stores
+func objectPrefix(prefix, cluster string) string {
+	return fmt.Sprintf("%s/%s/", prefix, cluster)
+}
+
+// contentTypeFor derives the content type of an object from its key
+func contentTypeFor(key string) string {
+	switch path.Ext(key) {
+	case ".parquet":
+		return parquetContentType
+	case ".gz":
+		return "application/gzip"
+	case ".jsonl", ".ndjson":
+		return "application/x-ndjson"
+	case checksumSuffix:
+		return "text/plain"
+	default:
+		return "application/json"
+	}
+}
+
+// withRetry runs fn under the RETRY_<SINK>_* policy of remote sinks; local writes fail fast
+func withRetry(ctx context.Context, cfg Config, s Sink, fn func() error) error {
+	if _, remote := retryComponents[s.Name()]; !remote {
+		return fn()
+	}
+	return cfg.retryPolicy(s.Name()).Do(ctx, s.Name(), fn)
+}
+
+// s3Sink writes to S3_BUCKET under <S3_PREFIX>/<cluster>/
+type s3Sink struct {
+	client        *s3.Client
+	uploader      *manager.Uploader
+	partSize      int64
+	progressParts int // S3_PROGRESS_PARTS
+
+	bucket   string
+	prefix   string
+	sse      types.ServerSideEncryption // S3_SSE, empty for the bucket default
+	kmsKeyID string
+	class    types.StorageClass // S3_STORAGE_CLASS, empty for STANDARD
+	cluster  string
+	tags     map[string]string // S3_TAGS
+	compress bool              // COMPRESS_UPLOADS=gzip
+	headers  s3Headers
+	cdn      *cloudFrontInvalidation // CLOUDFRONT_DISTRIBUTION_ID, nil without
+	verify   bool                    // S3_VERIFY_UPLOADS
+	// S3_REQUIRE_LENGTH, or the store rejected a streamed upload without a length
+	requireLength atomic.Bool
+
+	reportPrefixes map[string]string // object prefix per report type with S3_PREFIX_<TYPE>
+}
+
+// s3Headers are the HTTP headers stored with uploaded objects, served as-is by S3 and
+// CloudFront to the dashboard
+type s3Headers struct {
+	cacheControl      string            // S3_CACHE_CONTROL
+	indexCacheControl string            // S3_INDEX_CACHE_CONTROL, falls back to cacheControl
+	disposition       string            // S3_CONTENT_DISPOSITION for report files
+	contentTypes      map[string]string // S3_CONTENT_TYPES by file extension
+}
+
+// contentType returns the S3_CONTENT_TYPES override of the key's extension, or contentTypeFor
+func (h s3Headers) contentType(key string) string {
+	if t, ok := h.contentTypes[path.Ext(key)]; ok {
+		return t
+	}
+	return contentTypeFor(key)
+}
+
+// Index and delta files of SCOPE=all and of split deployments
+var indexFileNames = map[string]bool{
+	"index.json": true, "index-cluster.json": true, "index-namespaced.json": true,
+	"index-delta.json": true, "index-delta-cluster.json": true, "index-delta-namespaced.json": true,
+}
+
+// cacheControlOf returns the Cache-Control of an object: the index and delta files change
+// every cycle and may be cached for less time than the report files
+func (h s3Headers) cacheControlOf(key string) string {
+	if indexFileNames[key] && h.indexCacheControl != "" {
+		return h.indexCacheControl
+	}
+	return h.cacheControl
+}
+
+// dispositionOf names downloaded report files after the cluster, e.g.
+// attachment; filename=prod-vulnerability-reports.json
+func (h s3Headers) dispositionOf(cluster, key string) string {
+	name := snapshotArtifact(key)
+	if h.disposition == "" || !isReportFile(name) {
+		return ""
+	}
+	return mime.FormatMediaType(h.disposition, map[string]string{"filename": cluster + "-" + name})
+}
+
+func (s *s3Sink) Name() string { return "s3" }
+
+func (s *s3Sink) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	compressed := s.compress && isReportFile(snapshotArtifact(key))
+	var input *s3.PutObjectInput
+	var digest *streamDigest
+	upload := S3Upload{Mode: s3UploadSized}
+	switch {
+	case compressed && s.requireLength.Load():
+		// Compressed to a temp file first, so the store gets the length of the object
+		f, d, err := gzipFile(r)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		digest, upload.Mode = d, s3UploadSpooled
+		input = s.putObjectInput(key, f, d.n, cycleIDFrom(ctx))
+		input.ContentEncoding = aws.String(compressGzip)
+	case compressed:
+		// The object keeps its JSON content type with Content-Encoding gzip, so browsers
+		// and HTTP clients decompress it transparently. Its length is unknown until the
+		// stream ends, so the SDK sends the checksum as a trailer.
+		body, d := gzipStream(r)
+		defer body.Close()
+		digest, upload.Mode = d, s3UploadStreamed
+		input = s.putObjectInput(key, body, -1, cycleIDFrom(ctx))
+		input.ContentEncoding = aws.String(compressGzip)
+	default:
+		input = s.putObjectInput(key, s.withProgress(key, r, size), size, cycleIDFrom(ctx))
+	}
+	out, err := s.uploader.Upload(ctx, input)
+	if err != nil {
+		if upload.Mode == s3UploadStreamed && isLengthRequiredError(err) {
+			// Every later compressed upload is spooled; the retry of this one already is
+			if !s.requireLength.Swap(true) {
+				log.Printf("📏 %s rejected a streamed upload without a length, compressing uploads to temp files from now on", s.bucket)
+			}
+			return &lengthRequiredError{key: key, err: err}
+		}
+		return err
+	}
+	sent := size
+	upload.Bytes = size
+	if digest != nil {
+		sent = digest.n
+		upload.Bytes, upload.SHA256 = digest.n, digest.sum()
+		log.Printf("🗜️ Uploaded %s gzip-compressed (%s): %d -> %d bytes, sha256 %s", key, upload.Mode, size, digest.n, upload.SHA256)
+	}
+	if isReportFile(key) {
+		s3Uploads.record(key, upload)
+	}
+	if s.verify {
+		if err := s.verifyUpload(ctx, key, sent, out); err != nil {
+			return err
+		}
+	}
+	s.cdn.changed(key, s.objectKey(key))
+	return nil
+}
+
+// putObjectInput applies the content type, encryption, storage class and tags
+func (s *s3Sink) putObjectInput(key string, r io.Reader, size int64, cycleID string) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.objectKey(key)),
+		Body:        r,
+		ContentType: aws.String(s.headers.contentType(key)),
+		Tagging:     aws.String(s.tagging(key, cycleID)),
+		// S3 validates the transfer against a checksum the SDK computes per request
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
+	if size >= 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	if s.sse != "" {
+		input.ServerSideEncryption = s.sse
+	}
+	if s.kmsKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.kmsKeyID)
+	}
+	if s.class != "" {
+		input.StorageClass = s.class
+	}
+	if v := s.headers.cacheControlOf(key); v != "" {
+		input.CacheControl = aws.String(v)
+	}
+	if v := s.headers.dispositionOf(s.cluster, key); v != "" {
+		input.ContentDisposition = aws.String(v)
+	}
+	return input
+}
+
+func (s *s3Sink) Get(ctx context.Context, key string) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeContent(obj.Body, aws.ToString(obj.ContentEncoding))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (s *s3Sink) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	return err
+}
+
+// list returns the objects under prefix, including those of report types written under an
+// S3_PREFIX_<TYPE> override
+func (s *s3Sink) list(ctx context.Context, prefix string) ([]listedObject, error) {
+	roots := []string{s.prefix}
+	for _, p := range s.reportPrefixes {
+		if !slices.Contains(roots, p) {
+			roots = append(roots, p)
+		}
+	}
+	var objects []listedObject
+	for _, root := range roots {
+		paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(s.bucket),
+			Prefix: aws.String(root + prefix),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, obj := range page.Contents {
+				key := strings.TrimPrefix(aws.ToString(obj.Key), root)
+				// Only objects the key maps back to, so shared prefixes are not listed twice
+				if s.objectKey(key) != aws.ToString(obj.Key) {
+					continue
+				}
+				objects = append(objects, listedObject{Key: key, Size: aws.ToInt64(obj.Size), Modified: aws.ToTime(obj.LastModified)})
+			}
+		}
+	}
+	return objects, nil
+}
+
+// deleteBatch removes up to maxDeleteBatch keys with one DeleteObjects call
+func (s *s3Sink) deleteBatch(ctx context.Context, keys []string) (map[string]error, error) {
+	objects := make([]types.ObjectIdentifier, len(keys))
+	byObjectKey := make(map[string]string, len(keys))
+	for i, key := range keys {
+		objects[i] = types.ObjectIdentifier{Key: aws.String(s.objectKey(key))}
+		byObjectKey[s.objectKey(key)] = key
+	}
+	out, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[string]error, len(out.Errors))
+	for _, e := range out.Errors {
+		failed[byObjectKey[aws.ToString(e.Key)]] = fmt.Errorf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))
+	}
+	return failed, nil
+}
+
+// fsSink writes to FS_OUTPUT_DIR and tracks its health in fsHealth
+type fsSink struct {
+	dir     string
+	cluster string
+	layout  string // FS_LAYOUT
+	gzip    bool   // FS_OUTPUT_GZIP: report files are written as .json.gz
+	opts    fileOptions
+
+	generations *fsGenerations // FS_GENERATIONS, nil without
+	lockPath    string         // FS_LOCKING: <cluster>.lock, "" without
+	lockTimeout time.Duration
+	cycleLocked atomic.Bool // The lock is held for the whole cycle by lockCycle
+}
+
+// FS_LAYOUT values
+const (
+	fsLayoutFlat   = "flat"
+	fsLayoutNested = "nested"
+)
+
+// fsClusterDir returns the directory the files of the cluster are written to
+func fsClusterDir(cfg Config) string {
+	if cfg.FSLayout == fsLayoutNested {
+		return filepath.Join(cfg.FSOutputDir, cfg.ClusterName)
+	}
+	return cfg.FSOutputDir
+}
+
+func (s *fsSink) Name() string { return "fs" }
+
+// path returns the destination of a key under FS_OUTPUT_DIR: <cluster>-<key> with
+// FS_LAYOUT=flat, the names the dashboard reads from its data dir, or <cluster>/<key> with
+// FS_LAYOUT=nested. Keys in subdirectories keep them, e.g. <cluster>-snapshots/<ts>/.
+func (s *fsSink) path(key string) string {
+	if isReportFile(key) && s.gzip {
+		key += ".gz"
+	}
+	return s.root() + filepath.FromSlash(key)
+}
+
+// root is the path prefix shared by every file of the cluster
+func (s *fsSink) root() string {
+	if s.layout == fsLayoutNested {
+		return filepath.Join(s.dir, s.cluster) + string(filepath.Separator)
+	}
+	return filepath.Join(s.dir, s.cluster+"-")
+}
+
+// fsLockPath returns the lock readers of the cluster's files share with FS_LOCKING
+func fsLockPath(cfg Config) string {
+	if !cfg.FSLocking {
+		return ""
+	}
+	return filepath.Join(cfg.FSOutputDir, cfg.ClusterName+".lock")
+}
+
+// lock takes the FS_LOCKING lock of the cluster for a write and returns its release. When
+// readers hold it past FS_LOCK_TIMEOUT the write goes ahead unlocked, as files are replaced
+// atomically anyway. Writes of a cycle run under the lock taken by lockCycle instead.
+func (s *fsSink) lock() func() {
+	if s.lockPath == "" || s.cycleLocked.Load() {
+		return func() {}
+	}
+	l, err := fslock.Exclusive(s.lockPath, s.lockTimeout)
+	if err != nil {
+		log.Printf("⏳ Writing to %s without the FS_LOCKING lock: %v", s.dir, err)
+		return func() {}
+	}
+	return func() { l.Unlock() }
+}
+
+// lockCycle holds the FS_LOCKING lock from the first report of a cycle to its index, so
+// readers never see the reports of one cycle next to the index of another
+func (s *fsSink) lockCycle() func() {
+	unlock := s.lock()
+	s.cycleLocked.Store(true)
+	return func() {
+		s.cycleLocked.Store(false)
+		unlock()
+	}
+}
+
+func (s *fsSink) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	defer s.lock()()
+	destPath := s.path(key)
+	if s.gzip && isReportFile(key) {
+		compressed, _ := gzipStream(r)
+		defer compressed.Close()
+		r = compressed
+	}
+	write := writeFile
+	if s.generations != nil && isReportFile(key) {
+		write = s.generations.write
+	}
+	if err := write(destPath, r, s.opts); err != nil {
+		fsHealth.fail(key, err)
+		return err
+	}
+	fsHealth.observe(nil)
+	log.Printf("💾 Saved to %s", destPath)
+	return nil
+}
+
+func (s *fsSink) Get(ctx context.Context, key string) ([]byte, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var body io.ReadCloser = f
+	if s.gzip && isReportFile(key) {
+		if body, err = decodeContent(f, compressGzip); err != nil {
+			return nil, err
+		}
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (s *fsSink) Delete(ctx context.Context, key string) error {
+	defer s.lock()()
+	if s.generations != nil && isReportFile(key) {
+		if err := s.generations.remove(s.path(key)); err != nil {
+			return err
+		}
+	}
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err == nil && strings.Contains(key, "/") {
+		// Drop the subdirectory once its last file is gone, e.g. a pruned snapshot
+		os.Remove(filepath.Dir(s.path(key)))
+	}
+	return err
+}
+
+// list walks the files of the cluster under prefix
+func (s *fsSink) list(ctx context.Context, prefix string) ([]listedObject, error) {
+	root := s.root()
+	var objects []listedObject
+	err := filepath.WalkDir(root+filepath.FromSlash(prefix), func(p string, d os.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, listedObject{Key: filepath.ToSlash(strings.TrimPrefix(p, root)), Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// writeFile replaces destPath atomically: the content is written and synced to
+// <destPath>.tmp, renamed into place and the directory synced, so readers of the shared
+// volume never see a partial file and a node crash cannot leave an empty one. The file
+// gets the mode and owner of opts before it is renamed into place.
+func writeFile(destPath string, r io.Reader, opts fileOptions) error {
+	dir := filepath.Dir(destPath)
+	if err := opts.mkdirAll(dir); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	tmpPath := destPath + ".tmp"
+	outFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, opts.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to create FS output file: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			outFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := io.Copy(outFile, r); err != nil {
+		return fmt.Errorf("failed to write FS output: %w", err)
+	}
+	// OpenFile applies the umask
+	if err := outFile.Chmod(opts.fileMode); err != nil {
+		return fmt.Errorf("failed to set the mode of FS output: %w", err)
+	}
+	if err := opts.chown(tmpPath); err != nil {
+		return err
+	}
+	if opts.fsync {
+		if err := outFile.Sync(); err != nil {
+			return fmt.Errorf("failed to sync FS output: %w", err)
+		}
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to write FS output: %w", err)
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move FS output into place: %w", err)
+	}
+	committed = true
+	if !opts.fsync {
+		return nil
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to sync output directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output directory: %w", err)
+	}
+	return nil
+}
+
+// Object tags set on every S3 upload, and the most S3 allows per object
+var s3BuiltinTags = []string{"cluster", "report-type", "collected-at"}
+
+const maxS3Tags = 10
+
+// parseS3Tags parses S3_TAGS, e.g. "team=platform,env=prod"
+func parseS3Tags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, entry := range splitList(s) {
+		k, v, ok := strings.Cut(entry, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" || slices.Contains(s3BuiltinTags, k) {
+			log.Printf("⚠️ Ignoring invalid S3_TAGS entry %q (expected key=value, not %s)", entry, strings.Join(s3BuiltinTags, ", "))
+			continue
+		}
+		tags[k] = strings.TrimSpace(v)
+	}
+	return tags
+}
+
+// parseS3ContentTypes parses S3_CONTENT_TYPES, a comma-separated list of
+// .extension=content/type overrides such as .gz=application/json
+func parseS3ContentTypes(s string) map[string]string {
+	overrides := make(map[string]string)
+	for _, entry := range splitList(s) {
+		ext, t, ok := strings.Cut(entry, "=")
+		ext = strings.TrimSpace(ext)
+		if !ok || !strings.HasPrefix(ext, ".") {
+			log.Printf("⚠️ Ignoring invalid S3_CONTENT_TYPES entry %q (expected .extension=content/type)", entry)
+			continue
+		}
+		overrides[ext] = strings.TrimSpace(t)
+	}
+	return overrides
+}
+
+// validHeaderValue rejects values that cannot be sent as an HTTP header, such as values
+// with embedded newlines from a multi-line secret or ConfigMap entry
+func validHeaderValue(v string) bool {
+	for _, c := range v {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// tagging returns the URL-encoded x-amz-tagging value of an object. collected-at is the
+// cycle ID written to index.json as cycleId; artifacts published outside a cycle, such as
+// runtime-config.json, have none.
+func (s *s3Sink) tagging(key, cycleID string) string {
+	tags := url.Values{}
+	for k, v := range s.tags {
+		tags.Set(k, v)
+	}
+	tags.Set("cluster", s.cluster)
+	tags.Set("report-type", reportTypeOf(key))
+	if cycleID != "" {
+		tags.Set("collected-at", cycleID)
+	}
+	// S3 expects spaces as %20 rather than the + of form encoding
+	return strings.ReplaceAll(tags.Encode(), "+", "%20")
+}
+
+// reportTypeOf names the report type of a key, e.g. "vulnerabilityreports" for
+// vulnerability-reports.json, "index" for index.json or "overflow" for overflow/<uid>.json.gz.
+// Snapshot copies are typed like the artifact they copy.
+func reportTypeOf(key string) string {
+	key = strings.TrimSuffix(snapshotArtifact(key), checksumSuffix)
+	for _, r := range reportResources {
+		if key == r.FileName+".json" {
+			return r.Name
+		}
+	}
+	name, _, _ := strings.Cut(key, "/")
+	name, _, _ = strings.Cut(name, ".")
+	return name
+}
+
+type cycleIDKey struct{}
+
+// withCycleID attaches the ID of the collection cycle to ctx for sinks that label objects
+func withCycleID(ctx context.Context, cycleID string) context.Context {
+	return context.WithValue(ctx, cycleIDKey{}, cycleID)
+}
+
+func cycleIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(cycleIDKey{}).(string)
+	return id
+}
+
+// isReportFile reports whether a key is the report file of a collected resource
+func isReportFile(key string) bool {
+	for _, r := range reportResources {
+		if key == r.FileName+".json" {
+			return true
+		}
+	}
+	return false
+}
+
+// closeSinks releases the sinks holding connections
+func closeSinks(sinks []Sink) {
+	for _, s := range sinks {
+		if c, ok := s.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"trivy-exporter/fslock"
+)
+
+// Readers must wait for the whole cycle while the cycle's own writes never block on its lock
+func TestFSSinkLockCycle(t *testing.T) {
+	dir := t.TempDir()
+	sink := &fsSink{dir: dir, cluster: "prod", layout: fsLayoutFlat, opts: defaultFileOptions,
+		lockPath: filepath.Join(dir, "prod.lock"), lockTimeout: time.Second}
+	ctx := context.Background()
+
+	unlock := lockSinks([]Sink{sink})
+	for _, key := range []string{"vulnerability-reports.json", "index.json"} {
+		done := make(chan error, 1)
+		go func() { done <- sink.Put(ctx, key, strings.NewReader("{}"), 2) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Put(%s) blocked on the lock of its own cycle", key)
+		}
+		if _, err := fslock.Shared(sink.lockPath, 50*time.Millisecond); !errors.Is(err, fslock.ErrTimeout) {
+			t.Fatalf("reader got the lock in the middle of the cycle: %v", err)
+		}
+	}
+	unlock()
+
+	lock, err := fslock.Shared(sink.lockPath, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("reader still locked out after the cycle: %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestFSSinkLayouts(t *testing.T) {
+	snapshot := snapshotKey("20260301-100000", "vulnerability-reports.json")
+	tests := []struct {
+		layout     string
+		gzip       bool
+		clusterDir string
+		paths      map[string]string // Key -> file under FS_OUTPUT_DIR
+	}{
+		{
+			layout:     fsLayoutFlat,
+			clusterDir: ".",
+			paths: map[string]string{
+				"vulnerability-reports.json": "prod-vulnerability-reports.json",
+				"index.json":                 "prod-index.json",
+				snapshot:                     "prod-snapshots/20260301-100000/vulnerability-reports.json",
+			},
+		},
+		{
+			layout:     fsLayoutNested,
+			clusterDir: "prod",
+			paths: map[string]string{
+				"vulnerability-reports.json": "prod/vulnerability-reports.json",
+				"index.json":                 "prod/index.json",
+				snapshot:                     "prod/snapshots/20260301-100000/vulnerability-reports.json",
+			},
+		},
+		{
+			layout:     fsLayoutNested,
+			gzip:       true,
+			clusterDir: "prod",
+			paths: map[string]string{
+				"vulnerability-reports.json": "prod/vulnerability-reports.json.gz",
+				"index.json":                 "prod/index.json",
+			},
+		},
+	}
+	for _, tt := range tests {
+		name := tt.layout
+		if tt.gzip {
+			name += " gzip"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := Config{FSOutputDir: dir, ClusterName: "prod", FSLayout: tt.layout}
+			if got := fsClusterDir(cfg); got != filepath.Join(dir, tt.clusterDir) {
+				t.Errorf("fsClusterDir() = %s, want %s", got, filepath.Join(dir, tt.clusterDir))
+			}
+
+			sink := &fsSink{dir: dir, cluster: "prod", layout: tt.layout, gzip: tt.gzip, opts: defaultFileOptions}
+			ctx := context.Background()
+			for _, key := range sortedKeys(tt.paths) {
+				if err := sink.Put(ctx, key, strings.NewReader(`{"key": "`+key+`"}`), -1); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(filepath.Join(dir, tt.paths[key])); err != nil {
+					t.Errorf("%s not written to %s: %v", key, tt.paths[key], err)
+				}
+				if data, err := sink.Get(ctx, key); err != nil || string(data) != `{"key": "`+key+`"}` {
+					t.Errorf("Get(%s) = %q, %v", key, data, err)
+				}
+			}
+		})
+	}
+}
+
+// Clusters sharing one volume, including names that prefix each other, only see their
+// own files
+func TestFSSinkClustersShareVolume(t *testing.T) {
+	for _, layout := range []string{fsLayoutFlat, fsLayoutNested} {
+		t.Run(layout, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx := context.Background()
+			clusters := []string{"prod", "prod-eu", "staging"}
+			sinks := make(map[string]*fsSink)
+			for _, cluster := range clusters {
+				sinks[cluster] = &fsSink{dir: dir, cluster: cluster, layout: layout, opts: defaultFileOptions}
+				for _, key := range []string{"vulnerability-reports.json", "index.json", snapshotKey("20260301-100000", "index.json")} {
+					if err := sinks[cluster].Put(ctx, key, strings.NewReader(cluster), -1); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			for _, cluster := range clusters {
+				sink := sinks[cluster]
+				for _, key := range []string{"vulnerability-reports.json", "index.json"} {
+					if data, err := sink.Get(ctx, key); err != nil || string(data) != cluster {
+						t.Errorf("%s: Get(%s) = %q, %v; want its own file", cluster, key, data, err)
+					}
+				}
+				objects, err := sink.list(ctx, snapshotDir+"/")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var keys []string
+				for _, obj := range objects {
+					keys = append(keys, obj.Key)
+				}
+				if want := []string{snapshotKey("20260301-100000", "index.json")}; !slices.Equal(keys, want) {
+					t.Errorf("%s: snapshots = %v, want %v", cluster, keys, want)
+				}
+			}
+
+			// Deleting the files of one cluster leaves the others alone
+			if err := sinks["prod"].Delete(ctx, "vulnerability-reports.json"); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := sinks["prod-eu"].Get(ctx, "vulnerability-reports.json"); string(data) != "prod-eu" {
+				t.Errorf("prod-eu report after deleting prod's = %q", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Directory of the point-in-time copies written with ENABLE_SNAPSHOTS
+const snapshotDir = "snapshots"
+
+// Layout of the snapshot directory names, the cycle ID
+const snapshotTimestampLayout = "20060102-150405"
+
+// snapshotKey names the copy of an artifact in the snapshot of a cycle, e.g.
+// snapshots/20240102-150405/vulnerability-reports.json
+func snapshotKey(timestamp, name string) string {
+	return snapshotDir + "/" + timestamp + "/" + name
+}
+
+// snapshotArtifact returns the artifact name within a snapshot key, or the key itself
+func snapshotArtifact(key string) string {
+	rest, ok := strings.CutPrefix(key, snapshotDir+"/")
+	if !ok {
+		return key
+	}
+	if _, name, ok := strings.Cut(rest, "/"); ok {
+		return name
+	}
+	return key
+}
+
+// parseRetention parses SNAPSHOT_RETENTION, a duration that also accepts days such as "30d"
+func parseRetention(s string, defaultVal time.Duration) time.Duration {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour
+		}
+	}
+	return parseDuration(s, defaultVal)
+}
+
+func (c Config) snapshotPruningEnabled() bool {
+	return c.EnableSnapshots && (c.SnapshotRetention > 0 || c.SnapshotKeepLast > 0 || c.FSRetention > 0 || c.FSKeepLast > 0)
+}
+
+// snapshotRetention returns the retention and the number of snapshots kept in a sink;
+// FS_RETENTION and FS_KEEP_LAST override the SNAPSHOT_ settings for FS_OUTPUT_DIR
+func (c Config) snapshotRetention(sink string) (time.Duration, int) {
+	retention, keepLast := c.SnapshotRetention, c.SnapshotKeepLast
+	if sink == "fs" {
+		if c.FSRetention > 0 {
+			retention = c.FSRetention
+		}
+		if c.FSKeepLast > 0 {
+			keepLast = c.FSKeepLast
+		}
+	}
+	return retention, keepLast
+}
+
+// expiredSnapshots returns the snapshot timestamps older than retention or beyond the
+// newest keepLast. Directories not named after a cycle are never expired.
+func expiredSnapshots(timestamps []string, now time.Time, retention time.Duration, keepLast int) map[string]bool {
+	var valid []string
+	for _, ts := range timestamps {
+		if _, err := time.Parse(snapshotTimestampLayout, ts); err == nil {
+			valid = append(valid, ts)
+		}
+	}
+	// The layout sorts chronologically; newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(valid)))
+
+	expired := make(map[string]bool)
+	for i, ts := range valid {
+		taken, _ := time.Parse(snapshotTimestampLayout, ts)
+		if (retention > 0 && now.Sub(taken) > retention) || (keepLast > 0 && i >= keepLast) {
+			expired[ts] = true
+		}
+	}
+	return expired
+}
+
+// pruneSnapshots deletes the expired snapshots from every sink that can list them and
+// returns the number of objects removed. Only keys under snapshots/ are considered, so the
+// latest files are never touched. Failures are logged and leave the snapshot for the next
+// cycle.
+func pruneSnapshots(ctx context.Context, sinks []Sink, cfg Config, cycleID string) int {
+	pruned := 0
+	for _, sink := range sinks {
+		l, ok := sink.(lister)
+		if !ok {
+			continue
+		}
+		retention, keepLast := cfg.snapshotRetention(sink.Name())
+		if retention == 0 && keepLast == 0 {
+			continue
+		}
+		var objects []listedObject
+		err := withRetry(ctx, cfg, sink, func() error {
+			var err error
+			objects, err = l.list(ctx, snapshotDir+"/")
+			return err
+		})
+		if err != nil {
+			log.Printf("⚠️ Failed to list snapshots in %s: %v", sink.Name(), err)
+			continue
+		}
+
+		byTimestamp := make(map[string][]listedObject)
+		for _, obj := range objects {
+			rest, ok := strings.CutPrefix(obj.Key, snapshotDir+"/")
+			if !ok {
+				continue
+			}
+			if ts, _, ok := strings.Cut(rest, "/"); ok {
+				byTimestamp[ts] = append(byTimestamp[ts], obj)
+			}
+		}
+		expired := expiredSnapshots(sortedKeys(byTimestamp), time.Now().UTC(), retention, keepLast)
+		if len(expired) == 0 {
+			continue
+		}
+
+		var reqs []DeletionRequest
+		for _, ts := range sortedKeys(expired) {
+			for _, obj := range byTimestamp[ts] {
+				reqs = append(reqs, DeletionRequest{
+					Key:     obj.Key,
+					Size:    obj.Size,
+					Feature: "snapshot-retention",
+					Reason:  "expired",
+					CycleID: cycleID,
+					DryRun:  cfg.SnapshotPruneDryRun,
+				})
+			}
+		}
+		n, freed, err := deleteArtifacts(ctx, []Sink{sink}, cfg, reqs)
+		if err != nil {
+			log.Printf("⚠️ Failed to prune snapshots in %s: %v", sink.Name(), err)
+		}
+		pruned += n
+		if !cfg.SnapshotPruneDryRun {
+			log.Printf("🧹 Pruned %d of %d objects in %d expired snapshots from %s, reclaiming %s",
+				n, len(reqs), len(expired), sink.Name(), formatSize(freed))
+		}
+	}
+	return pruned
+}
+
+// formatSize formats a byte count for the logs
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1e9:
+		return fmt.Sprintf("%.1f GB", float64(bytes)/1e9)
+	case bytes >= 1e6:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/1e6)
+	case bytes >= 1e3:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/1e3)
+	default:
+		return fmt.Sprintf("%d B", bytes)
+	}
+}
+
+// fsSnapshotsFull remembers the cycle whose snapshot ran out of space in FS_OUTPUT_DIR.
+// The rest of that snapshot is not written there, so the space left is kept for the
+// latest files of the report types published after it.
+var fsSnapshotsFull = &fullSnapshot{}
+
+type fullSnapshot struct {
+	mu      sync.Mutex
+	cycleID string
+}
+
+// observe records a failed snapshot write of the cycle that hit a full volume
+func (f *fullSnapshot) observe(cycleID string, err error) {
+	if !errors.Is(err, syscall.ENOSPC) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cycleID != cycleID {
+		f.cycleID = cycleID
+		log.Printf("💾 FS_OUTPUT_DIR is full, skipping the rest of snapshot %s there so the latest files are still written", cycleID)
+	}
+}
+
+// sinks returns the sinks the snapshot of the cycle is written to
+func (f *fullSnapshot) sinks(sinks []Sink, cycleID string) []Sink {
+	f.mu.Lock()
+	full := f.cycleID == cycleID
+	f.mu.Unlock()
+	if !full {
+		return sinks
+	}
+	var out []Sink
+	for _, sink := range sinks {
+		if sink.Name() != "fs" {
+			out = append(out, sink)
+		}
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// shutdownRequested is closed on SIGINT/SIGTERM. Spread collection stops waiting for its
+// next slot once it is closed, while the in-flight slot still runs to completion.
+var shutdownRequested = make(chan struct{})
+
+// spreadSlot returns the offset between resource slots when SPREAD_COLLECTION spreads n
+// resources evenly over the sync interval
+func spreadSlot(interval time.Duration, n int) time.Duration {
+	if n <= 1 {
+		return 0
+	}
+	return interval / time.Duration(n)
+}
+
+// waitForSlot sleeps until start+offset. It returns false when shutdown was requested or
+// the context is cancelled first.
+func waitForSlot(ctx context.Context, start time.Time, offset time.Duration) bool {
+	wait := time.Until(start.Add(offset))
+	if wait <= 0 {
+		return true
+	}
+
+	log.Printf("⏳ Next slot in %v", wait.Round(time.Millisecond))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-shutdownRequested:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
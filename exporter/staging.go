@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Prefix of the per-run staging directories under TEMP_DIR
+const stagingDirPrefix = "trivy-exporter-"
+
+// stagingDir holds the temp files of this run, "" for the default temp directory as used by
+// the subcommands
+var stagingDir string
+
+// stagingPath returns the directory temp files are created in
+func stagingPath() string {
+	if stagingDir == "" {
+		return os.TempDir()
+	}
+	return stagingDir
+}
+
+// isStagingFile reports whether an entry of TEMP_DIR was created by an exporter: a run
+// directory, or a temp file written directly to the temp directory by older versions
+func isStagingFile(name string) bool {
+	patterns := []string{stagingDirPrefix + "*", "bundle-*.tar.gz", "findings-*.parquet", "trivy-oci-*", "upload-*.json.gz"}
+	for _, r := range reportResources {
+		patterns = append(patterns, r.FileName+"-*.json")
+	}
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// removeOrphanedStaging removes the staging files of crashed runs from dir. Only entries
+// untouched for staleAfter are removed, as a shared TEMP_DIR may hold the files of another
+// live exporter.
+func removeOrphanedStaging(dir string, staleAfter time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("⚠️ Failed to look for orphaned staging files in %s: %v", dir, err)
+		return
+	}
+	cutoff := time.Now().Add(-staleAfter)
+	removed := 0
+	for _, entry := range entries {
+		if !isStagingFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			log.Printf("⚠️ Failed to remove orphaned staging file %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("🧹 Removed %d orphaned staging files from %s", removed, dir)
+	}
+}
+
+// setupStagingDir checks that TEMP_DIR is writable, removes the staging files crashed runs
+// left there and creates the directory of this run. The returned function removes it.
+func setupStagingDir(cfg Config) (func(), error) {
+	base := cfg.TempDir
+	if base == "" {
+		base = os.TempDir()
+	}
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create TEMP_DIR %s: %w", base, err)
+	}
+	removeOrphanedStaging(base, cfg.OwnerStaleAfter)
+
+	dir, err := os.MkdirTemp(base, stagingDirPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("TEMP_DIR %s is not writable: %w", base, err)
+	}
+	probe, err := os.CreateTemp(dir, ".probe-*")
+	if err == nil {
+		_, err = probe.WriteString(strings.Repeat(" ", 4096))
+		if closeErr := probe.Close(); err == nil {
+			err = closeErr
+		}
+		os.Remove(probe.Name())
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("TEMP_DIR %s is not writable: %w", base, err)
+	}
+
+	stagingDir = dir
+	log.Printf("🗂️ Staging report files in %s", dir)
+	return func() { os.RemoveAll(dir) }, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Maximum number of offending items listed in diagnostics.json
+const maxDiscrepancySamples = 50
+
+// summarySpec describes where a report kind keeps the findings its report.summary counts
+type summarySpec struct {
+	entries    []string // path of the findings slice
+	failedOnly bool     // only entries with success=false are counted (audit checks)
+}
+
+var summarySpecs = map[string]summarySpec{
+	"VulnerabilityReport":          {entries: []string{"report", "vulnerabilities"}},
+	"ClusterVulnerabilityReport":   {entries: []string{"report", "vulnerabilities"}},
+	"ExposedSecretReport":          {entries: []string{"report", "secrets"}},
+	"ConfigAuditReport":            {entries: []string{"report", "checks"}, failedOnly: true},
+	"ClusterConfigAuditReport":     {entries: []string{"report", "checks"}, failedOnly: true},
+	"RbacAssessmentReport":         {entries: []string{"report", "checks"}, failedOnly: true},
+	"ClusterRbacAssessmentReport":  {entries: []string{"report", "checks"}, failedOnly: true},
+	"InfraAssessmentReport":        {entries: []string{"report", "checks"}, failedOnly: true},
+	"ClusterInfraAssessmentReport": {entries: []string{"report", "checks"}, failedOnly: true},
+}
+
+// Severity -> report.summary field
+var severityCountFields = map[string]string{
+	"CRITICAL": "criticalCount",
+	"HIGH":     "highCount",
+	"MEDIUM":   "mediumCount",
+	"LOW":      "lowCount",
+	"UNKNOWN":  "unknownCount",
+	"NONE":     "noneCount",
+}
+
+// SummaryDiscrepancy records an item whose embedded summary disagreed with its findings
+type SummaryDiscrepancy struct {
+	Kind      string         `json:"kind"`
+	Namespace string         `json:"namespace,omitempty"`
+	Name      string         `json:"name"`
+	Embedded  map[string]int `json:"embedded"`
+	Actual    map[string]int `json:"actual"`
+}
+
+// severityCounts counts the findings of a report item per upper-case severity
+func severityCounts(obj map[string]interface{}, spec summarySpec) map[string]int {
+	counts := make(map[string]int)
+	for _, e := range nestedSlice(obj, spec.entries...) {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if spec.failedOnly {
+			if success, _ := entry["success"].(bool); success {
+				continue
+			}
+		}
+		severity, _ := entry["severity"].(string)
+		counts[strings.ToUpper(severity)]++
+	}
+	return counts
+}
+
+// reconcileSummary recomputes the per-severity counts of a report item and compares them
+// with report.summary. On a mismatch the discrepancy is returned and, when fix is set, the
+// summary is rewritten to match the findings actually present in the item.
+func reconcileSummary(obj map[string]interface{}, kind string, fix bool) *SummaryDiscrepancy {
+	spec, ok := summarySpecs[kind]
+	if !ok {
+		return nil
+	}
+	summary, found, err := unstructured.NestedFieldNoCopy(obj, "report", "summary")
+	if !found || err != nil {
+		return nil
+	}
+	summaryMap, ok := summary.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	actual := make(map[string]int)
+	for severity, n := range severityCounts(obj, spec) {
+		if field, ok := severityCountFields[severity]; ok {
+			actual[field] = n
+		}
+	}
+
+	// Only compare the fields the operator writes for this kind
+	embedded := make(map[string]int)
+	mismatch := false
+	for _, field := range severityCountFields {
+		v, present := summaryMap[field]
+		if !present {
+			continue
+		}
+		embedded[field] = toInt(v)
+		if embedded[field] != actual[field] {
+			mismatch = true
+		}
+	}
+	if !mismatch {
+		return nil
+	}
+
+	if fix {
+		for field := range embedded {
+			summaryMap[field] = int64(actual[field])
+		}
+	}
+
+	reported := make(map[string]int, len(embedded))
+	for field := range embedded {
+		reported[field] = actual[field]
+	}
+	u := unstructured.Unstructured{Object: obj}
+	return &SummaryDiscrepancy{
+		Kind:      kind,
+		Namespace: u.GetNamespace(),
+		Name:      u.GetName(),
+		Embedded:  embedded,
+		Actual:    reported,
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// tempSpace tracks the free space of the temp directory the report files are streamed to
+// and the size of those files, so a full emptyDir skips a report type instead of
+// publishing a truncated file
+var tempSpace = &tempSpaceTracker{lastSizes: make(map[string]int64), peaks: make(map[string]int64), minFree: -1}
+
+type tempSpaceTracker struct {
+	mu        sync.Mutex
+	lastSizes map[string]int64 // Temp file of the last collection per resource
+	peaks     map[string]int64 // Largest temp file per resource since the exporter started
+	minFree   int64            // Lowest free space seen during the cycle, -1 before any check
+}
+
+// tempFreeBytes returns the space of the temp directory available to the exporter
+func tempFreeBytes() (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(stagingPath(), &st); err != nil {
+		return 0, fmt.Errorf("failed to stat temp directory %s: %w", stagingPath(), err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// check returns an error when the temp directory has less free space than TEMP_MIN_FREE_MB
+// or than the report file of the resource took last time. Space that cannot be determined
+// does not block the collection.
+func (t *tempSpaceTracker) check(cfg Config, resource string) error {
+	free, err := tempFreeBytes()
+	if err != nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.minFree < 0 || free < t.minFree {
+		t.minFree = free
+	}
+	needed := max(int64(cfg.TempMinFreeMB)*1024*1024, t.lastSizes[resource])
+	if free < needed {
+		return fmt.Errorf("not enough space in temp directory %s to collect %s: %s free, %s needed",
+			stagingPath(), resource, formatSize(free), formatSize(needed))
+	}
+	return nil
+}
+
+// record remembers the size of a finished report file and returns the largest one of the
+// resource so far
+func (t *tempSpaceTracker) record(resource string, size int64) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastSizes[resource] = size
+	t.peaks[resource] = max(t.peaks[resource], size)
+	return t.peaks[resource]
+}
+
+// reset returns the lowest free space seen since the last reset, or 0 when it is unknown
+func (t *tempSpaceTracker) reset() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	free := max(t.minFree, 0)
+	t.minFree = -1
+	return free
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kubernetes truncates termination messages to 4096 bytes
+const maxTerminationMessageBytes = 4096
+
+// cycleStatus tracks the outcome of the collection cycles of a run for the termination message
+var cycleStatus = struct {
+	sync.Mutex
+	lastCycleAt         time.Time
+	lastError           string
+	consecutiveFailures int
+	cycles              int
+}{}
+
+// recordCycle stores the outcome of a collection cycle
+func recordCycle(err error) {
+	cycleStatus.Lock()
+	defer cycleStatus.Unlock()
+	cycleStatus.cycles++
+	cycleStatus.lastCycleAt = time.Now()
+	if err != nil {
+		cycleStatus.consecutiveFailures++
+		cycleStatus.lastError = err.Error()
+	} else {
+		cycleStatus.consecutiveFailures = 0
+		cycleStatus.lastError = ""
+	}
+}
+
+// TerminationMessage is written to TERMINATION_LOG_PATH so it shows up in the pod's
+// lastState.terminated.message
+type TerminationMessage struct {
+	Reason              string `json:"reason"` // shutdown or fatal
+	Code                int    `json:"code,omitempty"`
+	Class               string `json:"class,omitempty"`
+	Error               string `json:"error,omitempty"`
+	Cycles              int    `json:"cycles"`
+	LastCycleAt         string `json:"lastCycleAt,omitempty"`
+	LastCycleStatus     string `json:"lastCycleStatus,omitempty"`
+	LastCycleError      string `json:"lastCycleError,omitempty"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+}
+
+// writeTerminationMessage records why the exporter stops. err is nil on graceful shutdown.
+// The same message is logged as the final record of the run.
+func writeTerminationMessage(path string, err error) {
+	cycleStatus.Lock()
+	msg := TerminationMessage{
+		Reason:              "shutdown",
+		Cycles:              cycleStatus.cycles,
+		LastCycleError:      cycleStatus.lastError,
+		ConsecutiveFailures: cycleStatus.consecutiveFailures,
+	}
+	if !cycleStatus.lastCycleAt.IsZero() {
+		msg.LastCycleAt = cycleStatus.lastCycleAt.UTC().Format(time.RFC3339)
+		msg.LastCycleStatus = "ok"
+		if cycleStatus.lastError != "" {
+			msg.LastCycleStatus = "failed"
+		}
+	}
+	cycleStatus.Unlock()
+
+	if err != nil {
+		msg.Reason = "fatal"
+		msg.Code = exitCode(err)
+		msg.Class = exitClasses[msg.Code]
+		msg.Error = err.Error()
+	}
+
+	data, _ := json.Marshal(msg)
+	if len(data) > maxTerminationMessageBytes {
+		// Errors are the only unbounded fields
+		msg.Error = truncateString(msg.Error, 1024)
+		msg.LastCycleError = truncateString(msg.LastCycleError, 1024)
+		data, _ = json.Marshal(msg)
+	}
+	log.Printf("🪦 Termination: %s", data)
+
+	if path == "" {
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Printf("⚠️ Failed to write termination message to %s: %v", path, err)
+	}
+}
+
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
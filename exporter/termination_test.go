@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readTerminationMessage(t *testing.T, path string) TerminationMessage {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("no termination message: %v", err)
+	}
+	if len(data) > maxTerminationMessageBytes {
+		t.Errorf("termination message is %d bytes, Kubernetes keeps %d", len(data), maxTerminationMessageBytes)
+	}
+	var msg TerminationMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("termination message is not JSON: %v\n%s", err, data)
+	}
+	return msg
+}
+
+func TestWriteTerminationMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		cycles []error // Outcomes of the cycles before termination
+		err    error
+		want   TerminationMessage
+	}{
+		{
+			name: "shutdown before the first cycle",
+			want: TerminationMessage{Reason: "shutdown"},
+		},
+		{
+			name:   "shutdown after a successful cycle",
+			cycles: []error{errors.New("bucket gone"), nil},
+			want:   TerminationMessage{Reason: "shutdown", Cycles: 2, LastCycleStatus: "ok"},
+		},
+		{
+			name:   "fatal after failed cycles",
+			cycles: []error{nil, errors.New("timeout"), errors.New("bucket gone")},
+			err:    fmt.Errorf("startup: %w", storageError(errors.New("disk full"))),
+			want: TerminationMessage{Reason: "fatal", Code: exitStorage, Class: "storage", Error: "startup: disk full",
+				Cycles: 3, LastCycleStatus: "failed", LastCycleError: "bucket gone", ConsecutiveFailures: 2},
+		},
+		{
+			name: "unclassified fatal error",
+			err:  errors.New("boom"),
+			want: TerminationMessage{Reason: "fatal", Code: exitInternal, Class: "internal", Error: "boom"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cycleStatus.cycles, cycleStatus.consecutiveFailures = 0, 0
+			cycleStatus.lastCycleAt, cycleStatus.lastError = time.Time{}, ""
+			for _, err := range tt.cycles {
+				recordCycle(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "termination-log")
+			writeTerminationMessage(path, tt.err)
+			got := readTerminationMessage(t, path)
+			if (got.LastCycleAt != "") != (len(tt.cycles) > 0) {
+				t.Errorf("lastCycleAt = %q after %d cycles", got.LastCycleAt, len(tt.cycles))
+			}
+			got.LastCycleAt = ""
+			if got != tt.want {
+				t.Errorf("termination message = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteTerminationMessageTruncatesErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termination-log")
+	writeTerminationMessage(path, k8sError(errors.New(strings.Repeat("x", 10000))))
+	if msg := readTerminationMessage(t, path); msg.Code != exitK8s || !strings.HasSuffix(msg.Error, "...") {
+		t.Errorf("termination message = code %d, error of %d bytes; want the class and a truncated error", msg.Code, len(msg.Error))
+	}
+}
+
+// Fatal errors of the collection loop exit with os.Exit, which skips defers; the
+// termination message must already be on disk by then
+func TestFatalErrorWritesTerminationMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+		args []string
+		code int
+	}{
+		{name: "invalid configuration", env: []string{"LIST_RESTARTS=-1"}, code: exitConfig},
+		{name: "unreadable kubeconfig", args: []string{"--kubeconfig", filepath.Join(t.TempDir(), "missing")}, code: exitK8s},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "termination-log")
+			env := append([]string{"FS_OUTPUT_DIR=" + dir, "TERMINATION_LOG_PATH=" + path}, tt.env...)
+			code, stderr := runMainHelper(t, env, tt.args...)
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d\n%s", code, tt.code, stderr)
+			}
+			msg := readTerminationMessage(t, path)
+			if msg.Reason != "fatal" || msg.Code != tt.code || msg.Class != exitClasses[tt.code] || msg.Error == "" {
+				t.Errorf("termination message = %+v, want the fatal error with code %d", msg, tt.code)
+			}
+		})
+	}
+}
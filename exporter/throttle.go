@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"golang.org/x/time/rate"
+)
+
+// Largest read charged to the bandwidth limiter at once, which is also its burst
+const throttleChunk = 32 * 1024
+
+// bandwidthLimiter is a token bucket shared by every upload to a remote sink, so
+// UPLOAD_BANDWIDTH_LIMIT bounds concurrent uploads in aggregate
+type bandwidthLimiter struct {
+	limiter *rate.Limiter
+	limit   int64 // bytes per second
+}
+
+// uploadBandwidth throttles uploads, nil without UPLOAD_BANDWIDTH_LIMIT
+var uploadBandwidth *bandwidthLimiter
+
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), throttleChunk),
+		limit:   bytesPerSecond,
+	}
+}
+
+// reader returns r throttled by the limiter, or r itself without a limit. The result
+// only implements io.Reader so that sinks cannot bypass the limit with Seek or ReadAt.
+func (b *bandwidthLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiter: b.limiter}
+}
+
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.WaitN(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+var bandwidthPattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([kKmMgG]?)(B|b)ps$`)
+
+// parseBandwidth parses UPLOAD_BANDWIDTH_LIMIT into bytes per second: B for bytes and b
+// for bits with decimal prefixes, e.g. 5MBps or 20Mbps (2.5 MB/s). Empty means no limit.
+func parseBandwidth(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m := bandwidthPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid UPLOAD_BANDWIDTH_LIMIT %q (e.g. 500KBps, 5MBps or 20Mbps)", s)
+	}
+	v, _ := strconv.ParseFloat(m[1], 64)
+	switch m[2] {
+	case "k", "K":
+		v *= 1e3
+	case "m", "M":
+		v *= 1e6
+	case "g", "G":
+		v *= 1e9
+	}
+	if m[3] == "b" {
+		v /= 8
+	}
+	if v < 1 {
+		return 0, fmt.Errorf("invalid UPLOAD_BANDWIDTH_LIMIT %q: below 1 byte per second", s)
+	}
+	return int64(v), nil
+}
+
+// formatBandwidth formats bytes per second for the throughput logs
+func formatBandwidth(bytesPerSecond float64) string {
+	switch {
+	case bytesPerSecond >= 1e6:
+		return fmt.Sprintf("%.1f MB/s", bytesPerSecond/1e6)
+	case bytesPerSecond >= 1e3:
+		return fmt.Sprintf("%.1f KB/s", bytesPerSecond/1e3)
+	default:
+		return fmt.Sprintf("%.0f B/s", bytesPerSecond)
+	}
+}
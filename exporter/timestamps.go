@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marker added to items with a timestamp that could not be parsed. The original value is
+// kept, and freshness treats the item as unknown-age.
+const timestampParseErrorField = "timestampParseError"
+
+// Timestamp fields trivy-operator writes into report items. Paths ending in "[]" address
+// a field of every element of a list.
+var timestampFields = [][]string{
+	{"metadata", "creationTimestamp"},
+	{"report", "updateTimestamp"},
+	{"status", "updateTimestamp"},
+	{"report", "vulnerabilities[]", "publishedDate"},
+	{"report", "vulnerabilities[]", "lastModifiedDate"},
+}
+
+// Layouts observed across operator versions, tried in order
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700 MST",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02",
+}
+
+// parseTimestamp parses a timestamp value in any of the observed formats: RFC3339 with or
+// without fractional seconds, zone-less ISO 8601, Go's default time format, and epoch
+// seconds or milliseconds as numbers or numeric strings
+func parseTimestamp(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case string:
+		s := strings.TrimSpace(t)
+		if s == "" {
+			return time.Time{}, false
+		}
+		for _, layout := range timestampLayouts {
+			if parsed, err := time.Parse(layout, s); err == nil {
+				return parsed, true
+			}
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return epochTime(f), true
+		}
+	case json.Number:
+		if f, err := t.Float64(); err == nil {
+			return epochTime(f), true
+		}
+	case int64:
+		return epochTime(float64(t)), true
+	case float64:
+		return epochTime(t), true
+	}
+	return time.Time{}, false
+}
+
+// epochTime converts epoch seconds. Values above 1e12 would be past the year 33658 as
+// seconds, so they are read as milliseconds.
+func epochTime(f float64) time.Time {
+	if f > 1e12 {
+		return time.UnixMilli(int64(f))
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9))
+}
+
+// normalizeTimestamps rewrites every known timestamp field of an item as strict RFC3339 UTC.
+// Unparsable values are left as they are and the item is flagged with timestampParseError.
+// It returns the number of unparsable values. Null fields are absent data, not errors.
+func normalizeTimestamps(obj map[string]interface{}) int {
+	failures := 0
+	for _, path := range timestampFields {
+		failures += normalizeTimestampPath(obj, path)
+	}
+	if failures > 0 {
+		obj[timestampParseErrorField] = true
+	}
+	return failures
+}
+
+func normalizeTimestampPath(obj map[string]interface{}, path []string) int {
+	key := path[0]
+	if list := strings.TrimSuffix(key, "[]"); list != key {
+		items, _ := obj[list].([]interface{})
+		failures := 0
+		for _, item := range items {
+			if m, ok := item.(map[string]interface{}); ok {
+				failures += normalizeTimestampPath(m, path[1:])
+			}
+		}
+		return failures
+	}
+
+	if len(path) > 1 {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return 0
+		}
+		return normalizeTimestampPath(child, path[1:])
+	}
+
+	v, ok := obj[key]
+	if !ok || v == nil {
+		return 0
+	}
+	t, ok := parseTimestamp(v)
+	if !ok {
+		return 1
+	}
+	obj[key] = t.UTC().Format(time.RFC3339)
+	return 0
+}
+
+// hasTimestampParseError reports whether normalizeTimestamps flagged the item
+func hasTimestampParseError(obj map[string]interface{}) bool {
+	flagged, _ := obj[timestampParseErrorField].(bool)
+	return flagged
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Timestamp formats observed in report.updateTimestamp across trivy-operator versions
+func TestNormalizeTimestampsFormats(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{} // Normalized value; the original when unparsable
+		fails int
+	}{
+		{name: "RFC3339", value: "2026-03-01T10:00:00Z", want: "2026-03-01T10:00:00Z"},
+		{name: "RFC3339 with nanoseconds", value: "2026-03-01T10:00:00.123456789Z", want: "2026-03-01T10:00:00Z"},
+		{name: "RFC3339 with an offset", value: "2026-03-01T11:00:00+01:00", want: "2026-03-01T10:00:00Z"},
+		{name: "zone-less ISO 8601", value: "2026-03-01T10:00:00", want: "2026-03-01T10:00:00Z"},
+		{name: "Go default format", value: "2026-03-01 11:00:00.5 +0100 CET", want: "2026-03-01T10:00:00Z"},
+		{name: "space separated with an offset", value: "2026-03-01 10:00:00Z", want: "2026-03-01T10:00:00Z"},
+		{name: "date only", value: "2026-03-01", want: "2026-03-01T00:00:00Z"},
+		{name: "surrounding whitespace", value: " 2026-03-01T10:00:00Z\n", want: "2026-03-01T10:00:00Z"},
+		{name: "epoch seconds", value: int64(1772359200), want: "2026-03-01T10:00:00Z"},
+		{name: "epoch seconds as float", value: float64(1772359200.75), want: "2026-03-01T10:00:00Z"},
+		{name: "epoch milliseconds", value: int64(1772359200000), want: "2026-03-01T10:00:00Z"},
+		{name: "epoch as JSON number", value: json.Number("1772359200"), want: "2026-03-01T10:00:00Z"},
+		{name: "epoch as numeric string", value: "1772359200", want: "2026-03-01T10:00:00Z"},
+		{name: "null", value: nil, want: nil},
+		{name: "empty string", value: "", want: "", fails: 1},
+		{name: "garbage", value: "yesterday", want: "yesterday", fails: 1},
+		{name: "wrong type", value: true, want: true, fails: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := map[string]interface{}{"report": map[string]interface{}{"updateTimestamp": tt.value}}
+			if fails := normalizeTimestamps(obj); fails != tt.fails {
+				t.Errorf("normalizeTimestamps() = %d failures, want %d", fails, tt.fails)
+			}
+			if got := obj["report"].(map[string]interface{})["updateTimestamp"]; got != tt.want {
+				t.Errorf("updateTimestamp = %#v, want %#v", got, tt.want)
+			}
+			if flagged := hasTimestampParseError(obj); flagged != (tt.fails > 0) {
+				t.Errorf("flagged = %v, want %v", flagged, tt.fails > 0)
+			}
+		})
+	}
+}
+
+func TestNormalizeTimestampsFields(t *testing.T) {
+	obj := map[string]interface{}{
+		"metadata": map[string]interface{}{"creationTimestamp": "2026-03-01T10:00:00.5Z"},
+		"status":   map[string]interface{}{"updateTimestamp": int64(1772359200)},
+		"report": map[string]interface{}{
+			"vulnerabilities": []interface{}{
+				map[string]interface{}{"publishedDate": "2026-03-01T10:00:00", "lastModifiedDate": "not a date"},
+				map[string]interface{}{"publishedDate": nil},
+				"not an object",
+			},
+		},
+	}
+	if fails := normalizeTimestamps(obj); fails != 1 {
+		t.Errorf("normalizeTimestamps() = %d failures, want 1", fails)
+	}
+	vulns := obj["report"].(map[string]interface{})["vulnerabilities"].([]interface{})
+	for field, tt := range map[string]struct{ got, want interface{} }{
+		"creationTimestamp":        {obj["metadata"].(map[string]interface{})["creationTimestamp"], "2026-03-01T10:00:00Z"},
+		"status.updateTimestamp":   {obj["status"].(map[string]interface{})["updateTimestamp"], "2026-03-01T10:00:00Z"},
+		"publishedDate":            {vulns[0].(map[string]interface{})["publishedDate"], "2026-03-01T10:00:00Z"},
+		"unparsable lastModified":  {vulns[0].(map[string]interface{})["lastModifiedDate"], "not a date"},
+		"null publishedDate":       {vulns[1].(map[string]interface{})["publishedDate"], nil},
+		"timestampParseError flag": {obj[timestampParseErrorField], true},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %#v, want %#v", field, tt.got, tt.want)
+		}
+	}
+}
+
+// Items with an unparsable timestamp are of unknown age for freshness
+func TestObserveUpdateTimestampSkipsFlaggedItems(t *testing.T) {
+	var stats ResourceStats
+	for _, value := range []interface{}{"2026-03-01T10:00:00Z", "2026-02-01T10:00:00Z", "last week", ""} {
+		obj := map[string]interface{}{"report": map[string]interface{}{"updateTimestamp": value}}
+		normalizeTimestamps(obj)
+		stats.observeUpdateTimestamp(obj)
+	}
+	f := buildFreshness("prod", 0, map[string]ResourceStats{"vulnerabilityreports": stats})
+	rf := f.ReportTypes["vulnerabilityreports"]
+	if rf.NewestUpdate != "2026-03-01T10:00:00Z" || rf.OldestUpdate != "2026-02-01T10:00:00Z" {
+		t.Errorf("scan time range = %s..%s, want the parsable timestamps only", rf.OldestUpdate, rf.NewestUpdate)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// uploadQueue publishes collected report files on UPLOAD_CONCURRENCY workers while the
+// next report type is being listed. Each job owns its temp file until it finishes.
+type uploadQueue struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	results map[string]uploadResult
+}
+
+// uploadResult is the outcome of publishing one report type
+type uploadResult struct {
+	err        error
+	duration   time.Duration
+	finishedAt time.Time
+	snapshot   bool
+	// Size of the S3 object after the upload, or after the last upload when unchanged
+	verifiedSize int64
+	s3Upload     *S3Upload
+	// Sinks the report file was not uploaded to, with the reason code
+	skipped map[string]string
+}
+
+func newUploadQueue(concurrency int) *uploadQueue {
+	return &uploadQueue{
+		slots:   make(chan struct{}, concurrency),
+		results: make(map[string]uploadResult),
+	}
+}
+
+// submit runs upload on a worker, blocking while all workers are busy, so at most
+// UPLOAD_CONCURRENCY temp files wait for their upload besides the one being written
+func (q *uploadQueue) submit(resource string, upload func() uploadResult) {
+	q.slots <- struct{}{}
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		defer func() { <-q.slots }()
+		result := upload()
+		q.mu.Lock()
+		q.results[resource] = result
+		q.mu.Unlock()
+	}()
+}
+
+// wait blocks until every submitted upload finished and returns their results by resource
+func (q *uploadQueue) wait() map[string]uploadResult {
+	q.wg.Wait()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	results := q.results
+	q.results = make(map[string]uploadResult)
+	return results
+}
+
+// uploadReport publishes a report file with its checksum sidecar and, with
+// ENABLE_SNAPSHOTS, its snapshot copy
+func uploadReport(ctx context.Context, sinks []Sink, cfg Config, resource ReportResource, timestamp string, artifact Artifact) uploadResult {
+	start := time.Now()
+	var result uploadResult
+	err := publishArtifact(ctx, sinks, cfg, artifact)
+	result.skipped = uploadSkips.artifact(artifact.Name)
+	if err != nil {
+		result.err = storageError(fmt.Errorf("failed to publish latest %s: %w", resource.Name, err))
+	} else {
+		result.verifiedSize = verifiedSizes.lookup(artifact.Name)
+		result.s3Upload = s3Uploads.lookup(artifact.Name)
+		if err := publishArtifact(ctx, sinks, cfg, checksumSidecar(artifact)); err != nil {
+			log.Printf("⚠️ Failed to publish checksum of %s: %v", resource.Name, err)
+		}
+		// The same temp file is published again as the point-in-time copy
+		if snapshotSinks := fsSnapshotsFull.sinks(sinks, timestamp); cfg.EnableSnapshots && len(snapshotSinks) > 0 {
+			snapshot := Artifact{Name: snapshotKey(timestamp, artifact.Name), File: artifact.File}
+			if err := publishArtifact(ctx, snapshotSinks, cfg, snapshot); err != nil {
+				fsSnapshotsFull.observe(timestamp, err)
+				log.Printf("⚠️ Failed to publish snapshot of %s: %v", resource.Name, err)
+			} else {
+				result.snapshot = true
+			}
+		}
+	}
+	result.finishedAt = time.Now()
+	result.duration = result.finishedAt.Sub(start)
+	if result.err != nil {
+		log.Printf("❌ Failed to publish %s after %v: %v", resource.Name, result.duration.Round(time.Millisecond), result.err)
+	} else {
+		log.Printf("📤 Published %s in %v", resource.Name, result.duration.Round(time.Millisecond))
+	}
+	return result
+}
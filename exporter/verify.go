@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// uploadMismatchError reports an uploaded object that differs from what was sent. It is
+// retried like a transient S3 error, which uploads the artifact again.
+type uploadMismatchError struct {
+	key    string
+	detail string
+}
+
+func (e *uploadMismatchError) Error() string {
+	return fmt.Sprintf("uploaded object %s does not match: %s", e.key, e.detail)
+}
+
+// verifyUpload compares the stored object with a finished upload (S3_VERIFY_UPLOADS): its
+// size with the bytes sent, and for single-part uploads its checksum or ETag with the
+// upload response. Multipart objects only have composite checksums and ETags.
+func (s *s3Sink) verifyUpload(ctx context.Context, key string, sent int64, out *manager.UploadOutput) error {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(s.objectKey(key)),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify upload of %s: %w", key, err)
+	}
+	stored := aws.ToInt64(head.ContentLength)
+	if sent >= 0 && stored != sent {
+		return &uploadMismatchError{key: key, detail: fmt.Sprintf("%d bytes stored, %d sent", stored, sent)}
+	}
+	if out.UploadID == "" {
+		switch {
+		case out.ChecksumSHA256 != nil && head.ChecksumSHA256 != nil:
+			if *out.ChecksumSHA256 != *head.ChecksumSHA256 {
+				return &uploadMismatchError{key: key, detail: fmt.Sprintf("checksum %s stored, %s sent", *head.ChecksumSHA256, *out.ChecksumSHA256)}
+			}
+		case out.ETag != nil && head.ETag != nil:
+			if *out.ETag != *head.ETag {
+				return &uploadMismatchError{key: key, detail: fmt.Sprintf("ETag %s stored, %s uploaded", *head.ETag, *out.ETag)}
+			}
+		}
+	}
+	verifiedSizes.record(key, stored)
+	return nil
+}
+
+// verifiedSizes remembers the verified size of each uploaded S3 object for the lifetime of
+// the process, so report files skipped as unchanged keep the size of their last upload
+var verifiedSizes = &sizeRecorder{sizes: make(map[string]int64)}
+
+type sizeRecorder struct {
+	mu    sync.Mutex
+	sizes map[string]int64
+}
+
+func (r *sizeRecorder) record(key string, size int64) {
+	r.mu.Lock()
+	r.sizes[key] = size
+	r.mu.Unlock()
+}
+
+// lookup returns the verified size of a key, 0 when it was never verified
+func (r *sizeRecorder) lookup(key string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sizes[key]
+}
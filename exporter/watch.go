@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// COLLECTION_MODE values
+const (
+	collectionPoll  = "poll"  // every cycle lists the report types page by page
+	collectionWatch = "watch" // informers keep the report types in memory between cycles
+)
+
+// reportWatches keeps the report types in sync between cycles, nil unless
+// COLLECTION_MODE=watch
+var reportWatches *reportWatcher
+
+// reportWatcher runs an informer per report type. Cycles serialize the informer caches
+// instead of listing the report types again.
+type reportWatcher struct {
+	client dynamic.Interface
+	cfg    Config
+
+	mu      sync.Mutex
+	watches map[string]*reportWatch
+}
+
+// reportWatch is the informer of one report type
+type reportWatch struct {
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+	failures atomic.Int32 // Watch errors since the last event
+	failed   atomic.Bool  // Stopped after WATCH_MAX_FAILURES; the type is listed again
+}
+
+func newReportWatcher(client dynamic.Interface, cfg Config) *reportWatcher {
+	return &reportWatcher{client: client, cfg: cfg, watches: make(map[string]*reportWatch)}
+}
+
+// watch starts the informers of resources not watched yet and stops those of report types
+// no longer collected, e.g. after discovery or REPORT_TYPES changes
+func (w *reportWatcher) watch(resources []ReportResource) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wanted := make(map[string]bool, len(resources))
+	for _, r := range resources {
+		wanted[r.Name] = true
+		if _, ok := w.watches[r.Name]; !ok {
+			w.watches[r.Name] = w.start(r)
+		}
+	}
+	for name, rw := range w.watches {
+		if !wanted[name] {
+			if rw.failed.CompareAndSwap(false, true) {
+				close(rw.stop)
+			}
+			delete(w.watches, name)
+		}
+	}
+}
+
+// start runs the informer of a report type. Objects are pruned of WATCH_PRUNE_FIELDS before
+// they enter the cache, which bounds its memory at the cost of those fields in the report
+// file.
+func (w *reportWatcher) start(resource ReportResource) *reportWatch {
+	informer := dynamicinformer.NewFilteredDynamicInformer(w.client, reportGVR(resource), metav1.NamespaceAll, 0, cache.Indexers{}, nil).Informer()
+	rw := &reportWatch{informer: informer, stop: make(chan struct{})}
+
+	informer.SetTransform(func(obj interface{}) (interface{}, error) {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			pruneFields(u.Object, w.cfg.WatchPruneFields)
+		}
+		return obj, nil
+	})
+	informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		// Closed and expired watches are part of normal operation; the informer relists
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+			return
+		}
+		n := rw.failures.Add(1)
+		log.Printf("⚠️ Watch of %s failed (%d/%d): %v", resource.Name, n, w.cfg.WatchMaxFailures, err)
+		// A missing CRD or RBAC rule will not fix itself; listing reports it properly
+		permanent := apierrors.IsNotFound(err) || apierrors.IsForbidden(err)
+		if (permanent || int(n) >= w.cfg.WatchMaxFailures) && rw.failed.CompareAndSwap(false, true) {
+			log.Printf("↩️ Giving up the watch of %s, listing it page by page again", resource.Name)
+			close(rw.stop)
+		}
+	})
+	// Any event, including the relist after a failed watch, shows the watch works again.
+	// With WATCH_FEED the changes are also appended to the feed.
+	reset := func() { rw.failures.Store(0) }
+	registration, _ := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, initial bool) {
+			reset()
+			reportFeed.observe(resource, feedAdded, asUnstructured(obj), initial)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			reset()
+			// A relist delivers every object as an update, changed or not
+			old, u := asUnstructured(oldObj), asUnstructured(newObj)
+			if old == nil || u == nil || old.GetResourceVersion() != u.GetResourceVersion() {
+				reportFeed.observe(resource, feedUpdated, u, false)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			reset()
+			reportFeed.observe(resource, feedDeleted, asUnstructured(obj), false)
+		},
+	})
+	if reportFeed != nil && registration != nil {
+		go func() {
+			if cache.WaitForCacheSync(rw.stop, registration.HasSynced) {
+				reportFeed.synced(resource)
+			}
+		}()
+	}
+
+	go informer.Run(rw.stop)
+	log.Printf("👀 Watching %s", resource.Name)
+	return rw
+}
+
+// asUnstructured returns the report of an informer event, including the last known state of
+// one deleted while the watch was down
+func asUnstructured(obj interface{}) *unstructured.Unstructured {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, _ := obj.(*unstructured.Unstructured)
+	return u
+}
+
+// snapshot returns the items of a report type cached by its informer, or nil when the type
+// has to be listed: outside watch mode, after its watch failed, or while its cache has not
+// synced within WATCH_SYNC_TIMEOUT
+func (w *reportWatcher) snapshot(ctx context.Context, resource ReportResource) *watchSnapshot {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	rw, ok := w.watches[resource.Name]
+	w.mu.Unlock()
+	if !ok || rw.failed.Load() {
+		return nil
+	}
+	syncCtx, cancel := context.WithTimeout(ctx, w.cfg.WatchSyncTimeout)
+	defer cancel()
+	synced := cache.WaitForCacheSync(syncCtx.Done(), func() bool {
+		return rw.informer.HasSynced() || rw.failed.Load()
+	})
+	if rw.failed.Load() {
+		return nil
+	}
+	if !synced {
+		if ctx.Err() == nil {
+			log.Printf("⏳ Watch cache of %s not synced after %v, listing it this cycle", resource.Name, w.cfg.WatchSyncTimeout)
+		}
+		return nil
+	}
+	store := rw.informer.GetStore()
+	keys := store.ListKeys()
+	sort.Strings(keys)
+	return &watchSnapshot{store: store, keys: keys}
+}
+
+// watchSnapshot pages through the keys of an informer cache at the start of the cycle.
+// Items are copied a page at a time, so collection never modifies the cache and memory
+// stays bounded by the page size.
+type watchSnapshot struct {
+	store cache.Store
+	keys  []string
+}
+
+// next returns up to limit items; its continue token is set while items remain
+func (s *watchSnapshot) next(limit int64) *unstructured.UnstructuredList {
+	list := &unstructured.UnstructuredList{}
+	for len(s.keys) > 0 && int64(len(list.Items)) < limit {
+		key := s.keys[0]
+		s.keys = s.keys[1:]
+		obj, exists, err := s.store.GetByKey(key)
+		if err != nil || !exists {
+			// Deleted since the snapshot
+			continue
+		}
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			list.Items = append(list.Items, *u.DeepCopy())
+		}
+	}
+	if len(s.keys) > 0 {
+		list.SetContinue(collectionWatch)
+	}
+	return list
+}
+
+// pruneFields removes the dot-separated field paths of WATCH_PRUNE_FIELDS from an object
+func pruneFields(obj map[string]interface{}, paths []string) {
+	for _, p := range paths {
+		unstructured.RemoveNestedField(obj, strings.Split(p, ".")...)
+	}
+}